@@ -0,0 +1,710 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"filippo.io/mkcert/issuer"
+)
+
+// acmeLifetime is how long ACME orders and authorizations stay usable.
+const acmeLifetime = 24 * time.Hour
+
+// localTLDs are the special-use domains of RFC 6761 and RFC 8375, plus
+// .local (mDNS) and .internal, which ICANN reserved for private use. No one
+// can get a public certificate for names under them.
+var localTLDs = []string{"localhost", "test", "example", "invalid", "local", "internal", "home.arpa"}
+
+// isLocalName reports whether the ACME identifier value can only refer to
+// a machine on the local network: a name under one of the localTLDs, a
+// single-label name like a Docker Compose service, or a loopback or private
+// IP address. A wildcard of a single label, like *.com, would match names
+// under a public TLD, so it's only local if the label is one of localTLDs.
+func isLocalName(name string) bool {
+	if ip := net.ParseIP(name); ip != nil {
+		return ip.IsLoopback() || ip.IsPrivate()
+	}
+	name = strings.ToLower(name)
+	wildcard := strings.HasPrefix(name, "*.")
+	name = strings.TrimPrefix(name, "*.")
+	if !wildcard && !strings.Contains(name, ".") {
+		return true
+	}
+	for _, tld := range localTLDs {
+		if name == tld || strings.HasSuffix(name, "."+tld) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseACMEAddr parses the -acme address, like ":14000", and returns the
+// address to listen on and the base URL of the server. Without a host, the
+// server only listens on the loopback interface: since challenges always
+// pass, anyone who can reach it can get certificates that this machine
+// trusts for local names like the ones of the router or NAS.
+func parseACMEAddr(s string) (addr, baseURL string, err error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid -acme address %q, it must be like :14000 or localhost:14000", s)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", "", fmt.Errorf("invalid -acme address %q, it must be like :14000 or localhost:14000", s)
+	}
+	addr = s
+	if host == "" {
+		addr = net.JoinHostPort("127.0.0.1", port)
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return addr, "https://" + net.JoinHostPort(host, port), nil
+}
+
+// isLoopbackAddr reports whether the listen address addr is only reachable
+// from this machine.
+func isLoopbackAddr(addr string) bool {
+	host, _, _ := net.SplitHostPort(addr)
+	ip := net.ParseIP(host)
+	return host == "localhost" || ip != nil && ip.IsLoopback()
+}
+
+// acmeServer is a minimal ACME (RFC 8555) server that issues certificates
+// from the local CA. It only accepts local names (see isLocalName), and
+// doesn't check challenges: they pass as soon as the client asks for them to
+// be validated. All state is in memory, and lost when mkcert exits.
+type acmeServer struct {
+	m       *mkcert
+	baseURL string
+
+	mu            sync.Mutex
+	nonces        map[string]bool
+	accounts      map[string]*acmeAccount
+	accountsByKey map[string]*acmeAccount
+	orders        map[string]*acmeOrder
+	authzs        map[string]*acmeAuthz
+	challenges    map[string]*acmeChallenge
+	certs         map[string][]byte
+}
+
+type acmeAccount struct {
+	id       string
+	key      crypto.PublicKey
+	orderIDs []string
+
+	Status  string   `json:"status"`
+	Contact []string `json:"contact,omitempty"`
+	Orders  string   `json:"orders"`
+}
+
+type acmeIdentifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type acmeOrder struct {
+	id, accountID string
+	authzs        []*acmeAuthz
+
+	Status         string           `json:"status"`
+	Expires        time.Time        `json:"expires"`
+	Identifiers    []acmeIdentifier `json:"identifiers"`
+	Authorizations []string         `json:"authorizations"`
+	Finalize       string           `json:"finalize"`
+	Certificate    string           `json:"certificate,omitempty"`
+}
+
+type acmeAuthz struct {
+	id, accountID string
+
+	Identifier acmeIdentifier   `json:"identifier"`
+	Status     string           `json:"status"`
+	Expires    time.Time        `json:"expires"`
+	Challenges []*acmeChallenge `json:"challenges"`
+	Wildcard   bool             `json:"wildcard,omitempty"`
+}
+
+type acmeChallenge struct {
+	authz *acmeAuthz
+
+	Type      string     `json:"type"`
+	URL       string     `json:"url"`
+	Token     string     `json:"token"`
+	Status    string     `json:"status"`
+	Validated *time.Time `json:"validated,omitempty"`
+}
+
+// An acmeProblem is an RFC 7807 error document, with an ACME error type.
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func acmeError(status int, typ, format string, a ...interface{}) *acmeProblem {
+	return &acmeProblem{Type: "urn:ietf:params:acme:error:" + typ, Detail: fmt.Sprintf(format, a...), Status: status}
+}
+
+// acmeServer returns the -acme server, with a certificate for its name
+// issued by the local CA.
+func (m *mkcert) acmeServer() (*http.Server, string, error) {
+	addr, baseURL, err := parseACMEAddr(m.acmeAddr)
+	if err != nil {
+		return nil, "", err
+	}
+	s := &acmeServer{
+		m: m, baseURL: baseURL,
+		nonces:        make(map[string]bool),
+		accounts:      make(map[string]*acmeAccount),
+		accountsByKey: make(map[string]*acmeAccount),
+		orders:        make(map[string]*acmeOrder),
+		authzs:        make(map[string]*acmeAuthz),
+		challenges:    make(map[string]*acmeChallenge),
+		certs:         make(map[string][]byte),
+	}
+
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if host, _, _ := net.SplitHostPort(addr); host != "" && host != "localhost" && !net.ParseIP(host).IsLoopback() && !net.ParseIP(host).IsUnspecified() {
+		hosts = append(hosts, host)
+	}
+	cert, err := m.ca.IssueServer(hosts, &issuer.Options{ECDSA: true})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to issue the -acme server certificate: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/directory", s.handleDirectory)
+	mux.HandleFunc("/new-nonce", s.handleNewNonce)
+	mux.HandleFunc("/new-account", s.post(s.handleNewAccount))
+	mux.HandleFunc("/account/", s.post(s.handleAccount))
+	mux.HandleFunc("/new-order", s.post(s.handleNewOrder))
+	mux.HandleFunc("/order/", s.post(s.handleOrder))
+	mux.HandleFunc("/authz/", s.post(s.handleAuthz))
+	mux.HandleFunc("/challenge/", s.post(s.handleChallenge))
+	mux.HandleFunc("/cert/", s.post(s.handleCert))
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{{
+			Certificate: [][]byte{cert.Cert.Raw}, PrivateKey: cert.Key, Leaf: cert.Cert,
+		}}},
+	}
+	return srv, baseURL + "/directory", nil
+}
+
+func (s *acmeServer) handleDirectory(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/directory" || r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"newNonce":   s.baseURL + "/new-nonce",
+		"newAccount": s.baseURL + "/new-account",
+		"newOrder":   s.baseURL + "/new-order",
+	})
+}
+
+func (s *acmeServer) handleNewNonce(w http.ResponseWriter, r *http.Request) {
+	s.setHeaders(w)
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// acmeRequest is a verified JWS request. Payload is nil for POST-as-GET.
+type acmeRequest struct {
+	url     string
+	payload []byte
+	key     crypto.PublicKey
+	account *acmeAccount // nil for new-account requests
+}
+
+// An acmeHandler returns the HTTP status and the object to encode, or a
+// PEM certificate chain as a []byte.
+type acmeHandler func(w http.ResponseWriter, req *acmeRequest) (int, interface{}, *acmeProblem)
+
+// post wraps an ACME handler, verifying the JWS request and encoding the
+// returned object or problem document.
+func (s *acmeServer) post(h acmeHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.setHeaders(w)
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			s.writeProblem(w, acmeError(http.StatusMethodNotAllowed, "malformed", "ACME requests must be POSTs"))
+			return
+		}
+		req, prob := s.verify(r)
+		if prob != nil {
+			s.writeProblem(w, prob)
+			return
+		}
+		// The objects are encoded while holding the lock, as handlers of
+		// other requests modify them.
+		s.mu.Lock()
+		status, v, prob := h(w, req)
+		body, isCert := v.([]byte)
+		if prob == nil && !isCert {
+			body, _ = json.Marshal(v)
+		}
+		s.mu.Unlock()
+		if prob != nil {
+			s.writeProblem(w, prob)
+			return
+		}
+		if isCert {
+			w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.WriteHeader(status)
+		w.Write(body)
+	}
+}
+
+func (s *acmeServer) setHeaders(w http.ResponseWriter) {
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.Header().Add("Link", "<"+s.baseURL+"/directory>;rel=\"index\"")
+}
+
+func (s *acmeServer) writeProblem(w http.ResponseWriter, p *acmeProblem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+func (s *acmeServer) newNonce() string {
+	nonce := randomID()
+	s.mu.Lock()
+	s.nonces[nonce] = true
+	s.mu.Unlock()
+	return nonce
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// verify checks the JWS signature, nonce and URL of an ACME request, as
+// specified in RFC 8555, Section 6.2.
+func (s *acmeServer) verify(r *http.Request) (*acmeRequest, *acmeProblem) {
+	if ct := r.Header.Get("Content-Type"); ct != "application/jose+json" {
+		return nil, acmeError(http.StatusUnsupportedMediaType, "malformed", "the Content-Type must be application/jose+json, not %q", ct)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, acmeError(http.StatusBadRequest, "malformed", "failed to read the request: %v", err)
+	}
+	var jws struct {
+		Protected, Payload, Signature string
+	}
+	if err := json.Unmarshal(body, &jws); err != nil {
+		return nil, acmeError(http.StatusBadRequest, "malformed", "the request is not a flattened JWS: %v", err)
+	}
+	protected, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err != nil {
+		return nil, acmeError(http.StatusBadRequest, "malformed", "invalid JWS protected header: %v", err)
+	}
+	var header struct {
+		Alg   string          `json:"alg"`
+		Nonce string          `json:"nonce"`
+		URL   string          `json:"url"`
+		JWK   json.RawMessage `json:"jwk"`
+		KID   string          `json:"kid"`
+	}
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return nil, acmeError(http.StatusBadRequest, "malformed", "invalid JWS protected header: %v", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(jws.Signature)
+	if err != nil {
+		return nil, acmeError(http.StatusBadRequest, "malformed", "invalid JWS signature: %v", err)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		return nil, acmeError(http.StatusBadRequest, "malformed", "invalid JWS payload: %v", err)
+	}
+
+	s.mu.Lock()
+	validNonce := s.nonces[header.Nonce]
+	delete(s.nonces, header.Nonce)
+	s.mu.Unlock()
+	if !validNonce {
+		return nil, acmeError(http.StatusBadRequest, "badNonce", "the nonce %q is invalid or was already used", header.Nonce)
+	}
+	req := &acmeRequest{url: s.baseURL + r.URL.Path}
+	if header.URL != req.url {
+		return nil, acmeError(http.StatusUnauthorized, "unauthorized", "the JWS url %q doesn't match the request URL %q", header.URL, req.url)
+	}
+
+	switch {
+	case r.URL.Path == "/new-account":
+		if len(header.JWK) == 0 || header.KID != "" {
+			return nil, acmeError(http.StatusBadRequest, "malformed", "new-account requests must have a jwk and no kid")
+		}
+		req.key, err = parseJWK(header.JWK)
+		if err != nil {
+			return nil, acmeError(http.StatusBadRequest, "badPublicKey", "%v", err)
+		}
+	case len(header.JWK) != 0 || header.KID == "":
+		return nil, acmeError(http.StatusBadRequest, "malformed", "requests must have a kid and no jwk")
+	default:
+		s.mu.Lock()
+		req.account = s.accounts[strings.TrimPrefix(header.KID, s.baseURL+"/account/")]
+		s.mu.Unlock()
+		if req.account == nil {
+			return nil, acmeError(http.StatusBadRequest, "accountDoesNotExist", "unknown account %q, accounts don't survive restarting mkcert", header.KID)
+		}
+		if req.account.Status != "valid" {
+			return nil, acmeError(http.StatusUnauthorized, "unauthorized", "the account is %s", req.account.Status)
+		}
+		req.key = req.account.key
+	}
+	if err := verifyJWS(req.key, header.Alg, []byte(jws.Protected+"."+jws.Payload), sig); err != nil {
+		if errors.Is(err, errUnsupportedJWSAlg) {
+			return nil, acmeError(http.StatusBadRequest, "badSignatureAlgorithm", "%v", err)
+		}
+		return nil, acmeError(http.StatusBadRequest, "malformed", "%v", err)
+	}
+	if len(payload) != 0 {
+		req.payload = payload
+	}
+	return req, nil
+}
+
+var errUnsupportedJWSAlg = errors.New("unsupported JWS algorithm, use RS256, ES256, ES384, ES512 or EdDSA")
+
+func parseJWK(data []byte) (crypto.PublicKey, error) {
+	var jwk struct {
+		Kty, Crv, N, E, X, Y string
+	}
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, fmt.Errorf("invalid JWK: %v", err)
+	}
+	b64 := func(s string) *big.Int {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil
+		}
+		return new(big.Int).SetBytes(b)
+	}
+	switch jwk.Kty {
+	case "RSA":
+		n, e := b64(jwk.N), b64(jwk.E)
+		if n == nil || e == nil || !e.IsInt64() || n.BitLen() < 2048 {
+			return nil, errors.New("invalid RSA JWK, or shorter than 2048 bits")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, x, y := curves[jwk.Crv], b64(jwk.X), b64(jwk.Y)
+		if curve == nil || x == nil || y == nil || !curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid EC JWK")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if jwk.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid OKP JWK")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported JWK type %q", jwk.Kty)
+}
+
+func verifyJWS(pub crypto.PublicKey, alg string, signed, sig []byte) error {
+	var digest []byte
+	switch alg {
+	case "RS256", "ES256":
+		h := sha256.Sum256(signed)
+		digest = h[:]
+	case "ES384":
+		h := sha512.Sum384(signed)
+		digest = h[:]
+	case "ES512":
+		h := sha512.Sum512(signed)
+		digest = h[:]
+	}
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			return errUnsupportedJWSAlg
+		}
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, sig) != nil {
+			return errors.New("invalid JWS signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if want := map[int]string{32: "ES256", 48: "ES384", 66: "ES512"}[size]; alg != want {
+			return errUnsupportedJWSAlg
+		}
+		if len(sig) != 2*size {
+			return errors.New("invalid JWS signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid JWS signature")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			return errUnsupportedJWSAlg
+		}
+		if !ed25519.Verify(pub, signed, sig) {
+			return errors.New("invalid JWS signature")
+		}
+		return nil
+	}
+	return errUnsupportedJWSAlg
+}
+
+func (s *acmeServer) handleNewAccount(w http.ResponseWriter, req *acmeRequest) (int, interface{}, *acmeProblem) {
+	var p struct {
+		Contact            []string `json:"contact"`
+		OnlyReturnExisting bool     `json:"onlyReturnExisting"`
+	}
+	if err := json.Unmarshal(req.payload, &p); err != nil {
+		return 0, nil, acmeError(http.StatusBadRequest, "malformed", "invalid new-account request: %v", err)
+	}
+	spki, err := x509.MarshalPKIXPublicKey(req.key)
+	if err != nil {
+		return 0, nil, acmeError(http.StatusBadRequest, "badPublicKey", "%v", err)
+	}
+	fingerprint := fingerprintHex(spki)
+	if acct := s.accountsByKey[fingerprint]; acct != nil {
+		w.Header().Set("Location", s.baseURL+"/account/"+acct.id)
+		return http.StatusOK, acct, nil
+	}
+	if p.OnlyReturnExisting {
+		return 0, nil, acmeError(http.StatusBadRequest, "accountDoesNotExist", "no account exists with this key")
+	}
+	acct := &acmeAccount{id: randomID(), key: req.key, Status: "valid", Contact: p.Contact}
+	acct.Orders = s.baseURL + "/account/" + acct.id + "/orders"
+	s.accounts[acct.id] = acct
+	s.accountsByKey[fingerprint] = acct
+	w.Header().Set("Location", s.baseURL+"/account/"+acct.id)
+	return http.StatusCreated, acct, nil
+}
+
+func (s *acmeServer) handleAccount(w http.ResponseWriter, req *acmeRequest) (int, interface{}, *acmeProblem) {
+	id := strings.TrimPrefix(req.url, s.baseURL+"/account/")
+	if strings.TrimSuffix(id, "/orders") != req.account.id {
+		return 0, nil, acmeError(http.StatusUnauthorized, "unauthorized", "the account URL doesn't match the kid")
+	}
+	if strings.HasSuffix(id, "/orders") {
+		var orders []string
+		for _, oid := range req.account.orderIDs {
+			orders = append(orders, s.baseURL+"/order/"+oid)
+		}
+		return http.StatusOK, map[string][]string{"orders": orders}, nil
+	}
+	if req.payload != nil {
+		var p struct {
+			Status  string   `json:"status"`
+			Contact []string `json:"contact"`
+		}
+		if err := json.Unmarshal(req.payload, &p); err != nil {
+			return 0, nil, acmeError(http.StatusBadRequest, "malformed", "invalid account update: %v", err)
+		}
+		if p.Contact != nil {
+			req.account.Contact = p.Contact
+		}
+		if p.Status == "deactivated" {
+			req.account.Status = p.Status
+		}
+	}
+	return http.StatusOK, req.account, nil
+}
+
+func (s *acmeServer) handleNewOrder(w http.ResponseWriter, req *acmeRequest) (int, interface{}, *acmeProblem) {
+	var p struct {
+		Identifiers []acmeIdentifier `json:"identifiers"`
+	}
+	if err := json.Unmarshal(req.payload, &p); err != nil || len(p.Identifiers) == 0 {
+		return 0, nil, acmeError(http.StatusBadRequest, "malformed", "invalid new-order request, it must have identifiers")
+	}
+
+	expires := time.Now().Add(acmeLifetime).UTC().Truncate(time.Second)
+	o := &acmeOrder{id: randomID(), accountID: req.account.id, Status: "pending", Expires: expires, Identifiers: p.Identifiers}
+	for _, ident := range p.Identifiers {
+		switch {
+		case ident.Type == "ip" && net.ParseIP(ident.Value) != nil:
+		case ident.Type == "dns" && hostnameRegexp.MatchString(ident.Value):
+		default:
+			return 0, nil, acmeError(http.StatusBadRequest, "rejectedIdentifier", "%q is not a valid %s identifier", ident.Value, ident.Type)
+		}
+		if !isLocalName(ident.Value) {
+			return 0, nil, acmeError(http.StatusBadRequest, "rejectedIdentifier",
+				"%q is not a local name, mkcert -acme only issues certificates for names like localhost, *.test or a private IP", ident.Value)
+		}
+
+		a := &acmeAuthz{id: randomID(), accountID: req.account.id, Identifier: ident, Status: "pending", Expires: expires}
+		types := []string{"http-01", "tls-alpn-01", "dns-01"}
+		if strings.HasPrefix(ident.Value, "*.") {
+			a.Identifier.Value, a.Wildcard = ident.Value[2:], true
+			types = []string{"dns-01"}
+		} else if ident.Type == "ip" {
+			types = types[:2]
+		}
+		for _, typ := range types {
+			id := randomID()
+			c := &acmeChallenge{authz: a, Type: typ, URL: s.baseURL + "/challenge/" + id, Token: randomID(), Status: "pending"}
+			s.challenges[id] = c
+			a.Challenges = append(a.Challenges, c)
+		}
+		s.authzs[a.id] = a
+		o.authzs = append(o.authzs, a)
+		o.Authorizations = append(o.Authorizations, s.baseURL+"/authz/"+a.id)
+	}
+	o.Finalize = s.baseURL + "/order/" + o.id + "/finalize"
+	s.orders[o.id] = o
+	req.account.orderIDs = append(req.account.orderIDs, o.id)
+	w.Header().Set("Location", s.baseURL+"/order/"+o.id)
+	return http.StatusCreated, o, nil
+}
+
+func (s *acmeServer) handleOrder(w http.ResponseWriter, req *acmeRequest) (int, interface{}, *acmeProblem) {
+	id := strings.TrimPrefix(req.url, s.baseURL+"/order/")
+	o := s.orders[strings.TrimSuffix(id, "/finalize")]
+	if o == nil || o.accountID != req.account.id {
+		return 0, nil, acmeError(http.StatusNotFound, "malformed", "unknown order")
+	}
+	if o.Status == "pending" {
+		ready := true
+		for _, a := range o.authzs {
+			ready = ready && a.Status == "valid"
+		}
+		if ready {
+			o.Status = "ready"
+		}
+	}
+	if !strings.HasSuffix(id, "/finalize") {
+		w.Header().Set("Location", s.baseURL+"/order/"+o.id)
+		return http.StatusOK, o, nil
+	}
+
+	if o.Status != "ready" {
+		return 0, nil, acmeError(http.StatusForbidden, "orderNotReady", "the order is %s, not ready", o.Status)
+	}
+	var p struct {
+		CSR string `json:"csr"`
+	}
+	if err := json.Unmarshal(req.payload, &p); err != nil {
+		return 0, nil, acmeError(http.StatusBadRequest, "malformed", "invalid finalize request: %v", err)
+	}
+	der, err := base64.RawURLEncoding.DecodeString(p.CSR)
+	if err != nil {
+		return 0, nil, acmeError(http.StatusBadRequest, "badCSR", "invalid CSR encoding: %v", err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		return 0, nil, acmeError(http.StatusBadRequest, "badCSR", "invalid CSR: %v", err)
+	}
+	var want, got []string
+	for _, ident := range o.Identifiers {
+		want = append(want, strings.ToLower(ident.Value))
+	}
+	for _, name := range csr.DNSNames {
+		got = append(got, strings.ToLower(name))
+	}
+	for _, ip := range csr.IPAddresses {
+		got = append(got, ip.String())
+	}
+	sort.Strings(want)
+	sort.Strings(got)
+	if strings.Join(want, ",") != strings.Join(got, ",") || len(csr.EmailAddresses) != 0 || len(csr.URIs) != 0 {
+		return 0, nil, acmeError(http.StatusBadRequest, "badCSR", "the CSR names %v don't match the order identifiers %v", got, want)
+	}
+
+	// The CSR comes from any client that can reach the server, so only its
+	// names are honored, and CA certificates are never issued.
+	policy := issuer.CSRPolicy{SANsOnly: true, RejectCA: true}
+	cert, err := s.m.ca.SignCSR(csr, &issuer.Options{CSRPolicy: policy, NotAfter: s.m.notAfter, Template: s.m.template, Extensions: s.m.extensions})
+	if err != nil {
+		return 0, nil, acmeError(http.StatusBadRequest, "badCSR", "failed to issue the certificate: %v", err)
+	}
+	s.certs[o.id] = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Cert.Raw})
+	s.m.health.recordIssued(cert.Cert)
+	o.Status, o.Certificate = "valid", s.baseURL+"/cert/"+o.id
+	log.Printf("Issued a certificate for %s over ACME, expiring on %s 📜", strings.Join(issuer.Hosts(cert.Cert), ", "), cert.Cert.NotAfter.Format("2 January 2006"))
+	w.Header().Set("Location", s.baseURL+"/order/"+o.id)
+	return http.StatusOK, o, nil
+}
+
+func (s *acmeServer) handleAuthz(w http.ResponseWriter, req *acmeRequest) (int, interface{}, *acmeProblem) {
+	a := s.authzs[strings.TrimPrefix(req.url, s.baseURL+"/authz/")]
+	if a == nil || a.accountID != req.account.id {
+		return 0, nil, acmeError(http.StatusNotFound, "malformed", "unknown authorization")
+	}
+	if req.payload != nil {
+		var p struct {
+			Status string `json:"status"`
+		}
+		if err := json.Unmarshal(req.payload, &p); err != nil || p.Status != "deactivated" {
+			return 0, nil, acmeError(http.StatusBadRequest, "malformed", "authorizations can only be deactivated")
+		}
+		a.Status = p.Status
+	}
+	return http.StatusOK, a, nil
+}
+
+// handleChallenge marks the challenge and its authorization valid when the
+// client responds to it, without checking it, as the name is local.
+func (s *acmeServer) handleChallenge(w http.ResponseWriter, req *acmeRequest) (int, interface{}, *acmeProblem) {
+	c := s.challenges[strings.TrimPrefix(req.url, s.baseURL+"/challenge/")]
+	if c == nil || c.authz.accountID != req.account.id {
+		return 0, nil, acmeError(http.StatusNotFound, "malformed", "unknown challenge")
+	}
+	if req.payload != nil && c.Status == "pending" && c.authz.Status == "pending" {
+		now := time.Now().UTC().Truncate(time.Second)
+		c.Status, c.Validated = "valid", &now
+		c.authz.Status = "valid"
+	}
+	w.Header().Add("Link", "<"+s.baseURL+"/authz/"+c.authz.id+">;rel=\"up\"")
+	return http.StatusOK, c, nil
+}
+
+func (s *acmeServer) handleCert(w http.ResponseWriter, req *acmeRequest) (int, interface{}, *acmeProblem) {
+	id := strings.TrimPrefix(req.url, s.baseURL+"/cert/")
+	o := s.orders[id]
+	if o == nil || o.accountID != req.account.id || s.certs[id] == nil {
+		return 0, nil, acmeError(http.StatusNotFound, "malformed", "unknown certificate")
+	}
+	return http.StatusOK, s.certs[id], nil
+}
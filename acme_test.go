@@ -0,0 +1,105 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"filippo.io/mkcert/issuer"
+)
+
+// TestACMEFinalizeRejectsCA checks that ACME clients can't get a CA
+// certificate by asking for one in the CSR.
+func TestACMEFinalizeRejectsCA(t *testing.T) {
+	ca, err := issuer.NewCA(t.TempDir(), &issuer.Options{ECDSA: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &acmeServer{
+		m: &mkcert{ca: ca}, baseURL: "https://localhost:14000",
+		orders: make(map[string]*acmeOrder),
+		certs:  make(map[string][]byte),
+	}
+	account := &acmeAccount{id: "account"}
+	finalize := func(id string, extensions []pkix.Extension) (*acmeOrder, *acmeProblem) {
+		s.orders[id] = &acmeOrder{id: id, accountID: account.id, Status: "ready",
+			Identifiers: []acmeIdentifier{{Type: "dns", Value: "example.test"}}}
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			DNSNames: []string{"example.test"}, ExtraExtensions: extensions,
+		}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload, _ := json.Marshal(map[string]string{"csr": base64.RawURLEncoding.EncodeToString(csr)})
+		_, _, prob := s.handleOrder(httptest.NewRecorder(), &acmeRequest{
+			url: s.baseURL + "/order/" + id + "/finalize", payload: payload, account: account,
+		})
+		return s.orders[id], prob
+	}
+
+	constraints, err := asn1.Marshal(struct {
+		IsCA bool `asn1:"optional"`
+	}{true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, prob := finalize("ca", []pkix.Extension{{Id: asn1.ObjectIdentifier{2, 5, 29, 19}, Critical: true, Value: constraints}})
+	if prob == nil || prob.Status != http.StatusBadRequest {
+		t.Errorf("finalizing with a CA:TRUE CSR: got problem %+v, want a 400", prob)
+	}
+	if o.Status != "ready" || s.certs["ca"] != nil {
+		t.Errorf("finalizing with a CA:TRUE CSR issued a certificate")
+	}
+
+	o, prob = finalize("leaf", nil)
+	if prob != nil {
+		t.Fatalf("finalizing with a leaf CSR: %+v", prob)
+	}
+	if o.Status != "valid" || s.certs["leaf"] == nil {
+		t.Errorf("finalizing with a leaf CSR: got status %q, want valid", o.Status)
+	}
+}
+
+func TestIsLocalName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"localhost":          true,
+		"db":                 true,
+		"example.test":       true,
+		"*.example.test":     true,
+		"*.test":             true,
+		"*.home.arpa":        true,
+		"nas.home.arpa":      true,
+		"printer.LOCAL":      true,
+		"127.0.0.1":          true,
+		"192.168.1.1":        true,
+		"::1":                true,
+		"*.db":               false,
+		"*.com":              false,
+		"*.io":               false,
+		"example.com":        false,
+		"*.example.com":      false,
+		"test.example.com":   false,
+		"8.8.8.8":            false,
+		"2001:4860:4860::88": false,
+	} {
+		if got := isLocalName(name); got != want {
+			t.Errorf("isLocalName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// parseAIAURL checks the -aia URL, which must be plain HTTP, as clients
+// don't fetch AIA issuers over HTTPS to avoid loops.
+func parseAIAURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -aia URL: %w", err)
+	}
+	if u.Scheme != "http" || u.Host == "" {
+		return nil, errors.New("invalid -aia URL: it must be an http:// URL, like http://localhost:8001/rootCA.cer")
+	}
+	return u, nil
+}
+
+// serveEndpoints serves the DER CA certificate at the -aia URL, OCSP
+// responses at the -ocsp URL, and the CRL at the -crl URL, for clients that
+// fetch them while verifying certificates, and the -acme server, until
+// interrupted.
+func (m *mkcert) serveEndpoints() error {
+	muxes := make(map[string]*endpointMux)
+	paths := make(map[string]bool)
+	handle := func(u *url.URL, h http.HandlerFunc, subtree bool) error {
+		path := u.Path
+		if path == "" {
+			path = "/"
+		}
+		if paths[u.Host+path] {
+			return fmt.Errorf("the -aia, -ocsp and -crl URLs must be different, but two are %s", u)
+		}
+		paths[u.Host+path] = true
+		if muxes[u.Host] == nil {
+			muxes[u.Host] = &endpointMux{}
+		}
+		muxes[u.Host].handle(path, h, subtree)
+		return nil
+	}
+
+	if m.aiaURL != "" {
+		u, err := parseAIAURL(m.aiaURL)
+		if err != nil {
+			return err
+		}
+		if err := handle(u, m.aiaHandler(u), false); err != nil {
+			return err
+		}
+		log.Printf("Serving the local CA certificate at %s 📡", u)
+	}
+	if m.ocspURL != "" {
+		u, err := parseOCSPURL(m.ocspURL)
+		if err != nil {
+			return err
+		}
+		if err := handle(u, m.ocspHandler(u), true); err != nil {
+			return err
+		}
+		log.Printf("Serving OCSP responses for the local CA at %s 📡", u)
+	}
+	if m.crlURL != "" {
+		u, err := parseCRLURL(m.crlURL)
+		if err != nil {
+			return err
+		}
+		if err := handle(u, m.crlHandler(u), false); err != nil {
+			return err
+		}
+		log.Printf("Serving the CRL of the local CA at %s 📡", u)
+	}
+	var acme *http.Server
+	if m.acmeAddr != "" {
+		srv, directory, err := m.acmeServer()
+		if err != nil {
+			return err
+		}
+		if muxes[srv.Addr] != nil {
+			return fmt.Errorf("the -acme address must be different from the -aia, -ocsp and -crl ones, but they are all %s", srv.Addr)
+		}
+		acme = srv
+		log.Printf("Serving an ACME directory for the local CA at %s 📡", directory)
+		if !isLoopbackAddr(srv.Addr) {
+			log.Printf("Warning: the ACME server listens on %s, so any machine that can reach it can get certificates for local names, like the ones of your router or NAS, that this machine trusts ⚠️", srv.Addr)
+		}
+	}
+	if acme != nil {
+		if err := m.serveHealth("acme"); err != nil {
+			return err
+		}
+	}
+	log.Print("Press Ctrl-C to stop.")
+
+	errc := make(chan error, len(muxes)+1)
+	for host, mux := range muxes {
+		go func(host string, mux *endpointMux) {
+			errc <- http.ListenAndServe(host, mux)
+		}(host, mux)
+	}
+	if acme != nil {
+		go func() { errc <- acme.ListenAndServeTLS("", "") }()
+	}
+	return <-errc
+}
+
+// An endpointMux routes the requests of a host to the -aia, -ocsp and -crl
+// endpoints. Unlike http.ServeMux, it doesn't clean the path, which would
+// redirect the OCSP GET requests whose base64 happens to contain "//".
+type endpointMux struct {
+	exact    map[string]http.Handler
+	subtrees map[string]http.Handler
+}
+
+// handle registers h at path, and if subtree is set, at all the paths below
+// it, like the GET requests of RFC 6960, Appendix A.1.
+func (mux *endpointMux) handle(path string, h http.Handler, subtree bool) {
+	if mux.exact == nil {
+		mux.exact = make(map[string]http.Handler)
+		mux.subtrees = make(map[string]http.Handler)
+	}
+	mux.exact[path] = h
+	if subtree {
+		mux.subtrees[strings.TrimSuffix(path, "/")+"/"] = h
+	}
+}
+
+func (mux *endpointMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h, ok := mux.exact[r.URL.Path]; ok {
+		h.ServeHTTP(w, r)
+		return
+	}
+	var match string
+	for prefix := range mux.subtrees {
+		if strings.HasPrefix(r.URL.Path, prefix) && len(prefix) > len(match) {
+			match = prefix
+		}
+	}
+	if match == "" {
+		http.NotFound(w, r)
+		return
+	}
+	mux.subtrees[match].ServeHTTP(w, r)
+}
+
+func (m *mkcert) aiaHandler(u *url.URL) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != u.Path && !(u.Path == "" && r.URL.Path == "/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pkix-cert")
+		w.Write(m.ca.Cert.Raw)
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/mkcert/issuer"
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// An auditFinding is a line of the -audit report. Status is "ok",
+// "expiring" or "expired" for the certificates issued by the local CA, and
+// otherwise one of the problems: "different-ca", "mismatched-key",
+// "orphaned-key", "drift", "stale" or "unreachable".
+type auditFinding struct {
+	File     string     `json:"file,omitempty"`
+	Service  string     `json:"service,omitempty"`
+	Status   string     `json:"status"`
+	NotAfter *time.Time `json:"not_after,omitempty"`
+	Detail   string     `json:"detail,omitempty"`
+}
+
+type auditCert struct {
+	path string
+	cert *x509.Certificate
+	// key is the public key of the private key in the same file, if any.
+	key crypto.PublicKey
+}
+
+type auditKey struct {
+	path string
+	pub  crypto.PublicKey
+}
+
+// audit implements -audit. It finds the certificates and keys mkcert
+// generated under dir, and reports the certificates that expire within
+// m.within or are from a different local CA, the keys that don't match
+// their certificate or have none, and the m.auditTargets that serve a
+// different certificate than the one on disk for their key.
+func (m *mkcert) audit(dir string) error {
+	certs, keys, cas, err := scanAuditDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var findings []auditFinding
+	matched := make(map[string]bool)
+	for _, c := range certs {
+		f := auditFinding{File: c.path, Status: "ok", NotAfter: &c.cert.NotAfter,
+			Detail: strings.Join(issuer.Hosts(c.cert), ", ")}
+		switch now := time.Now(); {
+		case c.cert.CheckSignatureFrom(m.ca.Cert) != nil:
+			f.Status, f.Detail = "different-ca", "issued by a different local CA, re-issue it with -resign"
+		case now.After(c.cert.NotAfter):
+			f.Status = "expired"
+		case c.cert.NotAfter.Sub(now) <= m.within:
+			f.Status = "expiring"
+		}
+		findings = append(findings, f)
+
+		if c.key != nil && !samePublicKey(c.key, c.cert.PublicKey) {
+			findings = append(findings, auditFinding{File: c.path, Status: "mismatched-key",
+				Detail: "the key in the file doesn't match the certificate"})
+		}
+		for _, k := range keys {
+			if samePublicKey(k.pub, c.cert.PublicKey) {
+				matched[k.path] = true
+			} else if k.path == keyPathFor(c.path) {
+				findings = append(findings, auditFinding{File: k.path, Status: "mismatched-key",
+					Detail: fmt.Sprintf("doesn't match the certificate in %s", c.path)})
+				matched[k.path] = true
+			}
+		}
+	}
+	// The keys of the local CA, and of the CAs found under dir, like the
+	// ones replaced by -rotate-ca, are not orphaned.
+	for _, k := range keys {
+		for _, ca := range append(cas, m.ca.Cert) {
+			if samePublicKey(k.pub, ca.PublicKey) {
+				matched[k.path] = true
+			}
+		}
+	}
+	for _, k := range keys {
+		if !matched[k.path] {
+			findings = append(findings, auditFinding{File: k.path, Status: "orphaned-key",
+				Detail: "no certificate under the directory uses this key"})
+		}
+	}
+	for _, service := range m.auditTargets {
+		findings = append(findings, auditService(service, certs))
+	}
+
+	if m.jsonOutput {
+		out, err := json.MarshalIndent(findings, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, string(out))
+	}
+
+	var problems int
+	for _, f := range findings {
+		if f.Status != "ok" {
+			problems++
+		}
+		if m.jsonOutput {
+			continue
+		}
+		date := "          "
+		if f.NotAfter != nil {
+			date = f.NotAfter.Format("2006-01-02")
+		}
+		what := f.File
+		if f.Service != "" {
+			what = f.Service
+		}
+		fmt.Fprintf(stdout, "%-14s  %s  %s (%s)\n", f.Status, date, what, f.Detail)
+	}
+	if !m.jsonOutput {
+		fmt.Fprintf(stdout, "\nAudited %d certificate(s) and %d key(s) under %s\n", len(certs), len(keys), dir)
+	}
+	if problems > 0 {
+		return fmt.Errorf("found %d problem(s)", problems)
+	}
+	return nil
+}
+
+// scanAuditDir returns the mkcert certificates, the "-key.pem" files and the
+// CA certificates found under dir.
+func scanAuditDir(dir string) ([]auditCert, []auditKey, []*x509.Certificate, error) {
+	var certs []auditCert
+	var keys []auditKey
+	var cas []*x509.Certificate
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := filepath.Ext(path)
+		if d.IsDir() || (ext != ".pem" && ext != ".crt" && ext != ".key" && ext != ".p12") {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if ext == ".p12" {
+			key, cert, _, err := pkcs12.DecodeChain(data, "changeit")
+			if err == nil && isMkcertLeaf(cert) {
+				certs = append(certs, auditCert{path: path, cert: cert, key: publicKeyOf(key)})
+			}
+			return nil
+		}
+
+		var cert *x509.Certificate
+		var key crypto.PublicKey
+		for rest := data; ; {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			switch {
+			case block.Type == "CERTIFICATE" && cert == nil:
+				cert, _ = x509.ParseCertificate(block.Bytes)
+			case strings.HasSuffix(block.Type, "PRIVATE KEY") && key == nil:
+				key = parsePEMPublicKey(block)
+			}
+		}
+		switch {
+		case cert != nil && cert.IsCA:
+			cas = append(cas, cert)
+		case cert != nil && isMkcertLeaf(cert):
+			certs = append(certs, auditCert{path: path, cert: cert, key: key})
+		case cert == nil && key != nil && strings.HasSuffix(path, "-key.pem"):
+			keys = append(keys, auditKey{path: path, pub: key})
+		}
+		return nil
+	})
+	return certs, keys, cas, err
+}
+
+// auditService connects to service, and checks that it serves the
+// certificate on disk for its key.
+func auditService(service string, certs []auditCert) auditFinding {
+	f := auditFinding{Service: service}
+	addr, host, err := parseProbeTarget(service)
+	if err != nil {
+		f.Status, f.Detail = "unreachable", err.Error()
+		return f
+	}
+	config := &tls.Config{InsecureSkipVerify: true}
+	if net.ParseIP(host) == nil {
+		config.ServerName = host
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", addr, config)
+	if err != nil {
+		f.Status, f.Detail = "unreachable", err.Error()
+		return f
+	}
+	state := conn.ConnectionState()
+	conn.Close()
+	if len(state.PeerCertificates) == 0 {
+		f.Status, f.Detail = "unreachable", "the server presented no certificates"
+		return f
+	}
+	served := state.PeerCertificates[0]
+	f.NotAfter = &served.NotAfter
+	servedHosts := issuer.Hosts(served)
+
+	for _, c := range certs {
+		if !samePublicKey(c.cert.PublicKey, served.PublicKey) {
+			continue
+		}
+		f.File = c.path
+		switch {
+		case bytes.Equal(c.cert.Raw, served.Raw):
+			f.Status, f.Detail = "ok", strings.Join(servedHosts, ", ")
+		case !sameHosts(issuer.Hosts(c.cert), servedHosts):
+			f.Status = "drift"
+			f.Detail = fmt.Sprintf("serves %s, but %s is for %s", strings.Join(servedHosts, ", "),
+				c.path, strings.Join(issuer.Hosts(c.cert), ", "))
+		default:
+			f.Status = "stale"
+			f.Detail = fmt.Sprintf("serves a different certificate than %s, reload it", c.path)
+		}
+		return f
+	}
+	f.Status = "drift"
+	f.Detail = fmt.Sprintf("serves a certificate for %s that is not under the directory", strings.Join(servedHosts, ", "))
+	return f
+}
+
+// keyPathFor returns where mkcert saves the key of the certificate at path.
+func keyPathFor(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "-key.pem"
+}
+
+func parsePEMPublicKey(block *pem.Block) crypto.PublicKey {
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		err = errors.New("unsupported key type")
+	}
+	if err != nil {
+		return nil
+	}
+	return publicKeyOf(key)
+}
+
+func publicKeyOf(key interface{}) crypto.PublicKey {
+	if signer, ok := key.(crypto.Signer); ok {
+		return signer.Public()
+	}
+	return nil
+}
+
+func samePublicKey(a, b crypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
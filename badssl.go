@@ -0,0 +1,112 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"filippo.io/mkcert/issuer"
+)
+
+// A badCert is one of the intentionally broken certificates of the
+// -badssl-suite, each of which a correct client must reject.
+type badCert struct {
+	name, problem string
+	issue         func(m *mkcert, hosts []string, opts *issuer.Options) (*issuer.Certificate, error)
+}
+
+var badCerts = []badCert{
+	{"expired", "expired yesterday", func(m *mkcert, hosts []string, opts *issuer.Options) (*issuer.Certificate, error) {
+		opts.Now = func() time.Time { return time.Now().AddDate(-2, -3, -1) }
+		return m.ca.IssueServer(hosts, opts)
+	}},
+	{"not-yet-valid", "only becomes valid in 30 days", func(m *mkcert, hosts []string, opts *issuer.Options) (*issuer.Certificate, error) {
+		opts.Now = func() time.Time { return time.Now().AddDate(0, 0, 30) }
+		return m.ca.IssueServer(hosts, opts)
+	}},
+	{"wrong-host", "is only valid for wrong.host.invalid", func(m *mkcert, hosts []string, opts *issuer.Options) (*issuer.Certificate, error) {
+		return m.ca.IssueServer([]string{"wrong.host.invalid"}, opts)
+	}},
+	{"untrusted-root", "is issued by a CA that is not installed (in untrusted-root-ca/)", func(m *mkcert, hosts []string, opts *issuer.Options) (*issuer.Certificate, error) {
+		dir := filepath.Join(m.badsslDir, "untrusted-root-ca")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create %q: %w", dir, err)
+		}
+		ca, err := issuer.NewCA(dir, &issuer.Options{ECDSA: m.ecdsa, Curve: m.curve, RSABits: m.rsaBits})
+		if err != nil {
+			return nil, err
+		}
+		return ca.IssueServer(hosts, opts)
+	}},
+	{"revoked", "is revoked, according to the OCSP response in revoked.ocsp", func(m *mkcert, hosts []string, opts *issuer.Options) (*issuer.Certificate, error) {
+		cert, err := m.ca.IssueServer(hosts, opts)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := m.ca.SignOCSP(cert.Cert, issuer.OCSPStatus{Revoked: true})
+		if err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(filepath.Join(m.badsslDir, "revoked.ocsp"), resp, 0644); err != nil {
+			return nil, fmt.Errorf("failed to save the OCSP response: %w", err)
+		}
+		return cert, nil
+	}},
+	{"weak-key", "has a 1024-bit RSA key", func(m *mkcert, hosts []string, opts *issuer.Options) (*issuer.Certificate, error) {
+		key, err := rsa.GenerateKey(rand.Reader, 1024)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate certificate key: %w", err)
+		}
+		opts.Key = key
+		return m.ca.IssueServer(hosts, opts)
+	}},
+}
+
+// makeBadSSLSuite generates the broken certificates for hosts in the
+// -badssl-suite directory, for testing the error handling of clients. They
+// are labeled as broken in the Organizational Unit, and the untrusted root is
+// never installed.
+func (m *mkcert) makeBadSSLSuite(hosts []string) error {
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1", "::1"}
+	}
+	if err := os.MkdirAll(m.badsslDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %q: %w", m.badsslDir, err)
+	}
+
+	readme := "Intentionally broken certificates generated by mkcert -badssl-suite.\n" +
+		"Clients must reject every one of them. Do not install untrusted-root-ca.\n\n"
+	for _, b := range badCerts {
+		name := b.name
+		opts := &issuer.Options{ECDSA: m.ecdsa, Curve: m.curve, RSABits: m.rsaBits, Template: func(tpl *x509.Certificate) error {
+			tpl.Subject.OrganizationalUnit = []string{"BROKEN TEST CERTIFICATE: " + name}
+			return nil
+		}}
+		cert, err := b.issue(m, hosts, opts)
+		if err != nil {
+			return fmt.Errorf("failed to generate the %s certificate: %w", b.name, err)
+		}
+		certFile := filepath.Join(m.badsslDir, b.name+".pem")
+		keyFile := filepath.Join(m.badsslDir, b.name+"-key.pem")
+		if err := m.writeCert(cert, certFile, keyFile, ""); err != nil {
+			return err
+		}
+		readme += fmt.Sprintf("%-20s %s\n", b.name+".pem", b.problem)
+	}
+	if err := ioutil.WriteFile(filepath.Join(m.badsslDir, "README.txt"), []byte(readme), 0644); err != nil {
+		return fmt.Errorf("failed to save README.txt: %w", err)
+	}
+
+	log.Printf("Created broken test certificates for %q in %q, see README.txt for what's wrong with each of them 🧨\n\n", hosts, m.badsslDir)
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const manifestName = "mkcert-manifest.json"
+
+// A manifestEntry describes one of the certificates generated with -count.
+type manifestEntry struct {
+	Name     string    `json:"name"`
+	CertFile string    `json:"cert_file"`
+	KeyFile  string    `json:"key_file,omitempty"`
+	Serial   string    `json:"serial"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+// makeCerts generates m.count certificates, one for each expansion of the
+// pattern, and saves a manifest listing them.
+func (m *mkcert) makeCerts(pattern string) error {
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid name pattern: %w", err)
+	}
+
+	// Expand and validate all the names before generating anything.
+	var names []string
+	seen := make(map[string]bool)
+	for n := 1; n <= m.count; n++ {
+		var name strings.Builder
+		if err := tmpl.Execute(&name, struct{ N int }{n}); err != nil {
+			return fmt.Errorf("invalid name pattern: %w", err)
+		}
+		hosts := []string{name.String()}
+		if err := m.normalizeHosts(hosts); err != nil {
+			return err
+		}
+		if seen[hosts[0]] {
+			return fmt.Errorf("the name pattern generated %q twice, use {{.N}} to make the names unique", hosts[0])
+		}
+		seen[hosts[0]] = true
+		names = append(names, hosts[0])
+	}
+
+	var manifest []manifestEntry
+	for _, name := range names {
+		hosts := []string{name}
+		cert, err := m.issue(hosts)
+		if err != nil {
+			return err
+		}
+		certFile, keyFile, p12File := m.fileNames(hosts)
+		if err := m.writeCert(cert, certFile, keyFile, p12File); err != nil {
+			return err
+		}
+
+		entry := manifestEntry{
+			Name:     hosts[0],
+			CertFile: certFile,
+			KeyFile:  keyFile,
+			Serial:   fmt.Sprintf("%x", cert.Cert.SerialNumber),
+			NotAfter: cert.Cert.NotAfter,
+		}
+		if m.pkcs12 || m.jks {
+			entry.CertFile, entry.KeyFile = p12File, ""
+		}
+		manifest = append(manifest, entry)
+	}
+
+	out, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := m.writeOutput(manifestName, append(out, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to save the manifest: %w", err)
+	}
+
+	log.Printf("\nCreated %d certificates, from %q to %q 📜", len(manifest), manifest[0].Name, manifest[len(manifest)-1].Name)
+	log.Printf("\nThe list of files is at \"./%s\" ✅\n\n", manifestName)
+	log.Printf("They will expire on %s 🗓\n\n", manifest[0].NotAfter.Format("2 January 2006"))
+	return nil
+}
@@ -5,144 +5,314 @@
 package main
 
 import (
+	"context"
 	"crypto"
 	"crypto/ecdsa"
-	"crypto/elliptic"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/sha1"
 	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/asn1"
 	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"log"
-	"math/big"
-	"net"
-	"net/mail"
-	"net/url"
 	"os"
-	"os/user"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
+	"filippo.io/mkcert/issuer"
+	"filippo.io/mkcert/kms"
+	"filippo.io/mkcert/localca"
 	pkcs12 "software.sslmate.com/src/go-pkcs12"
 )
 
-var userAndHostname string
-
-func init() {
-	u, err := user.Current()
-	if err == nil {
-		userAndHostname = u.Username + "@"
-	}
-	if h, err := os.Hostname(); err == nil {
-		userAndHostname += h
-	}
-	if err == nil && u.Name != "" && u.Name != u.Username {
-		userAndHostname += " (" + u.Name + ")"
+func (m *mkcert) makeCert(hosts []string) error {
+	certFile, keyFile, p12File := m.fileNames(hosts)
+	if m.refreshMode {
+		if fresh, err := m.refresh(hosts, certFile, keyFile); err != nil || fresh {
+			return err
+		}
 	}
-}
 
-func (m *mkcert) makeCert(hosts []string) {
-	if m.caKey == nil {
-		log.Fatalln("ERROR: can't create new certificates because the CA key (rootCA-key.pem) is missing")
+	var keyLocation string
+	if m.hardwareKey {
+		name := fmt.Sprintf("mkcert %s %s", hosts[0], time.Now().Format("20060102150405"))
+		key, location, err := newHardwareKey(name, m.ecdsa)
+		if err != nil {
+			return err
+		}
+		m.leafKey, keyLocation = key, location
 	}
 
-	priv, err := m.generateKey(false)
-	fatalIfErr(err, "failed to generate certificate key")
-	pub := priv.(crypto.Signer).Public()
-
-	// Certificates last for 2 years and 3 months, which is always less than
-	// 825 days, the limit that macOS/iOS apply to all certificates,
-	// including custom roots. See https://support.apple.com/en-us/HT210176.
-	expiration := time.Now().AddDate(2, 3, 0)
+	cert, err := m.issue(hosts)
+	if err != nil {
+		return err
+	}
 
-	tpl := &x509.Certificate{
-		SerialNumber: randomSerialNumber(),
-		Subject: pkix.Name{
-			Organization:       []string{"mkcert development certificate"},
-			OrganizationalUnit: []string{userAndHostname},
-		},
+	if m.pivSlot != "" {
+		if err := m.importPIV(cert, certFile); err != nil {
+			return err
+		}
+	} else if m.hardwareKey {
+		certPEM := append(cert.CertPEM(), m.chainPEM()...)
+		if err := m.writeOutput(certFile, certPEM, 0644); err != nil {
+			return fmt.Errorf("failed to save certificate: %w", err)
+		}
+		m.written = append(m.written, certFile)
+	} else if err := m.writeCert(cert, certFile, keyFile, p12File); err != nil {
+		return err
+	}
 
-		NotBefore: time.Now(), NotAfter: expiration,
+	m.printHosts(hosts)
 
-		KeyUsage: x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+	if len(m.chain) != 0 && !m.pkcs12 && !m.jks {
+		log.Println()
+		if err := m.writeChain(certFile); err != nil {
+			return err
+		}
+	}
+	if m.fullChain != "" {
+		log.Println()
+		if err := m.writeFullChain(cert.Cert); err != nil {
+			return err
+		}
 	}
 
-	for _, h := range hosts {
-		if ip := net.ParseIP(h); ip != nil {
-			tpl.IPAddresses = append(tpl.IPAddresses, ip)
-		} else if email, err := mail.ParseAddress(h); err == nil && email.Address == h {
-			tpl.EmailAddresses = append(tpl.EmailAddresses, h)
-		} else if uriName, err := url.Parse(h); err == nil && uriName.Scheme != "" && uriName.Host != "" {
-			tpl.URIs = append(tpl.URIs, uriName)
+	if m.hardwareKey {
+		log.Printf("\nThe certificate is at \"%s\", and the key is non-exportable in %s 🔐\n\n", certFile, keyLocation)
+	} else if m.pivSlot != "" {
+		log.Printf("\nThe key and certificate are in the %s slot (%s) of the YubiKey, and the certificate is also at \"%s\" 🔑\n\n", m.pivSlot, pivSlots[m.pivSlot], certFile)
+	} else if m.jks {
+		log.Printf("\nThe Java keystore is at \"%s\", with the key and certificate under the alias %q ✅\n", p12File, jksAlias)
+		log.Printf("\nThe keystore password is \"%s\" ℹ️\n\n", m.storePass)
+	} else if !m.pkcs12 {
+		if certFile == keyFile {
+			log.Printf("\nThe certificate and key are at \"%s\" ✅\n\n", certFile)
 		} else {
-			tpl.DNSNames = append(tpl.DNSNames, h)
+			log.Printf("\nThe certificate is at \"%s\" and the key at \"%s\" ✅\n\n", certFile, keyFile)
+		}
+		if m.keyPass != "" {
+			log.Printf("The key is encrypted as PKCS #8 with the -keypass password ℹ️\n\n")
 		}
+	} else {
+		log.Printf("\nThe PKCS#12 bundle is at \"%s\" ✅\n", p12File)
+		log.Printf("\nThe legacy PKCS#12 encryption password is the often hardcoded default \"changeit\" ℹ️\n\n")
 	}
 
-	if m.client {
-		tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+	log.Printf("It will expire on %s 🗓\n\n", cert.Cert.NotAfter.Format("2 January 2006"))
+
+	if m.pin {
+		m.printPins(cert.Cert)
+	}
+
+	if m.smimeImport {
+		if err := m.importSMIME(p12File); err != nil {
+			return err
+		}
 	}
-	if len(tpl.IPAddresses) > 0 || len(tpl.DNSNames) > 0 || len(tpl.URIs) > 0 {
-		tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+	if m.output == "dotnet" {
+		return m.printDotnetConfig(p12File)
 	}
-	if len(tpl.EmailAddresses) > 0 {
-		tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, x509.ExtKeyUsageEmailProtection)
+	if m.output != "" {
+		return m.printDatabaseConfig(hosts, certFile, keyFile)
 	}
+	return nil
+}
+
+// issue generates a new certificate for hosts according to the flags.
+func (m *mkcert) issue(hosts []string) (*issuer.Certificate, error) {
+	opts := &issuer.Options{ECDSA: m.ecdsa, Curve: m.curve, Ed25519: m.ed25519, RSABits: m.rsaBits,
+		NotAfter: m.notAfter, Template: m.template, Key: m.leafKey, Extensions: m.extensions}
 
 	// IIS (the main target of PKCS #12 files), only shows the deprecated
 	// Common Name in the UI. See issue #115.
 	if m.pkcs12 {
-		tpl.Subject.CommonName = hosts[0]
+		opts.CommonName = hosts[0]
 	}
 
-	cert, err := x509.CreateCertificate(rand.Reader, tpl, m.caCert, pub, m.caKey)
-	fatalIfErr(err, "failed to generate certificate")
-
-	certFile, keyFile, p12File := m.fileNames(hosts)
+	if m.vault != nil {
+		return m.vault.Issue(context.Background(), hosts)
+	}
+	ca := m.ca
+	if m.leafCA != nil {
+		ca = m.leafCA
+	}
+	if m.client {
+		return ca.IssueClient(hosts, opts)
+	}
+	return ca.IssueServer(hosts, opts)
+}
 
-	if !m.pkcs12 {
-		certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
-		privDER, err := x509.MarshalPKCS8PrivateKey(priv)
-		fatalIfErr(err, "failed to encode certificate key")
-		privPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})
+// template applies the flags that add extensions to every issued leaf.
+func (m *mkcert) template(tpl *x509.Certificate) error {
+	if m.certTemplate != nil {
+		m.certTemplate.apply(tpl)
+	}
+	if m.output == "dotnet" {
+		tpl.ExtraExtensions = append(tpl.ExtraExtensions, aspNetHTTPSExtension)
+	}
+	if m.eap {
+		m.eapTemplate(tpl)
+	}
+	if m.smime {
+		m.smimeTemplate(tpl)
+	}
+	if m.aiaURL != "" {
+		tpl.IssuingCertificateURL = []string{m.aiaURL}
+	}
+	if m.ocspURL != "" {
+		tpl.OCSPServer = []string{m.ocspURL}
+	}
+	if m.crlURL != "" {
+		tpl.CRLDistributionPoints = []string{m.crlURL}
+	}
+	if m.sigHash != 0 {
+		ca := m.ca
+		if m.leafCA != nil {
+			ca = m.leafCA
+		}
+		alg, err := signatureAlgorithm(ca.Cert.PublicKey, m.sigHash)
+		if err != nil {
+			return err
+		}
+		tpl.SignatureAlgorithm = alg
+	}
+	if len(m.rawSANs) != 0 {
+		ext, err := rawSANExtension(tpl, m.rawSANs)
+		if err != nil {
+			return err
+		}
+		tpl.ExtraExtensions = append(tpl.ExtraExtensions, ext)
+	}
+	return nil
+}
 
-		if certFile == keyFile {
-			err = ioutil.WriteFile(keyFile, append(certPEM, privPEM...), 0600)
-			fatalIfErr(err, "failed to save certificate and key")
-		} else {
-			err = ioutil.WriteFile(certFile, certPEM, 0644)
-			fatalIfErr(err, "failed to save certificate")
-			err = ioutil.WriteFile(keyFile, privPEM, 0600)
-			fatalIfErr(err, "failed to save certificate key")
+// parseSigAlg parses the -sig-alg flag. SHA-1 is only accepted with
+// -insecure-sig-alg, as it's only useful to test that clients reject it.
+func parseSigAlg(name string, insecure bool) (crypto.Hash, error) {
+	switch strings.ToLower(strings.ReplaceAll(name, "-", "")) {
+	case "":
+		return 0, nil
+	case "sha256":
+		return crypto.SHA256, nil
+	case "sha384":
+		return crypto.SHA384, nil
+	case "sha512":
+		return crypto.SHA512, nil
+	case "sha1":
+		if !insecure {
+			return 0, errors.New("-sig-alg sha1 produces certificates that modern clients reject, add -insecure-sig-alg to use it anyway")
 		}
-	} else {
-		domainCert, _ := x509.ParseCertificate(cert)
-		pfxData, err := pkcs12.Encode(rand.Reader, priv, domainCert, []*x509.Certificate{m.caCert}, "changeit")
-		fatalIfErr(err, "failed to generate PKCS#12")
-		err = ioutil.WriteFile(p12File, pfxData, 0644)
-		fatalIfErr(err, "failed to save PKCS#12")
+		return crypto.SHA1, nil
+	default:
+		return 0, fmt.Errorf("unknown -sig-alg %q, options are: sha256, sha384, sha512 and sha1", name)
 	}
+}
 
-	m.printHosts(hosts)
+// signatureAlgorithm returns the algorithm for signing with the CA key pub
+// and the hash h.
+func signatureAlgorithm(pub crypto.PublicKey, h crypto.Hash) (x509.SignatureAlgorithm, error) {
+	algs := map[crypto.Hash][2]x509.SignatureAlgorithm{
+		crypto.SHA1:   {x509.SHA1WithRSA, x509.ECDSAWithSHA1},
+		crypto.SHA256: {x509.SHA256WithRSA, x509.ECDSAWithSHA256},
+		crypto.SHA384: {x509.SHA384WithRSA, x509.ECDSAWithSHA384},
+		crypto.SHA512: {x509.SHA512WithRSA, x509.ECDSAWithSHA512},
+	}
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return algs[h][0], nil
+	case *ecdsa.PublicKey:
+		return algs[h][1], nil
+	default:
+		return 0, errors.New("-sig-alg is only supported with RSA and ECDSA CA keys")
+	}
+}
 
-	if !m.pkcs12 {
-		if certFile == keyFile {
-			log.Printf("\nThe certificate and key are at \"%s\" ✅\n\n", certFile)
-		} else {
-			log.Printf("\nThe certificate is at \"%s\" and the key at \"%s\" ✅\n\n", certFile, keyFile)
+// writeCert saves cert as PEM files, with the key encrypted with -keypass, as
+// DER files with -der, as a PKCS #12 bundle with -pkcs12, or as a Java
+// keystore at p12File with -jks.
+func (m *mkcert) writeCert(cert *issuer.Certificate, certFile, keyFile, p12File string) error {
+	if m.jks {
+		data, err := m.encodeKeystore(cert, p12File, m.storePass)
+		if err != nil {
+			return fmt.Errorf("failed to generate the keystore: %w", err)
+		}
+		if err := m.writeOutput(p12File, data, 0600); err != nil {
+			return fmt.Errorf("failed to save the keystore: %w", err)
 		}
+		m.written = append(m.written, p12File)
+		return nil
+	}
+	if m.pkcs12 {
+		if _, ok := cert.Key.(ed25519.PrivateKey); ok {
+			return errors.New("can't export an Ed25519 key as PKCS #12, as most of the applications that import them don't support it")
+		}
+		caCerts := append(append([]*x509.Certificate{}, m.chain...), m.ca.Cert)
+		pfxData, err := pkcs12.Encode(rand.Reader, cert.Key, cert.Cert, caCerts, "changeit")
+		if err != nil {
+			return fmt.Errorf("failed to generate PKCS#12: %w", err)
+		}
+		if err := m.writeOutput(p12File, pfxData, 0644); err != nil {
+			return fmt.Errorf("failed to save PKCS#12: %w", err)
+		}
+		m.written = append(m.written, p12File)
+		return nil
+	}
+
+	if m.der {
+		return m.writeCertDER(cert, certFile, keyFile)
+	}
+
+	certPEM := append(cert.CertPEM(), m.chainPEM()...)
+	var privPEM []byte
+	var err error
+	if m.keyPass != "" {
+		privPEM, err = cert.EncryptedKeyPEM(m.keyPass)
 	} else {
-		log.Printf("\nThe PKCS#12 bundle is at \"%s\" ✅\n", p12File)
-		log.Printf("\nThe legacy PKCS#12 encryption password is the often hardcoded default \"changeit\" ℹ️\n\n")
+		privPEM, err = cert.KeyPEM()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode certificate key: %w", err)
+	}
+	if certFile == keyFile {
+		if err := m.writeOutput(keyFile, append(certPEM, privPEM...), 0600); err != nil {
+			return fmt.Errorf("failed to save certificate and key: %w", err)
+		}
+		m.written = append(m.written, keyFile)
+		return nil
 	}
+	if err := m.writeOutput(certFile, certPEM, 0644); err != nil {
+		return fmt.Errorf("failed to save certificate: %w", err)
+	}
+	if err := m.writeOutput(keyFile, privPEM, 0600); err != nil {
+		return fmt.Errorf("failed to save certificate key: %w", err)
+	}
+	m.written = append(m.written, certFile, keyFile)
+	return nil
+}
 
-	log.Printf("It will expire on %s 🗓\n\n", expiration.Format("2 January 2006"))
+// writeCertDER saves the certificate and its PKCS #8 key as DER files. The
+// chain can't be saved with them, so -der doesn't support -intermediates.
+func (m *mkcert) writeCertDER(cert *issuer.Certificate, certFile, keyFile string) error {
+	if certFile == keyFile {
+		return errors.New("can't save the certificate and key in the same DER file, set different -cert-file and -key-file")
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(cert.Key)
+	if err != nil {
+		return fmt.Errorf("failed to encode certificate key: %w", err)
+	}
+	if err := m.writeOutput(certFile, cert.Cert.Raw, 0644); err != nil {
+		return fmt.Errorf("failed to save certificate: %w", err)
+	}
+	if err := m.writeOutput(keyFile, privDER, 0600); err != nil {
+		return fmt.Errorf("failed to save certificate key: %w", err)
+	}
+	m.written = append(m.written, certFile, keyFile)
+	return nil
 }
 
 func (m *mkcert) printHosts(hosts []string) {
@@ -154,6 +324,9 @@ func (m *mkcert) printHosts(hosts []string) {
 			log.Printf("   Warning: many browsers don't support second-level wildcards like %q ⚠️", h)
 		}
 	}
+	for _, san := range m.rawSANs {
+		log.Printf(" - %q (raw)", san.flag)
+	}
 
 	for _, h := range hosts {
 		if strings.HasPrefix(h, "*.") {
@@ -163,35 +336,25 @@ func (m *mkcert) printHosts(hosts []string) {
 	}
 }
 
-func (m *mkcert) generateKey(rootCA bool) (crypto.PrivateKey, error) {
-	if m.ecdsa {
-		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	}
-	if rootCA {
-		return rsa.GenerateKey(rand.Reader, 3072)
-	}
-	return rsa.GenerateKey(rand.Reader, 2048)
-}
-
 func (m *mkcert) fileNames(hosts []string) (certFile, keyFile, p12File string) {
-	defaultName := strings.Replace(hosts[0], ":", "_", -1)
-	defaultName = strings.Replace(defaultName, "*", "_wildcard", -1)
-	if len(hosts) > 1 {
-		defaultName += "+" + strconv.Itoa(len(hosts)-1)
-	}
-	if m.client {
-		defaultName += "-client"
-	}
+	defaultName := localca.BaseName(hosts, m.client)
 
 	certFile = "./" + defaultName + ".pem"
+	keyFile = "./" + defaultName + "-key.pem"
+	if m.der {
+		certFile = "./" + defaultName + ".crt"
+		keyFile = "./" + defaultName + ".key"
+	}
 	if m.certFile != "" {
 		certFile = m.certFile
 	}
-	keyFile = "./" + defaultName + "-key.pem"
 	if m.keyFile != "" {
 		keyFile = m.keyFile
 	}
 	p12File = "./" + defaultName + ".p12"
+	if m.jks {
+		p12File = "./" + defaultName + ".jks"
+	}
 	if m.p12File != "" {
 		p12File = m.p12File
 	}
@@ -199,170 +362,211 @@ func (m *mkcert) fileNames(hosts []string) (certFile, keyFile, p12File string) {
 	return
 }
 
-func randomSerialNumber() *big.Int {
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
-	fatalIfErr(err, "failed to generate serial number")
-	return serialNumber
-}
-
-func (m *mkcert) makeCertFromCSR() {
-	if m.caKey == nil {
-		log.Fatalln("ERROR: can't create new certificates because the CA key (rootCA-key.pem) is missing")
+// makeCertsFromCSRs signs each CSR in m.csrPaths, expanding directories to
+// the ".csr" files they contain. A single CSR is handled like names on the
+// command line, while multiple CSRs each get a certificate next to them.
+func (m *mkcert) makeCertsFromCSRs() error {
+	var paths []string
+	for _, path := range m.csrPaths {
+		if path == "-" || !isDir(path) {
+			paths = append(paths, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*.csr"))
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("no \".csr\" files found in %q", path)
+		}
+		paths = append(paths, matches...)
 	}
 
-	csrPEMBytes, err := ioutil.ReadFile(m.csrPath)
-	fatalIfErr(err, "failed to read the CSR")
-	csrPEM, _ := pem.Decode(csrPEMBytes)
-	if csrPEM == nil {
-		log.Fatalln("ERROR: failed to read the CSR: unexpected content")
+	if len(paths) == 1 && !isDir(m.csrPaths[0]) {
+		return m.makeCertFromCSR(paths[0], "")
 	}
-	if csrPEM.Type != "CERTIFICATE REQUEST" &&
-		csrPEM.Type != "NEW CERTIFICATE REQUEST" {
-		log.Fatalln("ERROR: failed to read the CSR: expected CERTIFICATE REQUEST, got " + csrPEM.Type)
+	if m.fullChain != "" {
+		return errors.New("-fullchain-file can only be used with a single CSR")
 	}
-	csr, err := x509.ParseCertificateRequest(csrPEM.Bytes)
-	fatalIfErr(err, "failed to parse the CSR")
-	fatalIfErr(csr.CheckSignature(), "invalid CSR signature")
-
-	expiration := time.Now().AddDate(2, 3, 0)
-	tpl := &x509.Certificate{
-		SerialNumber:    randomSerialNumber(),
-		Subject:         csr.Subject,
-		ExtraExtensions: csr.Extensions, // includes requested SANs, KUs and EKUs
-
-		NotBefore: time.Now(), NotAfter: expiration,
-
-		// If the CSR does not request a SAN extension, fix it up for them as
-		// the Common Name field does not work in modern browsers. Otherwise,
-		// this will get overridden.
-		DNSNames: []string{csr.Subject.CommonName},
+	var stdin bool
+	for _, path := range paths {
+		if path == "-" {
+			if stdin {
+				return errors.New("can't read more than one CSR from stdin")
+			}
+			stdin = true
+		}
+	}
+	for _, path := range paths {
+		certFile := ""
+		if path != "-" {
+			ext := ".pem"
+			if m.der {
+				ext = ".crt"
+			}
+			if filepath.Ext(path) == ".csr" {
+				certFile = strings.TrimSuffix(path, ".csr") + ext
+			} else {
+				certFile = path + "-cert" + ext
+			}
+		}
+		if err := m.makeCertFromCSR(path, certFile); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
 
-		// Likewise, if the CSR does not set KUs and EKUs, fix it up as Apple
-		// platforms require serverAuth for TLS.
-		KeyUsage:    x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+// makeCertFromCSR signs the CSR at path, or from stdin if path is "-", and
+// saves the certificate at certFile, or at the default location if empty.
+func (m *mkcert) makeCertFromCSR(path, certFile string) error {
+	var csrPEMBytes []byte
+	var err error
+	if path == "-" {
+		csrPEMBytes, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		csrPEMBytes, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the CSR: %w", err)
+	}
+	csr, err := issuer.ParseCSR(csrPEMBytes)
+	if err != nil {
+		return err
 	}
 
-	if m.client {
-		tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+	var cert *issuer.Certificate
+	if m.vault != nil {
+		cert, err = m.vault.SignCSR(context.Background(), csr)
+	} else {
+		cert, err = m.ca.SignCSR(csr, &issuer.Options{CSRPolicy: m.csrPolicy, NotAfter: m.notAfter, Template: m.template, Extensions: m.extensions, DryRun: m.dryRun})
 	}
-	if len(csr.EmailAddresses) > 0 {
-		tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, x509.ExtKeyUsageEmailProtection)
+	if err != nil {
+		return err
+	}
+	if m.dryRun {
+		m.printCSRPreview(path, csr, cert.Cert)
+		return nil
 	}
 
-	cert, err := x509.CreateCertificate(rand.Reader, tpl, m.caCert, csr.PublicKey, m.caKey)
-	fatalIfErr(err, "failed to generate certificate")
-	c, err := x509.ParseCertificate(cert)
-	fatalIfErr(err, "failed to parse generated certificate")
+	hosts := issuer.Hosts(cert.Cert)
+	if certFile == "" {
+		certFile, _, _ = m.fileNames(hosts)
+	}
 
-	var hosts []string
-	hosts = append(hosts, c.DNSNames...)
-	hosts = append(hosts, c.EmailAddresses...)
-	for _, ip := range c.IPAddresses {
-		hosts = append(hosts, ip.String())
+	certData := cert.CertPEM()
+	if m.der {
+		certData = cert.Cert.Raw
 	}
-	for _, uri := range c.URIs {
-		hosts = append(hosts, uri.String())
+	err = m.writeOutput(certFile, certData, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to save certificate: %w", err)
 	}
-	certFile, _, _ := m.fileNames(hosts)
-
-	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(
-		&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0644)
-	fatalIfErr(err, "failed to save certificate")
+	m.written = append(m.written, certFile)
 
 	m.printHosts(hosts)
 
 	log.Printf("\nThe certificate is at \"%s\" ✅\n\n", certFile)
+	if m.fullChain != "" {
+		if err := m.writeFullChain(cert.Cert); err != nil {
+			return err
+		}
+	}
 
-	log.Printf("It will expire on %s 🗓\n\n", expiration.Format("2 January 2006"))
-}
+	log.Printf("It will expire on %s 🗓\n\n", cert.Cert.NotAfter.Format("2 January 2006"))
 
-// loadCA will load or create the CA at CAROOT.
-func (m *mkcert) loadCA() {
-	if !pathExists(filepath.Join(m.CAROOT, rootName)) {
-		m.newCA()
+	if m.pin {
+		m.printPins(cert.Cert)
 	}
+	return nil
+}
 
-	certPEMBlock, err := ioutil.ReadFile(filepath.Join(m.CAROOT, rootName))
-	fatalIfErr(err, "failed to read the CA certificate")
-	certDERBlock, _ := pem.Decode(certPEMBlock)
-	if certDERBlock == nil || certDERBlock.Type != "CERTIFICATE" {
-		log.Fatalln("ERROR: failed to read the CA certificate: unexpected content")
-	}
-	m.caCert, err = x509.ParseCertificate(certDERBlock.Bytes)
-	fatalIfErr(err, "failed to parse the CA certificate")
+// printPins prints the SPKI pins of cert and of the local CA, in the base64
+// format used by Android's network security config, OkHttp and TrustKit.
+func (m *mkcert) printPins(cert *x509.Certificate) {
+	log.Printf("The SHA-256 SPKI pin of the certificate is %q 📌", spkiPin(cert))
+	log.Printf("The SHA-256 SPKI pin of the local CA is %q (pin it to keep trusting new certificates) 📌\n\n", spkiPin(m.ca.Cert))
+}
 
-	if !pathExists(filepath.Join(m.CAROOT, rootKeyName)) {
-		return // keyless mode, where only -install works
+// readCertFile returns the first certificate in a PEM file.
+func readCertFile(file string) (*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the certificate: %w", err)
 	}
-
-	keyPEMBlock, err := ioutil.ReadFile(filepath.Join(m.CAROOT, rootKeyName))
-	fatalIfErr(err, "failed to read the CA key")
-	keyDERBlock, _ := pem.Decode(keyPEMBlock)
-	if keyDERBlock == nil || keyDERBlock.Type != "PRIVATE KEY" {
-		log.Fatalln("ERROR: failed to read the CA key: unexpected content")
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("failed to read %q: no PEM certificate found", file)
 	}
-	m.caKey, err = x509.ParsePKCS8PrivateKey(keyDERBlock.Bytes)
-	fatalIfErr(err, "failed to parse the CA key")
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", file, err)
+	}
+	return cert, nil
 }
 
-func (m *mkcert) newCA() {
-	priv, err := m.generateKey(true)
-	fatalIfErr(err, "failed to generate the CA key")
-	pub := priv.(crypto.Signer).Public()
-
-	spkiASN1, err := x509.MarshalPKIXPublicKey(pub)
-	fatalIfErr(err, "failed to encode public key")
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
 
-	var spki struct {
-		Algorithm        pkix.AlgorithmIdentifier
-		SubjectPublicKey asn1.BitString
+// loadCA will load or create the CA at CAROOT.
+func (m *mkcert) loadCA() error {
+	var kmsKey crypto.Signer
+	if m.caKMS != "" {
+		var err error
+		kmsKey, err = kms.NewSigner(context.Background(), m.caKMS)
+		if err != nil {
+			return err
+		}
 	}
-	_, err = asn1.Unmarshal(spkiASN1, &spki)
-	fatalIfErr(err, "failed to decode public key")
-
-	skid := sha1.Sum(spki.SubjectPublicKey.Bytes)
-
-	tpl := &x509.Certificate{
-		SerialNumber: randomSerialNumber(),
-		Subject: pkix.Name{
-			Organization:       []string{"mkcert development CA"},
-			OrganizationalUnit: []string{userAndHostname},
-
-			// The CommonName is required by iOS to show the certificate in the
-			// "Certificate Trust Settings" menu.
-			// https://github.com/FiloSottile/mkcert/issues/47
-			CommonName: "mkcert " + userAndHostname,
-		},
-		SubjectKeyId: skid[:],
-
-		NotAfter:  time.Now().AddDate(10, 0, 0),
-		NotBefore: time.Now(),
 
-		KeyUsage: x509.KeyUsageCertSign,
-
-		BasicConstraintsValid: true,
-		IsCA:                  true,
-		MaxPathLenZero:        true,
+	exists := pathExists(filepath.Join(m.CAROOT, issuer.RootName))
+	if !exists {
+		m.notePeerCA()
+	}
+	opts := &issuer.Options{ECDSA: m.ecdsa, Curve: m.curve, RSABits: m.rsaBits, Key: kmsKey, KeyDir: m.caKeyDir}
+	if m.intermediates > 0 {
+		opts.Template = allowIntermediates
+	}
+	local, err := localca.Open(m.CAROOT, opts)
+	switch {
+	case m.systemCAROOT && errors.Is(err, fs.ErrPermission) && !exists:
+		return errNoSharedCA
+	case m.systemCAROOT && errors.Is(err, fs.ErrPermission):
+		return m.loadSharedCA()
+	case err != nil:
+		return err
+	}
+	if local.Created {
+		log.Printf("Created a new local CA 💥\n")
+	}
+	ca := local.CA
+	if kmsKey != nil {
+		// The CA certificate is shared out of band, like with -link-caroot,
+		// so make sure it's the one for this key.
+		if pub, ok := ca.Cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(kmsKey.Public()) {
+			return fmt.Errorf("the CA certificate in %q doesn't match the KMS key, replace it with the team's rootCA.pem", m.CAROOT)
+		}
+		ca.Key = kmsKey
 	}
-
-	cert, err := x509.CreateCertificate(rand.Reader, tpl, tpl, pub, priv)
-	fatalIfErr(err, "failed to generate CA certificate")
-
-	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
-	fatalIfErr(err, "failed to encode CA key")
-	err = ioutil.WriteFile(filepath.Join(m.CAROOT, rootKeyName), pem.EncodeToMemory(
-		&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0400)
-	fatalIfErr(err, "failed to save CA key")
-
-	err = ioutil.WriteFile(filepath.Join(m.CAROOT, rootName), pem.EncodeToMemory(
-		&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0644)
-	fatalIfErr(err, "failed to save CA certificate")
-
-	log.Printf("Created a new local CA 💥\n")
+	m.ca = ca
+	m.rootPath = filepath.Join(m.CAROOT, issuer.RootName)
+	return nil
 }
 
-func (m *mkcert) caUniqueName() string {
-	return "mkcert development CA " + m.caCert.SerialNumber.String()
+// loadVaultCA fetches the root of the Vault PKI mount, and saves it in the
+// CAROOT for the trust stores, in place of the local CA.
+func (m *mkcert) loadVaultCA() error {
+	root, err := m.vault.Root(context.Background())
+	if err != nil {
+		return err
+	}
+	m.ca = &issuer.CA{Cert: root}
+	m.rootPath = filepath.Join(m.CAROOT, "vault-"+strings.ReplaceAll(m.vault.Mount, "/", "_")+"-rootCA.pem")
+	rootPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+	if err := ioutil.WriteFile(m.rootPath, rootPEM, 0644); err != nil {
+		return fmt.Errorf("failed to save the Vault CA certificate: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,85 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"strings"
+
+	"filippo.io/mkcert/issuer"
+)
+
+// allowIntermediates lifts the path length constraint of a new local CA, so
+// that it can be used with -intermediates.
+func allowIntermediates(tpl *x509.Certificate) error {
+	tpl.MaxPathLen, tpl.MaxPathLenZero = -1, false
+	return nil
+}
+
+// makeChain generates the -intermediates, from the one issued by the local
+// CA to the one that will issue the leaf. Each of them is constrained to the
+// number of intermediates below it, to exercise path length handling.
+func (m *mkcert) makeChain() error {
+	root := m.ca.Cert
+	if root.MaxPathLenZero || (root.MaxPathLen > 0 && root.MaxPathLen < m.intermediates) {
+		return fmt.Errorf("the local CA in %q can't issue %d intermediates, so create a separate one for chain tests with \"CAROOT=/path/to/chain-ca mkcert -intermediates %d -install\"", m.CAROOT, m.intermediates, m.intermediates)
+	}
+
+	m.leafCA = m.ca
+	for i := m.intermediates; i > 0; i-- {
+		below := i - 1
+		inter, err := m.leafCA.NewIntermediate(&issuer.Options{
+			ECDSA:      m.ecdsa,
+			CommonName: fmt.Sprintf("mkcert intermediate %d", i),
+			Template: func(tpl *x509.Certificate) error {
+				tpl.MaxPathLen, tpl.MaxPathLenZero = below, below == 0
+				return nil
+			},
+		})
+		if err != nil {
+			return err
+		}
+		m.leafCA = inter
+		m.chain = append([]*x509.Certificate{inter.Cert}, m.chain...)
+	}
+	return nil
+}
+
+// chainPEM returns the PEM encoding of the intermediates, leaf issuer first.
+func (m *mkcert) chainPEM() []byte {
+	var chain []byte
+	for _, c := range m.chain {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	return chain
+}
+
+// writeFullChain implements -fullchain-file. It saves cert, the
+// intermediates and the local CA, in this order, as web servers like nginx
+// and HAProxy expect.
+func (m *mkcert) writeFullChain(cert *x509.Certificate) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	data = append(data, m.chainPEM()...)
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.ca.Cert.Raw})...)
+	if err := m.writeOutput(m.fullChain, data, 0644); err != nil {
+		return fmt.Errorf("failed to save the full chain: %w", err)
+	}
+	m.written = append(m.written, m.fullChain)
+	log.Printf("The full chain, up to the local CA, is at \"%s\" 🔗\n\n", m.fullChain)
+	return nil
+}
+
+// writeChain saves the intermediates next to certFile.
+func (m *mkcert) writeChain(certFile string) error {
+	chainFile := strings.TrimSuffix(certFile, ".pem") + "-chain.pem"
+	if err := m.writeOutput(chainFile, m.chainPEM(), 0644); err != nil {
+		return fmt.Errorf("failed to save the chain: %w", err)
+	}
+	log.Printf("The %d intermediates are at \"%s\", and also follow the certificate in its file 🔗\n\n", len(m.chain), chainFile)
+	return nil
+}
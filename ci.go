@@ -0,0 +1,27 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"filippo.io/mkcert/issuer"
+)
+
+// finishCI ends a -ci run by deleting the key of the throwaway CA, so that
+// it can't issue any other certificate, and printing its root to stdout,
+// for test harnesses that load it explicitly.
+func (m *mkcert) finishCI() error {
+	if err := os.Remove(filepath.Join(m.CAROOT, issuer.RootKeyName)); err != nil {
+		return fmt.Errorf("failed to delete the throwaway CA key: %w", err)
+	}
+	log.Printf("The throwaway CA certificate is at %q, and its key was deleted 🧪\n\n", m.rootPath)
+	_, err := os.Stdout.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.ca.Cert.Raw}))
+	return err
+}
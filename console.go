@@ -0,0 +1,232 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"filippo.io/mkcert/truststore"
+)
+
+// stdout is where the reports of -inspect and -probe are printed. Like the
+// log output, it's wrapped by setupConsole.
+var stdout io.Writer = os.Stdout
+
+// setupConsole makes the messages of mkcert plain text, without emoji, if
+// -no-emoji or NO_COLOR (https://no-color.org) are set, or if the output is
+// not a terminal that can render them, like a CI log or a legacy Windows
+// console. With -log-format json, each message is a JSON event instead.
+func setupConsole(noEmoji bool, format string) error {
+	switch format {
+	case "", "text":
+	case "json":
+		events = &eventWriter{w: os.Stderr}
+		log.SetOutput(events)
+		return nil
+	default:
+		return fmt.Errorf("unknown -log-format %q, expected \"text\" or \"json\"", format)
+	}
+	if noEmoji || os.Getenv("NO_COLOR") != "" || !emojiTerminal(os.Stderr) {
+		log.SetOutput(&plainWriter{os.Stderr})
+	}
+	if noEmoji || os.Getenv("NO_COLOR") != "" || !emojiTerminal(os.Stdout) {
+		stdout = &plainWriter{os.Stdout}
+	}
+	return nil
+}
+
+// emojiTerminal reports whether f is a terminal likely to render emoji.
+func emojiTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	// The legacy console host renders emoji as boxes, unlike Windows
+	// Terminal and the terminals of editors like VS Code.
+	if runtime.GOOS == "windows" {
+		return os.Getenv("WT_SESSION") != "" || os.Getenv("TERM_PROGRAM") != ""
+	}
+	return true
+}
+
+// A plainWriter removes the emoji from each message written to it, along
+// with the space before them.
+type plainWriter struct {
+	w io.Writer
+}
+
+func (pw *plainWriter) Write(p []byte) (int, error) {
+	var b strings.Builder
+	s := string(p)
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		if r == utf8.RuneError {
+			b.WriteString(s[:size])
+		} else if isEmoji(r) {
+			plain := strings.TrimRight(b.String(), " ")
+			b.Reset()
+			b.WriteString(plain)
+		} else {
+			b.WriteRune(r)
+		}
+		s = s[size:]
+	}
+	if _, err := io.WriteString(pw.w, b.String()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func isEmoji(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF: // pictographs, emoticons, and symbols
+		return true
+	case r >= 0x2300 && r <= 0x23FF: // miscellaneous technical, like ⏳ and ⏰
+		return true
+	case r >= 0x2600 && r <= 0x27BF: // miscellaneous symbols and dingbats
+		return true
+	case r >= 0x2B00 && r <= 0x2BFF: // miscellaneous symbols and arrows
+		return true
+	case r == 0x2139 || r == 0xFE0F || r == 0x200D: // ℹ, emoji presentation, ZWJ
+		return true
+	}
+	return false
+}
+
+// events is the destination of the log messages and trust store results
+// with -log-format json, or nil.
+var events *eventWriter
+
+// An event is a line of -log-format json output. Messages only have Level
+// and Message set, while the outcome of an operation on a trust store has
+// Operation, Store, Result and, if it failed, Command and Error.
+type event struct {
+	Time      time.Time `json:"time"`
+	Level     string    `json:"level"`
+	Message   string    `json:"message,omitempty"`
+	Operation string    `json:"operation,omitempty"`
+	Store     string    `json:"store,omitempty"`
+	Result    string    `json:"result,omitempty"`
+	Command   string    `json:"command,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// An eventWriter turns each log message written to it into an event.
+type eventWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (ew *eventWriter) Write(p []byte) (int, error) {
+	var plain strings.Builder
+	(&plainWriter{&plain}).Write(p)
+	msg := strings.TrimSpace(plain.String())
+	if msg == "" {
+		return len(p), nil
+	}
+	level := "info"
+	switch {
+	case strings.HasPrefix(msg, "ERROR: "):
+		level, msg = "error", strings.TrimPrefix(msg, "ERROR: ")
+	case strings.HasPrefix(msg, "Warning: "):
+		level = "warning"
+	}
+	if err := ew.emit(event{Level: level, Message: msg}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (ew *eventWriter) emit(e event) error {
+	e.Time = time.Now().UTC()
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	_, err = ew.w.Write(append(line, '\n'))
+	return err
+}
+
+// logResult emits the outcome of operation ("check", "install" or
+// "uninstall") on a trust store as an event, with -log-format json.
+func logResult(operation string, r truststore.Result) {
+	if events == nil {
+		return
+	}
+	e := event{Level: "info", Operation: operation, Store: r.Store, Result: r.Status.String()}
+	if r.Err != nil {
+		e.Level, e.Error = "error", r.Err.Error()
+		var cmdErr *truststore.CmdError
+		if errors.As(r.Err, &cmdErr) {
+			e.Command, e.Error = cmdErr.Cmd, cmdErr.Err.Error()
+			if out := strings.TrimSpace(string(cmdErr.Out)); out != "" {
+				e.Error += ": " + out
+			}
+		}
+		var nssErr *truststore.NSSError
+		if errors.As(r.Err, &nssErr) {
+			e.Error = nssErr.Error()
+		}
+	}
+	events.emit(e)
+}
+
+// printMessage prints a note or warning from the truststore package.
+func printMessage(msg truststore.Message) {
+	if msg.Level == truststore.Warning {
+		log.Printf("Warning: %s ⚠️", msg.Text)
+	} else {
+		log.Printf("Note: %s ℹ️", msg.Text)
+	}
+	if msg.Kind == truststore.KindNSSLegacy {
+		msg.Help = `Run "mkcert -install -nss-legacy migrate" to migrate them, or "-nss-legacy dual" to also keep writing the old format.`
+	}
+	if msg.Help != "" {
+		log.Printf("%s 👈", msg.Help)
+	}
+}
+
+// A messageQueue holds the messages about each trust store, so that they
+// are printed after the outcome of the operation on that store, instead of
+// before all of them.
+type messageQueue struct {
+	msgs []truststore.Message
+}
+
+func (q *messageQueue) Report(msg truststore.Message) {
+	if msg.Store == "" {
+		printMessage(msg)
+		return
+	}
+	q.msgs = append(q.msgs, msg)
+}
+
+// flush prints and removes the queued messages about store.
+func (q *messageQueue) flush(store string) {
+	var rest []truststore.Message
+	for _, msg := range q.msgs {
+		if msg.Store == store {
+			printMessage(msg)
+		} else {
+			rest = append(rest, msg)
+		}
+	}
+	q.msgs = rest
+}
@@ -0,0 +1,70 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestPlainWriterStripsEmoji checks that every string literal in the source
+// comes out of plainWriter without emoji, so that new messages can't leak
+// them into -no-emoji, NO_COLOR and -log-format json output.
+func TestPlainWriterStripsEmoji(t *testing.T) {
+	err := filepath.WalkDir(".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return err
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+		if err != nil {
+			return err
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			lit, ok := n.(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+			s, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				t.Errorf("%s: %v", path, err)
+				return true
+			}
+			var b strings.Builder
+			(&plainWriter{&b}).Write([]byte(s))
+			for _, r := range b.String() {
+				// Block elements are how -serve-ca draws the QR code.
+				if r >= 0x2100 && !(r >= 0x2580 && r <= 0x259F) {
+					t.Errorf("%s: %q is not removed from %q", path, r, s)
+				}
+			}
+			return true
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlainWriter(t *testing.T) {
+	for in, want := range map[string]string{
+		"Created a new local CA 💥\n":        "Created a new local CA\n",
+		"Note: the CA is installed ℹ️\n":    "Note: the CA is installed\n",
+		"Serving for 10m0s, press Ctrl-C ⏳": "Serving for 10m0s, press Ctrl-C",
+		"Warning: café ⚠️ done":             "Warning: café done",
+	} {
+		var b strings.Builder
+		(&plainWriter{&b}).Write([]byte(in))
+		if got := b.String(); got != want {
+			t.Errorf("plainWriter(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"path/filepath"
+)
+
+// aspNetHTTPSOID marks the ASP.NET Core HTTPS development certificate, which
+// "dotnet dev-certs https" manages and Kestrel uses by default. The value of
+// the extension is the version of the certificate format.
+var aspNetHTTPSOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 84, 1, 1}
+
+var aspNetHTTPSExtension = pkix.Extension{Id: aspNetHTTPSOID, Value: []byte{2}}
+
+// printDotnetConfig prints how to make the PKCS #12 file generated for
+// -output dotnet the development certificate of ASP.NET Core. .NET uses the
+// system trust store on every platform, so -install is enough for SslStream
+// and HttpClient to trust it.
+func (m *mkcert) printDotnetConfig(p12File string) error {
+	p12File, err := filepath.Abs(p12File)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("# Replace the ASP.NET Core development certificate\n")
+	fmt.Printf("dotnet dev-certs https --clean\n")
+	fmt.Printf("dotnet dev-certs https --import %q --password changeit\n\n", p12File)
+	fmt.Printf("# or only configure Kestrel to use it\n")
+	fmt.Printf("export ASPNETCORE_Kestrel__Certificates__Default__Path=%q\n", p12File)
+	fmt.Printf("export ASPNETCORE_Kestrel__Certificates__Default__Password=changeit\n")
+	return nil
+}
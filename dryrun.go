@@ -0,0 +1,98 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"strings"
+
+	"filippo.io/mkcert/issuer"
+)
+
+var extensionNames = map[string]string{
+	"2.5.29.14":               "Subject Key Identifier",
+	"2.5.29.15":               "Key Usage",
+	"2.5.29.17":               "Subject Alternative Name",
+	"2.5.29.19":               "Basic Constraints",
+	"2.5.29.30":               "Name Constraints",
+	"2.5.29.31":               "CRL Distribution Points",
+	"2.5.29.32":               "Certificate Policies",
+	"2.5.29.35":               "Authority Key Identifier",
+	"2.5.29.37":               "Extended Key Usage",
+	"1.3.6.1.5.5.7.1.1":       "Authority Information Access",
+	"1.3.6.1.5.5.7.1.24":      "TLS Feature",
+	"2.16.840.1.113730.1.13":  "mkcert version comment",
+	"1.3.6.1.4.1.311.84.1.1":  "ASP.NET Core HTTPS development certificate",
+	"1.3.6.1.4.1.11129.2.4.2": "Signed Certificate Timestamps",
+}
+
+// printCSRPreview implements -dry-run. It prints the certificate that would
+// be issued for csr, from a dry run, and the extensions requested by csr
+// that it doesn't include.
+func (m *mkcert) printCSRPreview(path string, csr *x509.CertificateRequest, cert *x509.Certificate) {
+	printField := func(name, value string) {
+		fmt.Fprintf(stdout, "%-16s%s\n", name+":", value)
+	}
+	if path == "-" {
+		path = "stdin"
+	}
+	printField("CSR", path+" (signature verified)")
+	printField("Subject", cert.Subject.String())
+	printField("Issuer", cert.Issuer.String())
+	if names := issuer.Hosts(cert); len(names) > 0 {
+		printField("Names", strings.Join(names, ", "))
+	}
+	printField("Valid", fmt.Sprintf("%s to %s (%d days)",
+		cert.NotBefore.Format("2006-01-02 15:04 MST"), cert.NotAfter.Format("2006-01-02 15:04 MST"),
+		int(cert.NotAfter.Sub(cert.NotBefore).Hours()/24)))
+	printField("Key", keyDescription(cert))
+	printField("Signature", cert.SignatureAlgorithm.String())
+	if cert.IsCA {
+		printField("CA", "yes")
+	}
+	if usages := keyUsages(cert.KeyUsage); len(usages) > 0 {
+		printField("Key usage", strings.Join(usages, ", "))
+	}
+	if usages := extKeyUsages(cert.ExtKeyUsage); len(usages) > 0 {
+		printField("Ext key usage", strings.Join(usages, ", "))
+	}
+	for i, ext := range cert.Extensions {
+		name := ext.Id.String()
+		if n, ok := extensionNames[name]; ok {
+			name = fmt.Sprintf("%s (%s)", n, name)
+		}
+		if ext.Critical {
+			name += ", critical"
+		}
+		for _, req := range csr.Extensions {
+			if req.Id.Equal(ext.Id) && bytes.Equal(req.Value, ext.Value) {
+				name += ", from the CSR"
+			}
+		}
+		label := ""
+		if i == 0 {
+			label = "Extensions:"
+		}
+		fmt.Fprintf(stdout, "%-16s%s\n", label, name)
+	}
+
+	for _, req := range csr.Extensions {
+		honored := false
+		for _, ext := range cert.Extensions {
+			honored = honored || req.Id.Equal(ext.Id) && bytes.Equal(req.Value, ext.Value)
+		}
+		if !honored {
+			name := req.Id.String()
+			if n, ok := extensionNames[name]; ok {
+				name = fmt.Sprintf("%s (%s)", n, name)
+			}
+			printField("Not honored", name)
+		}
+	}
+	log.Print("\nThis is a dry run, nothing was signed with the local CA. Run again without -dry-run to issue the certificate 👀\n\n")
+}
@@ -0,0 +1,55 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"net"
+	"net/mail"
+	"strings"
+)
+
+// oidEAPOverLAN is id-kp-eapOverLAN from RFC 4334, which some supplicants
+// look for to pick the certificate for 802.1X.
+var oidEAPOverLAN = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 14}
+
+// checkEAPHosts rejects the names that RADIUS servers and supplicants don't
+// match for EAP-TLS: IP addresses, URLs and wildcards, and emails for server
+// certificates.
+func checkEAPHosts(hosts []string, client bool) error {
+	for _, h := range hosts {
+		if net.ParseIP(h) != nil || strings.Contains(h, "://") {
+			return fmt.Errorf("%q can't be used with -eap, supplicants only match hostnames", h)
+		}
+		if strings.HasPrefix(h, "*.") {
+			return fmt.Errorf("%q can't be used with -eap, Windows and Android supplicants don't match wildcards", h)
+		}
+		if email, err := mail.ParseAddress(h); err == nil && email.Address == h && !client {
+			return fmt.Errorf("%q can't be used with -eap without -client, the RADIUS server needs a hostname", h)
+		}
+	}
+	return nil
+}
+
+// eapTemplate makes tpl an EAP-TLS certificate: the supplicants of Windows
+// show and match the Common Name, and expect only the server or client
+// authentication EKU, plus id-kp-eapOverLAN.
+func (m *mkcert) eapTemplate(tpl *x509.Certificate) {
+	if tpl.Subject.CommonName == "" {
+		if len(tpl.DNSNames) > 0 {
+			tpl.Subject.CommonName = tpl.DNSNames[0]
+		} else if len(tpl.EmailAddresses) > 0 {
+			tpl.Subject.CommonName = tpl.EmailAddresses[0]
+		}
+	}
+	if m.client {
+		tpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	} else {
+		tpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	tpl.UnknownExtKeyUsage = append(tpl.UnknownExtKeyUsage, oidEAPOverLAN)
+}
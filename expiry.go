@@ -0,0 +1,125 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// An expiryResult is the -check-expiry status of a single certificate.
+// Status is "ok", "expiring" or "expired".
+type expiryResult struct {
+	File     string    `json:"file"`
+	Subject  string    `json:"subject"`
+	NotAfter time.Time `json:"not_after"`
+	Status   string    `json:"status"`
+	Root     bool      `json:"root"`
+}
+
+// checkExpiry reports the expiration of m.expiryFiles and of the local CA,
+// and returns an error if any of them expire within m.within.
+func (m *mkcert) checkExpiry() error {
+	check := func(file string, cert *x509.Certificate) expiryResult {
+		r := expiryResult{File: file, Subject: cert.Subject.String(), NotAfter: cert.NotAfter, Status: "ok"}
+		switch now := time.Now(); {
+		case now.After(cert.NotAfter):
+			r.Status = "expired"
+		case cert.NotAfter.Sub(now) <= m.within:
+			r.Status = "expiring"
+		}
+		return r
+	}
+
+	root := check(m.rootPath, m.ca.Cert)
+	root.Root = true
+	results := []expiryResult{root}
+	for _, file := range m.expiryFiles {
+		cert, err := readCertFile(file)
+		if err != nil {
+			return err
+		}
+		results = append(results, check(file, cert))
+	}
+
+	if m.metricsFile != "" {
+		if err := writeMetrics(m.metricsFile, results); err != nil {
+			return err
+		}
+	}
+
+	if m.jsonOutput {
+		out, err := json.MarshalIndent(results, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	}
+
+	var failed int
+	for _, r := range results {
+		if r.Status != "ok" {
+			failed++
+		}
+		if m.jsonOutput {
+			continue
+		}
+		fmt.Printf("%-8s  %s  %s\n", r.Status, r.NotAfter.Format("2006-01-02"), r.File)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d certificate(s) expired or expiring within %s", failed, m.within)
+	}
+	return nil
+}
+
+// writeMetrics saves the expiration of the checked certificates in the
+// Prometheus text format, for the node_exporter textfile collector. The file
+// is replaced atomically, so the collector never reads a partial file.
+func writeMetrics(path string, results []expiryResult) error {
+	var b strings.Builder
+	b.WriteString("# HELP mkcert_certificate_not_after_timestamp_seconds Expiration time of the certificate.\n")
+	b.WriteString("# TYPE mkcert_certificate_not_after_timestamp_seconds gauge\n")
+	for _, r := range results {
+		fmt.Fprintf(&b, "mkcert_certificate_not_after_timestamp_seconds{file=%s,subject=%s,root=\"%t\"} %d\n",
+			metricsLabel(r.File), metricsLabel(r.Subject), r.Root, r.NotAfter.Unix())
+	}
+	b.WriteString("# HELP mkcert_check_timestamp_seconds Time of the last mkcert -check-expiry run.\n")
+	b.WriteString("# TYPE mkcert_check_timestamp_seconds gauge\n")
+	fmt.Fprintf(&b, "mkcert_check_timestamp_seconds %d\n", time.Now().Unix())
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".mkcert-metrics-*")
+	if err != nil {
+		return fmt.Errorf("failed to save metrics: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save metrics: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save metrics: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to save metrics: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save metrics: %w", err)
+	}
+	return nil
+}
+
+// metricsLabel quotes a label value for the Prometheus text format.
+func metricsLabel(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `"`, `\"`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	return `"` + v + `"`
+}
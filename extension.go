@@ -0,0 +1,51 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseExtension parses an -ext value, "OID:BASE64" or
+// "OID:critical:BASE64", where BASE64 is the DER encoding of the extension
+// value, like "1.3.6.1.4.1.11129.2.4.3:critical:BQA=".
+func parseExtension(s string) (pkix.Extension, error) {
+	parts := strings.Split(s, ":")
+	var ext pkix.Extension
+	switch {
+	case len(parts) == 2:
+	case len(parts) == 3 && strings.EqualFold(parts[1], "critical"):
+		ext.Critical = true
+	default:
+		return pkix.Extension{}, fmt.Errorf("invalid -ext %q, the format is OID[:critical]:BASE64", s)
+	}
+
+	for _, arc := range strings.Split(parts[0], ".") {
+		n, err := strconv.Atoi(arc)
+		if err != nil || n < 0 {
+			return pkix.Extension{}, fmt.Errorf("invalid -ext %q: %q is not an OID", s, parts[0])
+		}
+		ext.Id = append(ext.Id, n)
+	}
+	if len(ext.Id) < 2 {
+		return pkix.Extension{}, fmt.Errorf("invalid -ext %q: %q is not an OID", s, parts[0])
+	}
+
+	value, err := base64.StdEncoding.DecodeString(parts[len(parts)-1])
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("invalid -ext %q: the value is not valid base64: %w", s, err)
+	}
+	var raw asn1.RawValue
+	if rest, err := asn1.Unmarshal(value, &raw); err != nil || len(rest) != 0 {
+		return pkix.Extension{}, fmt.Errorf("invalid -ext %q: the value is not a single DER-encoded ASN.1 value", s)
+	}
+	ext.Value = value
+	return ext, nil
+}
@@ -0,0 +1,40 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// printFingerprints implements -fingerprint. It prints the fingerprints of
+// the local CA, or of the first certificate in the file in args, in the
+// formats that enrollment and pinning workflows ask for: colon-separated
+// hex, base64, and the "SHA256:" format of ssh-keygen.
+func (m *mkcert) printFingerprints(args []string) error {
+	cert := m.ca.Cert
+	if len(args) == 1 {
+		certs, err := readCertsFile(args[0])
+		if err != nil {
+			return err
+		}
+		cert = certs[0]
+	}
+
+	printField := func(name, value string) {
+		fmt.Fprintf(stdout, "%-18s%s\n", name+":", value)
+	}
+	sha256Sum, sha1Sum := sha256.Sum256(cert.Raw), sha1.Sum(cert.Raw)
+	printField("Subject", cert.Subject.String())
+	printField("SHA-256", fingerprint(sha256Sum[:]))
+	printField("SHA-256 base64", base64.StdEncoding.EncodeToString(sha256Sum[:]))
+	printField("SHA-256 OpenSSH", "SHA256:"+base64.RawStdEncoding.EncodeToString(sha256Sum[:]))
+	printField("SHA-1", fingerprint(sha1Sum[:]))
+	printField("SHA-1 base64", base64.StdEncoding.EncodeToString(sha1Sum[:]))
+	printField("SHA-1 OpenSSH", "SHA1:"+base64.RawStdEncoding.EncodeToString(sha1Sum[:]))
+	return nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+const gpoName = "mkcert-root"
+
+// writeGPOFiles writes the artifacts IT needs to deploy the local CA to
+// managed Windows machines: the certificate for the Group Policy and Intune
+// importers, a .reg file with the policy key Group Policy would create, and a
+// standalone PowerShell script.
+func (m *mkcert) writeGPOFiles(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %q: %w", dir, err)
+	}
+
+	p7b, err := certsOnlyPKCS7(m.ca.Cert.Raw)
+	if err != nil {
+		return fmt.Errorf("failed to encode the PKCS #7 bundle: %w", err)
+	}
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{gpoName + ".cer", m.ca.Cert.Raw},
+		{gpoName + ".p7b", p7b},
+		{gpoName + ".reg", rootRegFile(m.ca.Cert.Raw)},
+		{gpoName + ".ps1", []byte(rootPowerShell(m.ca.Cert.Raw))},
+	}
+	for _, f := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, f.name), f.data, 0644); err != nil {
+			return fmt.Errorf("failed to save %s: %w", f.name, err)
+		}
+	}
+
+	log.Printf("The Windows deployment files for the local CA are in %q 📦\n\n", dir)
+	log.Printf(" - Group Policy: in Computer Configuration > Policies > Windows Settings > Security Settings > Public Key Policies > Trusted Root Certification Authorities, import %s.cer (or %s.p7b)\n", gpoName, gpoName)
+	log.Printf(" - Intune: create a \"Trusted certificate\" configuration profile for Windows with %s.cer, and the \"Computer certificate store - Root\" destination\n", gpoName)
+	log.Printf(" - Registry: %s.reg creates the same policy key as Group Policy, for other management tools\n", gpoName)
+	log.Printf(" - Scripts: %s.ps1 adds the CA to the machine Root store, and has to run as Administrator\n\n", gpoName)
+	return nil
+}
+
+// certsOnlyPKCS7 returns a degenerate PKCS #7 SignedData with no signers,
+// the .p7b format accepted by the Windows certificate import tools.
+func certsOnlyPKCS7(certs ...[]byte) ([]byte, error) {
+	var certSet []byte
+	for _, c := range certs {
+		certSet = append(certSet, c...)
+	}
+	signedData, err := asn1.Marshal(struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+		Certificates     asn1.RawValue
+		SignerInfos      asn1.RawValue
+	}{
+		Version:          1,
+		DigestAlgorithms: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true},
+		ContentInfo:      struct{ ContentType asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certSet},
+		SignerInfos:      asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{
+		ContentType: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2},
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+}
+
+// rootRegFile returns a .reg file adding cert to the Group Policy trusted
+// roots, in the UTF-16 encoding regedit expects.
+func rootRegFile(cert []byte) []byte {
+	thumbprint := sha1.Sum(cert)
+
+	// The Blob value is a serialized certificate store element: a list of
+	// (property ID, reserved, length, value) entries, where property 3 is
+	// the SHA-1 hash and 32 is the encoded certificate.
+	var blob bytes.Buffer
+	for _, prop := range []struct {
+		id    uint32
+		value []byte
+	}{{3, thumbprint[:]}, {32, cert}} {
+		binary.Write(&blob, binary.LittleEndian, [3]uint32{prop.id, 1, uint32(len(prop.value))})
+		blob.Write(prop.value)
+	}
+
+	var reg strings.Builder
+	reg.WriteString("Windows Registry Editor Version 5.00\r\n\r\n")
+	fmt.Fprintf(&reg, "[HKEY_LOCAL_MACHINE\\SOFTWARE\\Policies\\Microsoft\\SystemCertificates\\Root\\Certificates\\%X]\r\n", thumbprint)
+	line := `"Blob"=hex:`
+	for i, b := range blob.Bytes() {
+		if len(line) > 76 {
+			reg.WriteString(line + "\\\r\n")
+			line = "  "
+		}
+		line += fmt.Sprintf("%02x", b)
+		if i != blob.Len()-1 {
+			line += ","
+		}
+	}
+	reg.WriteString(line + "\r\n")
+
+	out := []byte{0xff, 0xfe} // UTF-16LE BOM
+	for _, u := range utf16.Encode([]rune(reg.String())) {
+		out = append(out, byte(u), byte(u>>8))
+	}
+	return out
+}
+
+// rootPowerShell returns a self-contained script adding cert to the machine
+// Root store.
+func rootPowerShell(cert []byte) string {
+	return fmt.Sprintf(`# Adds the mkcert development CA to the trusted roots of this machine.
+# Run as Administrator, or deploy as an Intune or Configuration Manager script.
+$ErrorActionPreference = 'Stop'
+$bytes = [Convert]::FromBase64String('%s')
+$cert = New-Object System.Security.Cryptography.X509Certificates.X509Certificate2(,$bytes)
+$store = New-Object System.Security.Cryptography.X509Certificates.X509Store('Root', 'LocalMachine')
+$store.Open('ReadWrite')
+$store.Add($cert)
+$store.Close()
+Write-Output "Installed $($cert.Subject) ($($cert.Thumbprint))"
+`, base64.StdEncoding.EncodeToString(cert))
+}
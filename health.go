@@ -0,0 +1,236 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"filippo.io/mkcert/issuer"
+)
+
+// parseHealthAddr parses the -health address, like ":9090". Without a host,
+// the endpoint only listens on the loopback interface.
+func parseHealthAddr(s string) (string, error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil || port == "" {
+		return "", fmt.Errorf("invalid -health address %q, it must be like :9090 or localhost:9090", s)
+	}
+	if host == "" {
+		return net.JoinHostPort("127.0.0.1", port), nil
+	}
+	return s, nil
+}
+
+// healthState is what the -health endpoint reports about the long-running
+// -acme and -watch modes. Its methods do nothing on a nil healthState, so
+// that they can be called whether -health is set or not.
+type healthState struct {
+	mu      sync.Mutex
+	mode    string
+	started time.Time
+
+	// issued and certs are the certificates issued by -acme.
+	issued int
+	certs  []healthCert
+
+	// lastCheck, nextCheck and stores are the results of -watch, with
+	// whether the local CA is in each trust store.
+	lastCheck, nextCheck time.Time
+	stores               map[string]bool
+}
+
+type healthCert struct {
+	Serial     string    `json:"serial"`
+	Names      []string  `json:"names"`
+	NotAfter   time.Time `json:"not_after"`
+	RenewAfter time.Time `json:"renew_after"`
+}
+
+func newHealthState(mode string) *healthState {
+	return &healthState{mode: mode, started: time.Now(), stores: make(map[string]bool)}
+}
+
+// recordIssued tracks cert until it expires. ACME clients usually renew
+// certificates two thirds into their validity period, like Let's Encrypt
+// recommends, so that's when it's expected to be renewed.
+func (h *healthState) recordIssued(cert *x509.Certificate) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	h.issued++
+	h.certs = append(h.certs, healthCert{
+		Serial:     fmt.Sprintf("%x", cert.SerialNumber),
+		Names:      issuer.Hosts(cert),
+		NotAfter:   cert.NotAfter,
+		RenewAfter: cert.NotBefore.Add(lifetime * 2 / 3),
+	})
+}
+
+// recordCheck saves the results of a -watch check, if stores is not nil,
+// and when the next one is due.
+func (h *healthState) recordCheck(stores map[string]bool, next time.Time) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextCheck = next
+	if stores != nil {
+		h.lastCheck = time.Now()
+	}
+	for store, ok := range stores {
+		h.stores[store] = ok
+	}
+}
+
+// healthReport is the JSON body of the -health endpoint.
+type healthReport struct {
+	Mode        string          `json:"mode"`
+	Started     time.Time       `json:"started"`
+	Issued      *int            `json:"issued,omitempty"`
+	Tracked     []healthCert    `json:"tracked,omitempty"`
+	NextRenewal *time.Time      `json:"next_renewal,omitempty"`
+	LastCheck   *time.Time      `json:"last_check,omitempty"`
+	NextCheck   *time.Time      `json:"next_check,omitempty"`
+	Stores      map[string]bool `json:"stores,omitempty"`
+}
+
+func (h *healthState) report(now time.Time) *healthReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r := &healthReport{Mode: h.mode, Started: h.started}
+	if h.mode == "acme" {
+		issued := h.issued
+		r.Issued = &issued
+	}
+	var certs []healthCert
+	for _, c := range h.certs {
+		if c.NotAfter.After(now) {
+			certs = append(certs, c)
+		}
+	}
+	h.certs = certs
+	r.Tracked = append(r.Tracked, certs...)
+	sort.Slice(r.Tracked, func(i, j int) bool { return r.Tracked[i].RenewAfter.Before(r.Tracked[j].RenewAfter) })
+	if len(r.Tracked) > 0 {
+		r.NextRenewal = &r.Tracked[0].RenewAfter
+	}
+	if !h.nextCheck.IsZero() {
+		nextCheck := h.nextCheck
+		r.NextCheck = &nextCheck
+	}
+	if !h.lastCheck.IsZero() {
+		lastCheck := h.lastCheck
+		r.LastCheck = &lastCheck
+		r.Stores = make(map[string]bool)
+		for store, ok := range h.stores {
+			r.Stores[store] = ok
+		}
+	}
+	return r
+}
+
+// ServeHTTP serves the report as JSON at /health, and in the Prometheus text
+// format at /metrics.
+func (h *healthState) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := h.report(time.Now())
+	switch r.URL.Path {
+	case "/health":
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	case "/metrics":
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(report.metrics()))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (r *healthReport) metrics() string {
+	var b strings.Builder
+	b.WriteString("# HELP mkcert_start_timestamp_seconds Time mkcert started.\n")
+	b.WriteString("# TYPE mkcert_start_timestamp_seconds gauge\n")
+	fmt.Fprintf(&b, "mkcert_start_timestamp_seconds{mode=%s} %d\n", metricsLabel(r.Mode), r.Started.Unix())
+	if r.Issued != nil {
+		b.WriteString("# HELP mkcert_acme_certificates_issued_total Certificates issued over ACME.\n")
+		b.WriteString("# TYPE mkcert_acme_certificates_issued_total counter\n")
+		fmt.Fprintf(&b, "mkcert_acme_certificates_issued_total %d\n", *r.Issued)
+		b.WriteString("# HELP mkcert_acme_certificate_renew_after_timestamp_seconds Time the certificate is expected to be renewed.\n")
+		b.WriteString("# TYPE mkcert_acme_certificate_renew_after_timestamp_seconds gauge\n")
+		for _, c := range r.Tracked {
+			fmt.Fprintf(&b, "mkcert_acme_certificate_renew_after_timestamp_seconds{serial=%s,names=%s} %d\n",
+				metricsLabel(c.Serial), metricsLabel(strings.Join(c.Names, ",")), c.RenewAfter.Unix())
+		}
+		b.WriteString("# HELP mkcert_acme_certificate_not_after_timestamp_seconds Expiration time of the certificate.\n")
+		b.WriteString("# TYPE mkcert_acme_certificate_not_after_timestamp_seconds gauge\n")
+		for _, c := range r.Tracked {
+			fmt.Fprintf(&b, "mkcert_acme_certificate_not_after_timestamp_seconds{serial=%s,names=%s} %d\n",
+				metricsLabel(c.Serial), metricsLabel(strings.Join(c.Names, ",")), c.NotAfter.Unix())
+		}
+	}
+	if r.NextCheck != nil {
+		b.WriteString("# HELP mkcert_watch_next_check_timestamp_seconds Time of the next trust store check.\n")
+		b.WriteString("# TYPE mkcert_watch_next_check_timestamp_seconds gauge\n")
+		fmt.Fprintf(&b, "mkcert_watch_next_check_timestamp_seconds %d\n", r.NextCheck.Unix())
+	}
+	if r.LastCheck != nil {
+		b.WriteString("# HELP mkcert_watch_check_timestamp_seconds Time of the last trust store check.\n")
+		b.WriteString("# TYPE mkcert_watch_check_timestamp_seconds gauge\n")
+		fmt.Fprintf(&b, "mkcert_watch_check_timestamp_seconds %d\n", r.LastCheck.Unix())
+		b.WriteString("# HELP mkcert_watch_trust_store_installed Whether the local CA is in the trust store.\n")
+		b.WriteString("# TYPE mkcert_watch_trust_store_installed gauge\n")
+		var stores []string
+		for store := range r.Stores {
+			stores = append(stores, store)
+		}
+		sort.Strings(stores)
+		for _, store := range stores {
+			installed := 0
+			if r.Stores[store] {
+				installed = 1
+			}
+			fmt.Fprintf(&b, "mkcert_watch_trust_store_installed{store=%s} %d\n", metricsLabel(store), installed)
+		}
+	}
+	return b.String()
+}
+
+// serveHealth starts the -health endpoint in the background, if set, for the
+// long-running mode.
+func (m *mkcert) serveHealth(mode string) error {
+	if m.healthAddr == "" {
+		return nil
+	}
+	addr, err := parseHealthAddr(m.healthAddr)
+	if err != nil {
+		return err
+	}
+	m.health = newHealthState(mode)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to serve the -health endpoint: %w", err)
+	}
+	go func() {
+		if err := http.Serve(ln, m.health); err != nil {
+			log.Printf("Warning: the -health endpoint stopped: %v ⚠️", err)
+		}
+	}()
+	log.Printf("Serving health reports at http://%s/health and http://%s/metrics 🩺", ln.Addr(), ln.Addr())
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/mkcert/issuer"
+)
+
+func TestHealth(t *testing.T) {
+	ca, err := issuer.NewCA(t.TempDir(), &issuer.Options{ECDSA: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ca.IssueServer([]string{"example.test"}, &issuer.Options{ECDSA: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newHealthState("acme")
+	h.recordIssued(cert.Cert)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/health", nil))
+	var report healthReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Mode != "acme" || report.Issued == nil || *report.Issued != 1 || len(report.Tracked) != 1 {
+		t.Fatalf("got %+v, want one issued and tracked certificate", report)
+	}
+	lifetime := cert.Cert.NotAfter.Sub(cert.Cert.NotBefore)
+	renewAfter := cert.Cert.NotBefore.Add(lifetime * 2 / 3)
+	if report.NextRenewal == nil || !report.NextRenewal.Equal(renewAfter) {
+		t.Errorf("got next renewal %v, want %v", report.NextRenewal, renewAfter)
+	}
+	if got := report.Tracked[0].Names; len(got) != 1 || got[0] != "example.test" {
+		t.Errorf("got names %v, want example.test", got)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), "\nmkcert_acme_certificates_issued_total 1\n") {
+		t.Errorf("the metrics don't count the issued certificate:\n%s", w.Body)
+	}
+
+	// Expired certificates are not tracked anymore.
+	if r := h.report(cert.Cert.NotAfter.Add(time.Second)); len(r.Tracked) != 0 || r.NextRenewal != nil || *r.Issued != 1 {
+		t.Errorf("after expiration: got %+v", r)
+	}
+
+	h = newHealthState("watch")
+	next := time.Now().Add(time.Hour)
+	h.recordCheck(map[string]bool{"system": true, "nss": false}, next)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`mkcert_watch_trust_store_installed{store="nss"} 0`,
+		`mkcert_watch_trust_store_installed{store="system"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("the metrics are missing %q:\n%s", line, w.Body)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"filippo.io/mkcert/truststore"
+)
+
+// runExecHook runs the -exec command through the shell, if any certificates
+// were written, so that servers can reload them. The paths of the written
+// files are passed in $MKCERT_FILES, separated by the OS path list
+// separator.
+func (m *mkcert) runExecHook() error {
+	if m.execHook == "" || len(m.written) == 0 {
+		return nil
+	}
+	return m.runHook("MKCERT_FILES=" + strings.Join(m.written, string(os.PathListSeparator)))
+}
+
+// runHook runs the -exec command through the shell, with env added to its
+// environment.
+func (m *mkcert) runHook(env string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd.exe", "/C", m.execHook)
+	} else {
+		cmd = exec.Command("sh", "-c", m.execHook)
+	}
+	cmd.Env = append(os.Environ(), env)
+	out, err := m.cmdFS.Exec(context.Background(), cmd)
+	if err != nil {
+		return &truststore.CmdError{Cmd: m.execHook, Out: out, Err: err}
+	}
+	if out := strings.TrimSpace(string(out)); out != "" {
+		log.Println(out)
+	}
+	log.Printf("Ran %q ✅\n\n", m.execHook)
+	return nil
+}
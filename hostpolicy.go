@@ -0,0 +1,64 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// A hostPolicy relaxes the validation of hostnames, for environments that
+// legitimately use names that are not valid DNS hostnames.
+type hostPolicy struct {
+	// trailingDot accepts fully qualified names like "example.test.", and
+	// drops the dot, which certificates don't have.
+	trailingDot bool
+	// longLabels silences the warning for labels longer than 63 characters
+	// and names longer than 253, which DNS can't resolve but hosts files and
+	// proxies can.
+	longLabels bool
+	// pattern accepts the names that match it as they are.
+	pattern *regexp.Regexp
+}
+
+// parseHostPolicy parses the -allow-hostnames list and -hostname-regexp.
+func parseHostPolicy(allow, pattern string) (hostPolicy, error) {
+	var p hostPolicy
+	for _, opt := range strings.Split(allow, ",") {
+		switch strings.TrimSpace(opt) {
+		case "":
+		case "trailing-dot":
+			p.trailingDot = true
+		case "long-labels":
+			p.longLabels = true
+		default:
+			return p, fmt.Errorf("unknown -allow-hostnames option %q, options are: trailing-dot and long-labels", opt)
+		}
+	}
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return p, fmt.Errorf("invalid -hostname-regexp: %w", err)
+		}
+		p.pattern = re
+	}
+	return p, nil
+}
+
+// warn prints the warnings for valid names that some clients still reject.
+func (p hostPolicy) warn(name string) {
+	if strings.Contains(name, "_") {
+		log.Printf("Warning: %q has an underscore, which is not allowed in hostnames and some clients reject ⚠️", name)
+	}
+	tooLong := len(name) > 253
+	for _, label := range strings.Split(name, ".") {
+		tooLong = tooLong || len(label) > 63
+	}
+	if tooLong && !p.longLabels {
+		log.Printf("Warning: %q has a label longer than 63 characters or is longer than 253, which DNS can't resolve, so it only works through hosts files or proxies (use -allow-hostnames long-labels to silence this) ⚠️", name)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"filippo.io/mkcert/truststore"
+)
+
+// The hosts file entries added by mkcert are kept between these markers, so
+// they can be updated and removed without touching the rest of the file.
+const (
+	hostsBegin = "# BEGIN mkcert"
+	hostsEnd   = "# END mkcert"
+)
+
+func hostsPath() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("SystemRoot"), "System32", "drivers", "etc", "hosts")
+	}
+	return "/etc/hosts"
+}
+
+// splitHosts returns the hosts file without the mkcert block, and the names
+// in the block.
+func splitHosts(hosts []byte) (rest []string, names []string) {
+	var inBlock bool
+	for _, line := range strings.Split(strings.TrimRight(string(hosts), "\r\n"), "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case line == hostsBegin:
+			inBlock = true
+		case line == hostsEnd:
+			inBlock = false
+		case inBlock:
+			if fields := strings.Fields(line); len(fields) == 2 && !contains(names, fields[1]) {
+				names = append(names, fields[1])
+			}
+		default:
+			rest = append(rest, line)
+		}
+	}
+	return rest, names
+}
+
+// updateHosts maps the hostnames among hosts to 127.0.0.1 in the hosts file.
+func (m *mkcert) updateHosts(hosts []string) error {
+	path := hostsPath()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the hosts file: %w", err)
+	}
+	rest, names := splitHosts(data)
+
+	var added []string
+	for _, h := range hosts {
+		if net.ParseIP(h) != nil || strings.ContainsAny(h, "*@/:") || h == "localhost" {
+			continue
+		}
+		if !contains(names, h) {
+			names = append(names, h)
+			added = append(added, h)
+		}
+	}
+	if len(added) == 0 {
+		log.Printf("The hosts file at %q already maps these names to 127.0.0.1 👍\n\n", path)
+		return nil
+	}
+
+	lines := append(rest, hostsBegin)
+	for _, name := range names {
+		lines = append(lines, "127.0.0.1\t"+name)
+	}
+	lines = append(lines, hostsEnd)
+	if err := writeHosts(m.cmdFS, path, data, lines); err != nil {
+		return err
+	}
+	log.Printf("Added %s to the hosts file at %q 🏠", strings.Join(added, ", "), path)
+	log.Printf("Run \"mkcert -remove-hosts\" to remove all the names added by mkcert.\n\n")
+	return nil
+}
+
+// removeHosts removes the names added by updateHosts from the hosts file.
+func removeHosts(cmdFS *truststore.CmdFS) error {
+	path := hostsPath()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the hosts file: %w", err)
+	}
+	rest, names := splitHosts(data)
+	if len(names) == 0 && !bytes.Contains(data, []byte(hostsBegin)) {
+		log.Printf("The hosts file at %q has no names added by mkcert 👍", path)
+		return nil
+	}
+	if err := writeHosts(cmdFS, path, data, rest); err != nil {
+		return err
+	}
+	log.Printf("Removed the names added by mkcert from the hosts file at %q 👋", path)
+	return nil
+}
+
+// writeHosts replaces the hosts file with lines, keeping the line endings of
+// the original, and elevating privileges with sudo if necessary.
+func writeHosts(cmdFS *truststore.CmdFS, path string, orig []byte, lines []string) error {
+	newline := "\n"
+	if bytes.Contains(orig, []byte("\r\n")) {
+		newline = "\r\n"
+	}
+	data := []byte(strings.Join(lines, newline) + newline)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the hosts file: %w", err)
+	}
+	err = ioutil.WriteFile(path, data, info.Mode().Perm())
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("failed to write the hosts file: %w", err)
+	}
+	if runtime.GOOS == "windows" {
+		return fmt.Errorf("failed to write the hosts file, run mkcert as Administrator: %w", err)
+	}
+
+	cmd := exec.Command("tee", path)
+	cmd.Stdin = bytes.NewReader(data)
+	if out, err := cmdFS.SudoExec(context.Background(), cmd); err != nil {
+		return &truststore.CmdError{Cmd: "tee", Out: out, Err: err}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
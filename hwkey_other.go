@@ -0,0 +1,23 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+
+package main
+
+import (
+	"crypto"
+	"errors"
+	"runtime"
+)
+
+// newHardwareKey is only implemented on Windows. Keys in the macOS Secure
+// Enclave can only be generated through the Security framework, which
+// requires cgo.
+func newHardwareKey(name string, useECDSA bool) (crypto.Signer, string, error) {
+	if runtime.GOOS == "darwin" {
+		return nil, "", errors.New("-hardware-key doesn't support the Secure Enclave yet, it's only supported on Windows, with a TPM")
+	}
+	return nil, "", errors.New("-hardware-key is only supported on Windows, with a TPM")
+}
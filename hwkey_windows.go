@@ -0,0 +1,211 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modncrypt                     = syscall.NewLazyDLL("ncrypt.dll")
+	procNCryptOpenStorageProvider = modncrypt.NewProc("NCryptOpenStorageProvider")
+	procNCryptCreatePersistedKey  = modncrypt.NewProc("NCryptCreatePersistedKey")
+	procNCryptSetProperty         = modncrypt.NewProc("NCryptSetProperty")
+	procNCryptFinalizeKey         = modncrypt.NewProc("NCryptFinalizeKey")
+	procNCryptExportKey           = modncrypt.NewProc("NCryptExportKey")
+	procNCryptSignHash            = modncrypt.NewProc("NCryptSignHash")
+	procNCryptFreeObject          = modncrypt.NewProc("NCryptFreeObject")
+)
+
+const (
+	// tpmProvider is the CNG key storage provider backed by the TPM, which
+	// generates keys that can't be exported.
+	tpmProvider = "Microsoft Platform Crypto Provider"
+
+	bcryptPadPKCS1 = 0x2
+	bcryptPadPSS   = 0x8
+)
+
+// A cngKey is a CNG key handle, used as a crypto.Signer.
+type cngKey struct {
+	handle uintptr
+	pub    crypto.PublicKey
+}
+
+func ncryptErr(name string, r uintptr) error {
+	if r == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s: 0x%08x", name, uint32(r))
+}
+
+func utf16Ptr(s string) *uint16 {
+	p, _ := syscall.UTF16PtrFromString(s)
+	return p
+}
+
+// newHardwareKey generates a persisted, non-exportable key named name in the
+// TPM, and returns it with a description of where it's stored.
+func newHardwareKey(name string, useECDSA bool) (crypto.Signer, string, error) {
+	var prov uintptr
+	r, _, _ := procNCryptOpenStorageProvider.Call(uintptr(unsafe.Pointer(&prov)), uintptr(unsafe.Pointer(utf16Ptr(tpmProvider))), 0)
+	if err := ncryptErr("NCryptOpenStorageProvider", r); err != nil {
+		return nil, "", fmt.Errorf("failed to open the TPM key storage provider (is a TPM available?): %w", err)
+	}
+	defer procNCryptFreeObject.Call(prov)
+
+	alg := "RSA"
+	if useECDSA {
+		alg = "ECDSA_P256"
+	}
+	var handle uintptr
+	r, _, _ = procNCryptCreatePersistedKey.Call(prov, uintptr(unsafe.Pointer(&handle)),
+		uintptr(unsafe.Pointer(utf16Ptr(alg))), uintptr(unsafe.Pointer(utf16Ptr(name))), 0, 0)
+	if err := ncryptErr("NCryptCreatePersistedKey", r); err != nil {
+		return nil, "", fmt.Errorf("failed to create the TPM key: %w", err)
+	}
+	if !useECDSA {
+		bits := uint32(2048)
+		r, _, _ = procNCryptSetProperty.Call(handle, uintptr(unsafe.Pointer(utf16Ptr("Length"))),
+			uintptr(unsafe.Pointer(&bits)), 4, 0)
+		if err := ncryptErr("NCryptSetProperty", r); err != nil {
+			procNCryptFreeObject.Call(handle)
+			return nil, "", fmt.Errorf("failed to create the TPM key: %w", err)
+		}
+	}
+	r, _, _ = procNCryptFinalizeKey.Call(handle, 0)
+	if err := ncryptErr("NCryptFinalizeKey", r); err != nil {
+		procNCryptFreeObject.Call(handle)
+		return nil, "", fmt.Errorf("failed to create the TPM key: %w", err)
+	}
+
+	pub, err := cngPublicKey(handle, useECDSA)
+	if err != nil {
+		procNCryptFreeObject.Call(handle)
+		return nil, "", err
+	}
+	return &cngKey{handle: handle, pub: pub}, fmt.Sprintf("the TPM (%s) as %q", tpmProvider, name), nil
+}
+
+func cngPublicKey(handle uintptr, useECDSA bool) (crypto.PublicKey, error) {
+	blobType := utf16Ptr("RSAPUBLICBLOB")
+	if useECDSA {
+		blobType = utf16Ptr("ECCPUBLICBLOB")
+	}
+	var size uint32
+	r, _, _ := procNCryptExportKey.Call(handle, 0, uintptr(unsafe.Pointer(blobType)), 0, 0, 0, uintptr(unsafe.Pointer(&size)), 0)
+	if err := ncryptErr("NCryptExportKey", r); err != nil {
+		return nil, fmt.Errorf("failed to export the TPM public key: %w", err)
+	}
+	blob := make([]byte, size)
+	r, _, _ = procNCryptExportKey.Call(handle, 0, uintptr(unsafe.Pointer(blobType)), 0,
+		uintptr(unsafe.Pointer(&blob[0])), uintptr(size), uintptr(unsafe.Pointer(&size)), 0)
+	if err := ncryptErr("NCryptExportKey", r); err != nil {
+		return nil, fmt.Errorf("failed to export the TPM public key: %w", err)
+	}
+	blob = blob[:size]
+	errBlob := errors.New("failed to parse the TPM public key")
+
+	if useECDSA {
+		// BCRYPT_ECCKEY_BLOB: Magic, cbKey, then X and Y.
+		if len(blob) < 8 {
+			return nil, errBlob
+		}
+		n := int(binary.LittleEndian.Uint32(blob[4:8]))
+		if len(blob) < 8+2*n {
+			return nil, errBlob
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(blob[8 : 8+n]),
+			Y:     new(big.Int).SetBytes(blob[8+n : 8+2*n]),
+		}, nil
+	}
+	// BCRYPT_RSAKEY_BLOB: Magic, BitLength, cbPublicExp, cbModulus, cbPrime1,
+	// cbPrime2, then the public exponent and the modulus.
+	if len(blob) < 24 {
+		return nil, errBlob
+	}
+	expLen := int(binary.LittleEndian.Uint32(blob[8:12]))
+	modLen := int(binary.LittleEndian.Uint32(blob[12:16]))
+	if len(blob) < 24+expLen+modLen {
+		return nil, errBlob
+	}
+	e := new(big.Int).SetBytes(blob[24 : 24+expLen])
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(blob[24+expLen : 24+expLen+modLen]),
+		E: int(e.Int64()),
+	}, nil
+}
+
+func (k *cngKey) Public() crypto.PublicKey { return k.pub }
+
+func (k *cngKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var padInfo unsafe.Pointer
+	var flags uintptr
+	if _, ok := k.pub.(*rsa.PublicKey); ok {
+		var alg string
+		switch opts.HashFunc() {
+		case crypto.SHA1:
+			alg = "SHA1"
+		case crypto.SHA256:
+			alg = "SHA256"
+		case crypto.SHA384:
+			alg = "SHA384"
+		case crypto.SHA512:
+			alg = "SHA512"
+		default:
+			return nil, fmt.Errorf("unsupported hash for the TPM key: %v", opts.HashFunc())
+		}
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			salt := pss.SaltLength
+			if salt == rsa.PSSSaltLengthAuto || salt == rsa.PSSSaltLengthEqualsHash {
+				salt = opts.HashFunc().Size()
+			}
+			padInfo = unsafe.Pointer(&struct {
+				alg  *uint16
+				salt uint32
+			}{utf16Ptr(alg), uint32(salt)})
+			flags = bcryptPadPSS
+		} else {
+			padInfo = unsafe.Pointer(&struct{ alg *uint16 }{utf16Ptr(alg)})
+			flags = bcryptPadPKCS1
+		}
+	}
+
+	var size uint32
+	r, _, _ := procNCryptSignHash.Call(k.handle, uintptr(padInfo), uintptr(unsafe.Pointer(&digest[0])), uintptr(len(digest)),
+		0, 0, uintptr(unsafe.Pointer(&size)), flags)
+	if err := ncryptErr("NCryptSignHash", r); err != nil {
+		return nil, fmt.Errorf("failed to sign with the TPM key: %w", err)
+	}
+	sig := make([]byte, size)
+	r, _, _ = procNCryptSignHash.Call(k.handle, uintptr(padInfo), uintptr(unsafe.Pointer(&digest[0])), uintptr(len(digest)),
+		uintptr(unsafe.Pointer(&sig[0])), uintptr(size), uintptr(unsafe.Pointer(&size)), flags)
+	if err := ncryptErr("NCryptSignHash", r); err != nil {
+		return nil, fmt.Errorf("failed to sign with the TPM key: %w", err)
+	}
+	sig = sig[:size]
+
+	if _, ok := k.pub.(*ecdsa.PublicKey); ok {
+		// CNG returns r || s, while crypto.Signer returns an ASN.1 sequence.
+		half := len(sig) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			new(big.Int).SetBytes(sig[:half]), new(big.Int).SetBytes(sig[half:]),
+		})
+	}
+	return sig, nil
+}
@@ -0,0 +1,309 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"filippo.io/mkcert/issuer"
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// readCertsFile returns all the certificates in a PEM, DER or PKCS #12 file.
+// PKCS #12 files are tried with the "changeit" password mkcert uses.
+func readCertsFile(file string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the certificate: %w", err)
+	}
+
+	var certs []*x509.Certificate
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %w", file, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) > 0 {
+		return certs, nil
+	}
+
+	if cert, err := x509.ParseCertificate(data); err == nil {
+		return []*x509.Certificate{cert}, nil
+	}
+	for _, password := range []string{"changeit", ""} {
+		_, cert, caCerts, err := pkcs12.DecodeChain(data, password)
+		if err == nil {
+			return append([]*x509.Certificate{cert}, caCerts...), nil
+		}
+		if errors.Is(err, pkcs12.ErrIncorrectPassword) {
+			continue
+		}
+		if trustStore, err := pkcs12.DecodeTrustStore(data, password); err == nil {
+			return trustStore, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to read %q: no PEM, DER or PKCS#12 certificate found (PKCS#12 files must use the \"changeit\" password)", file)
+}
+
+// inspect prints the details of the certificates in file, and whether they
+// chain to the local CA, as text or with -json as a JSON array.
+func (m *mkcert) inspect(file string) error {
+	certs, err := readCertsFile(file)
+	if err != nil {
+		return err
+	}
+	if m.jsonOutput {
+		return m.printCertsJSON(certs)
+	}
+	m.printCerts(certs)
+	return nil
+}
+
+type inspectedCert struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	Names              []string  `json:"names,omitempty"`
+	NotBefore          time.Time `json:"not_before"`
+	NotAfter           time.Time `json:"not_after"`
+	Status             string    `json:"status"`
+	Key                string    `json:"key"`
+	SignatureAlgorithm string    `json:"signature_algorithm"`
+	CA                 bool      `json:"ca"`
+	KeyUsage           []string  `json:"key_usage,omitempty"`
+	ExtKeyUsage        []string  `json:"ext_key_usage,omitempty"`
+	Serial             string    `json:"serial"`
+	SHA256             string    `json:"sha256"`
+	SHA1               string    `json:"sha1"`
+	SPKIPin            string    `json:"spki_pin"`
+	// LocalCA is "self" for the local CA itself, "chains" if the
+	// certificate chains to it, and "no" otherwise, with LocalCAError.
+	LocalCA      string `json:"local_ca"`
+	LocalCAError string `json:"local_ca_error,omitempty"`
+}
+
+// printCertsJSON prints the details of a chain of certificates, leaf first,
+// as a JSON array.
+func (m *mkcert) printCertsJSON(certs []*x509.Certificate) error {
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	var results []inspectedCert
+	for _, cert := range certs {
+		sha256Sum, sha1Sum := sha256.Sum256(cert.Raw), sha1.Sum(cert.Raw)
+		r := inspectedCert{
+			Subject:            cert.Subject.String(),
+			Issuer:             cert.Issuer.String(),
+			Names:              issuer.Hosts(cert),
+			NotBefore:          cert.NotBefore,
+			NotAfter:           cert.NotAfter,
+			Status:             "valid",
+			Key:                keyDescription(cert),
+			SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+			CA:                 cert.IsCA,
+			KeyUsage:           keyUsages(cert.KeyUsage),
+			ExtKeyUsage:        extKeyUsages(cert.ExtKeyUsage),
+			Serial:             fmt.Sprintf("%X", cert.SerialNumber),
+			SHA256:             fingerprint(sha256Sum[:]),
+			SHA1:               fingerprint(sha1Sum[:]),
+			SPKIPin:            spkiPin(cert),
+			LocalCA:            "chains",
+		}
+		switch now := time.Now(); {
+		case now.Before(cert.NotBefore):
+			r.Status = "not_yet_valid"
+		case now.After(cert.NotAfter):
+			r.Status = "expired"
+		}
+		switch err := m.verifyLocalCA(cert, intermediates); {
+		case cert.Equal(m.ca.Cert):
+			r.LocalCA = "self"
+		case err != nil:
+			r.LocalCA, r.LocalCAError = "no", err.Error()
+		}
+		results = append(results, r)
+	}
+	out, err := json.MarshalIndent(results, "", "\t")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, string(out))
+	return nil
+}
+
+// verifyLocalCA checks that cert chains to the local CA, through any of
+// intermediates, for any usage.
+func (m *mkcert) verifyLocalCA(cert *x509.Certificate, intermediates *x509.CertPool) error {
+	roots := x509.NewCertPool()
+	roots.AddCert(m.ca.Cert)
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots: roots, Intermediates: intermediates,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+// printCerts prints the details of a chain of certificates, leaf first.
+func (m *mkcert) printCerts(certs []*x509.Certificate) {
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+
+	for i, cert := range certs {
+		if i > 0 {
+			fmt.Println()
+		}
+		printField := func(name, value string) {
+			fmt.Fprintf(stdout, "%-16s%s\n", name+":", value)
+		}
+
+		printField("Subject", cert.Subject.String())
+		printField("Issuer", cert.Issuer.String())
+		if names := issuer.Hosts(cert); len(names) > 0 {
+			printField("Names", strings.Join(names, ", "))
+		}
+		printField("Valid", fmt.Sprintf("%s to %s (%s)",
+			cert.NotBefore.Format("2006-01-02 15:04 MST"), cert.NotAfter.Format("2006-01-02 15:04 MST"), validity(cert)))
+		printField("Key", keyDescription(cert))
+		printField("Signature", cert.SignatureAlgorithm.String())
+		if cert.IsCA {
+			pathLen := "unlimited intermediates"
+			if cert.MaxPathLen > 0 || cert.MaxPathLenZero {
+				pathLen = fmt.Sprintf("at most %d intermediates", cert.MaxPathLen)
+			}
+			printField("CA", "yes, "+pathLen)
+		}
+		if usages := keyUsages(cert.KeyUsage); len(usages) > 0 {
+			printField("Key usage", strings.Join(usages, ", "))
+		}
+		if usages := extKeyUsages(cert.ExtKeyUsage); len(usages) > 0 {
+			printField("Ext key usage", strings.Join(usages, ", "))
+		}
+		printField("Serial", fmt.Sprintf("%X", cert.SerialNumber))
+		sha256Sum, sha1Sum := sha256.Sum256(cert.Raw), sha1.Sum(cert.Raw)
+		printField("SHA-256", fingerprint(sha256Sum[:]))
+		printField("SHA-1", fingerprint(sha1Sum[:]))
+		printField("SPKI pin", spkiPin(cert))
+
+		switch err := m.verifyLocalCA(cert, intermediates); {
+		case cert.Equal(m.ca.Cert):
+			printField("Local CA", "this is the local CA ✅")
+		case err == nil:
+			printField("Local CA", "chains to the local CA ✅")
+		default:
+			printField("Local CA", fmt.Sprintf("does not chain to the local CA ❌ (%v)", err))
+		}
+	}
+}
+
+func validity(cert *x509.Certificate) string {
+	switch now := time.Now(); {
+	case now.Before(cert.NotBefore):
+		return "not yet valid"
+	case now.After(cert.NotAfter):
+		return "expired"
+	default:
+		return fmt.Sprintf("%d days left", int(cert.NotAfter.Sub(now).Hours()/24))
+	}
+}
+
+func keyDescription(cert *x509.Certificate) string {
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + pub.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return cert.PublicKeyAlgorithm.String()
+	}
+}
+
+func fingerprint(sum []byte) string {
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, ":")
+}
+
+// spkiPin returns the base64 SHA-256 hash of the SubjectPublicKeyInfo of
+// cert, as used for certificate pinning.
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+var keyUsageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "Digital Signature"},
+	{x509.KeyUsageContentCommitment, "Content Commitment"},
+	{x509.KeyUsageKeyEncipherment, "Key Encipherment"},
+	{x509.KeyUsageDataEncipherment, "Data Encipherment"},
+	{x509.KeyUsageKeyAgreement, "Key Agreement"},
+	{x509.KeyUsageCertSign, "Certificate Sign"},
+	{x509.KeyUsageCRLSign, "CRL Sign"},
+	{x509.KeyUsageEncipherOnly, "Encipher Only"},
+	{x509.KeyUsageDecipherOnly, "Decipher Only"},
+}
+
+func keyUsages(ku x509.KeyUsage) []string {
+	var names []string
+	for _, u := range keyUsageNames {
+		if ku&u.usage != 0 {
+			names = append(names, u.name)
+		}
+	}
+	return names
+}
+
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "Any",
+	x509.ExtKeyUsageServerAuth:      "Server Authentication",
+	x509.ExtKeyUsageClientAuth:      "Client Authentication",
+	x509.ExtKeyUsageCodeSigning:     "Code Signing",
+	x509.ExtKeyUsageEmailProtection: "Email Protection",
+	x509.ExtKeyUsageTimeStamping:    "Time Stamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSP Signing",
+}
+
+func extKeyUsages(ekus []x509.ExtKeyUsage) []string {
+	var names []string
+	for _, u := range ekus {
+		if name, ok := extKeyUsageNames[u]; ok {
+			names = append(names, name)
+		} else {
+			names = append(names, fmt.Sprintf("unknown (%d)", u))
+		}
+	}
+	return names
+}
@@ -0,0 +1,267 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package issuer
+
+import (
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// RootName is the file name of the CA certificate inside CAROOT.
+	RootName = "rootCA.pem"
+	// RootKeyName is the file name of the CA key inside CAROOT.
+	RootKeyName = "rootCA-key.pem"
+)
+
+// ErrNoCAKey is returned when trying to issue certificates from a CA loaded
+// without its private key.
+var ErrNoCAKey = errors.New("can't create new certificates because the CA key (rootCA-key.pem) is missing")
+
+// CA is a local certificate authority.
+type CA struct {
+	Cert *x509.Certificate
+
+	// Key is nil if the CA was loaded in keyless mode, where only trust store
+	// installation works. It can be replaced with any crypto.Signer, such as
+	// one backed by a KMS, or with a RemoteKey.
+	Key crypto.PrivateKey
+
+	// Policy, if not nil, constrains the leaf certificates issued by the CA
+	// and by its intermediates.
+	Policy *Policy
+}
+
+// LoadCA loads the CA certificate and, if present, key from caroot.
+func LoadCA(caroot string) (*CA, error) {
+	return LoadCAWithKeyDir(caroot, caroot)
+}
+
+// LoadCAWithKeyDir is like LoadCA, but reads the key from keyDir, for keys
+// kept offline on a removable or encrypted volume. If keyDir or the key
+// doesn't exist, like when the volume is not mounted, the CA is loaded in
+// keyless mode, and caroot is only read.
+func LoadCAWithKeyDir(caroot, keyDir string) (*CA, error) {
+	certPEMBlock, err := ioutil.ReadFile(filepath.Join(caroot, RootName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CA certificate: %w", err)
+	}
+	certDERBlock, _ := pem.Decode(certPEMBlock)
+	if certDERBlock == nil || certDERBlock.Type != "CERTIFICATE" {
+		return nil, errors.New("failed to read the CA certificate: unexpected content")
+	}
+	ca := &CA{}
+	ca.Cert, err = x509.ParseCertificate(certDERBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the CA certificate: %w", err)
+	}
+	ca.Policy, err = LoadPolicy(caroot)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEMBlock, err := ioutil.ReadFile(filepath.Join(keyDir, RootKeyName))
+	if os.IsNotExist(err) {
+		return ca, nil // keyless mode, where only -install works
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CA key: %w", err)
+	}
+	keyDERBlock, _ := pem.Decode(keyPEMBlock)
+	if keyDERBlock == nil || keyDERBlock.Type != "PRIVATE KEY" {
+		return nil, errors.New("failed to read the CA key: unexpected content")
+	}
+	ca.Key, err = x509.ParsePKCS8PrivateKey(keyDERBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the CA key: %w", err)
+	}
+
+	return ca, nil
+}
+
+// NewCA generates a new CA and saves it to caroot, overwriting any existing
+// one. Only opts.ECDSA, opts.Curve and opts.RSABits, which select the key
+// type, opts.Key, opts.KeyDir, opts.Template, opts.Rand and opts.Now are
+// used. If opts.Key is set, it's
+// used as the CA key instead of generating one, and it's not saved to
+// caroot. If opts.KeyDir is set, the key is saved there instead.
+//
+// The CA can't issue intermediates, unless opts.Template lifts its path
+// length constraint.
+func NewCA(caroot string, opts *Options) (*CA, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	var priv crypto.PrivateKey = opts.Key
+	if opts.Key == nil {
+		var err error
+		priv, err = generateKey(opts, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate the CA key: %w", err)
+		}
+	}
+	pub := priv.(crypto.Signer).Public()
+
+	skid, err := subjectKeyID(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := randomSerialNumber(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization:       []string{"mkcert development CA"},
+			OrganizationalUnit: []string{userAndHostname},
+
+			// The CommonName is required by iOS to show the certificate in the
+			// "Certificate Trust Settings" menu.
+			// https://github.com/FiloSottile/mkcert/issues/47
+			CommonName: "mkcert " + userAndHostname,
+		},
+		SubjectKeyId: skid,
+
+		NotAfter:  opts.now().AddDate(10, 0, 0),
+		NotBefore: opts.now(),
+
+		KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+
+		ExtraExtensions: versionExtensions(),
+	}
+
+	if opts.Template != nil {
+		if err := opts.Template(tpl); err != nil {
+			return nil, err
+		}
+	}
+	cert, err := x509.CreateCertificate(opts.rand(), tpl, tpl, pub, priv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA certificate: %w", err)
+	}
+	ca := &CA{Key: priv}
+	ca.Cert, err = x509.ParseCertificate(cert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the CA certificate: %w", err)
+	}
+
+	if opts.Key == nil {
+		privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode CA key: %w", err)
+		}
+		keyDir := caroot
+		if opts.KeyDir != "" {
+			keyDir = opts.KeyDir
+		}
+		err = ioutil.WriteFile(filepath.Join(keyDir, RootKeyName), pem.EncodeToMemory(
+			&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0400)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save CA key: %w", err)
+		}
+	}
+
+	err = ioutil.WriteFile(filepath.Join(caroot, RootName), pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save CA certificate: %w", err)
+	}
+
+	return ca, nil
+}
+
+// NewIntermediate issues a subordinate CA with a new key, valid until the
+// issuing CA expires. It's not saved anywhere. opts.CommonName names it, and
+// opts.Template can set its path length constraint, which by default allows
+// it to only issue leaves.
+func (ca *CA) NewIntermediate(opts *Options) (*CA, error) {
+	if ca.Key == nil {
+		return nil, ErrNoCAKey
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	priv, err := generateKey(opts, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate the intermediate key: %w", err)
+	}
+	pub := priv.(crypto.Signer).Public()
+
+	skid, err := subjectKeyID(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := randomSerialNumber(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization:       []string{"mkcert development CA"},
+			OrganizationalUnit: []string{userAndHostname},
+			CommonName:         opts.CommonName,
+		},
+		SubjectKeyId: skid,
+
+		NotAfter:  ca.Cert.NotAfter,
+		NotBefore: opts.now(),
+
+		KeyUsage: x509.KeyUsageCertSign,
+
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+
+		ExtraExtensions: versionExtensions(),
+	}
+
+	cert, err := ca.sign(tpl, pub, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{Cert: cert, Key: priv, Policy: ca.Policy}, nil
+}
+
+// subjectKeyID returns the SHA-1 hash of the public key bits, as in method
+// (1) of RFC 5280, Section 4.2.1.2.
+func subjectKeyID(pub crypto.PublicKey) ([]byte, error) {
+	spkiASN1, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+
+	var spki struct {
+		Algorithm        pkix.AlgorithmIdentifier
+		SubjectPublicKey asn1.BitString
+	}
+	_, err = asn1.Unmarshal(spkiASN1, &spki)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode public key: %w", err)
+	}
+
+	skid := sha1.Sum(spki.SubjectPublicKey.Bytes)
+	return skid[:], nil
+}
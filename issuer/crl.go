@@ -0,0 +1,67 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package issuer
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// A Revocation is a certificate revoked by the CA.
+type Revocation struct {
+	SerialNumber *big.Int
+	RevokedAt    time.Time
+}
+
+// SignCRL returns a DER CRL of the revoked certificates, signed by the CA and
+// valid for a week. Its number is the current Unix time, so that newer CRLs
+// have higher numbers.
+//
+// CAs created before mkcert set the CRL Sign key usage are still used to
+// sign the CRL, but strict clients reject it. See HasCRLSign.
+func (ca *CA) SignCRL(revoked []Revocation, opts *Options) ([]byte, error) {
+	if ca.Key == nil {
+		return nil, ErrNoCAKey
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+	signer, ok := ca.Key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("the CA key can't sign")
+	}
+
+	now := opts.now()
+	tpl := &x509.RevocationList{
+		Number:     big.NewInt(now.Unix()),
+		ThisUpdate: now,
+		NextUpdate: now.AddDate(0, 0, 7),
+	}
+	for _, r := range revoked {
+		tpl.RevokedCertificates = append(tpl.RevokedCertificates, pkix.RevokedCertificate{
+			SerialNumber: r.SerialNumber, RevocationTime: r.RevokedAt,
+		})
+	}
+
+	// crypto/x509 refuses to sign with an issuer without the CRL Sign key
+	// usage, but only looks at the parsed field.
+	parent := *ca.Cert
+	parent.KeyUsage |= x509.KeyUsageCRLSign
+	crl, err := x509.CreateRevocationList(opts.rand(), tpl, &parent, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign the CRL: %w", err)
+	}
+	return crl, nil
+}
+
+// HasCRLSign reports whether the CA certificate allows signing CRLs.
+func (ca *CA) HasCRLSign() bool {
+	return ca.Cert.KeyUsage&x509.KeyUsageCRLSign != 0
+}
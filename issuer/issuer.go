@@ -0,0 +1,590 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package issuer implements the local CA and certificate issuance of mkcert,
+// so that other Go programs can embed it instead of running the mkcert binary.
+package issuer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"os/user"
+	"time"
+)
+
+var userAndHostname string
+
+func init() {
+	u, err := user.Current()
+	if err == nil {
+		userAndHostname = u.Username + "@"
+	}
+	if h, err := os.Hostname(); err == nil {
+		userAndHostname += h
+	}
+	if err == nil && u.Name != "" && u.Name != u.Username {
+		userAndHostname += " (" + u.Name + ")"
+	}
+}
+
+// Options controls how keys are generated and certificates are issued.
+// A nil *Options is equivalent to the zero value.
+type Options struct {
+	// ECDSA selects ECDSA keys instead of RSA ones.
+	ECDSA bool
+
+	// Curve is the curve of ECDSA keys. If nil, P-256 is used.
+	Curve elliptic.Curve
+
+	// Ed25519 selects Ed25519 keys for the leaf certificates instead of RSA
+	// ones. It's ignored by NewCA, as few clients accept Ed25519 roots.
+	Ed25519 bool
+
+	// RSABits is the size of RSA keys, 2048, 3072 or 4096. If zero, leaves
+	// get 2048-bit keys and CAs 3072-bit ones.
+	RSABits int
+
+	// CommonName, if set, is used as the deprecated Subject Common Name.
+	CommonName string
+
+	// CSRPolicy controls which requested extensions SignCSR honors.
+	CSRPolicy CSRPolicy
+
+	// Template, if set, is called with the template of each leaf certificate
+	// right before it's signed, and can modify any of its fields, for example
+	// to add policies or extensions. If it returns an error, the issuance is
+	// aborted with that error.
+	Template func(tpl *x509.Certificate) error
+
+	// Extensions are added to each leaf certificate as is, after Template is
+	// called. They replace any extension with the same OID, including the
+	// ones crypto/x509 encodes from the template fields, like the Subject
+	// Alternative Names.
+	Extensions []pkix.Extension
+
+	// DryRun, if set, makes the certificate be signed by a throwaway key
+	// instead of the CA key, so that it can be reviewed before it's issued
+	// for real. It's otherwise exactly what would be issued, but its
+	// signature doesn't verify.
+	DryRun bool
+
+	// Rand, if set, is the source of randomness for keys, serial numbers and
+	// signatures, instead of crypto/rand.Reader.
+	//
+	// Note that RSA and ECDSA key generation and ECDSA signatures are
+	// randomized by the standard library regardless of Rand, so
+	// byte-identical certificates can only be obtained from an RSA CA, with
+	// Ed25519 leaf keys, or with SignCSR or Renew.
+	Rand io.Reader
+
+	// Now, if set, replaces time.Now as the source of the validity period.
+	Now func() time.Time
+
+	// NotAfter, if not zero, is when the leaf certificates expire, instead
+	// of 2 years and 3 months from now.
+	NotAfter time.Time
+
+	// Key, if set, is used as the leaf key by IssueServer and IssueClient
+	// instead of generating a new one. It can be backed by a PKCS #11 token,
+	// a cloud KMS or a TPM, so that the private key never exists in memory.
+	Key crypto.Signer
+
+	// KeyDir, if set, is where NewCA saves the CA key, instead of next to
+	// the certificate. See LoadCAWithKeyDir.
+	KeyDir string
+
+	// NewKey makes Renew generate a new key, of the same type and size as
+	// the one of the old certificate, instead of reusing its public key.
+	NewKey bool
+}
+
+func (opts *Options) rand() io.Reader {
+	if opts.Rand != nil {
+		return opts.Rand
+	}
+	return rand.Reader
+}
+
+func (opts *Options) now() time.Time {
+	if opts.Now != nil {
+		return opts.Now()
+	}
+	return time.Now()
+}
+
+// CSRPolicy controls which parts of a CSR are copied into the certificate.
+// The zero value copies all requested extensions, which is only appropriate
+// for CSRs from trusted tools.
+type CSRPolicy struct {
+	// SANsOnly ignores all requested extensions except the Subject
+	// Alternative Names, so the default key usages are used.
+	SANsOnly bool
+
+	// CopyEKUs, together with SANsOnly, also honors the requested Extended
+	// Key Usages.
+	CopyEKUs bool
+
+	// RejectCA makes SignCSR fail if the CSR requests a CA certificate.
+	RejectCA bool
+}
+
+// ErrCSRRequestsCA is returned by SignCSR if the CSR requests a CA
+// certificate and CSRPolicy.RejectCA is set.
+var ErrCSRRequestsCA = errors.New("the CSR requests a CA certificate")
+
+var (
+	oidExtensionSubjectAltName   = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidExtensionBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
+	oidExtensionExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+)
+
+// Certificate is an issued certificate.
+type Certificate struct {
+	Cert *x509.Certificate
+
+	// Key is the private key generated for the certificate, or Options.Key.
+	// It is nil if the certificate was issued from a CSR.
+	Key crypto.PrivateKey
+}
+
+// CertPEM returns the PEM encoding of the certificate.
+func (c *Certificate) CertPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Cert.Raw})
+}
+
+// KeyPEM returns the PEM encoding of the private key, in PKCS #8 format.
+// It fails if the key is an external crypto.Signer that can't be exported.
+func (c *Certificate) KeyPEM() ([]byte, error) {
+	privDER, err := x509.MarshalPKCS8PrivateKey(c.Key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), nil
+}
+
+// IssueServer issues a certificate for the given hosts, which can be
+// hostnames, IP addresses, URLs, or email addresses.
+func (ca *CA) IssueServer(hosts []string, opts *Options) (*Certificate, error) {
+	return ca.issue(hosts, false, opts)
+}
+
+// IssueClient is like IssueServer, but the certificate is also valid for
+// client authentication.
+func (ca *CA) IssueClient(hosts []string, opts *Options) (*Certificate, error) {
+	return ca.issue(hosts, true, opts)
+}
+
+func (ca *CA) issue(hosts []string, client bool, opts *Options) (*Certificate, error) {
+	if ca.Key == nil {
+		return nil, ErrNoCAKey
+	}
+	if len(hosts) == 0 {
+		return nil, errors.New("no hosts specified")
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	var priv crypto.PrivateKey = opts.Key
+	if opts.Key == nil {
+		var err error
+		priv, err = generateKey(opts, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate certificate key: %w", err)
+		}
+	}
+	pub := priv.(crypto.Signer).Public()
+
+	serial, err := randomSerialNumber(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization:       []string{"mkcert development certificate"},
+			OrganizationalUnit: []string{userAndHostname},
+			CommonName:         opts.CommonName,
+		},
+
+		NotBefore: opts.now(), NotAfter: expiration(opts),
+
+		KeyUsage: x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+
+		ExtraExtensions: versionExtensions(),
+	}
+	if _, ok := pub.(ed25519.PublicKey); ok {
+		// Ed25519 keys can only sign.
+		tpl.KeyUsage = x509.KeyUsageDigitalSignature
+	}
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tpl.IPAddresses = append(tpl.IPAddresses, ip)
+		} else if email, err := mail.ParseAddress(h); err == nil && email.Address == h {
+			tpl.EmailAddresses = append(tpl.EmailAddresses, h)
+		} else if uriName, err := url.Parse(h); err == nil && uriName.Scheme != "" && uriName.Host != "" {
+			tpl.URIs = append(tpl.URIs, uriName)
+		} else {
+			tpl.DNSNames = append(tpl.DNSNames, h)
+		}
+	}
+
+	if client {
+		tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+	}
+	if len(tpl.IPAddresses) > 0 || len(tpl.DNSNames) > 0 || len(tpl.URIs) > 0 {
+		tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+	}
+	if len(tpl.EmailAddresses) > 0 {
+		tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, x509.ExtKeyUsageEmailProtection)
+	}
+
+	cert, err := ca.sign(tpl, pub, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Certificate{Cert: cert, Key: priv}, nil
+}
+
+// ParseCSR parses a PEM-encoded certificate signing request and checks its
+// signature.
+func ParseCSR(csrPEMBytes []byte) (*x509.CertificateRequest, error) {
+	csrPEM, _ := pem.Decode(csrPEMBytes)
+	if csrPEM == nil {
+		return nil, errors.New("failed to read the CSR: unexpected content")
+	}
+	if csrPEM.Type != "CERTIFICATE REQUEST" &&
+		csrPEM.Type != "NEW CERTIFICATE REQUEST" {
+		return nil, errors.New("failed to read the CSR: expected CERTIFICATE REQUEST, got " + csrPEM.Type)
+	}
+	csr, err := x509.ParseCertificateRequest(csrPEM.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the CSR: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid CSR signature: %w", err)
+	}
+	return csr, nil
+}
+
+// SignCSR issues a certificate for the public key and names in csr. The
+// signature of csr must have already been checked, for example by ParseCSR.
+func (ca *CA) SignCSR(csr *x509.CertificateRequest, opts *Options) (*Certificate, error) {
+	if ca.Key == nil {
+		return nil, ErrNoCAKey
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	serial, err := randomSerialNumber(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var extensions []pkix.Extension
+	for _, ext := range csr.Extensions {
+		if ext.Id.Equal(oidExtensionBasicConstraints) && opts.CSRPolicy.RejectCA {
+			var constraints struct {
+				IsCA bool `asn1:"optional"`
+			}
+			if _, err := asn1.Unmarshal(ext.Value, &constraints); err != nil || constraints.IsCA {
+				return nil, ErrCSRRequestsCA
+			}
+		}
+		if opts.CSRPolicy.SANsOnly && !ext.Id.Equal(oidExtensionSubjectAltName) &&
+			!(opts.CSRPolicy.CopyEKUs && ext.Id.Equal(oidExtensionExtendedKeyUsage)) {
+			continue
+		}
+		extensions = append(extensions, ext)
+	}
+	extensions = append(extensions, versionExtensions()...)
+
+	tpl := &x509.Certificate{
+		SerialNumber:    serial,
+		Subject:         csr.Subject,
+		ExtraExtensions: extensions, // includes requested SANs, KUs and EKUs, as allowed by the policy
+
+		NotBefore: opts.now(), NotAfter: expiration(opts),
+
+		// If the CSR does not request a SAN extension, fix it up for them as
+		// the Common Name field does not work in modern browsers. Otherwise,
+		// this will get overridden.
+		DNSNames: []string{csr.Subject.CommonName},
+
+		// Likewise, if the CSR does not set KUs and EKUs, fix it up as Apple
+		// platforms require serverAuth for TLS.
+		KeyUsage:    x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	if len(csr.EmailAddresses) > 0 {
+		tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, x509.ExtKeyUsageEmailProtection)
+	}
+
+	cert, err := ca.sign(tpl, csr.PublicKey, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Certificate{Cert: cert}, nil
+}
+
+// Renew issues a new certificate with the same public key, subject, names
+// and key usages as old, and a fresh serial number and validity period.
+// With opts.NewKey, the returned Certificate has a new Key instead.
+func (ca *CA) Renew(old *x509.Certificate, opts *Options) (*Certificate, error) {
+	if ca.Key == nil {
+		return nil, ErrNoCAKey
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	serial, err := randomSerialNumber(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      old.Subject,
+
+		NotBefore: opts.now(), NotAfter: expiration(opts),
+
+		DNSNames:       old.DNSNames,
+		EmailAddresses: old.EmailAddresses,
+		IPAddresses:    old.IPAddresses,
+		URIs:           old.URIs,
+
+		KeyUsage:           old.KeyUsage,
+		ExtKeyUsage:        old.ExtKeyUsage,
+		UnknownExtKeyUsage: old.UnknownExtKeyUsage,
+
+		ExtraExtensions: versionExtensions(),
+	}
+
+	pub := old.PublicKey
+	var key crypto.PrivateKey
+	if opts.NewKey {
+		k, err := keyLike(old.PublicKey, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate the new key: %w", err)
+		}
+		key, pub = k, k.Public()
+	}
+	cert, err := ca.sign(tpl, pub, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Certificate{Cert: cert, Key: key}, nil
+}
+
+func (ca *CA) sign(tpl *x509.Certificate, pub crypto.PublicKey, opts *Options) (*x509.Certificate, error) {
+	if opts.Template != nil {
+		if err := opts.Template(tpl); err != nil {
+			return nil, err
+		}
+	}
+	for _, ext := range opts.Extensions {
+		extensions := tpl.ExtraExtensions[:0:0]
+		for _, e := range tpl.ExtraExtensions {
+			if !e.Id.Equal(ext.Id) {
+				extensions = append(extensions, e)
+			}
+		}
+		tpl.ExtraExtensions = append(extensions, ext)
+	}
+	ca.Policy.limitLifetime(tpl)
+	parent, key := ca.Cert, ca.Key
+	remote, isRemote := ca.Key.(RemoteKey)
+	if opts.DryRun || isRemote {
+		// A key like the CA's keeps the signature algorithm the same.
+		throwaway, err := keyLike(ca.Cert.PublicKey, opts)
+		if err != nil {
+			return nil, err
+		}
+		// Keep the issuer name and key identifier of the CA.
+		dryRunParent := *ca.Cert
+		dryRunParent.PublicKey = throwaway.Public()
+		parent, key = &dryRunParent, throwaway
+	}
+	der, err := x509.CreateCertificate(opts.rand(), tpl, parent, pub, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated certificate: %w", err)
+	}
+	if isRemote && !opts.DryRun {
+		cert, err = remote.SignDraft(cert)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := ca.Policy.check(cert); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// RemoteKey is a CA Key held by another process, like one running as root,
+// which signs whole leaf certificates instead of arbitrary digests, so that
+// it can apply its own checks to what it signs. The other process usually
+// implements it with CA.SignDraft.
+//
+// Only leaf certificates can be issued with a RemoteKey. Intermediates,
+// CRLs and OCSP responses need a CA Key that is a crypto.Signer.
+type RemoteKey interface {
+	// SignDraft returns the certificate issued for draft, a leaf signed by
+	// a throwaway key like with Options.DryRun.
+	SignDraft(draft *x509.Certificate) (*x509.Certificate, error)
+}
+
+// ErrDraftIsCA is returned by SignDraft if the draft is a CA certificate.
+var ErrDraftIsCA = errors.New("the draft is a CA certificate")
+
+// SignDraft issues a leaf certificate with the contents of draft, like one
+// made with Options.DryRun by the other side of a RemoteKey. It rejects CA
+// certificates, and applies the Policy of ca, so it can be exposed to less
+// trusted users than the CA key itself. The serial number, validity
+// period, subject, public key, signature algorithm and extensions of draft
+// are kept, and the issuer and signature are replaced.
+func (ca *CA) SignDraft(draft *x509.Certificate) (*x509.Certificate, error) {
+	signer, ok := ca.Key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("the CA key can't sign")
+	}
+	if draft.IsCA || draft.KeyUsage&(x509.KeyUsageCertSign|x509.KeyUsageCRLSign) != 0 {
+		return nil, ErrDraftIsCA
+	}
+
+	// The extensions are copied as they are, and in the same order, which
+	// overrides the ones crypto/x509 would encode from the template fields.
+	// The Authority Key Identifier must already be the one of ca.
+	if len(draft.AuthorityKeyId) != 0 && !bytes.Equal(draft.AuthorityKeyId, ca.Cert.SubjectKeyId) {
+		return nil, errors.New("the draft is not for this CA: the authority key identifier doesn't match")
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:       draft.SerialNumber,
+		Subject:            draft.Subject,
+		NotBefore:          draft.NotBefore,
+		NotAfter:           draft.NotAfter,
+		SignatureAlgorithm: draft.SignatureAlgorithm,
+		ExtraExtensions:    draft.Extensions,
+	}
+	ca.Policy.limitLifetime(tpl)
+	der, err := x509.CreateCertificate(rand.Reader, tpl, ca.Cert, draft.PublicKey, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated certificate: %w", err)
+	}
+	// The Basic Constraints are in the copied extensions, so check again.
+	if cert.IsCA || cert.KeyUsage&(x509.KeyUsageCertSign|x509.KeyUsageCRLSign) != 0 {
+		return nil, ErrDraftIsCA
+	}
+	if err := ca.Policy.check(cert); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// Hosts returns the names a certificate is valid for, in the format accepted
+// by IssueServer and IssueClient.
+func Hosts(cert *x509.Certificate) []string {
+	var hosts []string
+	hosts = append(hosts, cert.DNSNames...)
+	hosts = append(hosts, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		hosts = append(hosts, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		hosts = append(hosts, uri.String())
+	}
+	return hosts
+}
+
+// expiration returns the NotAfter of a new leaf certificate.
+//
+// Unless opts.NotAfter is set, certificates last for 2 years and 3 months,
+// which is always less than 825 days, the limit that macOS/iOS apply to all
+// certificates, including custom roots. See
+// https://support.apple.com/en-us/HT210176.
+func expiration(opts *Options) time.Time {
+	if !opts.NotAfter.IsZero() {
+		return opts.NotAfter
+	}
+	return opts.now().AddDate(2, 3, 0)
+}
+
+// keyLike generates a new key of the same type and size as pub.
+func keyLike(pub crypto.PublicKey, opts *Options) (crypto.Signer, error) {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.GenerateKey(pub.Curve, opts.rand())
+	case ed25519.PublicKey:
+		_, priv, err := ed25519.GenerateKey(opts.rand())
+		return priv, err
+	case *rsa.PublicKey:
+		return rsa.GenerateKey(opts.rand(), pub.N.BitLen())
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", pub)
+	}
+}
+
+func generateKey(opts *Options, rootCA bool) (crypto.PrivateKey, error) {
+	if opts.Ed25519 && !rootCA {
+		_, priv, err := ed25519.GenerateKey(opts.rand())
+		return priv, err
+	}
+	if opts.ECDSA {
+		curve := opts.Curve
+		if curve == nil {
+			curve = elliptic.P256()
+		}
+		return ecdsa.GenerateKey(curve, opts.rand())
+	}
+	switch opts.RSABits {
+	case 0:
+	case 2048, 3072, 4096:
+		return rsa.GenerateKey(opts.rand(), opts.RSABits)
+	default:
+		return nil, fmt.Errorf("unsupported RSA key size %d, options are 2048, 3072 and 4096", opts.RSABits)
+	}
+	if rootCA {
+		return rsa.GenerateKey(opts.rand(), 3072)
+	}
+	return rsa.GenerateKey(opts.rand(), 2048)
+}
+
+func randomSerialNumber(opts *Options) (*big.Int, error) {
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(opts.rand(), serialNumberLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return serialNumber, nil
+}
@@ -0,0 +1,218 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package issuer
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	mathrand "math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSignDraft(t *testing.T) {
+	ca, err := NewCA(t.TempDir(), &Options{ECDSA: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The CA itself implements RemoteKey, like mkcert -sign-draft does.
+	remote := &CA{Cert: ca.Cert, Key: ca}
+
+	cert, err := remote.IssueServer([]string{"example.test"}, &Options{ECDSA: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.Cert.CheckSignatureFrom(ca.Cert); err != nil {
+		t.Errorf("the certificate is not signed by the CA: %v", err)
+	}
+	if len(cert.Cert.DNSNames) != 1 || cert.Cert.DNSNames[0] != "example.test" {
+		t.Errorf("got names %v, want example.test", cert.Cert.DNSNames)
+	}
+
+	if _, err := remote.NewIntermediate(nil); !errors.Is(err, ErrDraftIsCA) {
+		t.Errorf("issuing an intermediate: got %v, want ErrDraftIsCA", err)
+	}
+	draft, err := ca.NewIntermediate(&Options{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ca.SignDraft(draft.Cert); !errors.Is(err, ErrDraftIsCA) {
+		t.Errorf("signing a CA draft: got %v, want ErrDraftIsCA", err)
+	}
+
+	// The policy of the signing side applies, not the one of the requester.
+	ca.Policy = &Policy{AllowedSuffixes: []string{"test"}, MaxLifetimeDays: 30}
+	if _, err := remote.IssueServer([]string{"example.com"}, nil); !errors.Is(err, ErrPolicyViolation) {
+		t.Errorf("issuing for example.com: got %v, want ErrPolicyViolation", err)
+	}
+	cert, err = remote.IssueServer([]string{"example.test"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lifetime := cert.Cert.NotAfter.Sub(cert.Cert.NotBefore); lifetime > 30*24*time.Hour {
+		t.Errorf("got a lifetime of %v, want at most 30 days", lifetime)
+	}
+}
+
+func TestSignDraftExtensions(t *testing.T) {
+	ca, err := NewCA(t.TempDir(), &Options{ECDSA: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	draft, err := ca.IssueClient([]string{"alice@example.test", "127.0.0.1"}, &Options{DryRun: true,
+		Template: func(tpl *x509.Certificate) error {
+			tpl.SignatureAlgorithm = x509.ECDSAWithSHA384
+			return nil
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ca.SignDraft(draft.Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalExtensions(cert, draft.Cert) {
+		t.Errorf("the extensions changed: got %v, want %v", cert.Extensions, draft.Cert.Extensions)
+	}
+	if cert.SignatureAlgorithm != x509.ECDSAWithSHA384 {
+		t.Errorf("got signature algorithm %v, want %v", cert.SignatureAlgorithm, x509.ECDSAWithSHA384)
+	}
+	if cert.SerialNumber.Cmp(draft.Cert.SerialNumber) != 0 || !cert.NotAfter.Equal(draft.Cert.NotAfter) {
+		t.Error("the serial number or validity period changed")
+	}
+}
+
+func equalExtensions(a, b *x509.Certificate) bool {
+	if len(a.Extensions) != len(b.Extensions) {
+		return false
+	}
+	for i := range a.Extensions {
+		if !a.Extensions[i].Id.Equal(b.Extensions[i].Id) || string(a.Extensions[i].Value) != string(b.Extensions[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSignCSRPolicy(t *testing.T) {
+	ca, err := NewCA(t.TempDir(), &Options{ECDSA: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oidCustom := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}
+	newCSR := func(isCA bool) *x509.CertificateRequest {
+		constraints, err := asn1.Marshal(struct {
+			IsCA bool `asn1:"optional"`
+		}{isCA})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ekus, err := asn1.Marshal([]asn1.ObjectIdentifier{
+			{1, 3, 6, 1, 5, 5, 7, 3, 2}, // clientAuth
+			{1, 3, 6, 1, 5, 5, 7, 3, 3}, // codeSigning
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			DNSNames: []string{"example.test"},
+			ExtraExtensions: []pkix.Extension{
+				{Id: oidExtensionBasicConstraints, Critical: true, Value: constraints},
+				{Id: oidExtensionExtendedKeyUsage, Value: ekus},
+				{Id: oidCustom, Value: []byte{0x05, 0x00}},
+			},
+		}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return csr
+	}
+	requested := []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageCodeSigning}
+	defaults := []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+
+	for _, tt := range []struct {
+		name       string
+		policy     CSRPolicy
+		requestCA  bool
+		wantErr    error
+		wantCA     bool
+		wantEKUs   []x509.ExtKeyUsage
+		wantCustom bool
+	}{
+		{name: "zero", requestCA: true, wantCA: true, wantEKUs: requested, wantCustom: true},
+		{name: "sans-only", policy: CSRPolicy{SANsOnly: true}, requestCA: true, wantEKUs: defaults},
+		{name: "copy-eku", policy: CSRPolicy{SANsOnly: true, CopyEKUs: true}, requestCA: true, wantEKUs: requested},
+		{name: "reject-ca", policy: CSRPolicy{RejectCA: true}, requestCA: true, wantErr: ErrCSRRequestsCA},
+		{name: "reject-ca leaf", policy: CSRPolicy{RejectCA: true}, wantEKUs: requested, wantCustom: true},
+		{name: "sans-only reject-ca", policy: CSRPolicy{SANsOnly: true, RejectCA: true}, requestCA: true, wantErr: ErrCSRRequestsCA},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, err := ca.SignCSR(newCSR(tt.requestCA), &Options{CSRPolicy: tt.policy})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cert.Cert.DNSNames) != 1 || cert.Cert.DNSNames[0] != "example.test" {
+				t.Errorf("got names %v, want example.test", cert.Cert.DNSNames)
+			}
+			if cert.Cert.IsCA != tt.wantCA {
+				t.Errorf("got IsCA %v, want %v", cert.Cert.IsCA, tt.wantCA)
+			}
+			if !reflect.DeepEqual(cert.Cert.ExtKeyUsage, tt.wantEKUs) {
+				t.Errorf("got EKUs %v, want %v", cert.Cert.ExtKeyUsage, tt.wantEKUs)
+			}
+			var custom bool
+			for _, ext := range cert.Cert.Extensions {
+				custom = custom || ext.Id.Equal(oidCustom)
+			}
+			if custom != tt.wantCustom {
+				t.Errorf("got the custom extension %v, want %v", custom, tt.wantCustom)
+			}
+		})
+	}
+}
+
+func TestDeterministicIssuance(t *testing.T) {
+	// Only RSA signatures and Ed25519 key generation are deterministic.
+	ca, err := NewCA(t.TempDir(), &Options{RSABits: 2048})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	issue := func() []byte {
+		cert, err := ca.IssueServer([]string{"example.test"}, &Options{
+			Ed25519: true,
+			Rand:    mathrand.New(mathrand.NewSource(1)),
+			Now:     func() time.Time { return now },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.Cert.Raw
+	}
+	if a, b := issue(), issue(); !bytes.Equal(a, b) {
+		t.Error("issuing twice with the same Rand and Now produced different certificates")
+	}
+}
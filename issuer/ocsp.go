@@ -0,0 +1,111 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package issuer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// OCSPStatus is the status of a certificate in an OCSP response.
+type OCSPStatus struct {
+	// Revoked marks the certificate as revoked. Otherwise it's good.
+	Revoked bool
+	// RevokedAt is the revocation time. If zero, ThisUpdate is used.
+	RevokedAt time.Time
+
+	// ThisUpdate is when the status was known to be correct. If zero, the
+	// current time is used.
+	ThisUpdate time.Time
+	// NextUpdate is when newer information will be available. If zero, it's
+	// a week after ThisUpdate.
+	NextUpdate time.Time
+}
+
+// SignOCSP returns a DER OCSP response for cert, signed directly by the CA.
+func (ca *CA) SignOCSP(cert *x509.Certificate, status OCSPStatus) ([]byte, error) {
+	if ca.Key == nil {
+		return nil, ErrNoCAKey
+	}
+	if err := cert.CheckSignatureFrom(ca.Cert); err != nil {
+		return nil, errors.New("the certificate was not issued by this CA")
+	}
+	return ca.signOCSP(cert.SerialNumber, status)
+}
+
+// RespondOCSP returns a DER OCSP response to the DER OCSP request req, with
+// the status returned by status for the requested serial number. If the
+// request is malformed or not for a certificate of this CA, it returns the
+// corresponding unsigned error response, like an OCSP responder would.
+func (ca *CA) RespondOCSP(req []byte, status func(serial *big.Int) OCSPStatus) ([]byte, error) {
+	if ca.Key == nil {
+		return nil, ErrNoCAKey
+	}
+	r, err := ocsp.ParseRequest(req)
+	if err != nil {
+		return ocsp.MalformedRequestErrorResponse, nil
+	}
+
+	// The issuer is identified by the hash of its public key, without the
+	// rest of the SubjectPublicKeyInfo.
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(ca.Cert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, err
+	}
+	if !r.HashAlgorithm.Available() {
+		return ocsp.UnauthorizedErrorResponse, nil
+	}
+	h := r.HashAlgorithm.New()
+	h.Write(spki.PublicKey.RightAlign())
+	if !bytes.Equal(h.Sum(nil), r.IssuerKeyHash) {
+		return ocsp.UnauthorizedErrorResponse, nil
+	}
+	return ca.signOCSP(r.SerialNumber, status(r.SerialNumber))
+}
+
+func (ca *CA) signOCSP(serial *big.Int, status OCSPStatus) ([]byte, error) {
+	tpl := ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: serial,
+		ThisUpdate:   status.ThisUpdate,
+		NextUpdate:   status.NextUpdate,
+	}
+	if tpl.ThisUpdate.IsZero() {
+		tpl.ThisUpdate = time.Now()
+	}
+	if tpl.NextUpdate.IsZero() {
+		tpl.NextUpdate = tpl.ThisUpdate.AddDate(0, 0, 7)
+	}
+	if status.Revoked {
+		tpl.Status = ocsp.Revoked
+		tpl.RevokedAt = status.RevokedAt
+		if tpl.RevokedAt.IsZero() {
+			tpl.RevokedAt = tpl.ThisUpdate
+		}
+		tpl.RevocationReason = ocsp.Unspecified
+	}
+
+	signer, ok := ca.Key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("the CA key can't sign")
+	}
+	resp, err := ocsp.CreateResponse(ca.Cert, ca.Cert, tpl, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign the OCSP response: %w", err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package issuer
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestEncryptedKeyPEMOpenSSL checks that OpenSSL decrypts the keys encrypted
+// by EncryptedKeyPEM, for each key type.
+func TestEncryptedKeyPEMOpenSSL(t *testing.T) {
+	if _, err := exec.LookPath("openssl"); err != nil {
+		t.Skip("openssl is not available")
+	}
+	ca, err := NewCA(t.TempDir(), &Options{ECDSA: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, opts := range map[string]*Options{
+		"rsa": {}, "ecdsa": {ECDSA: true}, "ed25519": {Ed25519: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cert, err := ca.IssueServer([]string{"example.test"}, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encrypted, err := cert.EncryptedKeyPEM("hunter22")
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "key.pem")
+			if err := ioutil.WriteFile(path, encrypted, 0600); err != nil {
+				t.Fatal(err)
+			}
+			out, err := exec.Command("openssl", "pkcs8", "-in", path, "-passin", "pass:hunter22").Output()
+			if err != nil {
+				t.Fatalf("openssl pkcs8 failed: %v", err)
+			}
+			block, _ := pem.Decode(out)
+			if block == nil || block.Type != "PRIVATE KEY" {
+				t.Fatalf("unexpected openssl output: %q", out)
+			}
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if k, ok := key.(interface{ Equal(crypto.PrivateKey) bool }); !ok || !k.Equal(cert.Key) {
+				t.Error("the decrypted key is not the original one")
+			}
+
+			if err := exec.Command("openssl", "pkcs8", "-in", path, "-passin", "pass:wrong").Run(); err == nil {
+				t.Error("openssl decrypted the key with the wrong password")
+			}
+		})
+	}
+}
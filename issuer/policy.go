@@ -0,0 +1,139 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package issuer
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// PolicyName is the file name of the optional issuance policy inside CAROOT.
+const PolicyName = "policy.json"
+
+// ErrPolicyViolation is returned when a CA with a Policy is asked to issue a
+// leaf certificate that the policy doesn't allow.
+var ErrPolicyViolation = errors.New("the certificate is not allowed by the CA policy")
+
+// Policy constrains the leaf certificates a CA issues, so that a team sharing
+// a CA can limit what it signs. The zero value allows everything.
+type Policy struct {
+	// MaxLifetimeDays, if positive, caps the validity period of leaves. Longer
+	// certificates are issued with this lifetime instead.
+	MaxLifetimeDays int `json:"max_lifetime_days,omitempty"`
+
+	// AllowedSuffixes, if not empty, are the domains that the DNS names,
+	// email domains and URI hosts of leaves must be equal to or under, like
+	// "test" or "corp.example". IP addresses are not restricted.
+	AllowedSuffixes []string `json:"allowed_suffixes,omitempty"`
+
+	// AllowedKeyTypes, if not empty, are the allowed leaf key types, among
+	// "rsa", "ecdsa" and "ed25519".
+	AllowedKeyTypes []string `json:"allowed_key_types,omitempty"`
+
+	// ForbidPublicTLDs rejects names under a TLD delegated by ICANN, like
+	// "com" or "dev", so that only names under reserved or private TLDs like
+	// "test", "localhost" or "internal" can be issued.
+	ForbidPublicTLDs bool `json:"forbid_public_tlds,omitempty"`
+}
+
+var keyTypes = map[string]x509.PublicKeyAlgorithm{
+	"rsa": x509.RSA, "ecdsa": x509.ECDSA, "ed25519": x509.Ed25519,
+}
+
+// LoadPolicy loads the issuance policy from caroot. It returns nil if caroot
+// has no policy file.
+func LoadPolicy(caroot string) (*Policy, error) {
+	data, err := ioutil.ReadFile(filepath.Join(caroot, PolicyName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CA policy: %w", err)
+	}
+	p := &Policy{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(p); err != nil {
+		return nil, fmt.Errorf("failed to parse the CA policy %s: %w", PolicyName, err)
+	}
+	for _, kt := range p.AllowedKeyTypes {
+		if _, ok := keyTypes[kt]; !ok {
+			return nil, fmt.Errorf("failed to parse the CA policy %s: unknown key type %q, options are rsa, ecdsa and ed25519", PolicyName, kt)
+		}
+	}
+	return p, nil
+}
+
+// limitLifetime shortens the validity period of tpl to MaxLifetimeDays.
+func (p *Policy) limitLifetime(tpl *x509.Certificate) {
+	if p == nil || p.MaxLifetimeDays <= 0 || tpl.IsCA {
+		return
+	}
+	if max := tpl.NotBefore.Add(time.Duration(p.MaxLifetimeDays) * 24 * time.Hour); tpl.NotAfter.After(max) {
+		tpl.NotAfter = max
+	}
+}
+
+// check returns an error wrapping ErrPolicyViolation if cert is a leaf that
+// p doesn't allow.
+func (p *Policy) check(cert *x509.Certificate) error {
+	if p == nil || cert.IsCA {
+		return nil
+	}
+	if len(p.AllowedKeyTypes) > 0 {
+		allowed := false
+		for _, kt := range p.AllowedKeyTypes {
+			allowed = allowed || keyTypes[kt] == cert.PublicKeyAlgorithm
+		}
+		if !allowed {
+			return fmt.Errorf("%w: %s keys are not allowed, only %s", ErrPolicyViolation,
+				cert.PublicKeyAlgorithm, strings.Join(p.AllowedKeyTypes, ", "))
+		}
+	}
+	names := append([]string{}, cert.DNSNames...)
+	for _, email := range cert.EmailAddresses {
+		names = append(names, email[strings.LastIndex(email, "@")+1:])
+	}
+	for _, uri := range cert.URIs {
+		if uri.Hostname() != "" {
+			names = append(names, uri.Hostname())
+		}
+	}
+	for _, name := range names {
+		if err := p.checkName(strings.ToLower(strings.TrimPrefix(name, "*."))); err != nil {
+			return fmt.Errorf("%w: %q %v", ErrPolicyViolation, name, err)
+		}
+	}
+	return nil
+}
+
+func (p *Policy) checkName(name string) error {
+	if len(p.AllowedSuffixes) > 0 {
+		allowed := false
+		for _, suffix := range p.AllowedSuffixes {
+			suffix = strings.ToLower(strings.Trim(suffix, "."))
+			allowed = allowed || name == suffix || strings.HasSuffix(name, "."+suffix)
+		}
+		if !allowed {
+			return fmt.Errorf("is not under %s", strings.Join(p.AllowedSuffixes, ", "))
+		}
+	}
+	if p.ForbidPublicTLDs {
+		if _, icann := publicsuffix.PublicSuffix(name); icann {
+			return errors.New("is under a public TLD")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package issuer
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPolicy(t *testing.T) {
+	caroot := t.TempDir()
+	if p, err := LoadPolicy(caroot); err != nil || p != nil {
+		t.Errorf("without a policy file: got %v, %v, want nil, nil", p, err)
+	}
+
+	for _, tt := range []struct {
+		name, json string
+		ok         bool
+	}{
+		{"valid", `{"allowed_key_types": ["ecdsa", "ed25519"], "forbid_public_tlds": true}`, true},
+		{"unknown key type", `{"allowed_key_types": ["dsa"]}`, false},
+		{"unknown field", `{"allowed_key_type": ["ecdsa"]}`, false},
+		{"malformed", `{"allowed_key_types": "ecdsa"`, false},
+	} {
+		if err := ioutil.WriteFile(filepath.Join(caroot, PolicyName), []byte(tt.json), 0644); err != nil {
+			t.Fatal(err)
+		}
+		p, err := LoadPolicy(caroot)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("%s: the policy was accepted", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(p.AllowedKeyTypes) != 2 || !p.ForbidPublicTLDs {
+			t.Errorf("%s: got %+v", tt.name, p)
+		}
+	}
+}
+
+func TestPolicyForbidPublicTLDs(t *testing.T) {
+	ca, err := NewCA(t.TempDir(), &Options{ECDSA: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.Policy = &Policy{ForbidPublicTLDs: true}
+	for name, ok := range map[string]bool{
+		"example.test":    true,
+		"localhost":       true,
+		"example.com":     false,
+		"foo.co.uk":       false,
+		"*.foo.co.uk":     false,
+		"alice@foo.co.uk": false,
+	} {
+		_, err := ca.IssueServer([]string{name}, &Options{ECDSA: true})
+		if ok && err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if !ok && !errors.Is(err, ErrPolicyViolation) {
+			t.Errorf("%s: got %v, want ErrPolicyViolation", name, err)
+		}
+	}
+}
+
+func TestPolicyAllowedKeyTypes(t *testing.T) {
+	ca, err := NewCA(t.TempDir(), &Options{ECDSA: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.Policy = &Policy{AllowedKeyTypes: []string{"ecdsa", "ed25519"}}
+	if _, err := ca.IssueServer([]string{"example.test"}, &Options{ECDSA: true}); err != nil {
+		t.Errorf("issuing with an ECDSA key: %v", err)
+	}
+	if _, err := ca.IssueServer([]string{"example.test"}, &Options{Ed25519: true}); err != nil {
+		t.Errorf("issuing with an Ed25519 key: %v", err)
+	}
+	if _, err := ca.IssueServer([]string{"example.test"}, nil); !errors.Is(err, ErrPolicyViolation) {
+		t.Errorf("issuing with an RSA key: got %v, want ErrPolicyViolation", err)
+	}
+}
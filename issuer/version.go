@@ -0,0 +1,103 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package issuer
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// LinkedVersion, if set, overrides the module version reported by Version.
+// The mkcert command copies main.Version, which is set at link time, into it.
+var LinkedVersion string
+
+// Build describes the build of mkcert linked into the current program.
+type Build struct {
+	// Version is the module version, "(devel)" when built from within the
+	// module, or "(unknown)".
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version,omitempty"`
+	Revision  string `json:"revision,omitempty"`
+	// Time is the commit time of Revision, or nil without VCS information.
+	Time     *time.Time `json:"time,omitempty"`
+	Modified bool       `json:"modified,omitempty"`
+}
+
+// Version returns the version of mkcert linked into the current program.
+func Version() string {
+	return BuildInfo().Version
+}
+
+// BuildInfo returns the build metadata of mkcert, whether it's the main
+// module or a dependency of the current program.
+func BuildInfo() Build {
+	b := Build{Version: "(unknown)"}
+	if buildInfo, ok := debug.ReadBuildInfo(); ok {
+		b.GoVersion = buildInfo.GoVersion
+		if buildInfo.Main.Path == "filippo.io/mkcert" {
+			b.Version = buildInfo.Main.Version
+			for _, s := range buildInfo.Settings {
+				switch s.Key {
+				case "vcs.revision":
+					b.Revision = s.Value
+				case "vcs.time":
+					if t, err := time.Parse(time.RFC3339, s.Value); err == nil {
+						b.Time = &t
+					}
+				case "vcs.modified":
+					b.Modified = s.Value == "true"
+				}
+			}
+		}
+		for _, dep := range buildInfo.Deps {
+			if dep.Path == "filippo.io/mkcert" {
+				b.Version = dep.Version
+			}
+		}
+	}
+	if LinkedVersion != "" {
+		b.Version = LinkedVersion
+	}
+	return b
+}
+
+// oidNetscapeComment is the Netscape Comment extension, which carries a
+// free-form IA5String and is ignored by verifiers.
+var oidNetscapeComment = asn1.ObjectIdentifier{2, 16, 840, 1, 113730, 1, 13}
+
+const commentPrefix = "Generated by mkcert "
+
+// versionExtensions returns the extensions recording the mkcert version in
+// issued certificates, if the version can be encoded.
+func versionExtensions() []pkix.Extension {
+	value, err := asn1.MarshalWithParams(commentPrefix+Version(), "ia5")
+	if err != nil {
+		return nil
+	}
+	return []pkix.Extension{{Id: oidNetscapeComment, Value: value}}
+}
+
+// VersionOf returns the version of mkcert that generated cert, or "" if cert
+// does not record it.
+func VersionOf(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidNetscapeComment) {
+			continue
+		}
+		var comment string
+		if _, err := asn1.UnmarshalWithParams(ext.Value, &comment, "ia5"); err != nil {
+			return ""
+		}
+		if !strings.HasPrefix(comment, commentPrefix) {
+			return ""
+		}
+		return strings.TrimPrefix(comment, commentPrefix)
+	}
+	return ""
+}
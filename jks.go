@@ -0,0 +1,33 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/mkcert/issuer"
+	"filippo.io/mkcert/truststore"
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// jksAlias is the alias of the key entry in the -jks keystore, which is the
+// only entry, so that Tomcat and Spring Boot find it without a key alias.
+const jksAlias = "mkcert"
+
+// encodeKeystore implements -jks. It returns a PKCS #12 keystore if file has
+// the ".p12" or ".pfx" extension, and a JKS one otherwise, with the key of
+// cert, protected by password like the keystore, and the chain up to the
+// root.
+func (m *mkcert) encodeKeystore(cert *issuer.Certificate, file, password string) ([]byte, error) {
+	chain := append(append([]*x509.Certificate{cert.Cert}, m.chain...), m.ca.Cert)
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".p12", ".pfx":
+		return pkcs12.Encode(rand.Reader, cert.Key, cert.Cert, chain[1:], password)
+	}
+	return truststore.MarshalJKS(jksAlias, cert.Key, chain, password)
+}
@@ -0,0 +1,185 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// awsClient calls the AWS KMS JSON API, signing requests with Signature
+// Version 4 and the credentials in the environment.
+type awsClient struct {
+	endpoint     *url.URL
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+func newAWSSigner(ctx context.Context, keyID string) (crypto.Signer, error) {
+	c := &awsClient{
+		region:       os.Getenv("AWS_REGION"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if c.region == "" {
+		c.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	// arn:aws:kms:REGION:ACCOUNT:key/ID
+	if parts := strings.Split(keyID, ":"); len(parts) >= 6 && parts[0] == "arn" {
+		c.region = parts[3]
+	}
+	if c.region == "" {
+		return nil, errors.New("AWS_REGION is not set, and the KMS key is not an ARN")
+	}
+	if c.accessKey == "" || c.secretKey == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are not set (try \"eval $(aws configure export-credentials --format env)\")")
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_KMS")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint == "" {
+		endpoint = "https://kms." + c.region + ".amazonaws.com/"
+	}
+	var err error
+	c.endpoint, err = url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AWS KMS endpoint: %w", err)
+	}
+
+	var resp struct {
+		PublicKey []byte
+	}
+	if err := c.do(ctx, "GetPublicKey", map[string]string{"KeyId": keyID}, &resp); err != nil {
+		return nil, fmt.Errorf("failed to fetch the AWS KMS public key: %w", err)
+	}
+	pub, err := x509.ParsePKIXPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the AWS KMS public key: %w", err)
+	}
+
+	return &signer{pub: pub, sign: func(ctx context.Context, digest []byte, hash crypto.Hash) ([]byte, error) {
+		var alg string
+		switch pub.(type) {
+		case *ecdsa.PublicKey:
+			alg = "ECDSA_SHA_" + hashBits(hash)
+		case *rsa.PublicKey:
+			alg = "RSASSA_PKCS1_V1_5_SHA_" + hashBits(hash)
+		default:
+			return nil, fmt.Errorf("kms: unsupported AWS KMS key type %T", pub)
+		}
+		var resp struct {
+			Signature []byte
+		}
+		err := c.do(ctx, "Sign", map[string]interface{}{
+			"KeyId":            keyID,
+			"Message":          digest,
+			"MessageType":      "DIGEST",
+			"SigningAlgorithm": alg,
+		}, &resp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign with AWS KMS: %w", err)
+		}
+		return resp.Signature, nil
+	}}, nil
+}
+
+func (c *awsClient) do(ctx context.Context, action string, body, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.endpoint.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	c.signRequest(req, payload, time.Now().UTC())
+
+	return doJSON(req, payload, out, "AWS KMS", func(b []byte) string {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(b, &e)
+		if i := strings.LastIndex(e.Type, "#"); i >= 0 {
+			e.Type = e.Type[i+1:]
+		}
+		return strings.TrimPrefix(e.Type+": "+e.Message, ": ")
+	})
+}
+
+// signRequest adds the Signature Version 4 headers to req.
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+func (c *awsClient) signRequest(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if c.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+
+	headers := []string{"content-type", "host", "x-amz-date"}
+	if c.sessionToken != "" {
+		headers = append(headers, "x-amz-security-token")
+	}
+	headers = append(headers, "x-amz-target")
+	var canonicalHeaders strings.Builder
+	for _, h := range headers {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(v) + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(payload)
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery,
+		canonicalHeaders.String(), signedHeaders, hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + c.region + "/kms/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + c.secretKey)
+	for _, s := range []string{date, c.region, "kms", "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
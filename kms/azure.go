@@ -0,0 +1,154 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+)
+
+const azureAPIVersion = "7.4"
+
+// azureKey is the JSON Web Key returned by Key Vault.
+type azureKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func newAzureSigner(ctx context.Context, keyURL string) (crypto.Signer, error) {
+	token, err := cliToken(ctx, "az", "account", "get-access-token",
+		"--resource", "https://vault.azure.net", "--query", "accessToken", "--output", "tsv")
+	if err != nil {
+		return nil, err
+	}
+	do := func(ctx context.Context, method, url string, body, out interface{}) error {
+		var payload []byte
+		if body != nil {
+			var err error
+			if payload, err = json.Marshal(body); err != nil {
+				return err
+			}
+		}
+		req, err := http.NewRequestWithContext(ctx, method, url+"?api-version="+azureAPIVersion, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		return doJSON(req, payload, out, "Azure Key Vault", func(b []byte) string {
+			var e struct {
+				Error struct {
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			json.Unmarshal(b, &e)
+			return e.Error.Message
+		})
+	}
+
+	var resp struct {
+		Key azureKey `json:"key"`
+	}
+	if err := do(ctx, "GET", strings.TrimRight(keyURL, "/"), nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to fetch the Azure Key Vault key: %w", err)
+	}
+	pub, err := resp.Key.public()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the Azure Key Vault key: %w", err)
+	}
+	// The kid includes the version, so the key used to sign can't change
+	// under us if a new version is created.
+	kid := resp.Key.Kid
+
+	return &signer{pub: pub, sign: func(ctx context.Context, digest []byte, hash crypto.Hash) ([]byte, error) {
+		alg := "RS" + hashBits(hash)
+		if _, ok := pub.(*ecdsa.PublicKey); ok {
+			alg = "ES" + hashBits(hash)
+		}
+		var resp struct {
+			Value string `json:"value"`
+		}
+		err := do(ctx, "POST", kid+"/sign", map[string]string{
+			"alg":   alg,
+			"value": base64.RawURLEncoding.EncodeToString(digest),
+		}, &resp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign with Azure Key Vault: %w", err)
+		}
+		sig, err := base64.RawURLEncoding.DecodeString(resp.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode the Azure Key Vault signature: %w", err)
+		}
+		if _, ok := pub.(*ecdsa.PublicKey); ok {
+			// Key Vault returns the JWS encoding, r || s, while x509 expects
+			// an ASN.1 ECDSA-Sig-Value.
+			half := len(sig) / 2
+			return asn1.Marshal(struct{ R, S *big.Int }{
+				new(big.Int).SetBytes(sig[:half]), new(big.Int).SetBytes(sig[half:]),
+			})
+		}
+		return sig, nil
+	}}, nil
+}
+
+func (k *azureKey) public() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch strings.TrimSuffix(k.Kty, "-HSM") {
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
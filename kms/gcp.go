@@ -0,0 +1,82 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+const gcpEndpoint = "https://cloudkms.googleapis.com/v1/"
+
+func newGCPSigner(ctx context.Context, name string) (crypto.Signer, error) {
+	token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+	if token == "" {
+		var err error
+		token, err = cliToken(ctx, "gcloud", "auth", "print-access-token")
+		if err != nil {
+			return nil, err
+		}
+	}
+	do := func(ctx context.Context, method, path string, body, out interface{}) error {
+		var payload []byte
+		if body != nil {
+			var err error
+			if payload, err = json.Marshal(body); err != nil {
+				return err
+			}
+		}
+		req, err := http.NewRequestWithContext(ctx, method, gcpEndpoint+path, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		return doJSON(req, payload, out, "Google Cloud KMS", func(b []byte) string {
+			var e struct {
+				Error struct {
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			json.Unmarshal(b, &e)
+			return e.Error.Message
+		})
+	}
+
+	var key struct {
+		PEM string `json:"pem"`
+	}
+	if err := do(ctx, "GET", name+"/publicKey", nil, &key); err != nil {
+		return nil, fmt.Errorf("failed to fetch the Google Cloud KMS public key: %w", err)
+	}
+	block, _ := pem.Decode([]byte(key.PEM))
+	if block == nil {
+		return nil, errors.New("failed to parse the Google Cloud KMS public key: no PEM data")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the Google Cloud KMS public key: %w", err)
+	}
+
+	return &signer{pub: pub, sign: func(ctx context.Context, digest []byte, hash crypto.Hash) ([]byte, error) {
+		var resp struct {
+			Signature []byte `json:"signature"`
+		}
+		err := do(ctx, "POST", name+":asymmetricSign", map[string]interface{}{
+			"digest": map[string][]byte{"sha" + hashBits(hash): digest},
+		}, &resp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign with Google Cloud KMS: %w", err)
+		}
+		return resp.Signature, nil
+	}}, nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package kms implements crypto.Signer for CA keys held in a cloud key
+// management service, so that a development CA can be shared by a team
+// without its private key ever being handed out.
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// signTimeout bounds each remote signing operation, as crypto.Signer doesn't
+// take a context.
+const signTimeout = time.Minute
+
+// NewSigner returns a signer for the key named by uri, which is one of
+//
+//	awskms:KEY_ID_OR_ARN
+//	gcpkms:projects/P/locations/L/keyRings/R/cryptoKeys/K/cryptoKeyVersions/V
+//	azurekms:https://VAULT.vault.azure.net/keys/NAME[/VERSION]
+//
+// Credentials are taken from the same places as the respective CLI tools.
+// The key must be an asymmetric ECDSA or RSA PKCS #1 v1.5 signing key.
+func NewSigner(ctx context.Context, uri string) (crypto.Signer, error) {
+	i := strings.Index(uri, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid KMS key %q: missing awskms:, gcpkms: or azurekms: prefix", uri)
+	}
+	scheme, key := uri[:i], uri[i+1:]
+	switch scheme {
+	case "awskms":
+		return newAWSSigner(ctx, key)
+	case "gcpkms":
+		return newGCPSigner(ctx, key)
+	case "azurekms":
+		return newAzureSigner(ctx, key)
+	default:
+		return nil, fmt.Errorf("invalid KMS key %q: unknown provider %q", uri, scheme)
+	}
+}
+
+// A signer is a crypto.Signer that delegates the signature to a remote
+// service.
+type signer struct {
+	pub  crypto.PublicKey
+	sign func(ctx context.Context, digest []byte, hash crypto.Hash) ([]byte, error)
+}
+
+func (s *signer) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		return nil, errors.New("kms: RSA-PSS signatures are not supported")
+	}
+	switch opts.HashFunc() {
+	case crypto.SHA256, crypto.SHA384, crypto.SHA512:
+	default:
+		return nil, fmt.Errorf("kms: unsupported hash function %v", opts.HashFunc())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), signTimeout)
+	defer cancel()
+	return s.sign(ctx, digest, opts.HashFunc())
+}
+
+// hashBits returns "256", "384" or "512", as used by the algorithm names of
+// all providers.
+func hashBits(h crypto.Hash) string {
+	return fmt.Sprint(h.Size() * 8)
+}
+
+// Error is returned when the KMS rejects a request.
+type Error struct {
+	Provider   string
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s returned HTTP status %d", e.Provider, e.StatusCode)
+	}
+	return fmt.Sprintf("%s returned HTTP status %d: %s", e.Provider, e.StatusCode, e.Message)
+}
+
+// doJSON sends req with the JSON payload, if any, and decodes the response
+// into out. errMessage extracts the message from an error response.
+func doJSON(req *http.Request, payload []byte, out interface{}, provider string, errMessage func([]byte) string) error {
+	if payload != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(payload))
+		req.ContentLength = int64(len(payload))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", provider, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read the %s response: %w", provider, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return &Error{Provider: provider, StatusCode: resp.StatusCode, Message: errMessage(respBody)}
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to decode the %s response: %w", provider, err)
+	}
+	return nil
+}
+
+// cliToken runs a CLI tool that prints an access token.
+func cliToken(ctx context.Context, name string, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("failed to get an access token from %q: %s", name, bytes.TrimSpace(exitErr.Stderr))
+		}
+		return "", fmt.Errorf("failed to get an access token from %q: %w", name, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localca_test
+
+import (
+	"fmt"
+	"log"
+
+	"filippo.io/mkcert/localca"
+	"filippo.io/mkcert/truststore"
+)
+
+// This is the workflow of running "mkcert -install" and then
+// "mkcert example.test", for an application that drives it on its own: the
+// CA is created if needed, installed in the trust stores, and then issues a
+// certificate. None of these calls exit the program, so an application
+// can show any failure in its own interface instead of calling log.Fatal.
+func Example_installAndIssue() {
+	local, err := localca.Open("", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	local.Store.ContinueOnError = true
+	local.Store.Reporter = truststore.ReporterFunc(func(msg truststore.Message) {
+		fmt.Println(msg.Store, msg.Text, msg.Help)
+	})
+	results, err := local.Store.Install()
+	for _, r := range results {
+		fmt.Println(r.Store, r.Status)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	files, err := local.IssueServer(".", []string{"example.test", "127.0.0.1"}, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(files.CertFile, files.KeyFile)
+}
@@ -0,0 +1,180 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package localca runs the workflow of the mkcert command from other Go
+// programs: it finds the CAROOT, loads the local CA or creates it, installs
+// it in the trust stores, and saves the certificates it issues with the file
+// names mkcert uses.
+//
+// Like the issuer and truststore packages it's built on, it reports every
+// failure as an error and never terminates the program, so GUIs and daemons
+// can run "mkcert -install" and "mkcert example.test" on their own, and show
+// the results in their own interface.
+package localca
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"filippo.io/mkcert/issuer"
+	"filippo.io/mkcert/truststore"
+)
+
+// DefaultCAROOT returns the CAROOT of the mkcert command: the CAROOT
+// environment variable if set, or a "mkcert" directory in the data directory
+// of the user. It returns "" if the data directory can't be found.
+func DefaultCAROOT() string {
+	if env := os.Getenv("CAROOT"); env != "" {
+		return env
+	}
+
+	var dir string
+	switch {
+	case runtime.GOOS == "windows":
+		dir = os.Getenv("LocalAppData")
+	case os.Getenv("XDG_DATA_HOME") != "":
+		dir = os.Getenv("XDG_DATA_HOME")
+	case runtime.GOOS == "darwin":
+		dir = os.Getenv("HOME")
+		if dir == "" {
+			return ""
+		}
+		dir = filepath.Join(dir, "Library", "Application Support")
+	default: // Unix
+		dir = os.Getenv("HOME")
+		if dir == "" {
+			return ""
+		}
+		dir = filepath.Join(dir, ".local", "share")
+	}
+	return filepath.Join(dir, "mkcert")
+}
+
+// Local is the local CA in a CAROOT, and the trust stores to install it in.
+type Local struct {
+	// CAROOT is the directory of the CA certificate.
+	CAROOT string
+
+	// CA is the local CA. Its Key is nil if it was loaded in keyless mode.
+	CA *issuer.CA
+
+	// Store installs the CA certificate in the trust stores of the machine.
+	// Open sets its RootPath and Root, and the other fields, like Stores and
+	// Reporter, can be set before calling its methods.
+	Store *truststore.Store
+
+	// Created reports whether Open created the CA.
+	Created bool
+}
+
+// Open loads the local CA from caroot, or DefaultCAROOT if empty, creating
+// the directory and a new CA with issuer.NewCA and opts if there is none
+// yet. If opts.KeyDir is set, the key is saved and loaded from there, as
+// with issuer.LoadCAWithKeyDir.
+func Open(caroot string, opts *issuer.Options) (*Local, error) {
+	if caroot == "" {
+		caroot = DefaultCAROOT()
+	}
+	if caroot == "" {
+		return nil, errors.New("failed to find the default CA location, set one as the CAROOT env var")
+	}
+	if opts == nil {
+		opts = &issuer.Options{}
+	}
+	if err := os.MkdirAll(caroot, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the CAROOT: %w", err)
+	}
+
+	l := &Local{CAROOT: caroot}
+	rootPath := filepath.Join(caroot, issuer.RootName)
+	if _, err := os.Stat(rootPath); os.IsNotExist(err) {
+		if _, err := issuer.NewCA(caroot, opts); err != nil {
+			return nil, err
+		}
+		l.Created = true
+	}
+
+	keyDir := caroot
+	if opts.KeyDir != "" {
+		keyDir = opts.KeyDir
+	}
+	ca, err := issuer.LoadCAWithKeyDir(caroot, keyDir)
+	if err != nil {
+		return nil, err
+	}
+	l.CA = ca
+	l.Store = &truststore.Store{RootPath: rootPath, Root: ca.Cert}
+	return l, nil
+}
+
+// Files are a certificate issued by IssueServer or IssueClient, and the
+// paths it was saved to.
+type Files struct {
+	*issuer.Certificate
+
+	// CertFile is the path of the PEM certificate, and KeyFile the path of
+	// the PEM key in PKCS #8 format.
+	CertFile, KeyFile string
+}
+
+// IssueServer issues a certificate for hosts like issuer.CA.IssueServer, and
+// saves it to dir, with the same names as "mkcert example.test" would, like
+// "example.test.pem" and "example.test-key.pem".
+func (l *Local) IssueServer(dir string, hosts []string, opts *issuer.Options) (*Files, error) {
+	cert, err := l.CA.IssueServer(hosts, opts)
+	if err != nil {
+		return nil, err
+	}
+	return save(cert, dir, BaseName(hosts, false))
+}
+
+// IssueClient is like IssueServer, but issues a client certificate, like
+// "mkcert -client example.test", in "example.test-client.pem".
+func (l *Local) IssueClient(dir string, hosts []string, opts *issuer.Options) (*Files, error) {
+	cert, err := l.CA.IssueClient(hosts, opts)
+	if err != nil {
+		return nil, err
+	}
+	return save(cert, dir, BaseName(hosts, true))
+}
+
+func save(cert *issuer.Certificate, dir, name string) (*Files, error) {
+	key, err := cert.KeyPEM()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode certificate key: %w", err)
+	}
+	f := &Files{
+		Certificate: cert,
+		CertFile:    filepath.Join(dir, name+".pem"),
+		KeyFile:     filepath.Join(dir, name+"-key.pem"),
+	}
+	if err := ioutil.WriteFile(f.CertFile, cert.CertPEM(), 0644); err != nil {
+		return nil, fmt.Errorf("failed to save certificate: %w", err)
+	}
+	if err := ioutil.WriteFile(f.KeyFile, key, 0600); err != nil {
+		return nil, fmt.Errorf("failed to save certificate key: %w", err)
+	}
+	return f, nil
+}
+
+// BaseName returns the name mkcert gives the files of a certificate for
+// hosts, without the extension, like "example.test+1" for two hosts or
+// "_wildcard.example.test-client" for a client certificate.
+func BaseName(hosts []string, client bool) string {
+	name := strings.Replace(hosts[0], ":", "_", -1)
+	name = strings.Replace(name, "*", "_wildcard", -1)
+	if len(hosts) > 1 {
+		name += "+" + strconv.Itoa(len(hosts)-1)
+	}
+	if client {
+		name += "-client"
+	}
+	return name
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localca
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/mkcert/issuer"
+)
+
+func TestOpenAndIssue(t *testing.T) {
+	caroot := filepath.Join(t.TempDir(), "caroot")
+	local, err := Open(caroot, &issuer.Options{ECDSA: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !local.Created || local.CA.Key == nil {
+		t.Fatal("Open didn't create a CA with a key")
+	}
+	if local.Store.RootPath != filepath.Join(caroot, issuer.RootName) || !local.Store.Root.Equal(local.CA.Cert) {
+		t.Errorf("the Store is not for the CA: %q", local.Store.RootPath)
+	}
+
+	again, err := Open(caroot, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Created || !again.CA.Cert.Equal(local.CA.Cert) {
+		t.Error("opening the CAROOT again didn't load the same CA")
+	}
+
+	dir := t.TempDir()
+	files, err := again.IssueClient(dir, []string{"*.example.test", "127.0.0.1"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "_wildcard.example.test+1-client.pem"); files.CertFile != want {
+		t.Errorf("got CertFile %q, want %q", files.CertFile, want)
+	}
+	if _, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile); err != nil {
+		t.Error(err)
+	}
+	if fi, err := os.Stat(files.KeyFile); err != nil {
+		t.Error(err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Errorf("got key file mode %v, want 0600", fi.Mode().Perm())
+	}
+}
@@ -6,24 +6,31 @@
 package main
 
 import (
+	"context"
 	"crypto"
+	"crypto/elliptic"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/mail"
 	"net/url"
 	"os"
 	"os/exec"
-	"os/user"
-	"path/filepath"
 	"regexp"
-	"runtime"
-	"runtime/debug"
 	"strings"
-	"sync"
+	"time"
 
+	"filippo.io/mkcert/issuer"
+	"filippo.io/mkcert/localca"
+	"filippo.io/mkcert/truststore"
+	"filippo.io/mkcert/vault"
 	"golang.org/x/net/idna"
 )
 
@@ -51,31 +58,454 @@ const advancedUsage = `Advanced options:
 	-cert-file FILE, -key-file FILE, -p12-file FILE
 	    Customize the output paths.
 
+	-owner USER[:GROUP] -umask MASK
+	    Give the generated files, and any directories created for them,
+	    the owner and group, and remove MASK, like 027, from their
+	    permissions: 0644 for certificates, 0600 for keys and 0777 for
+	    the directories. Each file is created with them and then moved
+	    into place. Not supported on Windows.
+
 	-client
 	    Generate a certificate for client authentication.
 
 	-ecdsa
 	    Generate a certificate with an ECDSA key.
 
+	-curve P256|P384|P521
+	    Generate ECDSA keys on the given curve instead of P-256, for the
+	    certificate and for the local CA when it's first created. Implies
+	    -ecdsa.
+
+	-rsa-bits 2048|3072|4096
+	    Generate RSA keys of the given size, for the certificate and for
+	    the local CA when it's first created. By default, certificates
+	    get 2048-bit keys and the CA a 3072-bit one.
+
+	-ed25519
+	    Generate a certificate with an Ed25519 key. Browsers don't
+	    support Ed25519 certificates yet, but other TLS stacks do. It
+	    can't be exported as PKCS #12.
+
+	-days N
+	    Make the certificate expire in N days, instead of 2 years and 3
+	    months. Useful to test how clients handle expiring certificates.
+
+	-not-after DATE
+	    Make the certificate expire at DATE, like 2026-01-01 (midnight
+	    UTC) or 2026-01-01T15:04:05Z. Certificates valid for more than
+	    825 days are rejected by Apple platforms.
+
+	-template NAME
+	    Use the key type, Extended Key Usages, lifetime and format of
+	    the named template from the "templates.json" file in the CAROOT.
+	    Other flags are applied on top of it.
+
+	-smime
+	    Generate a certificate for signing and encrypting email with
+	    S/MIME, for the email addresses passed as names. It's saved as
+	    a PKCS #12 file with the CA certificates, to import in the mail
+	    client. Add -smime-import to import it in the Thunderbird
+	    profiles and, on macOS, in the login keychain used by Mail.
+
+	-eap
+	    Generate a certificate for EAP-TLS, as used by RADIUS servers
+	    and Wi-Fi supplicants: for the server, or with -client for a
+	    user or device. The first name is also used as the Common Name,
+	    and IP addresses, URLs and wildcards are rejected.
+
+	-raw-san TYPE:VALUE
+	    Add a Subject Alternative Name exactly as given, without any
+	    validation or punycode conversion, to test how other software
+	    handles unusual or invalid names. TYPE is dns, ip, uri or email.
+	    Can be repeated, and the names passed as arguments are still
+	    required and come first.
+
+	-ext OID[:critical]:BASE64
+	    Add an X.509 extension with the given OID and base64 DER value,
+	    marked critical if requested, to the certificate, for example to
+	    test proprietary or vendor-specific extensions. Can be repeated.
+	    It replaces any extension mkcert would add with the same OID.
+
 	-pkcs12
 	    Generate a ".p12" PKCS #12 file, also know as a ".pfx" file,
 	    containing certificate and key for legacy applications.
 
+	-fullchain-file FILE
+	    Also save the certificate followed by the intermediates and the
+	    local CA to FILE, for nginx and HAProxy.
+
+	-der
+	    Save the certificate and the (PKCS #8) key as binary DER files,
+	    with the ".crt" and ".key" extensions, instead of PEM.
+
+	-jks [-jks-file FILE] [-storepass PASSWORD]
+	    Generate a Java keystore with the key, the certificate and the
+	    chain up to the local CA, under the alias "mkcert", for Tomcat
+	    and Spring Boot. It's a PKCS #12 keystore if FILE ends in ".p12"
+	    or ".pfx", and a JKS one otherwise. The store and key password
+	    is "changeit" by default.
+
+	-pkcs8 [-keypass PASSWORD]
+	    Save the key file as unencrypted PKCS #8, which is also the
+	    default, as keys are never saved as PKCS #1 or SEC 1. With
+	    -keypass, encrypt it with PASSWORD, as an "ENCRYPTED PRIVATE
+	    KEY" PKCS #8 file.
+
 	-csr CSR
 	    Generate a certificate based on the supplied CSR. Conflicts with
 	    all other flags and arguments except -install and -cert-file.
+	    Use "-" to read the CSR from stdin. If -csr is repeated, or is a
+	    directory of ".csr" files, each certificate is saved next to its
+	    CSR, like "req.pem" for "req.csr".
+
+	-csr CSR -dry-run
+	    Check the signature of the CSR, and print the names, lifetime,
+	    key usages and extensions of the certificate that would be
+	    issued for it, including the requested extensions that are not
+	    honored, without signing anything with the local CA.
+
+	-csr-policy sans-only,copy-eku,reject-ca
+	    Limit what is copied from a CSR: only the Subject Alternative
+	    Names ("sans-only"), optionally with the Extended Key Usages
+	    ("copy-eku"), and fail if it requests a CA ("reject-ca"). By
+	    default all requested extensions are honored.
+
+	-output aws|gcloud
+	    Write a bundle of the system roots and the local CA to the
+	    CAROOT, and print the configuration for the AWS CLI (and boto3)
+	    or the Google Cloud CLI to use it.
+
+	-bundle-with-system FILE
+	    Write a bundle of the system roots and the local CA to FILE, for
+	    tools that take a single CA file, like "curl --cacert" or
+	    $REQUESTS_CA_BUNDLE, so they keep trusting public sites.
+
+	-env
+	    Print the commands that point SSL_CERT_FILE, REQUESTS_CA_BUNDLE
+	    and GIT_SSL_CAINFO at a bundle of the system roots and the local
+	    CA, and NODE_EXTRA_CA_CERTS and DENO_CERT at the local CA, to be
+	    used as: eval "$(mkcert -env)"
+
+	-output ansible|cloud-init
+	    Print an Ansible task list, or a cloud-config, that installs the
+	    local CA in the system trust store of the machines it's applied
+	    to, for provisioning VMs and test environments.
+
+	-output docker
+	    Save the local CA in the current directory, and print the
+	    Dockerfile lines that copy it into the image and install it in
+	    the system trust store of its distribution.
+
+	-output postgres|mysql|redis
+	    Along with the generated certificate, print the server settings
+	    and client connection parameters for a local database with TLS.
+	    With -client, print the settings for client authentication.
+
+	-output dotnet
+	    Generate a ".p12" file marked as the ASP.NET Core development
+	    certificate, and print the "dotnet dev-certs https" commands
+	    that make Kestrel use it instead of its own self-signed one.
+
+	-count N
+	    Generate N certificates from a single name pattern, where {{.N}}
+	    is replaced with 1 to N, like "device-{{.N}}.iot.test". A
+	    manifest of the generated files is saved as "mkcert-manifest.json".
+
+	-refresh [-within DURATION]
+	    If the certificate file already exists, only replace it if it
+	    was issued by a different CA, is for different names, or
+	    expires within DURATION (by default 720h), reusing its key.
+	    This makes running mkcert in project setup scripts idempotent.
+
+	-renew FILE [-new-key] [-key-file FILE]
+	    Re-issue the certificate in FILE, which must have been issued by
+	    the local CA, with the same names and key usages, and replace it.
+	    With -new-key, also replace its key, in FILE if it's there, or
+	    in -key-file, by default the "-key.pem" file next to it.
+
+	-renew-all DIR [-within DURATION]
+	    Renew the certificates issued by the local CA found under DIR
+	    that expire within DURATION (by default 720h), keeping their
+	    keys, and print a summary.
+
+	-resign DIR
+	    Re-issue the certificates found under DIR that were issued by a
+	    previous local CA with the current one, keeping their keys, names
+	    and expiration, for example after replacing the CA.
+
+	-watch INTERVAL [-install] [-exec COMMAND]
+	    Keep running, and check every INTERVAL (like "1h") that the local
+	    CA is still in the trust stores, since browser profile resets, OS
+	    upgrades and CA bundle updates can remove it. With -install, add
+	    it back. Otherwise report it, and run COMMAND with the affected
+	    stores in $MKCERT_STORES, for example to show a notification.
+
+	-health ADDR
+	    With -watch or -acme, serve a JSON report at /health and
+	    Prometheus metrics at /metrics on ADDR, like :9090, with the
+	    trust store checks, or the certificates issued over ACME and
+	    when their clients are expected to renew them. Without a host,
+	    it only listens on the loopback interface.
+
+	-renew-all DIR [-within DURATION] [-exec COMMAND] -install-service
+	    Register a systemd user timer, a launchd agent, or a Windows
+	    scheduled task that runs -renew-all with the same options and
+	    CAROOT daily, so that renewals survive reboots. Only -within,
+	    -exec, -days, -owner, -umask, -system-caroot, -ca-key-dir,
+	    -ca-kms, -cmd-timeout, -no-emoji and -log-format are accepted.
+
+	-audit DIR [-within DURATION] [-audit-service HOST[:PORT]] [-json]
+	    Report the certificates under DIR issued by the local CA that
+	    expire within DURATION (by default 720h) or are from a different
+	    local CA, keys that don't match their certificate, and "-key.pem"
+	    files without one. With -audit-service (which can be repeated),
+	    also check that the server serves the certificate on disk for its
+	    key. Exit with a non-zero status if there are problems.
+
+	-check-expiry FILE [-within DURATION] [-json] [-metrics-file FILE]
+	    Check whether the certificate in FILE (which can be repeated) or
+	    the local CA expire within DURATION (by default 720h), and exit
+	    with a non-zero status if so. With -json, print the results as
+	    JSON. With -metrics-file FILE, also save their expiration times
+	    for the Prometheus node_exporter textfile collector.
+
+	-add-hosts
+	    Map the generated hostnames to 127.0.0.1 in the hosts file, using
+	    sudo if needed. Remove all of them with "mkcert -remove-hosts".
+
+	-continue-on-error
+	    With -install or -uninstall, go through all the trust stores
+	    even if some fail, and report the failures at the end.
+
+	-rollback
+	    Undo the last -install that changed something: uninstall the
+	    local CA only from the trust stores it added it to, including
+	    any it failed half way through. The changes are recorded in
+	    install-log.json in $CAROOT.
+
+	-rotate-ca [-overlap DURATION]
+	    Replace the local CA with a new one, and install it. The previous
+	    root stays installed for the overlap window, 720h (30 days) by
+	    default, and is uninstalled by the first -install or -rotate-ca
+	    after it. It's moved to old-roots in $CAROOT, and the rotations
+	    are recorded in rotations.json.
+
+	-vault MOUNT/ROLE
+	    Issue certificates (and sign CSRs) with a role of a HashiCorp
+	    Vault PKI mount, like "pki/dev", and install the Vault root
+	    instead of the local CA. Vault is configured with the usual
+	    $VAULT_ADDR and $VAULT_TOKEN environment variables.
+
+	-ca-key-dir DIR
+	    Keep the CA key (rootCA-key.pem) in DIR instead of the CAROOT,
+	    like on a removable or encrypted volume. When it's not available,
+	    installing and checking the CA still work, and issuing fails.
+
+	-ca-kms URI
+	    Sign with a CA key held in a cloud KMS instead of rootCA-key.pem.
+	    URI is one of "awskms:KEY_ID_OR_ARN", "gcpkms:projects/.../
+	    cryptoKeyVersions/N" or "azurekms:https://VAULT.vault.azure.net/
+	    keys/NAME", using the credentials of the aws, gcloud or az CLI.
+
+	-step-import STEPPATH
+	    Use the root (and key, if not encrypted) of the step-ca in
+	    STEPPATH, like ~/.step, instead of creating a new local CA.
+
+	-step-export STEPPATH
+	    Write the local CA where "step ca init --root --key" expects it,
+	    or, if STEPPATH already has a step-ca, add the local CA to it as
+	    the root of an X5C provisioner named "mkcert".
+
+	-export-gpo DIR
+	    Write the files needed to deploy the CA to managed Windows
+	    machines with Group Policy or Intune: .cer, .p7b, .reg and a
+	    PowerShell script.
+
+	-ocsp-sign FILE [-ocsp-status good|revoked]
+	    Save a DER OCSP response for the certificate in FILE, signed by
+	    the local CA, next to it as FILE.ocsp, for servers to staple.
+	    -ocsp-this-update and -ocsp-next-update set the validity, as
+	    RFC 3339 times or durations from now like "-48h" (by default,
+	    now and a week from now).
+
+	-aia URL
+	    Add an Authority Information Access extension pointing to URL,
+	    like http://localhost:8001/rootCA.cer, to the certificates. Run
+	    with -aia and no names to serve the CA certificate at URL, for
+	    clients that fetch missing issuers.
+
+	-ocsp URL|:PORT [-ocsp-status good|revoked]
+	    Add an OCSP responder URL, like http://localhost:8888/ (which
+	    is what :8888 is short for), to the certificates. Run with -ocsp
+	    and no names to serve OCSP responses for the certificates of the
+	    local CA at URL: "revoked" for the ones revoked with -revoke, and
+	    the -ocsp-status (by default good) for the others. -aia, -ocsp
+	    and -crl can be served together, also with -acme.
+
+	-crl URL
+	    Add a CRL distribution point, like http://localhost:8002/rootCA.crl,
+	    to the certificates. Run with -crl and no names to serve a
+	    current CRL of the local CA at URL.
+
+	-acme ADDR
+	    Serve a minimal ACME server, like https://localhost:14000/directory
+	    for ADDR :14000, that issues certificates from the local CA to
+	    clients like Caddy, Traefik, cert-manager and certbot. It only
+	    accepts local names, like localhost, *.test or private IPs, and
+	    lets their HTTP-01, TLS-ALPN-01 and DNS-01 challenges pass
+	    without checking them. Without a host, like :14000, it only
+	    listens on the loopback interface. With one, like 0.0.0.0:14000,
+	    any machine that can reach it gets certificates trusted here.
+
+	-revoke FILE|SERIAL
+	    Revoke the certificate in FILE, or with the given hex serial
+	    number, by adding it to revoked.json in $CAROOT, and save a new
+	    CRL. The -ocsp responder answers "revoked" for it.
+
+	-gen-crl
+	    Save a CRL of the revoked certificates, signed by the local CA
+	    and valid for a week, as rootCA.crl in $CAROOT.
+
+	-badssl-suite DIR
+	    Generate intentionally broken certificates (expired, not yet
+	    valid, wrong host, untrusted root, revoked and weak key) for the
+	    given names, or localhost, in DIR, to test client error handling.
+
+	-intermediates N
+	    Issue the certificate behind N new intermediates, saved after it
+	    and in a "-chain.pem" file, to test chain building. CAs created
+	    by mkcert can't issue intermediates, unless -intermediates is
+	    used when the CA is first created (in a separate $CAROOT).
+
+	-inspect FILE [-json]
+	    Print the names, validity, key, usages and fingerprints of the
+	    certificates in a PEM, DER or PKCS#12 file, and whether they
+	    chain to the local CA. With -json, print them as a JSON array.
+
+	-probe URL|HOST[:PORT]
+	    Connect to a TLS server, print the chain it presents, and check
+	    it against the local CA and the system roots.
+
+	-fingerprint [FILE]
+	    Print the SHA-256 and SHA-1 fingerprints of the local CA, or of
+	    the certificate in FILE, as colon-separated hex, base64 and in
+	    the "SHA256:" format of OpenSSH.
+
+	-serve-ca
+	    Serve the local CA on the local network for ten minutes, as PEM,
+	    DER for Android and a configuration profile for iOS, and print a
+	    QR code to open the download page on a phone or tablet.
+
+	-serve-root ADDR
+	    Serve the same download page and files at ADDR, like :8080, until
+	    interrupted, for VMs and teammates that fetch the root from a
+	    fixed URL.
+
+	-sig-alg sha256|sha384|sha512
+	    Sign certificates with the given hash, to test how clients handle
+	    different signature algorithms. "sha1" is also accepted together
+	    with -insecure-sig-alg, to test that clients reject it.
+
+	-trust-purpose server-auth|all
+	    With -install, only trust the CA for TLS server certificates, or
+	    trust it for every purpose (including client authentication,
+	    S/MIME and code signing) in the macOS, Windows and NSS stores.
+
+	-no-emoji
+	    Print plain text messages, without emoji. This is the default
+	    if $NO_COLOR is set, or if the output is not a terminal.
+
+	-log-format text|json
+	    With "json", print each message, and the outcome of each trust
+	    store operation, as a JSON object on its own line, for
+	    provisioning tools and editor integrations.
+
+	-pin
+	    Print the base64 SHA-256 SPKI pins of the new certificate and of
+	    the local CA, for certificate pinning in local app builds.
+
+	-piv-slot 9a|9c|9d|9e
+	    Import the key and certificate into a PIV slot of the connected
+	    YubiKey, instead of saving the key to disk, to test client
+	    certificates and smart card logon on real hardware. Requires the
+	    YubiKey Manager CLI, "ykman", in PATH. Combine with -client, and
+	    -ecdsa for a P-256 key.
+
+	-hardware-key
+	    Generate the key of the certificate in the TPM (on Windows), so
+	    that it can't be exported, and only save the certificate. Use
+	    -ecdsa for a P-256 key. The macOS Secure Enclave is not supported.
+
+	-exec COMMAND
+	    Run COMMAND with the shell after certificates are issued or
+	    renewed, for example "systemctl --user reload nginx". The paths
+	    of the new files are in $MKCERT_FILES.
+
+	-system-caroot
+	    Use the machine-wide CAROOT shared by all users, in
+	    /usr/local/share/mkcert (or %ProgramData%\mkcert on Windows),
+	    instead of $CAROOT. Its key is only readable by root, so other
+	    users sign certificates through sudo.
+
+	-ci
+	    Issue the certificates from a throwaway CA in a temporary
+	    directory, without touching any trust store or asking for sudo.
+	    The CA key is deleted afterwards, and the CA certificate is
+	    printed to stdout for test harnesses to trust explicitly.
+
+	-nss-profile PATH
+	    Install, uninstall or check the root only in the NSS database in
+	    PATH, like a single Firefox profile directory, instead of all the
+	    detected ones. Unless -trust-stores or $TRUST_STORES is set, the
+	    other trust stores are left alone.
+
+	-nss-legacy dbm|migrate|dual
+	    How to handle NSS databases in the legacy cert8.db format, which
+	    current browsers ignore: install in them as they are ("dbm", the
+	    default), migrate them to cert9.db ("migrate"), or migrate them
+	    and keep cert8.db up to date for older browsers ("dual").
+
+	-trust-stores LIST
+	    Like $TRUST_STORES, which it overrides.
+
+	-allow-hostnames LIST
+	    Accept hostnames that are normally rejected or warned about:
+	    "trailing-dot" for fully qualified names like "example.test.",
+	    whose dot is dropped, and "long-labels" for labels over 63
+	    characters. Like $MKCERT_ALLOW_HOSTNAMES, which it overrides.
+
+	-hostname-regexp REGEXP
+	    Accept any name that matches REGEXP as is, even if it's not a
+	    valid hostname, like names used by an internal DNS. Like
+	    $MKCERT_HOSTNAME_REGEXP, which it overrides.
+
+	-cmd-timeout DURATION
+	    Stop any external command, like certutil, keytool or a sudo
+	    password prompt, that doesn't complete within DURATION (by
+	    default 5m). Use 0 to wait indefinitely.
 
 	-CAROOT
 	    Print the CA certificate and key storage location.
 
+	-link-caroot
+	    Share the same local CA between WSL and its Windows host, by
+	    linking (in WSL) or copying (on Windows) the CA files.
+
+	-version [-json]
+	    Print the mkcert version, or with -json its full build metadata.
+
 	$CAROOT (environment variable)
 	    Set the CA certificate and key storage location. (This allows
-	    maintaining multiple local CAs in parallel.)
+	    maintaining multiple local CAs in parallel.) A "policy.json"
+	    file in it can limit the lifetime, names and key types of the
+	    issued certificates.
 
 	$TRUST_STORES (environment variable)
 	    A comma-separated list of trust stores to install the local
-	    root CA into. Options are: "system", "java" and "nss" (includes
-	    Firefox). Autodetected by default.
+	    root CA into. Options are: "system", "java", "nss" (includes
+	    Firefox), "deno", "bun", "podman", "lima" and "colima".
+	    Autodetected by default. Prefix a store with "-" to skip it and
+	    still autodetect the others, like "-java" or "all,-nss".
 
 `
 
@@ -90,304 +520,1254 @@ func main() {
 		return
 	}
 	log.SetFlags(0)
+	issuer.LinkedVersion = Version
 	var (
-		installFlag   = flag.Bool("install", false, "")
-		uninstallFlag = flag.Bool("uninstall", false, "")
-		pkcs12Flag    = flag.Bool("pkcs12", false, "")
-		ecdsaFlag     = flag.Bool("ecdsa", false, "")
-		clientFlag    = flag.Bool("client", false, "")
-		helpFlag      = flag.Bool("help", false, "")
-		carootFlag    = flag.Bool("CAROOT", false, "")
-		csrFlag       = flag.String("csr", "", "")
-		certFileFlag  = flag.String("cert-file", "", "")
-		keyFileFlag   = flag.String("key-file", "", "")
-		p12FileFlag   = flag.String("p12-file", "", "")
-		versionFlag   = flag.Bool("version", false, "")
+		installFlag      = flag.Bool("install", false, "")
+		uninstallFlag    = flag.Bool("uninstall", false, "")
+		rollbackFlag     = flag.Bool("rollback", false, "")
+		rotateFlag       = flag.Bool("rotate-ca", false, "")
+		overlapFlag      = flag.Duration("overlap", 30*24*time.Hour, "")
+		pkcs12Flag       = flag.Bool("pkcs12", false, "")
+		pkcs8Flag        = flag.Bool("pkcs8", false, "")
+		derFlag          = flag.Bool("der", false, "")
+		jksFlag          = flag.Bool("jks", false, "")
+		fullChainFlag    = flag.String("fullchain-file", "", "")
+		jksFileFlag      = flag.String("jks-file", "", "")
+		storePassFlag    = flag.String("storepass", "changeit", "")
+		keyPassFlag      = flag.String("keypass", "", "")
+		ecdsaFlag        = flag.Bool("ecdsa", false, "")
+		ed25519Flag      = flag.Bool("ed25519", false, "")
+		rsaBitsFlag      = flag.Int("rsa-bits", 0, "")
+		curveFlag        = flag.String("curve", "", "")
+		daysFlag         = flag.Int("days", 0, "")
+		notAfterFlag     = flag.String("not-after", "", "")
+		clientFlag       = flag.Bool("client", false, "")
+		helpFlag         = flag.Bool("help", false, "")
+		carootFlag       = flag.Bool("CAROOT", false, "")
+		csrFlag          stringsFlag
+		certFileFlag     = flag.String("cert-file", "", "")
+		keyFileFlag      = flag.String("key-file", "", "")
+		p12FileFlag      = flag.String("p12-file", "", "")
+		ownerFlag        = flag.String("owner", "", "")
+		umaskFlag        = flag.String("umask", "", "")
+		versionFlag      = flag.Bool("version", false, "")
+		jsonFlag         = flag.Bool("json", false, "")
+		linkFlag         = flag.Bool("link-caroot", false, "")
+		outputFlag       = flag.String("output", "", "")
+		addHostsFlag     = flag.Bool("add-hosts", false, "")
+		rmHostsFlag      = flag.Bool("remove-hosts", false, "")
+		countFlag        = flag.Int("count", 0, "")
+		csrPolicyFlag    = flag.String("csr-policy", "", "")
+		dryRunFlag       = flag.Bool("dry-run", false, "")
+		renewAllFlag     = flag.String("renew-all", "", "")
+		renewFlag        = flag.String("renew", "", "")
+		newKeyFlag       = flag.Bool("new-key", false, "")
+		installSvcFlag   = flag.Bool("install-service", false, "")
+		resignFlag       = flag.String("resign", "", "")
+		templateFlag     = flag.String("template", "", "")
+		auditFlag        = flag.String("audit", "", "")
+		auditSvcFlag     stringsFlag
+		watchFlag        = flag.Duration("watch", 0, "")
+		healthFlag       = flag.String("health", "", "")
+		withinFlag       = flag.Duration("within", defaultWithin, "")
+		expiryFlag       stringsFlag
+		rawSANFlag       stringsFlag
+		extFlag          stringsFlag
+		metricsFlag      = flag.String("metrics-file", "", "")
+		timeoutFlag      = flag.Duration("cmd-timeout", 5*time.Minute, "")
+		continueFlag     = flag.Bool("continue-on-error", false, "")
+		vaultFlag        = flag.String("vault", "", "")
+		caKMSFlag        = flag.String("ca-kms", "", "")
+		caKeyDirFlag     = flag.String("ca-key-dir", "", "")
+		stepImport       = flag.String("step-import", "", "")
+		stepExport       = flag.String("step-export", "", "")
+		exportGPOFlag    = flag.String("export-gpo", "", "")
+		ocspSignFlag     = flag.String("ocsp-sign", "", "")
+		ocspStatusFlag   = flag.String("ocsp-status", "good", "")
+		ocspThisFlag     = flag.String("ocsp-this-update", "", "")
+		ocspNextFlag     = flag.String("ocsp-next-update", "", "")
+		aiaFlag          = flag.String("aia", "", "")
+		ocspFlag         = flag.String("ocsp", "", "")
+		crlFlag          = flag.String("crl", "", "")
+		revokeFlag       = flag.String("revoke", "", "")
+		genCRLFlag       = flag.Bool("gen-crl", false, "")
+		acmeFlag         = flag.String("acme", "", "")
+		badsslFlag       = flag.String("badssl-suite", "", "")
+		interFlag        = flag.Int("intermediates", 0, "")
+		inspectFlag      = flag.String("inspect", "", "")
+		probeFlag        = flag.String("probe", "", "")
+		serveCAFlag      = flag.Bool("serve-ca", false, "")
+		serveRootFlag    = flag.String("serve-root", "", "")
+		fingerprintFlag  = flag.Bool("fingerprint", false, "")
+		sigAlgFlag       = flag.String("sig-alg", "", "")
+		insecureSigFlag  = flag.Bool("insecure-sig-alg", false, "")
+		purposeFlag      = flag.String("trust-purpose", "", "")
+		noEmojiFlag      = flag.Bool("no-emoji", false, "")
+		logFormatFlag    = flag.String("log-format", "", "")
+		pinFlag          = flag.Bool("pin", false, "")
+		pivSlotFlag      = flag.String("piv-slot", "", "")
+		hwKeyFlag        = flag.Bool("hardware-key", false, "")
+		execFlag         = flag.String("exec", "", "")
+		systemCAROOTFlag = flag.Bool("system-caroot", false, "")
+		signDraftFlag    = flag.Bool("sign-draft", false, "") // used by -system-caroot through sudo
+		verifyRootFlag   = flag.String("verify-root", "", "") // used by -install to check from a new process
+		ciFlag           = flag.Bool("ci", false, "")
+		bundleFlag       = flag.String("bundle-with-system", "", "")
+		envFlag          = flag.Bool("env", false, "")
+		nssProfileFlag   = flag.String("nss-profile", "", "")
+		nssLegacyFlag    = flag.String("nss-legacy", "", "")
+		trustStoresFlag  = flag.String("trust-stores", "", "")
+		allowHostsFlag   = flag.String("allow-hostnames", "", "")
+		hostRegexpFlag   = flag.String("hostname-regexp", "", "")
+		eapFlag          = flag.Bool("eap", false, "")
+		smimeFlag        = flag.Bool("smime", false, "")
+		smimeImportFlag  = flag.Bool("smime-import", false, "")
+		refreshFlag      = flag.Bool("refresh", false, "")
 	)
+	flag.Var(&csrFlag, "csr", "")
+	flag.Var(&expiryFlag, "check-expiry", "")
+	flag.Var(&rawSANFlag, "raw-san", "")
+	flag.Var(&extFlag, "ext", "")
+	flag.Var(&auditSvcFlag, "audit-service", "")
 	flag.Usage = func() {
 		fmt.Fprint(flag.CommandLine.Output(), shortUsage)
 		fmt.Fprintln(flag.CommandLine.Output(), `For more options, run "mkcert -help".`)
 	}
 	flag.Parse()
+	if err := setupConsole(*noEmojiFlag, *logFormatFlag); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
 	if *helpFlag {
 		fmt.Print(shortUsage)
 		fmt.Print(advancedUsage)
 		return
 	}
 	if *versionFlag {
-		if Version != "" {
-			fmt.Println(Version)
-			return
-		}
-		if buildInfo, ok := debug.ReadBuildInfo(); ok {
-			fmt.Println(buildInfo.Main.Version)
+		if *jsonFlag {
+			out, err := json.MarshalIndent(issuer.BuildInfo(), "", "\t")
+			if err != nil {
+				log.Fatalln("ERROR:", err)
+			}
+			fmt.Println(string(out))
 			return
 		}
-		fmt.Println("(unknown)")
+		fmt.Println(issuer.Version())
 		return
 	}
 	if *carootFlag {
 		if *installFlag || *uninstallFlag {
 			log.Fatalln("ERROR: you can't set -[un]install and -CAROOT at the same time")
 		}
-		fmt.Println(getCAROOT())
+		if *systemCAROOTFlag {
+			fmt.Println(systemCAROOT())
+		} else {
+			fmt.Println(localca.DefaultCAROOT())
+		}
+		return
+	}
+	if *signDraftFlag {
+		if err := signDraft(); err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+		return
+	}
+	if *verifyRootFlag != "" {
+		if err := verifyRoot(*verifyRootFlag); err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+		return
+	}
+	if *envFlag && (flag.NArg() != 0 || len(csrFlag) != 0 || *outputFlag != "" || *installFlag || *uninstallFlag || *ciFlag) {
+		log.Fatalln("ERROR: -env can't be combined with names, -csr, -output, -[un]install or -ci")
+	}
+	if *bundleFlag != "" && (flag.NArg() != 0 || len(csrFlag) != 0 || *outputFlag != "" || *ciFlag) {
+		log.Fatalln("ERROR: -bundle-with-system doesn't generate a certificate, so it can't be combined with names, -csr, -output or -ci")
+	}
+	if *ciFlag && (*installFlag || *uninstallFlag || *systemCAROOTFlag || *linkFlag || *vaultFlag != "" || *caKMSFlag != "" || *stepImport != "" || *renewAllFlag != "" || *addHostsFlag) {
+		log.Fatalln("ERROR: -ci can't be combined with -install, -uninstall, -system-caroot, -link-caroot, -vault, -ca-kms, -step-import, -renew-all or -add-hosts")
+	}
+	if *systemCAROOTFlag && (*linkFlag || *vaultFlag != "" || *caKMSFlag != "") {
+		log.Fatalln("ERROR: -system-caroot can't be combined with -link-caroot, -vault or -ca-kms")
+	}
+	if *caKeyDirFlag != "" && (*systemCAROOTFlag || *ciFlag || *vaultFlag != "" || *caKMSFlag != "") {
+		log.Fatalln("ERROR: -ca-key-dir can't be combined with -system-caroot, -ci, -vault or -ca-kms")
+	}
+	// Run all the commands that need sudo in a single session, so the
+	// password is asked at most once.
+	cmdFS := &truststore.CmdFS{Timeout: *timeoutFlag, Batch: true,
+		Reporter: truststore.ReporterFunc(printMessage)}
+	if *rmHostsFlag {
+		err := removeHosts(cmdFS)
+		cmdFS.Close()
+		if err != nil {
+			log.Fatalln("ERROR:", err)
+		}
 		return
 	}
 	if *installFlag && *uninstallFlag {
 		log.Fatalln("ERROR: you can't set -install and -uninstall at the same time")
 	}
-	if *csrFlag != "" && (*pkcs12Flag || *ecdsaFlag || *clientFlag) {
+	if *rollbackFlag && (*installFlag || *uninstallFlag || *ciFlag || flag.NArg() != 0 || len(csrFlag) != 0) {
+		log.Fatalln("ERROR: -rollback can't be combined with -install, -uninstall, -ci, -csr or names")
+	}
+	if *rotateFlag {
+		if *uninstallFlag || *rollbackFlag || *ciFlag || *linkFlag || *vaultFlag != "" || *caKMSFlag != "" || *stepImport != "" || *watchFlag != 0 || flag.NArg() != 0 || len(csrFlag) != 0 {
+			log.Fatalln("ERROR: -rotate-ca can't be combined with -uninstall, -rollback, -ci, -link-caroot, -vault, -ca-kms, -step-import, -watch, -csr or names")
+		}
+		*installFlag = true
+	}
+	if *overlapFlag < 0 {
+		log.Fatalln("ERROR: -overlap can't be negative")
+	}
+	if len(csrFlag) != 0 && (*pkcs12Flag || *ecdsaFlag || *ed25519Flag || *clientFlag) {
 		log.Fatalln("ERROR: can only combine -csr with -install and -cert-file")
 	}
-	if *csrFlag != "" && flag.NArg() != 0 {
+	if len(csrFlag) != 0 && flag.NArg() != 0 {
 		log.Fatalln("ERROR: can't specify extra arguments when using -csr")
 	}
-	(&mkcert{
-		installMode: *installFlag, uninstallMode: *uninstallFlag, csrPath: *csrFlag,
-		pkcs12: *pkcs12Flag, ecdsa: *ecdsaFlag, client: *clientFlag,
-		certFile: *certFileFlag, keyFile: *keyFileFlag, p12File: *p12FileFlag,
-	}).Run(flag.Args())
+	csrPolicy, err := parseCSRPolicy(*csrPolicyFlag)
+	if err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	ocspStatus, err := parseOCSPStatus(*ocspStatusFlag, *ocspThisFlag, *ocspNextFlag)
+	if err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	if *ocspSignFlag != "" && (*vaultFlag != "" || len(csrFlag) != 0 || flag.NArg() != 0) {
+		log.Fatalln("ERROR: -ocsp-sign can't be combined with -vault, -csr or names")
+	}
+	if *aiaFlag != "" {
+		if _, err := parseAIAURL(*aiaFlag); err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+		if *vaultFlag != "" {
+			log.Fatalln("ERROR: -aia can't be combined with -vault, configure the issuing certificates URL of the mount instead")
+		}
+	}
+	var ocspURL string
+	if *ocspFlag != "" {
+		u, err := parseOCSPURL(*ocspFlag)
+		if err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+		if *vaultFlag != "" || len(csrFlag) != 0 {
+			log.Fatalln("ERROR: -ocsp can't be combined with -vault or -csr")
+		}
+		ocspURL = u.String()
+	}
+	if *crlFlag != "" {
+		if _, err := parseCRLURL(*crlFlag); err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+		if *vaultFlag != "" || len(csrFlag) != 0 {
+			log.Fatalln("ERROR: -crl can't be combined with -vault or -csr")
+		}
+	}
+	if *acmeFlag != "" {
+		if _, _, err := parseACMEAddr(*acmeFlag); err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+		if flag.NArg() != 0 || len(csrFlag) != 0 || *vaultFlag != "" || *installFlag || *uninstallFlag {
+			log.Fatalln("ERROR: -acme can't be combined with names, -csr, -vault, -install or -uninstall")
+		}
+	}
+	if (*revokeFlag != "" || *genCRLFlag) && (flag.NArg() != 0 || len(csrFlag) != 0 || *vaultFlag != "" || *installFlag || *uninstallFlag || *ocspSignFlag != "") {
+		log.Fatalln("ERROR: -revoke and -gen-crl can't be combined with names, -csr, -vault, -install, -uninstall or -ocsp-sign")
+	}
+	if *badsslFlag != "" && (*pkcs12Flag || len(csrFlag) != 0 || *vaultFlag != "" || *countFlag > 0 || *outputFlag != "" || *certFileFlag != "" || *keyFileFlag != "") {
+		log.Fatalln("ERROR: -badssl-suite can't be combined with -pkcs12, -csr, -vault, -count, -output or the output paths")
+	}
+	if *interFlag < 0 || *interFlag > 0 && (len(csrFlag) != 0 || *vaultFlag != "" || *countFlag > 0 || *badsslFlag != "") {
+		log.Fatalln("ERROR: -intermediates can't be combined with -csr, -vault, -count or -badssl-suite")
+	}
+	if *inspectFlag != "" && (flag.NArg() != 0 || len(csrFlag) != 0 || *vaultFlag != "") {
+		log.Fatalln("ERROR: -inspect can't be combined with names, -csr or -vault")
+	}
+	if *probeFlag != "" && (flag.NArg() != 0 || len(csrFlag) != 0 || *vaultFlag != "" || *inspectFlag != "") {
+		log.Fatalln("ERROR: -probe can't be combined with names, -csr, -vault or -inspect")
+	}
+	if *fingerprintFlag && (flag.NArg() > 1 || len(csrFlag) != 0 || *installFlag || *uninstallFlag || *inspectFlag != "" || *probeFlag != "" || *serveCAFlag) {
+		log.Fatalln("ERROR: -fingerprint takes at most one certificate file, and can't be combined with -csr, -install, -uninstall, -inspect, -probe or -serve-ca")
+	}
+	if *serveCAFlag && (flag.NArg() != 0 || len(csrFlag) != 0 || *installFlag || *uninstallFlag || *rollbackFlag || *ciFlag || *inspectFlag != "" || *probeFlag != "") {
+		log.Fatalln("ERROR: -serve-ca can't be combined with names, -csr, -install, -uninstall, -rollback, -ci, -inspect or -probe")
+	}
+	if *serveRootFlag != "" {
+		if _, _, err := net.SplitHostPort(*serveRootFlag); err != nil {
+			log.Fatalf("ERROR: invalid -serve-root address %q, it must be like :8080 or 192.168.1.2:8080", *serveRootFlag)
+		}
+		if flag.NArg() != 0 || len(csrFlag) != 0 || *installFlag || *uninstallFlag || *rollbackFlag || *ciFlag || *inspectFlag != "" || *probeFlag != "" || *serveCAFlag {
+			log.Fatalln("ERROR: -serve-root can't be combined with names, -csr, -install, -uninstall, -rollback, -ci, -inspect, -probe or -serve-ca")
+		}
+	}
+	trustPurpose, err := parseTrustPurpose(*purposeFlag)
+	if err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	nssLegacy, err := parseNSSLegacy(*nssLegacyFlag)
+	if err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	if *allowHostsFlag == "" {
+		*allowHostsFlag = os.Getenv("MKCERT_ALLOW_HOSTNAMES")
+	}
+	if *hostRegexpFlag == "" {
+		*hostRegexpFlag = os.Getenv("MKCERT_HOSTNAME_REGEXP")
+	}
+	hostPolicy, err := parseHostPolicy(*allowHostsFlag, *hostRegexpFlag)
+	if err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	var rawSANs []rawSAN
+	for _, v := range rawSANFlag {
+		san, err := parseRawSAN(v)
+		if err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+		rawSANs = append(rawSANs, san)
+	}
+	if len(rawSANs) != 0 && (*vaultFlag != "" || flag.NArg() == 0) {
+		log.Fatalln("ERROR: -raw-san needs at least one name as an argument, and can't be combined with -vault")
+	}
+	var extensions []pkix.Extension
+	for _, v := range extFlag {
+		ext, err := parseExtension(v)
+		if err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+		for _, e := range extensions {
+			if e.Id.Equal(ext.Id) {
+				log.Fatalf("ERROR: -ext %s is repeated, a certificate can only have one extension with the same OID", ext.Id)
+			}
+		}
+		extensions = append(extensions, ext)
+	}
+	if len(extensions) != 0 && (*vaultFlag != "" || (flag.NArg() == 0 && len(csrFlag) == 0)) {
+		log.Fatalln("ERROR: -ext needs names or -csr, and can't be combined with -vault")
+	}
+	sigHash, err := parseSigAlg(*sigAlgFlag, *insecureSigFlag)
+	if err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	if sigHash != 0 && *vaultFlag != "" {
+		log.Fatalln("ERROR: -sig-alg can't be used with -vault, the signature algorithm is chosen by the Vault role")
+	}
+	if *csrPolicyFlag != "" && len(csrFlag) == 0 {
+		log.Fatalln("ERROR: -csr-policy can only be used with -csr")
+	}
+	if *dryRunFlag && (len(csrFlag) == 0 || *vaultFlag != "") {
+		log.Fatalln("ERROR: -dry-run can only be used with -csr, and can't be combined with -vault")
+	}
+	if len(csrFlag) > 1 && *certFileFlag != "" {
+		log.Fatalln("ERROR: can't specify -cert-file when using multiple -csr")
+	}
+	if *renewAllFlag != "" && (flag.NArg() != 0 || len(csrFlag) != 0 || *countFlag != 0 || *outputFlag != "") {
+		log.Fatalln("ERROR: -renew-all can't be combined with names, -csr, -count or -output")
+	}
+	if *renewFlag != "" && (flag.NArg() != 0 || len(csrFlag) != 0 || *countFlag != 0 || *outputFlag != "" || *renewAllFlag != "" || *resignFlag != "" || *vaultFlag != "" || *certFileFlag != "" || *pkcs12Flag || *jksFlag || *derFlag || *pkcs8Flag) {
+		log.Fatalln("ERROR: -renew can't be combined with names, -csr, -count, -output, -renew-all, -resign, -vault, -cert-file, -pkcs12, -jks, -der or -pkcs8")
+	}
+	if *newKeyFlag && *renewFlag == "" {
+		log.Fatalln("ERROR: -new-key can only be used with -renew")
+	}
+	if *keyFileFlag != "" && *renewFlag != "" && !*newKeyFlag {
+		log.Fatalln("ERROR: -key-file can only be combined with -renew if -new-key is set")
+	}
+	if *resignFlag != "" && (flag.NArg() != 0 || len(csrFlag) != 0 || *countFlag != 0 || *outputFlag != "" || *renewAllFlag != "") {
+		log.Fatalln("ERROR: -resign can't be combined with names, -csr, -count, -output or -renew-all")
+	}
+	if *templateFlag != "" && (flag.NArg() == 0 || len(csrFlag) != 0 || *vaultFlag != "") {
+		log.Fatalln("ERROR: -template requires names, and can't be combined with -csr or -vault")
+	}
+	if *installSvcFlag && *renewAllFlag == "" {
+		log.Fatalln("ERROR: -install-service can only be used with -renew-all")
+	}
+	var svcFlags []string
+	if *installSvcFlag {
+		svcFlags, err = serviceFlags()
+		if err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+	}
+	if *watchFlag < 0 || *watchFlag > 0 && (flag.NArg() != 0 || len(csrFlag) != 0 || *uninstallFlag || *rollbackFlag || *ciFlag || *renewAllFlag != "" || *auditFlag != "") {
+		log.Fatalln("ERROR: -watch can't be combined with names, -csr, -uninstall, -rollback, -ci, -renew-all or -audit")
+	}
+	if *healthFlag != "" {
+		if _, err := parseHealthAddr(*healthFlag); err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+		if *watchFlag == 0 && *acmeFlag == "" {
+			log.Fatalln("ERROR: -health can only be used with -watch or -acme")
+		}
+	}
+	if len(expiryFlag) != 0 && (flag.NArg() != 0 || len(csrFlag) != 0 || *installFlag || *uninstallFlag || *renewAllFlag != "") {
+		log.Fatalln("ERROR: -check-expiry can only be combined with -within and -json")
+	}
+	if *auditFlag != "" && (flag.NArg() != 0 || len(csrFlag) != 0 || *installFlag || *uninstallFlag || *renewAllFlag != "" || len(expiryFlag) != 0) {
+		log.Fatalln("ERROR: -audit can only be combined with -within, -audit-service and -json")
+	}
+	if len(auditSvcFlag) != 0 && *auditFlag == "" {
+		log.Fatalln("ERROR: -audit-service can only be used with -audit")
+	}
+	if *metricsFlag != "" && len(expiryFlag) == 0 {
+		log.Fatalln("ERROR: -metrics-file can only be used with -check-expiry")
+	}
+	if *addHostsFlag && (len(csrFlag) != 0 || flag.NArg() == 0) {
+		log.Fatalln("ERROR: -add-hosts requires the names to add as arguments, and can't be combined with -csr")
+	}
+	perms, err := parseOutputPerms(*ownerFlag, *umaskFlag)
+	if err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	pivSlot, err := parsePIVSlot(*pivSlotFlag)
+	if err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	if pivSlot != "" && (*pkcs12Flag || len(csrFlag) != 0 || *countFlag > 0 || *badsslFlag != "" || *renewAllFlag != "" || *keyFileFlag != "" || flag.NArg() == 0) {
+		log.Fatalln("ERROR: -piv-slot requires names, and can't be combined with -pkcs12, -csr, -count, -badssl-suite, -renew-all or -key-file")
+	}
+	if *hwKeyFlag && (*pkcs12Flag || len(csrFlag) != 0 || *countFlag > 0 || *badsslFlag != "" || *renewAllFlag != "" || *keyFileFlag != "" || *vaultFlag != "" || pivSlot != "" || flag.NArg() == 0) {
+		log.Fatalln("ERROR: -hardware-key requires names, and can't be combined with -pkcs12, -csr, -count, -badssl-suite, -renew-all, -key-file, -vault or -piv-slot")
+	}
+	if *eapFlag && (len(csrFlag) != 0 || *vaultFlag != "" || *badsslFlag != "" || *outputFlag != "" || flag.NArg() == 0) {
+		log.Fatalln("ERROR: -eap requires names, and can't be combined with -csr, -vault, -badssl-suite or -output")
+	}
+	if *smimeImportFlag && !*smimeFlag {
+		log.Fatalln("ERROR: -smime-import can only be used with -smime")
+	}
+	if *smimeFlag {
+		if len(csrFlag) != 0 || *vaultFlag != "" || *badsslFlag != "" || *outputFlag != "" || *eapFlag || *clientFlag || *countFlag > 0 || pivSlot != "" || *hwKeyFlag || flag.NArg() == 0 {
+			log.Fatalln("ERROR: -smime requires email addresses, and can't be combined with -csr, -vault, -badssl-suite, -output, -eap, -client, -count, -piv-slot or -hardware-key")
+		}
+		// The certificate and key are imported in mail clients together.
+		*pkcs12Flag = true
+	}
+	if *refreshFlag && (*pkcs12Flag || len(csrFlag) != 0 || *countFlag > 0 || *badsslFlag != "" || *renewAllFlag != "" || *vaultFlag != "" || pivSlot != "" || *hwKeyFlag || flag.NArg() == 0) {
+		log.Fatalln("ERROR: -refresh requires names, and can't be combined with -pkcs12, -csr, -count, -badssl-suite, -renew-all, -vault, -piv-slot or -hardware-key")
+	}
+	if *ed25519Flag && (*ecdsaFlag || *pkcs12Flag || *eapFlag || *smimeFlag || pivSlot != "" || *hwKeyFlag || *outputFlag == "dotnet") {
+		log.Fatalln("ERROR: -ed25519 can't be combined with -ecdsa, -pkcs12, -eap, -smime, -piv-slot, -hardware-key or -output dotnet")
+	}
+	curve, err := parseCurve(*curveFlag)
+	if err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	if curve != nil {
+		if *ed25519Flag || len(csrFlag) != 0 || *vaultFlag != "" || *hwKeyFlag {
+			log.Fatalln("ERROR: -curve can't be combined with -ed25519, -csr, -vault or -hardware-key")
+		}
+		*ecdsaFlag = true
+	}
+	switch *rsaBitsFlag {
+	case 0, 2048, 3072, 4096:
+	default:
+		log.Fatalf("ERROR: unsupported -rsa-bits %d, options are 2048, 3072 and 4096", *rsaBitsFlag)
+	}
+	if *rsaBitsFlag != 0 && (*ecdsaFlag || *ed25519Flag || len(csrFlag) != 0 || *vaultFlag != "" || *hwKeyFlag) {
+		log.Fatalln("ERROR: -rsa-bits can't be combined with -ecdsa, -ed25519, -csr, -vault or -hardware-key")
+	}
+	notAfter, err := parseNotAfter(*daysFlag, *notAfterFlag)
+	if err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+	if !notAfter.IsZero() && *vaultFlag != "" {
+		log.Fatalln("ERROR: -days and -not-after can't be combined with -vault, as the role decides")
+	}
+	if notAfter.After(time.Now().AddDate(0, 0, 825)) {
+		log.Println("Warning: certificates valid for more than 825 days are rejected by macOS and iOS ⚠️")
+	}
+	if *countFlag < 0 {
+		log.Fatalln("ERROR: -count must be positive")
+	}
+	if *countFlag > 0 && (flag.NArg() != 1 || len(csrFlag) != 0 || *certFileFlag != "" || *keyFileFlag != "" || *p12FileFlag != "" || *addHostsFlag || *outputFlag != "") {
+		log.Fatalln("ERROR: -count requires a single name pattern, and can't be combined with -csr, -add-hosts, -output or the output paths")
+	}
+	var vaultClient *vault.Client
+	if *vaultFlag != "" {
+		if *ecdsaFlag || *ed25519Flag || *csrPolicyFlag != "" || *renewAllFlag != "" || *resignFlag != "" || *linkFlag {
+			log.Fatalln("ERROR: -vault can't be combined with -ecdsa, -ed25519, -csr-policy, -renew-all, -resign or -link-caroot, as the role decides")
+		}
+		i := strings.LastIndex(*vaultFlag, "/")
+		if i <= 0 {
+			log.Fatalln("ERROR: -vault must be MOUNT/ROLE, like pki/dev")
+		}
+		vaultClient, err = vault.FromEnv((*vaultFlag)[:i], (*vaultFlag)[i+1:])
+		if err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+	}
+	if *caKMSFlag != "" && *vaultFlag != "" {
+		log.Fatalln("ERROR: -ca-kms and -vault are mutually exclusive")
+	}
+	if (*stepImport != "" || *stepExport != "") && (*vaultFlag != "" || *linkFlag) {
+		log.Fatalln("ERROR: -step-import and -step-export can't be combined with -vault or -link-caroot")
+	}
+	switch *outputFlag {
+	case "":
+	case "aws", "gcloud", "ansible", "cloud-init", "docker":
+		if len(csrFlag) != 0 || flag.NArg() != 0 {
+			log.Fatalf("ERROR: -output %s doesn't generate a certificate, so it can't be combined with names or -csr", *outputFlag)
+		}
+	case "postgres", "mysql", "redis":
+		if len(csrFlag) != 0 || *pkcs12Flag {
+			log.Fatalf("ERROR: -output %s can't be combined with -csr or -pkcs12", *outputFlag)
+		}
+	case "dotnet":
+		if len(csrFlag) != 0 || *vaultFlag != "" || *countFlag > 0 || *pivSlotFlag != "" || *hwKeyFlag {
+			log.Fatalln("ERROR: -output dotnet can't be combined with -csr, -vault, -count, -piv-slot or -hardware-key")
+		}
+		// The development certificate is imported as a PKCS #12 file.
+		*pkcs12Flag = true
+	default:
+		log.Fatalf("ERROR: unknown -output %q, options are: aws, gcloud, ansible, cloud-init, docker, postgres, mysql, redis and dotnet", *outputFlag)
+	}
+	if *pkcs8Flag && (*pkcs12Flag || len(csrFlag) != 0 || pivSlot != "" || *hwKeyFlag || *outputFlag != "") {
+		log.Fatalln("ERROR: -pkcs8 can't be combined with -pkcs12, -csr, -piv-slot, -hardware-key or -output")
+	}
+	if *keyPassFlag != "" && !*pkcs8Flag {
+		log.Fatalln("ERROR: -keypass can only be used with -pkcs8")
+	}
+	if *derFlag && (*pkcs12Flag || *pkcs8Flag || pivSlot != "" || *hwKeyFlag || *outputFlag != "" || *interFlag > 0 || *badsslFlag != "" || *renewAllFlag != "") {
+		log.Fatalln("ERROR: -der can't be combined with -pkcs12, -pkcs8, -piv-slot, -hardware-key, -output, -intermediates, -badssl-suite or -renew-all")
+	}
+	if (*jksFileFlag != "" || *storePassFlag != "changeit") && !*jksFlag {
+		log.Fatalln("ERROR: -jks-file and -storepass can only be used with -jks")
+	}
+	if *jksFlag {
+		if *pkcs12Flag || *p12FileFlag != "" || *pkcs8Flag || *derFlag || len(csrFlag) != 0 || pivSlot != "" || *hwKeyFlag || *outputFlag != "" || *badsslFlag != "" || *renewAllFlag != "" {
+			log.Fatalln("ERROR: -jks can't be combined with -pkcs12, -p12-file, -pkcs8, -der, -csr, -piv-slot, -hardware-key, -output, -badssl-suite or -renew-all")
+		}
+		if len(*storePassFlag) < 6 {
+			log.Fatalln("ERROR: -storepass must be at least 6 characters long, as Java requires")
+		}
+		if *jksFileFlag != "" && *countFlag > 0 {
+			log.Fatalln("ERROR: -jks-file can't be combined with -count")
+		}
+	}
+	if *fullChainFlag != "" && (len(csrFlag) > 1 || flag.NArg() == 0 && len(csrFlag) == 0 || *pkcs12Flag || *jksFlag || *derFlag || *countFlag > 0 || *vaultFlag != "" || *badsslFlag != "" || *dryRunFlag) {
+		log.Fatalln("ERROR: -fullchain-file requires names or a single -csr, and can't be combined with -pkcs12, -jks, -der, -count, -vault, -badssl-suite or -dry-run")
+	}
+	// The -jks keystore takes the place of the PKCS #12 file.
+	p12File := *p12FileFlag
+	if *jksFlag {
+		p12File = *jksFileFlag
+	}
+	m := &mkcert{
+		installMode: *installFlag, uninstallMode: *uninstallFlag, csrPaths: csrFlag,
+		pkcs12: *pkcs12Flag, pkcs8: *pkcs8Flag, der: *derFlag, ecdsa: *ecdsaFlag, client: *clientFlag,
+		certFile: *certFileFlag, keyFile: *keyFileFlag, p12File: p12File,
+		jks: *jksFlag, storePass: *storePassFlag, keyPass: *keyPassFlag, fullChain: *fullChainFlag,
+		renewFile: *renewFlag, newKey: *newKeyFlag,
+		revokeTarget: *revokeFlag, genCRLMode: *genCRLFlag, acmeAddr: *acmeFlag,
+		serveCAMode: *serveCAFlag, serveRootAddr: *serveRootFlag,
+		linkMode: *linkFlag, output: *outputFlag, addHosts: *addHostsFlag,
+		count: *countFlag, csrPolicy: csrPolicy, pin: *pinFlag, pivSlot: pivSlot,
+		hardwareKey: *hwKeyFlag, execHook: *execFlag, envMode: *envFlag,
+		systemCAROOT: *systemCAROOTFlag, ciMode: *ciFlag, bundleFile: *bundleFlag,
+		renewDir: *renewAllFlag, within: *withinFlag, nssProfile: *nssProfileFlag,
+		trustStores: *trustStoresFlag, eap: *eapFlag, rollbackMode: *rollbackFlag,
+		rotateMode: *rotateFlag, overlap: *overlapFlag,
+		smime: *smimeFlag, smimeImport: *smimeImportFlag, refreshMode: *refreshFlag,
+		expiryFiles: expiryFlag, jsonOutput: *jsonFlag, metricsFile: *metricsFlag,
+		cmdFS: cmdFS, continueOnError: *continueFlag, vault: vaultClient,
+		caKMS: *caKMSFlag, caKeyDir: *caKeyDirFlag, stepImport: *stepImport, stepExport: *stepExport,
+		exportGPO: *exportGPOFlag, ocspFile: *ocspSignFlag, ocspStatus: ocspStatus,
+		aiaURL: *aiaFlag, ocspURL: ocspURL, crlURL: *crlFlag, badsslDir: *badsslFlag, intermediates: *interFlag,
+		inspectFile:  *inspectFlag,
+		probeTarget:  *probeFlag,
+		fingerprint:  *fingerprintFlag,
+		sigHash:      sigHash,
+		trustPurpose: trustPurpose,
+		hostPolicy:   hostPolicy,
+		rawSANs:      rawSANs,
+		extensions:   extensions,
+		dryRun:       *dryRunFlag,
+		perms:        perms,
+		ed25519:      *ed25519Flag,
+		rsaBits:      *rsaBitsFlag,
+		curve:        curve,
+		notAfter:     notAfter,
+		serviceMode:  *installSvcFlag,
+		serviceFlags: svcFlags,
+		resignDir:    *resignFlag,
+		templateName: *templateFlag,
+		auditDir:     *auditFlag,
+		auditTargets: auditSvcFlag,
+		nssLegacy:    nssLegacy,
+		watchEvery:   *watchFlag,
+		healthAddr:   *healthFlag,
+	}
+	err = m.Run(flag.Args())
+	if err == nil && m.ciMode {
+		err = m.finishCI()
+	}
+	if err == nil {
+		err = m.runExecHook()
+	}
+	cmdFS.Close()
+	if errors.Is(err, issuer.ErrNoCAKey) && m.caKeyDir != "" {
+		err = fmt.Errorf("the CA key is unavailable, as there is no %s in %s: mount the volume it's on, or check -ca-key-dir", issuer.RootKeyName, m.caKeyDir)
+	}
+	if err != nil {
+		var timeoutErr *truststore.TimeoutError
+		if errors.As(err, &timeoutErr) {
+			log.Printf("Note: if the command was waiting for a password or a confirmation, re-run with a longer -cmd-timeout (currently %s) 👈", timeoutErr.Timeout)
+		}
+		log.Fatalln("ERROR:", err)
+	}
 }
 
-const rootName = "rootCA.pem"
-const rootKeyName = "rootCA-key.pem"
-
 type mkcert struct {
 	installMode, uninstallMode bool
+	rollbackMode               bool
+	rotateMode                 bool
+	overlap                    time.Duration
 	pkcs12, ecdsa, client      bool
+	pkcs8, der, jks            bool
+	storePass, keyPass         string
+	fullChain                  string
+	ed25519                    bool
+	rsaBits                    int
+	curve                      elliptic.Curve
+	notAfter                   time.Time
 	keyFile, certFile, p12File string
-	csrPath                    string
+	csrPaths                   []string
+	csrPolicy                  issuer.CSRPolicy
+	renewDir                   string
+	renewFile                  string
+	newKey                     bool
+	serviceMode                bool
+	serviceFlags               []string
+	resignDir                  string
+	templateName               string
+	certTemplate               *certTemplate
+	auditDir                   string
+	auditTargets               []string
+	nssLegacy                  truststore.NSSLegacy
+	watchEvery                 time.Duration
+	healthAddr                 string
+	health                     *healthState
+	within                     time.Duration
+	expiryFiles                []string
+	jsonOutput                 bool
+	metricsFile                string
+	linkMode                   bool
+	stepImport, stepExport     string
+	exportGPO                  string
+	ocspFile                   string
+	ocspStatus                 issuer.OCSPStatus
+	aiaURL                     string
+	ocspURL                    string
+	crlURL                     string
+	revokeTarget               string
+	genCRLMode                 bool
+	acmeAddr                   string
+	badsslDir                  string
+	intermediates              int
+	inspectFile                string
+	probeTarget                string
+	serveCAMode                bool
+	serveRootAddr              string
+	fingerprint                bool
+	hostPolicy                 hostPolicy
+	rawSANs                    []rawSAN
+	extensions                 []pkix.Extension
+	dryRun                     bool
+	perms                      outputPerms
+	sigHash                    crypto.Hash
+	output                     string
+	addHosts                   bool
+	count                      int
+	pin                        bool
+	pivSlot                    string
+	hardwareKey                bool
+	execHook                   string
+	systemCAROOT               bool
+	ciMode                     bool
+	bundleFile                 string
+	nssProfile                 string
+	trustStores                string
+	eap                        bool
+	smime                      bool
+	smimeImport                bool
+	refreshMode                bool
+	envMode                    bool
+	written                    []string
 
-	CAROOT string
-	caCert *x509.Certificate
-	caKey  crypto.PrivateKey
+	CAROOT   string
+	rootPath string
+	ca       *issuer.CA
+	leafCA   *issuer.CA
+	leafKey  crypto.Signer
+	chain    []*x509.Certificate
+	vault    *vault.Client
+	caKMS    string
+	caKeyDir string
+	store    *truststore.Store
+	cmdFS    *truststore.CmdFS
 
-	// The system cert pool is only loaded once. After installing the root, checks
-	// will keep failing until the next execution. TODO: maybe execve?
-	// https://github.com/golang/go/issues/24540 (thanks, myself)
-	ignoreCheckFailure bool
+	continueOnError bool
+	trustPurpose    truststore.Purpose
+	messages        messageQueue
 }
 
-func (m *mkcert) Run(args []string) {
-	m.CAROOT = getCAROOT()
+func (m *mkcert) Run(args []string) error {
+	switch {
+	case m.ciMode:
+		dir, err := ioutil.TempDir("", "mkcert-ci-")
+		if err != nil {
+			return err
+		}
+		m.CAROOT = dir
+	case m.systemCAROOT:
+		m.CAROOT = systemCAROOT()
+	default:
+		m.CAROOT = localca.DefaultCAROOT()
+	}
 	if m.CAROOT == "" {
-		log.Fatalln("ERROR: failed to find the default CA location, set one as the CAROOT env var")
+		return errors.New("failed to find the default CA location, set one as the CAROOT env var")
 	}
-	fatalIfErr(os.MkdirAll(m.CAROOT, 0755), "failed to create the CAROOT")
-	m.loadCA()
-
-	if m.installMode {
-		m.install()
-		if len(args) == 0 {
-			return
+	if err := os.MkdirAll(m.CAROOT, 0755); err != nil {
+		if m.systemCAROOT && errors.Is(err, fs.ErrPermission) {
+			return errNoSharedCA
 		}
-	} else if m.uninstallMode {
-		m.uninstall()
-		return
-	} else {
-		var warning bool
-		if storeEnabled("system") && !m.checkPlatform() {
-			warning = true
-			log.Println("Note: the local CA is not installed in the system trust store.")
+		return fmt.Errorf("failed to create the CAROOT: %w", err)
+	}
+	if m.templateName != "" {
+		if err := m.loadTemplate(m.templateName); err != nil {
+			return err
 		}
-		if storeEnabled("nss") && hasNSS && CertutilInstallHelp != "" && !m.checkNSS() {
-			warning = true
-			log.Printf("Note: the local CA is not installed in the %s trust store.", NSSBrowsers)
+	}
+	if m.linkMode {
+		if err := m.linkCAROOT(); err != nil {
+			return err
 		}
-		if storeEnabled("java") && hasJava && !m.checkJava() {
-			warning = true
-			log.Println("Note: the local CA is not installed in the Java trust store.")
+	}
+	if m.stepImport != "" {
+		if err := m.importStepCA(m.stepImport); err != nil {
+			return err
 		}
-		if warning {
-			log.Println("Run \"mkcert -install\" for certificates to be trusted automatically ⚠️")
+	}
+	if m.rotateMode {
+		if err := m.rotateCA(); err != nil {
+			return err
+		}
+	}
+	if m.vault != nil {
+		if err := m.loadVaultCA(); err != nil {
+			return err
 		}
+	} else if err := m.loadCA(); err != nil {
+		return err
 	}
+	if m.stepExport != "" {
+		if err := m.exportStepCA(m.stepExport); err != nil {
+			return err
+		}
+	}
+	if m.exportGPO != "" {
+		if err := m.writeGPOFiles(m.exportGPO); err != nil {
+			return err
+		}
+	}
+	if (m.linkMode || m.stepImport != "" || m.stepExport != "" || m.exportGPO != "") && !m.installMode && len(args) == 0 {
+		return nil
+	}
+	if m.ocspFile != "" {
+		return m.signOCSP(m.ocspFile)
+	}
+	if m.revokeTarget != "" {
+		return m.revoke(m.revokeTarget)
+	}
+	if m.genCRLMode {
+		return m.genCRL()
+	}
+	if m.inspectFile != "" {
+		return m.inspect(m.inspectFile)
+	}
+	if m.probeTarget != "" {
+		return m.probe(m.probeTarget)
+	}
+	if m.serveCAMode {
+		return m.serveCA()
+	}
+	if m.serveRootAddr != "" {
+		return m.serveRoot(m.serveRootAddr)
+	}
+	if m.fingerprint {
+		return m.printFingerprints(args)
+	}
+	if m.envMode {
+		return m.printEnv()
+	}
+	if m.auditDir != "" {
+		return m.audit(m.auditDir)
+	}
+	if len(m.expiryFiles) != 0 {
+		return m.checkExpiry()
+	}
+	m.store = m.newStore()
 
-	if m.csrPath != "" {
-		m.makeCertFromCSR()
-		return
+	if m.watchEvery > 0 {
+		return m.watch()
+	}
+	if m.installMode {
+		if err := m.install(); err != nil {
+			return err
+		}
+		if err := m.retireRoots(); err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			return nil
+		}
+	} else if m.uninstallMode {
+		return m.uninstall()
+	} else if m.rollbackMode {
+		return m.rollback()
+	} else if m.ciMode {
+		// Nothing trusts the throwaway CA, so there is nothing to check.
+	} else if err := m.check(); err != nil {
+		return err
+	}
+
+	if m.bundleFile != "" {
+		return m.printBundleConfig()
+	}
+	if m.output == "aws" || m.output == "gcloud" {
+		return m.printCloudConfig()
+	}
+	if m.output == "ansible" || m.output == "cloud-init" || m.output == "docker" {
+		return m.printProvisioningConfig()
+	}
+
+	if len(m.csrPaths) != 0 {
+		return m.makeCertsFromCSRs()
+	}
+
+	if m.renewDir != "" && m.serviceMode {
+		return m.installRenewService()
+	}
+	if m.renewDir != "" {
+		return m.renewAll(m.renewDir)
+	}
+	if m.renewFile != "" {
+		return m.renewCert(m.renewFile)
+	}
+	if m.resignDir != "" {
+		return m.resignAll(m.resignDir)
+	}
+
+	if m.badsslDir != "" {
+		if err := m.normalizeHosts(args); err != nil {
+			return err
+		}
+		return m.makeBadSSLSuite(args)
 	}
 
 	if len(args) == 0 {
+		if m.aiaURL != "" || m.ocspURL != "" || m.crlURL != "" || m.acmeAddr != "" {
+			return m.serveEndpoints()
+		}
 		flag.Usage()
-		return
+		return nil
 	}
 
-	hostnameRegexp := regexp.MustCompile(`(?i)^(\*\.)?[0-9a-z_-]([0-9a-z._-]*[0-9a-z_-])?$`)
-	for i, name := range args {
-		if ip := net.ParseIP(name); ip != nil {
-			continue
+	if m.count > 0 {
+		return m.makeCerts(args[0])
+	}
+
+	if err := m.normalizeHosts(args); err != nil {
+		return err
+	}
+	if m.eap {
+		if err := checkEAPHosts(args, m.client); err != nil {
+			return err
 		}
-		if email, err := mail.ParseAddress(name); err == nil && email.Address == name {
-			continue
+	}
+	if m.smime {
+		if err := checkSMIMEHosts(args); err != nil {
+			return err
 		}
-		if uriName, err := url.Parse(name); err == nil && uriName.Scheme != "" && uriName.Host != "" {
-			continue
+	}
+
+	if m.intermediates > 0 {
+		if err := m.makeChain(); err != nil {
+			return err
 		}
-		punycode, err := idna.ToASCII(name)
-		if err != nil {
-			log.Fatalf("ERROR: %q is not a valid hostname, IP, URL or email: %s", name, err)
+	}
+	if err := m.makeCert(args); err != nil {
+		return err
+	}
+	if m.addHosts {
+		return m.updateHosts(args)
+	}
+	return nil
+}
+
+var hostnameRegexp = regexp.MustCompile(`(?i)^(\*\.)?[0-9a-z_-]([0-9a-z._-]*[0-9a-z_-])?$`)
+
+// normalizeHosts validates the names passed on the command line, converting
+// internationalized hostnames to punycode in place. Names that fail the
+// checks are still accepted if they match -hostname-regexp.
+func (m *mkcert) normalizeHosts(hosts []string) error {
+	for i, name := range hosts {
+		normalized, err := m.normalizeHost(name)
+		if err != nil && m.hostPolicy.pattern != nil && m.hostPolicy.pattern.MatchString(name) {
+			log.Printf("Warning: accepting %q as is because it matches -hostname-regexp ⚠️", name)
+			normalized, err = name, nil
 		}
-		args[i] = punycode
-		if !hostnameRegexp.MatchString(punycode) {
-			log.Fatalf("ERROR: %q is not a valid hostname, IP, URL or email", name)
+		if err != nil {
+			return err
 		}
+		hosts[i] = normalized
 	}
+	return nil
+}
 
-	m.makeCert(args)
+func (m *mkcert) normalizeHost(name string) (string, error) {
+	if ip := net.ParseIP(name); ip != nil {
+		return name, nil
+	}
+	if email, err := mail.ParseAddress(name); err == nil && email.Address == name {
+		return name, nil
+	}
+	if uriName, err := url.Parse(name); err == nil && uriName.Scheme != "" && uriName.Host != "" {
+		return name, nil
+	}
+	if trimmed := strings.TrimSuffix(name, "."); trimmed != name && trimmed != "" {
+		if !m.hostPolicy.trailingDot {
+			return "", fmt.Errorf("%q ends with a dot, which certificates don't have (use -allow-hostnames trailing-dot to drop it)", name)
+		}
+		log.Printf("Note: dropping the trailing dot of %q, clients match the name without it ℹ️", name)
+		name = trimmed
+	}
+	punycode, err := idna.ToASCII(name)
+	if err != nil {
+		return "", fmt.Errorf("%q is not a valid hostname, IP, URL or email: %s", name, err)
+	}
+	if !hostnameRegexp.MatchString(punycode) {
+		return "", fmt.Errorf("%q is not a valid hostname, IP, URL or email", name)
+	}
+	m.hostPolicy.warn(punycode)
+	return punycode, nil
 }
 
-func getCAROOT() string {
-	if env := os.Getenv("CAROOT"); env != "" {
-		return env
+// runtimeNames are the display names of the trust stores configured through
+// environment variables.
+var runtimeNames = map[string]string{"deno": "Deno", "bun": "Bun"}
+
+// vmNames are the display names of the trust stores of container VMs.
+var vmNames = map[string]string{"podman": "podman machine", "lima": "Lima", "colima": "Colima"}
+
+// storeName returns the display name of a trust store.
+func storeName(store string) string {
+	switch store {
+	case "system":
+		return "the system trust store"
+	case "nss":
+		return truststore.NSSBrowsers
+	case "java":
+		return "Java's trust store"
+	case "podman", "lima", "colima":
+		return "the " + vmNames[store] + " VMs"
 	}
+	return runtimeNames[store]
+}
 
-	var dir string
-	switch {
-	case runtime.GOOS == "windows":
-		dir = os.Getenv("LocalAppData")
-	case os.Getenv("XDG_DATA_HOME") != "":
-		dir = os.Getenv("XDG_DATA_HOME")
-	case runtime.GOOS == "darwin":
-		dir = os.Getenv("HOME")
-		if dir == "" {
-			return ""
-		}
-		dir = filepath.Join(dir, "Library", "Application Support")
-	default: // Unix
-		dir = os.Getenv("HOME")
-		if dir == "" {
-			return ""
-		}
-		dir = filepath.Join(dir, ".local", "share")
-	}
-	return filepath.Join(dir, "mkcert")
+// newStore returns a truststore.Store for the local CA and the enabled
+// trust stores.
+func (m *mkcert) newStore() *truststore.Store {
+	store := &truststore.Store{
+		RootPath: m.rootPath,
+		Root:     m.ca.Cert,
+		CmdFS:    m.cmdFS,
+
+		ContinueOnError: m.continueOnError,
+		Purpose:         m.trustPurpose,
+		NSSProfile:      m.nssProfile,
+		NSSLegacy:       m.nssLegacy,
+		VerifyPlatform:  m.verifyPlatform,
+		Reporter:        &m.messages,
+	}
+	stores := m.trustStores
+	if stores == "" {
+		stores = os.Getenv("TRUST_STORES")
+	}
+	if stores != "" {
+		store.Stores = strings.Split(stores, ",")
+	} else if m.nssProfile != "" {
+		store.Stores = []string{"nss"}
+	}
+	return store
 }
 
-func (m *mkcert) install() {
-	if storeEnabled("system") {
-		if m.checkPlatform() {
-			log.Print("The local CA is already installed in the system trust store! 👍")
-		} else {
-			if m.installPlatform() {
-				log.Print("The local CA is now installed in the system trust store! ⚡️")
-			}
-			m.ignoreCheckFailure = true // TODO: replace with a check for a successful install
+func (m *mkcert) check() error {
+	results, err := m.store.Check()
+	if err != nil {
+		return err
+	}
+	var warning bool
+	for _, r := range results {
+		logResult("check", r)
+		if r.Status == truststore.AlreadyInstalled {
+			continue
+		}
+		warning = true
+		switch r.Store {
+		case "system":
+			log.Println("Note: the local CA is not installed in the system trust store.")
+		case "nss":
+			log.Printf("Note: the local CA is not installed in the %s trust store.", truststore.NSSBrowsers)
+		case "java":
+			log.Println("Note: the local CA is not installed in the Java trust store.")
+		case "deno", "bun":
+			log.Printf("Note: the local CA is not trusted by %s.", runtimeNames[r.Store])
+		case "podman", "lima", "colima":
+			log.Printf("Note: the local CA is not installed in all the running %s VMs.", vmNames[r.Store])
 		}
 	}
-	if storeEnabled("nss") && hasNSS {
-		if m.checkNSS() {
-			log.Printf("The local CA is already installed in the %s trust store! 👍", NSSBrowsers)
-		} else {
-			if hasCertutil && m.installNSS() {
-				log.Printf("The local CA is now installed in the %s trust store (requires browser restart)! 🦊", NSSBrowsers)
-			} else if CertutilInstallHelp == "" {
-				log.Printf(`Note: %s support is not available on your platform. ℹ️`, NSSBrowsers)
-			} else if !hasCertutil {
-				log.Printf(`Warning: "certutil" is not available, so the CA can't be automatically installed in %s! ⚠️`, NSSBrowsers)
-				log.Printf(`Install "certutil" with "%s" and re-run "mkcert -install" 👈`, CertutilInstallHelp)
-			}
+	if warning {
+		log.Println("Run \"mkcert -install\" for certificates to be trusted automatically ⚠️")
+	}
+	return nil
+}
+
+// verifyPlatform checks that the root is trusted by the platform verifier
+// after installing it, by running mkcert again with -verify-root, since the
+// system cert pool of this process was loaded before the install.
+func (m *mkcert) verifyPlatform(ctx context.Context) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(self, "-verify-root", m.rootPath)
+	if out, err := m.cmdFS.Exec(ctx, cmd); err != nil {
+		return &truststore.CmdError{Cmd: "mkcert -verify-root", Out: out, Err: err}
+	}
+	return nil
+}
+
+// verifyRoot implements -verify-root: it fails unless the platform verifier
+// trusts the certificate in path.
+func verifyRoot(path string) error {
+	cert, err := readCertFile(path)
+	if err != nil {
+		return err
+	}
+	_, err = cert.Verify(x509.VerifyOptions{})
+	return err
+}
+
+func (m *mkcert) install() error {
+	installLog, err := m.beginInstallLog()
+	if err != nil {
+		return err
+	}
+	results, err := m.store.Install()
+	if installLog != nil {
+		if err := m.finishInstallLog(installLog, results); err != nil {
+			log.Printf("Warning: %v, -rollback might not undo this install ⚠️", err)
 		}
 	}
-	if storeEnabled("java") && hasJava {
-		if m.checkJava() {
-			log.Println("The local CA is already installed in Java's trust store! 👍")
-		} else {
-			if hasKeytool {
-				m.installJava()
-				log.Println("The local CA is now installed in Java's trust store! ☕️")
+	for _, r := range results {
+		logResult("install", r)
+		switch {
+		case r.Store == "system" && r.Status == truststore.AlreadyInstalled:
+			log.Print("The local CA is already installed in the system trust store! 👍")
+		case r.Store == "system" && r.Status == truststore.Installed:
+			log.Print("The local CA is now installed in the system trust store! ⚡️")
+			if info := m.store.PlatformInfo(); len(info.Command) > 0 {
+				log.Printf(" - %s (with %q)", info.Path, strings.Join(info.Command, " "))
 			} else {
-				log.Println(`Warning: "keytool" is not available, so the CA can't be automatically installed in Java's trust store! ⚠️`)
+				log.Printf(" - %s", info.Path)
 			}
+
+		case r.Store == "nss" && r.Status == truststore.AlreadyInstalled:
+			log.Printf("The local CA is already installed in the %s trust store! 👍", truststore.NSSBrowsers)
+		case r.Store == "nss" && r.Status == truststore.Installed:
+			log.Printf("The local CA is now installed in the %s trust store! 🦊", truststore.NSSBrowsers)
+			for _, p := range m.store.NSSProfiles() {
+				log.Printf(" - %s (%s)", p.Path, p.Browser)
+			}
+
+		case r.Store == "java" && r.Status == truststore.AlreadyInstalled:
+			log.Println("The local CA is already installed in Java's trust store! 👍")
+		case r.Store == "java" && r.Status == truststore.Installed:
+			log.Println("The local CA is now installed in Java's trust store! ☕️")
+			for _, r := range m.store.DetectJava() {
+				if r.Version != "" {
+					log.Printf(" - %s (Java %s)", r.CacertsPath, r.Version)
+				} else {
+					log.Printf(" - %s", r.CacertsPath)
+				}
+			}
+
+		case vmNames[r.Store] != "" && r.Status == truststore.AlreadyInstalled:
+			log.Printf("The local CA is already installed in the running %s VMs! 👍", vmNames[r.Store])
+		case vmNames[r.Store] != "" && r.Status == truststore.Installed:
+			log.Printf("The local CA is now installed in the running %s VMs! 🐳", vmNames[r.Store])
+
+		case r.Status == truststore.AlreadyInstalled:
+			log.Printf("The local CA is already trusted by %s! 👍", runtimeNames[r.Store])
+
+		case r.Status == truststore.Failed && !r.Reported:
+			log.Printf("Installing in %s failed ⚠️", storeName(r.Store))
+			log.Print(r.Err)
 		}
+		m.messages.flush(r.Store)
+	}
+	var multiErr *truststore.MultiError
+	if errors.As(err, &multiErr) {
+		return fmt.Errorf("the local CA could not be installed in %d trust store(s)", len(multiErr.Errors))
+	}
+	if err != nil {
+		return err
 	}
 	log.Print("")
+	return nil
 }
 
-func (m *mkcert) uninstall() {
-	if storeEnabled("nss") && hasNSS {
-		if hasCertutil {
-			m.uninstallNSS()
-		} else if CertutilInstallHelp != "" {
-			log.Print("")
-			log.Printf(`Warning: "certutil" is not available, so the CA can't be automatically uninstalled from %s (if it was ever installed)! ⚠️`, NSSBrowsers)
-			log.Printf(`You can install "certutil" with "%s" and re-run "mkcert -uninstall" 👈`, CertutilInstallHelp)
-			log.Print("")
-		}
+func (m *mkcert) uninstall() error {
+	uninstalled, err := m.uninstallFrom(m.store)
+	var multiErr *truststore.MultiError
+	if errors.As(err, &multiErr) {
+		return fmt.Errorf("the local CA could not be uninstalled from %d trust store(s)", len(multiErr.Errors))
 	}
-	if storeEnabled("java") && hasJava {
-		if hasKeytool {
-			m.uninstallJava()
-		} else {
-			log.Print("")
-			log.Println(`Warning: "keytool" is not available, so the CA can't be automatically uninstalled from Java's trust store (if it was ever installed)! ⚠️`)
-			log.Print("")
-		}
+	if err != nil {
+		return err
 	}
-	if storeEnabled("system") && m.uninstallPlatform() {
+	if uninstalled["system"] {
 		log.Print("The local CA is now uninstalled from the system trust store(s)! 👋")
 		log.Print("")
-	} else if storeEnabled("nss") && hasCertutil {
-		log.Printf("The local CA is now uninstalled from the %s trust store(s)! 👋", NSSBrowsers)
+	} else if uninstalled["nss"] {
+		log.Printf("The local CA is now uninstalled from the %s trust store(s)! 👋", truststore.NSSBrowsers)
 		log.Print("")
 	}
+	return nil
 }
 
-func (m *mkcert) checkPlatform() bool {
-	if m.ignoreCheckFailure {
-		return true
+// uninstallFrom uninstalls the root from store, logging the failures, and
+// returns the trust stores it was uninstalled from.
+func (m *mkcert) uninstallFrom(store *truststore.Store) (map[string]bool, error) {
+	results, err := store.Uninstall()
+	uninstalled := make(map[string]bool)
+	for _, r := range results {
+		logResult("uninstall", r)
+		switch {
+		case r.Status == truststore.Uninstalled:
+			uninstalled[r.Store] = true
+		case r.Status == truststore.Failed && !r.Reported && !errors.Is(r.Err, truststore.ErrUnsupported):
+			log.Printf("Uninstalling from %s failed ⚠️", storeName(r.Store))
+			log.Print(r.Err)
+		}
+		m.messages.flush(r.Store)
 	}
-
-	_, err := m.caCert.Verify(x509.VerifyOptions{})
-	return err == nil
+	return uninstalled, err
 }
 
-func storeEnabled(name string) bool {
-	stores := os.Getenv("TRUST_STORES")
-	if stores == "" {
-		return true
+func parseCSRPolicy(s string) (issuer.CSRPolicy, error) {
+	var policy issuer.CSRPolicy
+	if s == "" {
+		return policy, nil
 	}
-	for _, store := range strings.Split(stores, ",") {
-		if store == name {
-			return true
+	for _, p := range strings.Split(s, ",") {
+		switch p {
+		case "sans-only":
+			policy.SANsOnly = true
+		case "copy-eku":
+			policy.CopyEKUs = true
+		case "reject-ca":
+			policy.RejectCA = true
+		default:
+			return policy, fmt.Errorf("unknown -csr-policy %q, options are: sans-only, copy-eku and reject-ca", p)
 		}
 	}
-	return false
+	if policy.CopyEKUs && !policy.SANsOnly {
+		return policy, errors.New(`-csr-policy copy-eku only makes sense with sans-only`)
+	}
+	return policy, nil
 }
 
-func fatalIfErr(err error, msg string) {
-	if err != nil {
-		log.Fatalf("ERROR: %s: %s", msg, err)
+func parseTrustPurpose(s string) (truststore.Purpose, error) {
+	switch s {
+	case "":
+		return truststore.PurposeDefault, nil
+	case "server-auth":
+		return truststore.PurposeServerAuth, nil
+	case "all":
+		return truststore.PurposeAll, nil
+	default:
+		return 0, fmt.Errorf("unknown -trust-purpose %q, options are: server-auth and all", s)
 	}
 }
 
-func fatalIfCmdErr(err error, cmd string, out []byte) {
-	if err != nil {
-		log.Fatalf("ERROR: failed to execute \"%s\": %s\n\n%s\n", cmd, err, out)
+// parseCurve parses the -curve flag. It returns nil if s is empty.
+func parseCurve(s string) (elliptic.Curve, error) {
+	switch strings.ToUpper(strings.ReplaceAll(s, "-", "")) {
+	case "":
+		return nil, nil
+	case "P256":
+		return elliptic.P256(), nil
+	case "P384":
+		return elliptic.P384(), nil
+	case "P521":
+		return elliptic.P521(), nil
+	default:
+		return nil, fmt.Errorf("unknown -curve %q, options are P256, P384 and P521", s)
 	}
 }
 
-func pathExists(path string) bool {
-	_, err := os.Stat(path)
-	return err == nil
+// parseNotAfter parses the -days and -not-after flags. It returns the zero
+// time if neither is set.
+func parseNotAfter(days int, notAfter string) (time.Time, error) {
+	switch {
+	case days != 0 && notAfter != "":
+		return time.Time{}, errors.New("-days and -not-after can't be combined")
+	case days < 0:
+		return time.Time{}, errors.New("-days must be positive")
+	case days > 0:
+		return time.Now().AddDate(0, 0, days), nil
+	case notAfter == "":
+		return time.Time{}, nil
+	}
+	t, err := time.Parse("2006-01-02", notAfter)
+	if err != nil {
+		t, err = time.Parse(time.RFC3339, notAfter)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid -not-after %q, the format is 2006-01-02 or 2006-01-02T15:04:05Z", notAfter)
+	}
+	if !t.After(time.Now()) {
+		return time.Time{}, fmt.Errorf("-not-after %s is in the past", notAfter)
+	}
+	return t, nil
 }
 
-func binaryExists(name string) bool {
-	_, err := exec.LookPath(name)
-	return err == nil
+func parseNSSLegacy(s string) (truststore.NSSLegacy, error) {
+	switch s {
+	case "", "dbm":
+		return truststore.NSSLegacyDBM, nil
+	case "migrate":
+		return truststore.NSSLegacyMigrate, nil
+	case "dual":
+		return truststore.NSSLegacyDualWrite, nil
+	default:
+		return 0, fmt.Errorf("unknown -nss-legacy %q, options are: dbm, migrate and dual", s)
+	}
 }
 
-var sudoWarningOnce sync.Once
+// stringsFlag is a flag that can be repeated to collect multiple values.
+type stringsFlag []string
 
-func commandWithSudo(cmd ...string) *exec.Cmd {
-	if u, err := user.Current(); err == nil && u.Uid == "0" {
-		return exec.Command(cmd[0], cmd[1:]...)
-	}
-	if !binaryExists("sudo") {
-		sudoWarningOnce.Do(func() {
-			log.Println(`Warning: "sudo" is not available, and mkcert is not running as root. The (un)install operation might fail. ⚠️`)
-		})
-		return exec.Command(cmd[0], cmd[1:]...)
-	}
-	return exec.Command("sudo", append([]string{"--prompt=Sudo password:", "--"}, cmd...)...)
+func (s *stringsFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func pathExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
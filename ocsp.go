@@ -0,0 +1,146 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"filippo.io/mkcert/issuer"
+)
+
+// signOCSP saves a pre-signed OCSP response for the certificate at path next
+// to it, for servers that can staple a response from a file.
+func (m *mkcert) signOCSP(path string) error {
+	cert, err := readCertFile(path)
+	if err != nil {
+		return err
+	}
+	resp, err := m.ca.SignOCSP(cert, m.ocspStatus)
+	if err != nil {
+		return err
+	}
+
+	out := path + ".ocsp"
+	if err := m.writeOutput(out, resp, 0644); err != nil {
+		return fmt.Errorf("failed to save the OCSP response: %w", err)
+	}
+
+	status := "good"
+	if m.ocspStatus.Revoked {
+		status = "revoked"
+	}
+	log.Printf("The OCSP response (%s) is at %q ✅\n", status, out)
+	log.Printf("It is valid until %s 🗓\n\n", m.ocspStatus.NextUpdate.Format("2 January 2006 15:04 MST"))
+	log.Printf("To staple it with nginx, add \"ssl_stapling on; ssl_stapling_file %s;\"\n\n", out)
+	return nil
+}
+
+// parseOCSPURL checks the -ocsp URL, which must be plain HTTP, as clients
+// don't fetch OCSP responses over HTTPS to avoid loops. A bare ":PORT" is
+// short for http://localhost:PORT/.
+func parseOCSPURL(s string) (*url.URL, error) {
+	if strings.HasPrefix(s, ":") {
+		s = "http://localhost" + s + "/"
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -ocsp URL: %w", err)
+	}
+	if u.Scheme != "http" || u.Host == "" {
+		return nil, errors.New("invalid -ocsp URL: it must be an http:// URL or a :PORT, like http://localhost:8888/")
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u, nil
+}
+
+// ocspHandler is an OCSP responder for the local CA at u, which answers
+// "revoked" for the certificates revoked with -revoke, and the -ocsp-status
+// for the others.
+func (m *mkcert) ocspHandler(u *url.URL) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req []byte
+		var err error
+		switch r.Method {
+		case http.MethodPost:
+			req, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 10000))
+		case http.MethodGet:
+			// RFC 6960, Appendix A.1: the URL-encoded base64 of the request,
+			// after the slash that follows the -ocsp URL.
+			prefix := strings.TrimSuffix(u.EscapedPath(), "/") + "/"
+			var b64 string
+			b64, err = url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), prefix))
+			if err == nil {
+				req, err = base64.StdEncoding.DecodeString(b64)
+			}
+		default:
+			http.Error(w, "OCSP requests must be GET or POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if err != nil {
+			http.Error(w, "malformed OCSP request", http.StatusBadRequest)
+			return
+		}
+		resp, err := m.ca.RespondOCSP(req, m.revocationStatus)
+		if err != nil {
+			log.Printf("Failed to answer an OCSP request: %v ⚠️", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+	}
+}
+
+// parseOCSPStatus parses the -ocsp-status, -ocsp-this-update and
+// -ocsp-next-update flags.
+func parseOCSPStatus(status, thisUpdate, nextUpdate string) (issuer.OCSPStatus, error) {
+	var s issuer.OCSPStatus
+	switch status {
+	case "good":
+	case "revoked":
+		s.Revoked = true
+	default:
+		return s, fmt.Errorf("unknown -ocsp-status %q, options are: good and revoked", status)
+	}
+	var err error
+	if s.ThisUpdate, err = parseTime(thisUpdate); err != nil {
+		return s, fmt.Errorf("invalid -ocsp-this-update: %w", err)
+	}
+	if s.NextUpdate, err = parseTime(nextUpdate); err != nil {
+		return s, fmt.Errorf("invalid -ocsp-next-update: %w", err)
+	}
+	if s.ThisUpdate.IsZero() {
+		s.ThisUpdate = time.Now()
+	}
+	if s.NextUpdate.IsZero() {
+		s.NextUpdate = s.ThisUpdate.AddDate(0, 0, 7)
+	}
+	if !s.NextUpdate.After(s.ThisUpdate) {
+		return s, errors.New("-ocsp-next-update must be after -ocsp-this-update")
+	}
+	return s, nil
+}
+
+// parseTime parses an RFC 3339 time, or a duration relative to now like
+// "-48h". The empty string is the zero time.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,79 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/mkcert/issuer"
+	"golang.org/x/crypto/ocsp"
+)
+
+// TestOCSPGetDoubleSlash checks that GET requests are answered below the
+// -ocsp path, even when their base64 contains "//", which http.ServeMux
+// would redirect.
+func TestOCSPGetDoubleSlash(t *testing.T) {
+	caroot := t.TempDir()
+	ca, err := issuer.NewCA(caroot, &issuer.Options{ECDSA: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mkcert{CAROOT: caroot, ca: ca, ocspStatus: issuer.OCSPStatus{ThisUpdate: time.Now()}}
+
+	// Only the serial number of the certificate goes in the request, so
+	// look for one that makes a "//".
+	var serial *big.Int
+	var b64 string
+	for i := int64(1); i < 100000; i++ {
+		req, err := ocsp.CreateRequest(&x509.Certificate{SerialNumber: big.NewInt(i)}, ca.Cert, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b64 = base64.StdEncoding.EncodeToString(req); strings.Contains(b64, "//") {
+			serial = big.NewInt(i)
+			break
+		}
+	}
+	if serial == nil {
+		t.Fatal("failed to find a request with a double slash")
+	}
+
+	for _, path := range []string{"/ocsp", "/"} {
+		u := &url.URL{Path: path}
+		mux := &endpointMux{}
+		mux.handle(path, m.ocspHandler(u), true)
+		srv := httptest.NewServer(mux)
+		defer srv.Close()
+
+		resp, err := http.Get(srv.URL + strings.TrimSuffix(path, "/") + "/" + b64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: got status %d, want 200", path, resp.StatusCode)
+		}
+		r, err := ocsp.ParseResponse(body, ca.Cert)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if r.SerialNumber.Cmp(serial) != 0 || r.Status != ocsp.Good {
+			t.Errorf("%s: got serial %v with status %d, want %v good", path, r.SerialNumber, r.Status, serial)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"filippo.io/mkcert/truststore"
+)
+
+// bundleName is the file in CAROOT with the system roots and the local CA,
+// for tools that only accept a single CA file, and would lose trust in
+// public sites if pointed at rootCA.pem.
+const bundleName = "ca-bundle.pem"
+
+// writeBundle (re)generates the combined CA bundle at path, or in the CAROOT
+// if empty, and returns its path.
+func (m *mkcert) writeBundle(path string) (string, error) {
+	roots, err := truststore.SystemRoots()
+	if err != nil {
+		return "", fmt.Errorf("failed to load the system roots: %w", err)
+	}
+	var bundle []byte
+	for _, root := range roots {
+		if root.Equal(m.ca.Cert) {
+			continue
+		}
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})...)
+	}
+	bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.ca.Cert.Raw})...)
+
+	if path == "" {
+		path = filepath.Join(m.CAROOT, bundleName)
+	}
+	if err := ioutil.WriteFile(path, bundle, 0644); err != nil {
+		return "", fmt.Errorf("failed to save CA bundle: %w", err)
+	}
+	return path, nil
+}
+
+// printBundleConfig writes the combined CA bundle for -bundle-with-system,
+// and prints how to point common tools at it.
+func (m *mkcert) printBundleConfig() error {
+	bundle, err := m.writeBundle(m.bundleFile)
+	if err != nil {
+		return err
+	}
+	if abs, err := filepath.Abs(bundle); err == nil {
+		bundle = abs
+	}
+	log.Printf("The combined system and local CA bundle is at %q ✅\n\n", bundle)
+
+	fmt.Printf("curl --cacert %q https://example.test\n", bundle)
+	fmt.Printf("export REQUESTS_CA_BUNDLE=%q  # Python requests\n", bundle)
+	fmt.Printf("export SSL_CERT_FILE=%q  # OpenSSL, Ruby, Go\n", bundle)
+	return nil
+}
+
+// printEnv prints the shell commands that point the CA environment variables
+// of common tools at the local CA, for eval "$(mkcert -env)". The variables
+// that replace the system roots get the combined bundle, and the ones that
+// add to them get the local CA alone. The syntax follows $SHELL, or is for
+// PowerShell on Windows.
+func (m *mkcert) printEnv() error {
+	bundle, err := m.writeBundle("")
+	if err != nil {
+		return err
+	}
+	vars := []struct{ name, path string }{
+		{"SSL_CERT_FILE", bundle},
+		{"REQUESTS_CA_BUNDLE", bundle},
+		{"GIT_SSL_CAINFO", bundle},
+		{"NODE_EXTRA_CA_CERTS", m.rootPath},
+		{"DENO_CERT", m.rootPath},
+	}
+	for _, v := range vars {
+		switch {
+		case runtime.GOOS == "windows":
+			fmt.Printf("$env:%s = %q\n", v.name, v.path)
+		case filepath.Base(os.Getenv("SHELL")) == "fish":
+			fmt.Printf("set -gx %s %q;\n", v.name, v.path)
+		default:
+			fmt.Printf("export %s=%q\n", v.name, v.path)
+		}
+	}
+	return nil
+}
+
+// printCloudConfig prints the configuration that makes a cloud CLI trust the
+// local CA, for example to talk to emulators like LocalStack over TLS.
+func (m *mkcert) printCloudConfig() error {
+	bundle, err := m.writeBundle("")
+	if err != nil {
+		return err
+	}
+	log.Printf("The combined system and local CA bundle is at %q ✅\n\n", bundle)
+
+	switch m.output {
+	case "aws":
+		fmt.Printf("# AWS CLI and boto3, in ~/.aws/config\n")
+		fmt.Printf("[default]\nca_bundle = %s\n\n", bundle)
+		fmt.Printf("# or only for the current shell\n")
+		fmt.Printf("export AWS_CA_BUNDLE=%q\n", bundle)
+	case "gcloud":
+		fmt.Printf("# Google Cloud CLI\n")
+		fmt.Printf("gcloud config set core/custom_ca_certs_file %q\n\n", bundle)
+		fmt.Printf("# or only for the current shell\n")
+		fmt.Printf("export CLOUDSDK_CORE_CUSTOM_CA_CERTS_FILE=%q\n", bundle)
+	}
+	return nil
+}
+
+// printDatabaseConfig prints the server and client TLS settings for a local
+// database using the certificate just generated for hosts. With -client, the
+// certificate is used by the client to authenticate instead.
+func (m *mkcert) printDatabaseConfig(hosts []string, certFile, keyFile string) error {
+	root := m.rootPath
+	certFile, err := filepath.Abs(certFile)
+	if err != nil {
+		return err
+	}
+	keyFile, err = filepath.Abs(keyFile)
+	if err != nil {
+		return err
+	}
+
+	// Pick a name the client can connect to and verify against.
+	host := "localhost"
+	for _, h := range hosts {
+		if strings.ContainsAny(h, "*@/") {
+			continue
+		}
+		host = h
+		break
+	}
+	urlHost := host
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		urlHost = "[" + host + "]"
+	}
+
+	switch m.output {
+	case "postgres":
+		if !m.client {
+			fmt.Printf("# PostgreSQL server, in postgresql.conf\n")
+			fmt.Printf("ssl = on\nssl_cert_file = '%s'\nssl_key_file = '%s'\n\n", certFile, keyFile)
+			fmt.Printf("# psql and libpq clients\n")
+			fmt.Printf("psql \"host=%s sslmode=verify-full sslrootcert=%s\"\n", host, root)
+			fmt.Printf("postgresql://%s:5432/postgres?sslmode=verify-full&sslrootcert=%s\n", urlHost, root)
+		} else {
+			fmt.Printf("# PostgreSQL server, in postgresql.conf (and \"hostssl ... cert\" in pg_hba.conf)\n")
+			fmt.Printf("ssl_ca_file = '%s'\n\n", root)
+			fmt.Printf("# psql and libpq clients\n")
+			fmt.Printf("psql \"host=HOST sslmode=verify-full sslrootcert=%s sslcert=%s sslkey=%s\"\n", root, certFile, keyFile)
+			fmt.Printf("postgresql://HOST:5432/postgres?sslmode=verify-full&sslrootcert=%s&sslcert=%s&sslkey=%s\n", root, certFile, keyFile)
+		}
+	case "mysql":
+		if !m.client {
+			fmt.Printf("# MySQL and MariaDB server, in my.cnf\n")
+			fmt.Printf("[mysqld]\nssl_cert = %s\nssl_key = %s\n\n", certFile, keyFile)
+			fmt.Printf("# mysql client and MySQL Shell\n")
+			fmt.Printf("mysql -h %s --ssl-mode=VERIFY_IDENTITY --ssl-ca=%q\n", host, root)
+			fmt.Printf("mysql://%s:3306?ssl-mode=VERIFY_IDENTITY&ssl-ca=%s\n", urlHost, root)
+		} else {
+			fmt.Printf("# MySQL and MariaDB server, in my.cnf (and \"REQUIRE X509\" for the user)\n")
+			fmt.Printf("[mysqld]\nssl_ca = %s\n\n", root)
+			fmt.Printf("# mysql client and MySQL Shell\n")
+			fmt.Printf("mysql -h HOST --ssl-mode=VERIFY_IDENTITY --ssl-ca=%q --ssl-cert=%q --ssl-key=%q\n", root, certFile, keyFile)
+			fmt.Printf("mysql://HOST:3306?ssl-mode=VERIFY_IDENTITY&ssl-ca=%s&ssl-cert=%s&ssl-key=%s\n", root, certFile, keyFile)
+		}
+	case "redis":
+		if !m.client {
+			fmt.Printf("# Redis server, in redis.conf\n")
+			fmt.Printf("port 0\ntls-port 6379\ntls-cert-file %s\ntls-key-file %s\ntls-ca-cert-file %s\ntls-auth-clients optional\n\n", certFile, keyFile, root)
+			fmt.Printf("# redis-cli and other clients\n")
+			fmt.Printf("redis-cli -h %s --tls --cacert %q\n", host, root)
+			fmt.Printf("rediss://%s:6379\n", urlHost)
+		} else {
+			fmt.Printf("# Redis server, in redis.conf\n")
+			fmt.Printf("tls-ca-cert-file %s\ntls-auth-clients yes\n\n", root)
+			fmt.Printf("# redis-cli\n")
+			fmt.Printf("redis-cli -h HOST --tls --cacert %q --cert %q --key %q\n", root, certFile, keyFile)
+		}
+	}
+	fmt.Println()
+	return nil
+}
+
+// printProvisioningConfig prints an Ansible task list or a cloud-config that
+// installs the local CA in the system trust store of other machines.
+func (m *mkcert) printProvisioningConfig() error {
+	rootPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.ca.Cert.Raw})
+	indent := func(prefix string) string {
+		return prefix + strings.Replace(strings.TrimSpace(string(rootPEM)), "\n", "\n"+prefix, -1)
+	}
+
+	switch m.output {
+	case "ansible":
+		fmt.Printf("# Ansible tasks installing the mkcert development CA, generated by mkcert\n")
+		fmt.Printf("- name: Install the mkcert development CA\n")
+		fmt.Printf("  become: true\n")
+		fmt.Printf("  block:\n")
+		for _, d := range truststore.Distros {
+			variable := "mkcert_ca_" + strings.ToLower(d.Family)
+			fmt.Printf("    - name: Add the CA to the trust anchors (%s)\n", d.Family)
+			fmt.Printf("      ansible.builtin.copy:\n")
+			fmt.Printf("        dest: %s/%s%s\n", d.AnchorDir, m.store.SystemTrustName(), d.Ext)
+			fmt.Printf("        mode: \"0644\"\n")
+			fmt.Printf("        content: |\n%s\n", indent("          "))
+			fmt.Printf("      when: ansible_os_family == %q\n", d.Family)
+			fmt.Printf("      register: %s\n", variable)
+			fmt.Printf("    - name: Update the system trust store (%s)\n", d.Family)
+			fmt.Printf("      ansible.builtin.command: %s\n", strings.Join(d.Command, " "))
+			fmt.Printf("      when: %s is changed\n", variable)
+		}
+	case "docker":
+		return m.printDockerfile(rootPEM)
+	case "cloud-init":
+		// The ca_certs module knows the trust store of each distribution.
+		fmt.Printf("#cloud-config\n")
+		fmt.Printf("# Installs the mkcert development CA, generated by mkcert\n")
+		fmt.Printf("ca_certs:\n")
+		fmt.Printf("  trusted:\n")
+		fmt.Printf("    - |\n%s\n", indent("      "))
+	}
+	return nil
+}
+
+// dockerRootName is the file -output docker writes in the build context.
+const dockerRootName = "mkcert-rootCA.pem"
+
+// printDockerfile saves the root in the current directory, assumed to be
+// the Docker build context, and prints the Dockerfile lines that install it
+// in the system trust store of the image, detecting the distribution when
+// the image is built.
+func (m *mkcert) printDockerfile(rootPEM []byte) error {
+	if err := ioutil.WriteFile(dockerRootName, rootPEM, 0644); err != nil {
+		return fmt.Errorf("failed to save the CA certificate: %w", err)
+	}
+	log.Printf("The local CA is at \"./%s\", add these lines to the Dockerfile 🐳\n\n", dockerRootName)
+
+	tmp := "/tmp/" + dockerRootName
+	fmt.Printf("# Installs the mkcert development CA, generated by mkcert\n")
+	fmt.Printf("COPY %s %s\n", dockerRootName, tmp)
+	fmt.Printf("RUN set -e; \\\n")
+	seen := make(map[string]bool)
+	for i, d := range truststore.Distros {
+		if seen[d.AnchorDir] {
+			continue
+		}
+		seen[d.AnchorDir] = true
+		keyword := "elif"
+		if i == 0 {
+			keyword = "if"
+		}
+		fmt.Printf("    %s [ -d %s ]; then cp %s %s/%s%s && %s; \\\n", keyword, d.AnchorDir,
+			tmp, d.AnchorDir, m.store.SystemTrustName(), d.Ext, strings.Join(d.Command, " "))
+	}
+	fmt.Printf("    else echo \"no known CA trust store, install the ca-certificates package first\" >&2; exit 1; fi; \\\n")
+	fmt.Printf("    rm %s\n", tmp)
+	return nil
+}
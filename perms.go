@@ -0,0 +1,143 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// outputPerms are the -owner and -umask of the files mkcert generates.
+type outputPerms struct {
+	uid, gid int // -1 to leave unchanged
+	umask    *os.FileMode
+}
+
+// parseOutputPerms parses the -owner flag, USER[:GROUP] or :GROUP, where
+// both can be names or numeric IDs, and the -umask flag, in octal.
+func parseOutputPerms(owner, umask string) (outputPerms, error) {
+	p := outputPerms{uid: -1, gid: -1}
+	if owner == "" && umask == "" {
+		return p, nil
+	}
+	if runtime.GOOS == "windows" {
+		return p, errors.New("-owner and -umask are not supported on Windows")
+	}
+	if owner != "" {
+		userName, groupName, _ := strings.Cut(owner, ":")
+		if userName == "" && groupName == "" {
+			return p, fmt.Errorf("invalid -owner %q, the format is USER[:GROUP]", owner)
+		}
+		if userName != "" {
+			id := userName
+			if _, err := strconv.Atoi(userName); err != nil {
+				u, err := user.Lookup(userName)
+				if err != nil {
+					return p, fmt.Errorf("invalid -owner %q: %w", owner, err)
+				}
+				id = u.Uid
+			}
+			p.uid, _ = strconv.Atoi(id)
+		}
+		if groupName != "" {
+			id := groupName
+			if _, err := strconv.Atoi(groupName); err != nil {
+				g, err := user.LookupGroup(groupName)
+				if err != nil {
+					return p, fmt.Errorf("invalid -owner %q: %w", owner, err)
+				}
+				id = g.Gid
+			}
+			p.gid, _ = strconv.Atoi(id)
+		}
+	}
+	if umask != "" {
+		mask, err := strconv.ParseUint(umask, 8, 32)
+		if err != nil || mask > 0777 {
+			return p, fmt.Errorf("invalid -umask %q, it must be an octal mode like 027", umask)
+		}
+		m := os.FileMode(mask)
+		p.umask = &m
+	}
+	return p, nil
+}
+
+func (p outputPerms) enabled() bool {
+	return p.uid != -1 || p.gid != -1 || p.umask != nil
+}
+
+// writeOutput saves a file generated for the user, like a certificate or a
+// key. Without -owner and -umask, it's simply written with perm. Otherwise,
+// it's written to a temporary file that gets its final mode and owner before
+// it's renamed into place, so that it's never visible with the wrong ones,
+// and any missing directories are created with the same owner.
+func (m *mkcert) writeOutput(path string, data []byte, perm os.FileMode) error {
+	p := m.perms
+	if !p.enabled() {
+		return ioutil.WriteFile(path, data, perm)
+	}
+	if p.umask != nil {
+		perm &^= *p.umask
+	}
+	dir := filepath.Dir(path)
+	if err := p.mkdirAll(dir); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".mkcert-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chown(p.uid, p.gid); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// mkdirAll is like os.MkdirAll, but gives the new directories the -umask
+// and -owner.
+func (p outputPerms) mkdirAll(dir string) error {
+	switch _, err := os.Stat(dir); {
+	case err == nil:
+		return nil
+	case !os.IsNotExist(err):
+		return err
+	}
+	if err := p.mkdirAll(filepath.Dir(dir)); err != nil {
+		return err
+	}
+	mode := os.FileMode(0755)
+	if p.umask != nil {
+		mode = 0777 &^ *p.umask
+	}
+	if err := os.Mkdir(dir, mode); err != nil {
+		return err
+	}
+	// Mkdir applies the umask of the process on top of mode.
+	if err := os.Chmod(dir, mode); err != nil {
+		return err
+	}
+	return os.Chown(dir, p.uid, p.gid)
+}
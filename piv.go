@@ -0,0 +1,91 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/mkcert/issuer"
+	"filippo.io/mkcert/truststore"
+)
+
+// pivSlots are the YubiKey PIV slots a key and certificate can be imported
+// into, with their intended use.
+var pivSlots = map[string]string{
+	"9a": "PIV Authentication",
+	"9c": "Digital Signature",
+	"9d": "Key Management",
+	"9e": "Card Authentication",
+}
+
+func parsePIVSlot(slot string) (string, error) {
+	slot = strings.ToLower(slot)
+	if slot == "" || pivSlots[slot] != "" {
+		return slot, nil
+	}
+	return "", fmt.Errorf("unknown -piv-slot %q, options are 9a, 9c, 9d and 9e", slot)
+}
+
+// importPIV imports the key and certificate of cert into the -piv-slot of
+// the connected YubiKey with the YubiKey Manager CLI, and saves the
+// certificate to certFile. The key is only written to a temporary file.
+//
+// A non-default management key is read from $PIV_MANAGEMENT_KEY, and a PIN
+// (for PIN-protected management keys) from $PIV_PIN.
+func (m *mkcert) importPIV(cert *issuer.Certificate, certFile string) error {
+	ykman, err := exec.LookPath("ykman")
+	if err != nil {
+		return errors.New(`"ykman" is not available, install it from https://developers.yubico.com/yubikey-manager/ to use -piv-slot`)
+	}
+
+	dir, err := ioutil.TempDir("", "mkcert-piv")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	privPEM, err := cert.KeyPEM()
+	if err != nil {
+		return fmt.Errorf("failed to encode certificate key: %w", err)
+	}
+	keyPath, certPath := filepath.Join(dir, "key.pem"), filepath.Join(dir, "cert.pem")
+	if err := ioutil.WriteFile(keyPath, privPEM, 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(certPath, cert.CertPEM(), 0600); err != nil {
+		return err
+	}
+
+	var auth []string
+	if key := os.Getenv("PIV_MANAGEMENT_KEY"); key != "" {
+		auth = append(auth, "--management-key", key)
+	}
+	if pin := os.Getenv("PIV_PIN"); pin != "" {
+		auth = append(auth, "--pin", pin)
+	}
+	for _, imp := range []struct{ kind, file string }{
+		{"keys", keyPath},
+		{"certificates", certPath},
+	} {
+		args := append([]string{"piv", imp.kind, "import"}, auth...)
+		cmd := exec.Command(ykman, append(args, m.pivSlot, imp.file)...)
+		if out, err := m.cmdFS.Exec(context.Background(), cmd); err != nil {
+			return &truststore.CmdError{Cmd: "ykman piv " + imp.kind + " import", Out: out, Err: err}
+		}
+	}
+
+	certPEM := append(cert.CertPEM(), m.chainPEM()...)
+	if err := m.writeOutput(certFile, certPEM, 0644); err != nil {
+		return fmt.Errorf("failed to save certificate: %w", err)
+	}
+	m.written = append(m.written, certFile)
+	return nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// parseProbeTarget returns the address to connect to and the host name to
+// send in SNI and verify, from a URL or a host[:port], defaulting to port 443.
+func parseProbeTarget(target string) (addr, host string, err error) {
+	if strings.Contains(target, "://") {
+		u, err := url.Parse(target)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid target %q: %w", target, err)
+		}
+		target = u.Host
+	}
+	if target == "" {
+		return "", "", errors.New("invalid target: missing host")
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		host, port = strings.Trim(target, "[]"), "443"
+	}
+	return net.JoinHostPort(host, port), host, nil
+}
+
+// probe connects to a TLS server and prints the chain it presents, and
+// whether it validates against the local CA and the system roots.
+func (m *mkcert) probe(target string) error {
+	addr, host, err := parseProbeTarget(target)
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{
+		// Verification is done below, to report on it instead of failing.
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"h2", "http/1.1"},
+	}
+	if net.ParseIP(host) == nil {
+		config.ServerName = host
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", addr, config)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	state := conn.ConnectionState()
+	conn.Close()
+
+	printField := func(name, value string) {
+		fmt.Fprintf(stdout, "%-16s%s\n", name+":", value)
+	}
+	printField("Connected to", conn.RemoteAddr().String())
+	printField("Version", tlsVersionName(state.Version))
+	printField("Cipher suite", tls.CipherSuiteName(state.CipherSuite))
+	if config.ServerName != "" {
+		printField("SNI", config.ServerName)
+	} else {
+		printField("SNI", "none (connecting to an IP address)")
+	}
+	if state.NegotiatedProtocol != "" {
+		printField("ALPN", state.NegotiatedProtocol)
+	} else {
+		printField("ALPN", "none negotiated (offered h2, http/1.1)")
+	}
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("the server presented no certificates")
+	}
+	fmt.Printf("\nThe server presented %d certificate(s):\n\n", len(state.PeerCertificates))
+	m.printCerts(state.PeerCertificates)
+	fmt.Println()
+
+	leaf := state.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, c := range state.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	verify := func(roots *x509.CertPool) error {
+		_, err := leaf.Verify(x509.VerifyOptions{
+			DNSName: host, Roots: roots, Intermediates: intermediates,
+		})
+		return err
+	}
+
+	localRoots := x509.NewCertPool()
+	localRoots.AddCert(m.ca.Cert)
+	if err := verify(localRoots); err != nil {
+		printField("Local CA trust", fmt.Sprintf("not valid for %s ❌ (%v)", host, err))
+	} else {
+		printField("Local CA trust", fmt.Sprintf("valid for %s ✅", host))
+	}
+	if systemRoots, err := x509.SystemCertPool(); err != nil {
+		printField("System trust", fmt.Sprintf("unavailable (%v)", err))
+	} else if err := verify(systemRoots); err != nil {
+		printField("System trust", fmt.Sprintf("not valid for %s ❌ (%v)", host, err))
+	} else {
+		printField("System trust", fmt.Sprintf("valid for %s ✅", host))
+	}
+	return nil
+}
+
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("0x%04x", v)
+	}
+}
@@ -0,0 +1,393 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// This is a minimal QR code encoder for -serve-ca, which only needs to
+// encode a short URL. It supports byte mode at error correction level L,
+// up to version 6 (134 bytes), which don't need version information.
+// See ISO/IEC 18004:2015 and https://www.nayuki.io/page/qr-code-generator-library.
+
+// qrVersions are the total codewords, error correction codewords per
+// block, and number of blocks of versions 1 to 6 at level L.
+var qrVersions = [...]struct{ total, ecPerBlock, blocks int }{
+	{26, 7, 1}, {44, 10, 1}, {70, 15, 1}, {100, 20, 1}, {134, 26, 1}, {172, 18, 2},
+}
+
+// qrAlignment is the position of the alignment pattern of versions 2 to 6.
+var qrAlignment = [...]int{0, 18, 22, 26, 30, 34}
+
+type qrCode struct {
+	size       int
+	dark       [][]bool
+	isFunction [][]bool
+}
+
+// qrEncode returns the modules of a QR code for data, with the mask that
+// scores best, as a square of booleans that are true for dark modules.
+func qrEncode(data []byte) ([][]bool, error) {
+	return qrEncodeMask(data, -1)
+}
+
+// qrEncodeMask is like qrEncode, but with a fixed mask, unless mask is -1.
+func qrEncodeMask(data []byte, mask int) ([][]bool, error) {
+	version := 0
+	for v, info := range qrVersions {
+		// 4 bits of mode and 8 of length precede the data.
+		if len(data)+2 <= info.total-info.ecPerBlock*info.blocks {
+			version = v + 1
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errors.New("data too long for a QR code")
+	}
+	info := qrVersions[version-1]
+	dataCodewords := info.total - info.ecPerBlock*info.blocks
+
+	var bits []bool
+	appendBits := func(v, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, v>>i&1 == 1)
+		}
+	}
+	appendBits(0x4, 4) // byte mode
+	appendBits(len(data), 8)
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+	for i := 0; i < 4 && len(bits) < dataCodewords*8; i++ {
+		bits = append(bits, false) // terminator
+	}
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+	for pad := 0xec; len(bits) < dataCodewords*8; pad ^= 0xec ^ 0x11 {
+		appendBits(pad, 8)
+	}
+	codewords := make([]byte, dataCodewords)
+	for i, b := range bits {
+		if b {
+			codewords[i/8] |= 1 << (7 - i%8)
+		}
+	}
+
+	// Split the data into blocks, add error correction to each, and
+	// interleave them. All blocks have the same length at level L up to
+	// version 6.
+	gen := rsGenerator(info.ecPerBlock)
+	blockLen := dataCodewords / info.blocks
+	var blocks, ecBlocks [][]byte
+	for i := 0; i < info.blocks; i++ {
+		block := codewords[i*blockLen : (i+1)*blockLen]
+		blocks = append(blocks, block)
+		ecBlocks = append(ecBlocks, rsRemainder(block, gen))
+	}
+	var final []byte
+	for i := 0; i < blockLen; i++ {
+		for _, b := range blocks {
+			final = append(final, b[i])
+		}
+	}
+	for i := 0; i < info.ecPerBlock; i++ {
+		for _, b := range ecBlocks {
+			final = append(final, b[i])
+		}
+	}
+
+	q := newQRCode(version)
+	q.drawCodewords(final)
+	if mask == -1 {
+		best := -1
+		for m := 0; m < 8; m++ {
+			q.applyMask(m)
+			q.drawFormat(m)
+			if p := q.penalty(); best == -1 || p < best {
+				best, mask = p, m
+			}
+			q.applyMask(m) // undo
+		}
+	}
+	q.applyMask(mask)
+	q.drawFormat(mask)
+	return q.dark, nil
+}
+
+func newQRCode(version int) *qrCode {
+	q := &qrCode{size: 17 + 4*version}
+	for i := 0; i < q.size; i++ {
+		q.dark = append(q.dark, make([]bool, q.size))
+		q.isFunction = append(q.isFunction, make([]bool, q.size))
+	}
+	for i := 0; i < q.size; i++ {
+		q.setFunction(6, i, i%2 == 0)
+		q.setFunction(i, 6, i%2 == 0)
+	}
+	q.drawFinder(3, 3)
+	q.drawFinder(q.size-4, 3)
+	q.drawFinder(3, q.size-4)
+	if p := qrAlignment[version-1]; p != 0 {
+		for dy := -2; dy <= 2; dy++ {
+			for dx := -2; dx <= 2; dx++ {
+				q.setFunction(p+dx, p+dy, max(abs(dx), abs(dy)) != 1)
+			}
+		}
+	}
+	q.drawFormat(0) // reserve the format areas
+	return q
+}
+
+// setFunction sets the module in column x and row y, and marks it as part
+// of a function pattern, which is not masked.
+func (q *qrCode) setFunction(x, y int, dark bool) {
+	q.dark[y][x] = dark
+	q.isFunction[y][x] = true
+}
+
+// drawFinder draws a finder pattern centered at x, y, with its separator.
+func (q *qrCode) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			if 0 <= x+dx && x+dx < q.size && 0 <= y+dy && y+dy < q.size {
+				dist := max(abs(dx), abs(dy))
+				q.setFunction(x+dx, y+dy, dist != 2 && dist != 4)
+			}
+		}
+	}
+}
+
+// drawFormat draws both copies of the format information for level L and
+// mask, and the dark module.
+func (q *qrCode) drawFormat(mask int) {
+	data := 1<<3 | mask // level L
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		q.setFunction(8, i, bit(i))
+	}
+	q.setFunction(8, 7, bit(6))
+	q.setFunction(8, 8, bit(7))
+	q.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.setFunction(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.setFunction(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunction(8, q.size-15+i, bit(i))
+	}
+	q.setFunction(8, q.size-8, true)
+}
+
+// drawCodewords places data in the zig-zag pattern, skipping the function
+// patterns. The remainder bits are left light.
+func (q *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if upward {
+					y = q.size - 1 - vert
+				}
+				if !q.isFunction[y][x] && i < len(data)*8 {
+					q.dark[y][x] = data[i/8]>>(7-i%8)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask flips the data modules selected by mask. Applying it twice
+// undoes it.
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip && !q.isFunction[y][x] {
+				q.dark[y][x] = !q.dark[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores how hard the code is to scan, lower is better.
+func (q *qrCode) penalty() int {
+	var p int
+	finderLike := [][]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+	lines := make([][]bool, 0, 2*q.size)
+	for y := 0; y < q.size; y++ {
+		lines = append(lines, q.dark[y])
+		col := make([]bool, q.size)
+		for x := 0; x < q.size; x++ {
+			col[x] = q.dark[x][y]
+		}
+		lines = append(lines, col)
+	}
+	for _, line := range lines {
+		// Runs of five or more modules of the same color.
+		run := 1
+		for i := 1; i <= len(line); i++ {
+			if i < len(line) && line[i] == line[i-1] {
+				run++
+				continue
+			}
+			if run >= 5 {
+				p += 3 + run - 5
+			}
+			run = 1
+		}
+		// Patterns that look like finders, with the light border around
+		// the symbol counting as light modules.
+		padded := append(append(make([]bool, 4), line...), make([]bool, 4)...)
+		for i := 0; i+11 <= len(padded); i++ {
+			for _, pattern := range finderLike {
+				match := true
+				for j := range pattern {
+					match = match && padded[i+j] == pattern[j]
+				}
+				if match {
+					p += 40
+				}
+			}
+		}
+	}
+	dark := 0
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.dark[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 && q.dark[y][x] == q.dark[y-1][x] &&
+				q.dark[y][x] == q.dark[y][x-1] && q.dark[y][x] == q.dark[y-1][x-1] {
+				p += 3
+			}
+		}
+	}
+	total := q.size * q.size
+	p += ((abs(dark*20-total*10)+total-1)/total - 1) * 10
+	return p
+}
+
+// rsGenerator returns the Reed-Solomon generator polynomial of degree, with
+// the leading coefficient omitted.
+func rsGenerator(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMul(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	return result
+}
+
+func rsRemainder(data, gen []byte) []byte {
+	result := make([]byte, len(gen))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMul(gen[i], factor)
+		}
+	}
+	return result
+}
+
+// gfMul multiplies in GF(2⁸) modulo x⁸ + x⁴ + x³ + x² + 1.
+func gfMul(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11d
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+// qrTerminal renders modules with half block characters, two rows per line,
+// with a quiet zone. Light modules are drawn, so that the code reads right
+// on the dark background of most terminals.
+func qrTerminal(modules [][]bool) string {
+	const quiet = 4
+	size := len(modules)
+	light := func(x, y int) bool {
+		x, y = x-quiet, y-quiet
+		return x < 0 || y < 0 || x >= size || y >= size || !modules[y][x]
+	}
+	var b strings.Builder
+	for y := 0; y < size+2*quiet; y += 2 {
+		for x := 0; x < size+2*quiet; x++ {
+			top, bottom := light(x, y), y+1 < size+2*quiet && light(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,306 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRSRemainder(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		data, ec []byte
+	}{
+		// The "01234567" 1-M symbol of ISO/IEC 18004:2015, Annex I.
+		{"01234567",
+			[]byte{16, 32, 12, 86, 97, 128, 236, 17, 236, 17, 236, 17, 236, 17, 236, 17},
+			[]byte{165, 36, 212, 193, 237, 54, 199, 135, 44, 85}},
+		// The "HELLO WORLD" 1-M symbol of https://www.thonky.com/qr-code-tutorial/.
+		{"HELLO WORLD",
+			[]byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17},
+			[]byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}},
+	} {
+		if got := rsRemainder(tt.data, rsGenerator(len(tt.ec))); !bytes.Equal(got, tt.ec) {
+			t.Errorf("%s: got error correction %v, want %v", tt.name, got, tt.ec)
+		}
+	}
+}
+
+// qrFormatL are the format information bits of level L for each mask, from
+// ISO/IEC 18004:2015, Table C.1.
+var qrFormatL = [8]string{
+	"111011111000100", "111001011110011", "111110110101010", "111100010011101",
+	"110011000101111", "110001100011000", "110110001000001", "110100101110110",
+}
+
+func TestQREncode(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		version int
+	}{
+		{"", 1},
+		{strings.Repeat("a", 17), 1},
+		{strings.Repeat("b", 18), 2},
+		{"https://192.168.1.2:8443/", 2},
+		{strings.Repeat("c", 53), 3},
+		{strings.Repeat("d", 78), 4},
+		{strings.Repeat("e", 106), 5},
+		{strings.Repeat("f", 134), 6},
+	} {
+		in := tt.in
+		for mask := -1; mask < 8; mask++ {
+			modules, err := qrEncodeMask([]byte(in), mask)
+			if err != nil {
+				t.Fatalf("%q, mask %d: %v", in, mask, err)
+			}
+			if size := 17 + 4*tt.version; len(modules) != size {
+				t.Errorf("%q: got size %d, want %d", in, len(modules), size)
+				continue
+			}
+			got, gotMask, err := qrDecode(modules)
+			if err != nil {
+				t.Errorf("%q, mask %d: %v", in, mask, err)
+				continue
+			}
+			if mask != -1 && gotMask != mask {
+				t.Errorf("%q: got mask %d, want %d", in, gotMask, mask)
+			}
+			if string(got) != in {
+				t.Errorf("mask %d: decoded %q, want %q", mask, got, in)
+			}
+		}
+	}
+
+	if _, err := qrEncode(make([]byte, 135)); err == nil {
+		t.Error("encoding 135 bytes succeeded, want an error")
+	}
+}
+
+// qrDecode is a reference decoder for the symbols qrEncode produces, written
+// from ISO/IEC 18004:2015 rather than from the encoder. It checks the
+// function patterns, the format information and the error correction, and
+// returns the data and the mask.
+func qrDecode(m [][]bool) ([]byte, int, error) {
+	size := len(m)
+	version := (size - 17) / 4
+	if version < 1 || version > 6 || size != 17+4*version {
+		return nil, 0, fmt.Errorf("unexpected size %d", size)
+	}
+	for _, row := range m {
+		if len(row) != size {
+			return nil, 0, fmt.Errorf("not a square")
+		}
+	}
+
+	// reserved marks the modules that are not data, and fixed the ones
+	// whose color is known in advance.
+	reserved := make([][]bool, size)
+	for i := range reserved {
+		reserved[i] = make([]bool, size)
+	}
+	var fixedErr error
+	fixed := func(row, col int, dark bool) {
+		reserved[row][col] = true
+		if m[row][col] != dark && fixedErr == nil {
+			fixedErr = fmt.Errorf("module at row %d, column %d is wrong", row, col)
+		}
+	}
+	// Finder patterns and their separators (Section 6.3.3).
+	for _, corner := range [][2]int{{0, 0}, {0, size - 7}, {size - 7, 0}} {
+		for r := -1; r <= 7; r++ {
+			for c := -1; c <= 7; c++ {
+				row, col := corner[0]+r, corner[1]+c
+				if row < 0 || col < 0 || row >= size || col >= size {
+					continue
+				}
+				ring := r == 0 || r == 6 || c == 0 || c == 6
+				core := r >= 2 && r <= 4 && c >= 2 && c <= 4
+				fixed(row, col, r >= 0 && r <= 6 && c >= 0 && c <= 6 && (ring || core))
+			}
+		}
+	}
+	// Timing patterns (Section 6.3.5).
+	for i := 8; i < size-8; i++ {
+		fixed(6, i, i%2 == 0)
+		fixed(i, 6, i%2 == 0)
+	}
+	// The alignment pattern (Annex E), versions 2 to 6 have just one.
+	if version > 1 {
+		center := size - 7
+		for r := -2; r <= 2; r++ {
+			for c := -2; c <= 2; c++ {
+				fixed(center+r, center+c, r == -2 || r == 2 || c == -2 || c == 2 || r == 0 && c == 0)
+			}
+		}
+	}
+	// The dark module (Section 7.9.1).
+	fixed(size-8, 8, true)
+	if fixedErr != nil {
+		return nil, 0, fixedErr
+	}
+
+	// Format information (Section 7.9), bit 14 first.
+	var first, second strings.Builder
+	for _, p := range [][2]int{{8, 0}, {8, 1}, {8, 2}, {8, 3}, {8, 4}, {8, 5}, {8, 7}, {8, 8},
+		{7, 8}, {5, 8}, {4, 8}, {3, 8}, {2, 8}, {1, 8}, {0, 8}} {
+		reserved[p[0]][p[1]] = true
+		first.WriteString(map[bool]string{false: "0", true: "1"}[m[p[0]][p[1]]])
+	}
+	for i := 0; i < 7; i++ {
+		reserved[size-1-i][8] = true
+		second.WriteString(map[bool]string{false: "0", true: "1"}[m[size-1-i][8]])
+	}
+	for i := 0; i < 8; i++ {
+		reserved[8][size-8+i] = true
+		second.WriteString(map[bool]string{false: "0", true: "1"}[m[8][size-8+i]])
+	}
+	if first.String() != second.String() {
+		return nil, 0, fmt.Errorf("format copies differ: %s and %s", first.String(), second.String())
+	}
+	mask := -1
+	for i, f := range qrFormatL {
+		if f == first.String() {
+			mask = i
+		}
+	}
+	if mask == -1 {
+		return nil, 0, fmt.Errorf("format %s is not level L", first.String())
+	}
+
+	// Read the codewords in the two module wide columns, from the bottom
+	// right, alternating upwards and downwards (Section 7.7.3), and remove
+	// the mask (Table 10), where i is the row and j the column.
+	masked := func(i, j int) bool {
+		switch mask {
+		case 0:
+			return (i+j)%2 == 0
+		case 1:
+			return i%2 == 0
+		case 2:
+			return j%3 == 0
+		case 3:
+			return (i+j)%3 == 0
+		case 4:
+			return (i/2+j/3)%2 == 0
+		case 5:
+			return i*j%2+i*j%3 == 0
+		case 6:
+			return (i*j%2+i*j%3)%2 == 0
+		default:
+			return ((i+j)%2+i*j%3)%2 == 0
+		}
+	}
+	var bits []bool
+	up := true
+	for col := size - 1; col > 0; col -= 2 {
+		if col == 6 {
+			col--
+		}
+		for n := 0; n < size; n++ {
+			row := n
+			if up {
+				row = size - 1 - n
+			}
+			for _, j := range []int{col, col - 1} {
+				if !reserved[row][j] {
+					bits = append(bits, m[row][j] != masked(row, j))
+				}
+			}
+		}
+		up = !up
+	}
+	// Versions 2 to 6 have 7 remainder bits, version 1 none.
+	if version > 1 {
+		bits = bits[:len(bits)-7]
+	}
+	if len(bits)%8 != 0 {
+		return nil, 0, fmt.Errorf("%d data bits", len(bits))
+	}
+	codewords := make([]byte, len(bits)/8)
+	for i, b := range bits {
+		if b {
+			codewords[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+
+	// De-interleave and check each block (Table 9 and Section 7.6).
+	total := []int{26, 44, 70, 100, 134, 172}[version-1]
+	ecLen := []int{7, 10, 15, 20, 26, 18}[version-1]
+	blocks := []int{1, 1, 1, 1, 1, 2}[version-1]
+	if len(codewords) != total {
+		return nil, 0, fmt.Errorf("%d codewords, want %d", len(codewords), total)
+	}
+	dataLen := (total - ecLen*blocks) / blocks
+	var data []byte
+	for b := 0; b < blocks; b++ {
+		var block []byte
+		for i := 0; i < dataLen; i++ {
+			block = append(block, codewords[i*blocks+b])
+		}
+		data = append(data, block...)
+		for i := 0; i < ecLen; i++ {
+			block = append(block, codewords[dataLen*blocks+i*blocks+b])
+		}
+		if !rsValid(block, ecLen) {
+			return nil, 0, fmt.Errorf("block %d fails error correction", b)
+		}
+	}
+
+	// Byte mode, 8 bits of length, the data, then the terminator and the
+	// padding (Sections 7.4.5 and 7.4.10).
+	if data[0]>>4 != 0x4 {
+		return nil, 0, fmt.Errorf("mode %04b, want byte mode", data[0]>>4)
+	}
+	n := int(data[0]&0xf)<<4 | int(data[1]>>4)
+	if n+2 > len(data) {
+		return nil, 0, fmt.Errorf("length %d is too long", n)
+	}
+	out := make([]byte, n)
+	for i := range out {
+		out[i] = data[i+1]<<4 | data[i+2]>>4
+	}
+	if data[n+1]&0xf != 0 {
+		return nil, 0, fmt.Errorf("bad terminator")
+	}
+	for i, p := range data[n+2:] {
+		if want := []byte{0xec, 0x11}[i%2]; p != want {
+			return nil, 0, fmt.Errorf("pad codeword %d is %#x, want %#x", i, p, want)
+		}
+	}
+	return out, mask, nil
+}
+
+// rsValid reports whether block, with ecLen error correction codewords at
+// the end, evaluates to zero at the first ecLen powers of α, using its own
+// GF(2⁸) tables.
+func rsValid(block []byte, ecLen int) bool {
+	var exp [255]byte
+	var log [256]int
+	for i, x := 0, 1; i < 255; i++ {
+		exp[i], log[x] = byte(x), i
+		if x <<= 1; x >= 0x100 {
+			x ^= 0x11d
+		}
+	}
+	mul := func(a, b byte) byte {
+		if a == 0 || b == 0 {
+			return 0
+		}
+		return exp[(log[a]+log[b])%255]
+	}
+	for i := 0; i < ecLen; i++ {
+		var s byte
+		for _, c := range block {
+			s = mul(s, exp[i]) ^ c
+		}
+		if s != 0 {
+			return false
+		}
+	}
+	return true
+}
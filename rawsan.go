@@ -0,0 +1,106 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// GeneralName tags from RFC 5280, Section 4.2.1.6.
+const (
+	sanEmail = 1
+	sanDNS   = 2
+	sanURI   = 6
+	sanIP    = 7
+)
+
+// A rawSAN is a name from -raw-san, which is added to the certificate
+// exactly as given, to test how other software parses unusual names.
+type rawSAN struct {
+	flag  string // the -raw-san value, for printing
+	tag   int
+	value []byte
+}
+
+// parseRawSAN parses a -raw-san value, "dns:", "ip:", "uri:" or "email:"
+// followed by the name. Only IP addresses are parsed.
+//
+// mkcert parses every certificate it issues with crypto/x509, so the names
+// it rejects, like non-ASCII ones, can't be used.
+func parseRawSAN(s string) (rawSAN, error) {
+	typ, value, ok := strings.Cut(s, ":")
+	if !ok {
+		return rawSAN{}, fmt.Errorf("invalid -raw-san %q, the format is TYPE:VALUE", s)
+	}
+	san := rawSAN{flag: s, value: []byte(value)}
+	switch strings.ToLower(typ) {
+	case "dns":
+		san.tag = sanDNS
+	case "email":
+		san.tag = sanEmail
+	case "uri":
+		san.tag = sanURI
+	case "ip":
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return rawSAN{}, fmt.Errorf("invalid -raw-san %q: not an IP address", s)
+		}
+		if strings.Contains(value, ":") && ip.To4() != nil {
+			return rawSAN{}, fmt.Errorf("invalid -raw-san %q: IPv4-mapped IPv6 addresses are not supported", s)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		san.tag, san.value = sanIP, ip
+	default:
+		return rawSAN{}, fmt.Errorf("invalid -raw-san %q, types are: dns, ip, uri and email", s)
+	}
+	for _, c := range value {
+		if c > 0x7f {
+			return rawSAN{}, fmt.Errorf("invalid -raw-san %q: only ASCII names are supported", s)
+		}
+	}
+	return san, nil
+}
+
+// rawSANExtension returns a subjectAltName extension with the names of tpl,
+// encoded like crypto/x509 does, followed by raw. As an ExtraExtension, it
+// replaces the one crypto/x509 would generate, with its validation.
+func rawSANExtension(tpl *x509.Certificate, raw []rawSAN) (pkix.Extension, error) {
+	var names []asn1.RawValue
+	add := func(tag int, value []byte) {
+		names = append(names, asn1.RawValue{Tag: tag, Class: asn1.ClassContextSpecific, Bytes: value})
+	}
+	for _, name := range tpl.DNSNames {
+		add(sanDNS, []byte(name))
+	}
+	for _, email := range tpl.EmailAddresses {
+		add(sanEmail, []byte(email))
+	}
+	for _, ip := range tpl.IPAddresses {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		add(sanIP, ip)
+	}
+	for _, uri := range tpl.URIs {
+		add(sanURI, []byte(uri.String()))
+	}
+	for _, san := range raw {
+		add(san.tag, san.value)
+	}
+	value, err := asn1.Marshal(names)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidSubjectAltName, Value: value}, nil
+}
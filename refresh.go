@@ -0,0 +1,108 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"filippo.io/mkcert/issuer"
+)
+
+// refresh implements -refresh. If certFile already has a certificate from
+// the local CA for exactly hosts that doesn't expire within m.within, it
+// returns true and the certificate is left alone. Otherwise, the key in
+// keyFile, if any, is reused for the new certificate.
+func (m *mkcert) refresh(hosts []string, certFile, keyFile string) (bool, error) {
+	cert, err := readCertFile(certFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		log.Printf("Replacing %q, it can't be read: %v 🔄", certFile, err)
+		return false, nil
+	}
+
+	switch {
+	case cert.CheckSignatureFrom(m.ca.Cert) != nil:
+		log.Printf("Replacing %q, it was issued by a different CA 🔄", certFile)
+	case !sameHosts(issuer.Hosts(cert), hosts):
+		log.Printf("Replacing %q, it's for %s 🔄", certFile, strings.Join(issuer.Hosts(cert), ", "))
+	case time.Until(cert.NotAfter) < m.within:
+		log.Printf("Replacing %q, it expires on %s 🔄", certFile, cert.NotAfter.Format("2 January 2006"))
+	default:
+		log.Printf("The certificate at %q is up to date, it expires on %s 👍", certFile, cert.NotAfter.Format("2 January 2006"))
+		return true, nil
+	}
+
+	key, err := readKeyFile(keyFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	m.leafKey = key
+	return false, nil
+}
+
+// readKeyFile reads the first PKCS #8 private key in file.
+func readKeyFile(file string) (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the key: %w", err)
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("failed to read %q: no PEM private key found", file)
+		}
+		if block.Type != "PRIVATE KEY" {
+			continue
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %w", file, err)
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("failed to parse %q: unsupported key type %T", file, key)
+		}
+		return signer, nil
+	}
+}
+
+// sameHosts reports whether a and b contain the same names, regardless of
+// order and case.
+func sameHosts(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	norm := func(hosts []string) []string {
+		var out []string
+		for _, h := range hosts {
+			out = append(out, strings.ToLower(h))
+		}
+		sort.Strings(out)
+		return out
+	}
+	a, b = norm(a), norm(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
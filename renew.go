@@ -0,0 +1,238 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/mkcert/issuer"
+)
+
+// defaultWithin is how close to expiration -renew-all renews certificates.
+const defaultWithin = 30 * 24 * time.Hour
+
+// renewAll renews the certificates under dir issued by the local CA that
+// expire within m.within. The keys are reused, so only the certificate files
+// are rewritten.
+func (m *mkcert) renewAll(dir string) error {
+	var renewed, fresh, skipped int
+	err := walkLeaves(dir, func(path string, data []byte, cert *x509.Certificate) error {
+		if cert.CheckSignatureFrom(m.ca.Cert) != nil {
+			if isMkcertLeaf(cert) {
+				log.Printf("Skipping %q, it was issued by a different local CA (use -resign to re-issue it) ⚠️", path)
+				skipped++
+			}
+			return nil
+		}
+		if time.Until(cert.NotAfter) > m.within {
+			fresh++
+			return nil
+		}
+
+		newCert, err := m.ca.Renew(cert, &issuer.Options{NotAfter: m.notAfter})
+		if err != nil {
+			return err
+		}
+		if err := m.replaceLeaf(path, data, newCert); err != nil {
+			return err
+		}
+		log.Printf("Renewed %q, it now expires on %s 🔄", path, newCert.Cert.NotAfter.Format("2 January 2006"))
+		renewed++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("\nRenewed %d certificate(s), %d didn't need it, %d skipped ✅\n\n", renewed, fresh, skipped)
+	return nil
+}
+
+// renewCert implements -renew. It re-issues the certificate at path, which
+// must have been issued by the local CA, with the same names and key usages,
+// and replaces it in place. With -new-key, the key is replaced too, in the
+// same file if it's there, or in the -key-file, which defaults to the
+// "-key.pem" file next to it.
+func (m *mkcert) renewCert(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the certificate: %w", err)
+	}
+	cert, err := readCertFile(path)
+	if err != nil {
+		return err
+	}
+	if cert.IsCA {
+		return fmt.Errorf("%q is a CA certificate, not one issued by mkcert", path)
+	}
+	if cert.CheckSignatureFrom(m.ca.Cert) != nil {
+		return fmt.Errorf("%q was not issued by the current local CA (use -resign on its directory to re-issue it)", path)
+	}
+
+	newCert, err := m.ca.Renew(cert, &issuer.Options{NotAfter: m.notAfter, NewKey: m.newKey})
+	if err != nil {
+		return err
+	}
+	if !m.newKey {
+		if err := m.replaceLeaf(path, data, newCert); err != nil {
+			return err
+		}
+		log.Printf("Renewed %q, it now expires on %s 🔄\n\n", path, newCert.Cert.NotAfter.Format("2 January 2006"))
+		return nil
+	}
+
+	keyPEM, err := newCert.KeyPEM()
+	if err != nil {
+		return fmt.Errorf("failed to encode certificate key: %w", err)
+	}
+	if bytes.Contains(data, []byte("PRIVATE KEY-----")) {
+		// A -cert-file and -key-file that are the same file, with the
+		// certificate, the chain, and the key.
+		var out []byte
+		for rest, first := data, true; ; first = false {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			switch {
+			case first:
+				out = append(out, newCert.CertPEM()...)
+			case block.Type == "CERTIFICATE":
+				out = append(out, pem.EncodeToMemory(block)...)
+			}
+		}
+		if err := m.writeOutput(path, append(out, keyPEM...), 0600); err != nil {
+			return fmt.Errorf("failed to save certificate and key: %w", err)
+		}
+		m.written = append(m.written, path)
+		log.Printf("Renewed %q with a new key, it now expires on %s 🔄\n\n", path, newCert.Cert.NotAfter.Format("2 January 2006"))
+		return nil
+	}
+
+	keyFile := m.keyFile
+	if keyFile == "" {
+		keyFile = strings.TrimSuffix(path, ".pem") + "-key.pem"
+	}
+	if !pathExists(keyFile) {
+		return fmt.Errorf("failed to find the key of %q at %q, set its path with -key-file", path, keyFile)
+	}
+	if err := m.writeOutput(keyFile, keyPEM, 0600); err != nil {
+		return fmt.Errorf("failed to save certificate key: %w", err)
+	}
+	m.written = append(m.written, keyFile)
+	if err := m.replaceLeaf(path, data, newCert); err != nil {
+		return err
+	}
+	log.Printf("Renewed %q with a new key at %q, it now expires on %s 🔄\n\n", path, keyFile, newCert.Cert.NotAfter.Format("2 January 2006"))
+	return nil
+}
+
+// resignAll re-issues the certificates under dir that were issued by a
+// previous local CA under the current one, with the same keys, names and
+// validity period, so that they keep working after the CA is replaced.
+func (m *mkcert) resignAll(dir string) error {
+	var resigned, current int
+	err := walkLeaves(dir, func(path string, data []byte, cert *x509.Certificate) error {
+		if !isMkcertLeaf(cert) {
+			return nil
+		}
+		if cert.CheckSignatureFrom(m.ca.Cert) == nil {
+			current++
+			return nil
+		}
+
+		newCert, err := m.ca.Renew(cert, &issuer.Options{Template: func(tpl *x509.Certificate) error {
+			tpl.NotBefore, tpl.NotAfter = cert.NotBefore, cert.NotAfter
+			return nil
+		}})
+		if err != nil {
+			return err
+		}
+		if err := m.replaceLeaf(path, data, newCert); err != nil {
+			return err
+		}
+		if time.Now().After(cert.NotAfter) {
+			log.Printf("Re-signed %q, but it expired on %s (use -renew-all to renew it) ⚠️", path, cert.NotAfter.Format("2 January 2006"))
+		} else {
+			log.Printf("Re-signed %q with the current local CA 🔏", path)
+		}
+		resigned++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("\nRe-signed %d certificate(s), %d were already issued by the current local CA ✅\n\n", resigned, current)
+	return nil
+}
+
+// walkLeaves calls fn for each PEM file under dir that starts with a leaf
+// certificate, with its contents and the parsed certificate.
+func walkLeaves(dir string, fn func(path string, data []byte, cert *x509.Certificate) error) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".pem" || strings.HasSuffix(path, "-key.pem") {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil || cert.IsCA {
+			return nil
+		}
+		return fn(path, data, cert)
+	})
+}
+
+// replaceLeaf replaces the first certificate in the file at path, whose
+// contents are data, keeping anything else in the file, like the key if it
+// was generated with the same -cert-file and -key-file. The file keeps its
+// mode, so that such a combined file stays private.
+func (m *mkcert) replaceLeaf(path string, data []byte, cert *issuer.Certificate) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the certificate: %w", err)
+	}
+	_, rest := pem.Decode(data)
+	start := bytes.Index(data, []byte("-----BEGIN CERTIFICATE-----"))
+	out := append([]byte{}, data[:start]...)
+	out = append(out, cert.CertPEM()...)
+	out = append(out, bytes.TrimLeft(rest, "\r\n")...)
+	if err := m.writeOutput(path, out, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to save certificate: %w", err)
+	}
+	m.written = append(m.written, path)
+	return nil
+}
+
+// isMkcertLeaf reports whether cert looks like it was issued by mkcert.
+func isMkcertLeaf(cert *x509.Certificate) bool {
+	for _, org := range cert.Subject.Organization {
+		if org == "mkcert development certificate" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"filippo.io/mkcert/issuer"
+)
+
+// TestRenewKeepsCombinedFilePrivate checks that renewing a file with both
+// the certificate and the key under -umask doesn't make the key readable.
+func TestRenewKeepsCombinedFilePrivate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("-umask is not supported on Windows")
+	}
+	ca, err := issuer.NewCA(t.TempDir(), &issuer.Options{ECDSA: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ca.IssueServer([]string{"example.test"}, &issuer.Options{ECDSA: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := cert.KeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "example.test.pem")
+	if err := ioutil.WriteFile(path, append(cert.CertPEM(), key...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	perms, err := parseOutputPerms("", "022")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mkcert{ca: ca, perms: perms}
+	if err := m.renewCert(path); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("the renewed file has mode %#o, want 0600", perm)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/mkcert/issuer"
+)
+
+const (
+	// revocationsName is the file in CAROOT listing the certificates revoked
+	// with -revoke.
+	revocationsName = "revoked.json"
+
+	// crlName is the file in CAROOT where -revoke and -gen-crl save the CRL.
+	crlName = "rootCA.crl"
+)
+
+type revocation struct {
+	Serial    string    `json:"serial"`
+	RevokedAt time.Time `json:"revoked_at"`
+	Names     []string  `json:"names,omitempty"`
+}
+
+func (m *mkcert) revocationsPath() string {
+	return filepath.Join(m.CAROOT, revocationsName)
+}
+
+// revoke implements -revoke. It adds the certificate at target, or with the
+// hex serial number target, to the revocation list, and saves a new CRL.
+func (m *mkcert) revoke(target string) error {
+	var r revocation
+	if pathExists(target) {
+		cert, err := readCertFile(target)
+		if err != nil {
+			return err
+		}
+		if cert.CheckSignatureFrom(m.ca.Cert) != nil {
+			return fmt.Errorf("%q was not issued by the local CA", target)
+		}
+		r.Serial, r.Names = fmt.Sprintf("%X", cert.SerialNumber), issuer.Hosts(cert)
+	} else {
+		serial, ok := parseSerial(target)
+		if !ok {
+			return fmt.Errorf("invalid -revoke %q, it must be a certificate file or a hex serial number", target)
+		}
+		r.Serial = fmt.Sprintf("%X", serial)
+	}
+
+	revocations, err := m.loadRevocations()
+	if err != nil {
+		return err
+	}
+	for _, old := range revocations {
+		if old.Serial == r.Serial {
+			return fmt.Errorf("the certificate with serial %s was already revoked on %s", r.Serial, old.RevokedAt.Format("2 January 2006"))
+		}
+	}
+	r.RevokedAt = time.Now().UTC().Truncate(time.Second)
+	revocations = append(revocations, r)
+	data, err := json.MarshalIndent(revocations, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(m.revocationsPath(), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to save the revocation list: %w", err)
+	}
+
+	if len(r.Names) > 0 {
+		log.Printf("Revoked the certificate with serial %s for %s 🚫", r.Serial, strings.Join(r.Names, ", "))
+	} else {
+		log.Printf("Revoked the certificate with serial %s 🚫", r.Serial)
+	}
+	return m.genCRL()
+}
+
+// genCRL implements -gen-crl. It saves a CRL of the revoked certificates,
+// valid for a week, in the CAROOT.
+func (m *mkcert) genCRL() error {
+	crl, err := m.signCRL()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(m.CAROOT, crlName)
+	if err := m.writeOutput(path, crl, 0644); err != nil {
+		return fmt.Errorf("failed to save the CRL: %w", err)
+	}
+	log.Printf("\nThe CRL is at %q, and is valid for a week ✅\n\n", path)
+	if !m.ca.HasCRLSign() {
+		log.Printf("Note: the local CA was created by an older mkcert without the CRL Sign key usage, so strict clients like OpenSSL reject its CRLs. Replace it with \"mkcert -rotate-ca\" to test them ⚠️\n\n")
+	}
+	return nil
+}
+
+func (m *mkcert) signCRL() ([]byte, error) {
+	revocations, err := m.loadRevocations()
+	if err != nil {
+		return nil, err
+	}
+	var revoked []issuer.Revocation
+	for _, r := range revocations {
+		serial, _ := new(big.Int).SetString(r.Serial, 16)
+		revoked = append(revoked, issuer.Revocation{SerialNumber: serial, RevokedAt: r.RevokedAt})
+	}
+	return m.ca.SignCRL(revoked, nil)
+}
+
+// revocationStatus returns the OCSP status of the certificate with serial,
+// which is revoked if it's in the revocation list, and the -ocsp-status
+// otherwise.
+func (m *mkcert) revocationStatus(serial *big.Int) issuer.OCSPStatus {
+	status := m.ocspStatus
+	revocations, err := m.loadRevocations()
+	if err != nil {
+		log.Printf("Warning: %v ⚠️", err)
+		return status
+	}
+	for _, r := range revocations {
+		if s, ok := new(big.Int).SetString(r.Serial, 16); ok && s.Cmp(serial) == 0 {
+			status.Revoked, status.RevokedAt = true, r.RevokedAt
+		}
+	}
+	return status
+}
+
+func (m *mkcert) loadRevocations() ([]revocation, error) {
+	data, err := ioutil.ReadFile(m.revocationsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the revocation list: %w", err)
+	}
+	var revocations []revocation
+	if err := json.Unmarshal(data, &revocations); err != nil {
+		return nil, fmt.Errorf("failed to parse the revocation list %s: %w", m.revocationsPath(), err)
+	}
+	return revocations, nil
+}
+
+// parseSerial parses a hex serial number, as printed by -inspect or by
+// OpenSSL, optionally with colons.
+func parseSerial(s string) (*big.Int, bool) {
+	s = strings.TrimPrefix(strings.ReplaceAll(s, ":", ""), "0x")
+	if s == "" {
+		return nil, false
+	}
+	return new(big.Int).SetString(s, 16)
+}
+
+// crlHandler serves a CRL of the revoked certificates at u, signed on each
+// request so that it's always current.
+func (m *mkcert) crlHandler(u *url.URL) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != u.Path && !(u.Path == "" && r.URL.Path == "/") {
+			http.NotFound(w, r)
+			return
+		}
+		crl, err := m.signCRL()
+		if err != nil {
+			log.Printf("Failed to sign the CRL: %v ⚠️", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pkix-crl")
+		w.Write(crl)
+	}
+}
+
+// parseCRLURL checks the -crl URL, which must be plain HTTP, as clients
+// don't fetch CRLs over HTTPS to avoid loops.
+func parseCRLURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -crl URL: %w", err)
+	}
+	if u.Scheme != "http" || u.Host == "" {
+		return nil, errors.New("invalid -crl URL: it must be an http:// URL, like http://localhost:8002/rootCA.crl")
+	}
+	return u, nil
+}
@@ -0,0 +1,270 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"filippo.io/mkcert/truststore"
+)
+
+// installLogName is the file in CAROOT recording what the last -install that
+// changed something did, so that -rollback can undo it.
+const installLogName = "install-log.json"
+
+type installLog struct {
+	Root   string            `json:"root_sha256"`
+	Time   time.Time         `json:"time"`
+	Stores []installLogEntry `json:"stores"`
+}
+
+// An installLogEntry is a trust store that didn't have the root before the
+// install. Status is "pending" until the store is done, so that a store
+// that was being modified when mkcert was interrupted is rolled back too.
+type installLogEntry struct {
+	Store  string `json:"store"`
+	Status string `json:"status"`
+	// Targets are the files, databases and keystores modified, if known.
+	// For the "nss" and "java" stores, they are only the NSS profiles and
+	// cacerts keystores that didn't have the root, and -rollback leaves the
+	// others alone.
+	Targets []string `json:"targets,omitempty"`
+}
+
+func (m *mkcert) installLogPath() string {
+	return filepath.Join(m.CAROOT, installLogName)
+}
+
+func (m *mkcert) rootFingerprint() string {
+	return fingerprintHex(m.ca.Cert.Raw)
+}
+
+// beginInstallLog records the stores that Install is about to modify, before
+// it does. It returns nil if the root is already in all the trust stores.
+func (m *mkcert) beginInstallLog() (*installLog, error) {
+	results, err := m.store.Check()
+	if err != nil {
+		return nil, err
+	}
+	l := &installLog{Root: m.rootFingerprint(), Time: time.Now()}
+	for _, r := range results {
+		if r.Status != truststore.AlreadyInstalled {
+			l.Stores = append(l.Stores, installLogEntry{
+				Store: r.Store, Status: "pending", Targets: m.changedTargets(r.Store),
+			})
+		}
+	}
+	if len(l.Stores) == 0 {
+		return nil, nil
+	}
+	if err := m.writeInstallLog(l); err != nil {
+		// The CAROOT can be read-only, like when the key is kept elsewhere.
+		if !errors.Is(err, fs.ErrPermission) && !errors.Is(err, syscall.EROFS) {
+			return nil, err
+		}
+		log.Printf("Warning: %v, -rollback won't be able to undo this install ⚠️", err)
+		return nil, nil
+	}
+	return l, nil
+}
+
+// finishInstallLog records the outcome of Install. A store that failed is
+// kept, as it might have been modified in part, like only some NSS profiles.
+func (m *mkcert) finishInstallLog(l *installLog, results []truststore.Result) error {
+	status := make(map[string]truststore.Status)
+	for _, r := range results {
+		status[r.Store] = r.Status
+	}
+	var stores []installLogEntry
+	for _, e := range l.Stores {
+		switch s, ok := status[e.Store]; {
+		case !ok:
+			// Install stopped before getting to this store, or while
+			// modifying it. Keep it pending.
+		case s == truststore.Installed || s == truststore.Failed:
+			e.Status = s.String()
+		default:
+			continue
+		}
+		stores = append(stores, e)
+	}
+	l.Stores = stores
+	if len(stores) == 0 {
+		return os.Remove(m.installLogPath())
+	}
+	return m.writeInstallLog(l)
+}
+
+// storeTargets returns what Install modifies in store, for the log.
+func (m *mkcert) storeTargets(store string) []string {
+	var targets []string
+	switch store {
+	case "system":
+		if info := m.store.PlatformInfo(); info.Path != "" {
+			targets = append(targets, info.Path)
+		}
+	case "nss":
+		for _, p := range m.store.NSSProfiles() {
+			targets = append(targets, p.Path)
+		}
+	case "java":
+		for _, r := range m.store.DetectJava() {
+			targets = append(targets, r.CacertsPath)
+		}
+	}
+	return targets
+}
+
+// changedTargets returns the storeTargets that Install is about to modify:
+// for the "nss" and "java" stores, only the profiles and keystores that
+// don't have the root yet, or that can't be checked.
+func (m *mkcert) changedTargets(store string) []string {
+	targets := m.storeTargets(store)
+	if store != "nss" && store != "java" {
+		return targets
+	}
+	var changed []string
+	for _, t := range targets {
+		ts := m.targetStore(store, t)
+		if ts == nil {
+			continue
+		}
+		results, err := ts.Check()
+		if err == nil && len(results) == 1 && results[0].Status == truststore.AlreadyInstalled {
+			continue
+		}
+		changed = append(changed, t)
+	}
+	return changed
+}
+
+// targetStore returns a Store for a single target of the "nss" or "java"
+// store, an NSS profile or a cacerts keystore, or nil if the keystore
+// doesn't belong to a Java runtime anymore.
+func (m *mkcert) targetStore(store, target string) *truststore.Store {
+	ts := m.subStore(store)
+	switch store {
+	case "nss":
+		ts.NSSProfile = target
+	case "java":
+		for _, r := range m.store.DetectJava() {
+			if r.CacertsPath == target {
+				ts.Java = []truststore.JavaRuntime{r}
+			}
+		}
+		if ts.Java == nil {
+			return nil
+		}
+	}
+	return ts
+}
+
+// subStore returns a new Store like m.store, for only the given stores.
+func (m *mkcert) subStore(stores ...string) *truststore.Store {
+	s := m.newStore()
+	s.Stores = stores
+	s.Java = m.store.Java
+	return s
+}
+
+func (m *mkcert) writeInstallLog(l *installLog) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(m.installLogPath(), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to save the install log: %w", err)
+	}
+	return nil
+}
+
+// rollback implements -rollback. It uninstalls the root from the trust
+// stores that the last -install added it to, even if that install failed
+// half way, and leaves the others alone. In the "nss" and "java" stores, it
+// only uninstalls it from the recorded targets, so that profiles and
+// keystores that already had the root keep it.
+func (m *mkcert) rollback() error {
+	data, err := ioutil.ReadFile(m.installLogPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return errors.New("there is no install to roll back")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the install log: %w", err)
+	}
+	var l installLog
+	if err := json.Unmarshal(data, &l); err != nil {
+		return fmt.Errorf("failed to parse the install log %s: %w", m.installLogPath(), err)
+	}
+	if l.Root != m.rootFingerprint() {
+		return fmt.Errorf("the install log %s is for a different root than the one in CAROOT", m.installLogPath())
+	}
+
+	if len(l.Stores) == 0 {
+		return errors.New("there is no install to roll back")
+	}
+
+	log.Printf("Rolling back the install of %s 🔙", l.Time.Format("2 January 2006 15:04"))
+	whole := m.subStore()
+	stores := []*truststore.Store{whole}
+	for _, e := range l.Stores {
+		for _, t := range e.Targets {
+			log.Printf(" - %s: %s", storeName(e.Store), t)
+		}
+		if (e.Store != "nss" && e.Store != "java") || len(e.Targets) == 0 {
+			whole.Stores = append(whole.Stores, e.Store)
+			continue
+		}
+		for _, t := range e.Targets {
+			if ts := m.targetStore(e.Store, t); ts != nil {
+				stores = append(stores, ts)
+			}
+		}
+	}
+	if len(whole.Stores) == 0 {
+		stores = stores[1:]
+	}
+
+	var failed int
+	uninstalled := make(map[string]bool)
+	for _, s := range stores {
+		done, err := m.uninstallFrom(s)
+		var multiErr *truststore.MultiError
+		switch {
+		case errors.As(err, &multiErr):
+			failed += len(multiErr.Errors)
+		case err != nil:
+			return err
+		}
+		for store := range done {
+			uninstalled[store] = true
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("the local CA could not be uninstalled from %d trust store(s)", failed)
+	}
+	var names []string
+	for store := range uninstalled {
+		names = append(names, storeName(store))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		log.Printf("The local CA is now uninstalled from %s 👋", name)
+	}
+	if err := os.Remove(m.installLogPath()); err != nil {
+		return fmt.Errorf("failed to remove the install log: %w", err)
+	}
+	log.Print("The install is now rolled back! 👋")
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/mkcert/issuer"
+	"filippo.io/mkcert/truststore"
+)
+
+// TestRollbackKeepsTargetsThatHadTheRoot checks that -rollback only removes
+// the root from the Java keystores that the install added it to.
+func TestRollbackKeepsTargetsThatHadTheRoot(t *testing.T) {
+	fixture, err := ioutil.ReadFile(filepath.Join("truststore", "testdata", "cacerts.jks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var runtimes []truststore.JavaRuntime
+	for _, name := range []string{"had-root", "new"} {
+		home := filepath.Join(t.TempDir(), name)
+		cacerts := filepath.Join(home, "lib", "security", "cacerts")
+		if err := os.MkdirAll(filepath.Dir(cacerts), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(cacerts, fixture, 0644); err != nil {
+			t.Fatal(err)
+		}
+		runtimes = append(runtimes, truststore.JavaRuntime{Home: home, CacertsPath: cacerts})
+	}
+
+	caroot := t.TempDir()
+	ca, err := issuer.NewCA(caroot, &issuer.Options{ECDSA: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mkcert{CAROOT: caroot, ca: ca, rootPath: filepath.Join(caroot, issuer.RootName),
+		trustStores: "java", cmdFS: &truststore.CmdFS{}}
+	m.store = m.newStore()
+	m.store.Java = runtimes
+
+	installed := func(r truststore.JavaRuntime) bool {
+		results, err := m.targetStore("java", r.CacertsPath).Check()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(results) == 1 && results[0].Status == truststore.AlreadyInstalled
+	}
+
+	if _, err := m.targetStore("java", runtimes[0].CacertsPath).Install(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.install(); err != nil {
+		t.Fatal(err)
+	}
+	if !installed(runtimes[0]) || !installed(runtimes[1]) {
+		t.Fatal("the root is not installed in both keystores")
+	}
+
+	if err := m.rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if !installed(runtimes[0]) {
+		t.Error("the rollback removed the root from the keystore that already had it")
+	}
+	if installed(runtimes[1]) {
+		t.Error("the rollback didn't remove the root from the keystore the install added it to")
+	}
+}
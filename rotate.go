@@ -0,0 +1,204 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"filippo.io/mkcert/issuer"
+	"filippo.io/mkcert/truststore"
+)
+
+const (
+	// rotationsName is the file in CAROOT recording the -rotate-ca runs, so
+	// that the replaced roots are uninstalled after the overlap window.
+	rotationsName = "rotations.json"
+
+	// oldRootsDir is the directory in CAROOT (and in -ca-key-dir) where the
+	// replaced roots and their keys are moved.
+	oldRootsDir = "old-roots"
+)
+
+type rotation struct {
+	OldRoot string    `json:"old_root_sha256"`
+	NewRoot string    `json:"new_root_sha256"`
+	Time    time.Time `json:"time"`
+	// OldRootPath is the path of the replaced root, relative to CAROOT.
+	OldRootPath  string    `json:"old_root_path"`
+	OverlapUntil time.Time `json:"overlap_until"`
+	// Retired is set once the replaced root is uninstalled.
+	Retired bool `json:"retired,omitempty"`
+}
+
+func (m *mkcert) rotationsPath() string {
+	return filepath.Join(m.CAROOT, rotationsName)
+}
+
+// rotateCA implements -rotate-ca. It moves the current root and key to
+// old-roots, creates a new local CA in their place, and records the
+// rotation. The new root is then installed like with -install, and the old
+// one stays installed until retireRoots uninstalls it after m.overlap.
+func (m *mkcert) rotateCA() error {
+	rootPath := filepath.Join(m.CAROOT, issuer.RootName)
+	old, err := readCertFile(rootPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return errors.New("there is no local CA to rotate, run \"mkcert -install\" to create one")
+	}
+	if err != nil {
+		return err
+	}
+	keyDir := m.CAROOT
+	if m.caKeyDir != "" {
+		keyDir = m.caKeyDir
+	}
+	oldFingerprint := fingerprintHex(old.Raw)
+
+	oldRootPath := filepath.Join(oldRootsDir, oldFingerprint[:16]+".pem")
+	if err := os.MkdirAll(filepath.Join(m.CAROOT, oldRootsDir), 0755); err != nil {
+		return fmt.Errorf("failed to archive the local CA: %w", err)
+	}
+	if err := os.Rename(rootPath, filepath.Join(m.CAROOT, oldRootPath)); err != nil {
+		return fmt.Errorf("failed to archive the local CA: %w", err)
+	}
+	keyPath := filepath.Join(keyDir, issuer.RootKeyName)
+	oldKeyPath := filepath.Join(keyDir, oldRootsDir, oldFingerprint[:16]+"-key.pem")
+	hasKey := pathExists(keyPath)
+	if hasKey {
+		if err := os.MkdirAll(filepath.Dir(oldKeyPath), 0700); err != nil {
+			os.Rename(filepath.Join(m.CAROOT, oldRootPath), rootPath)
+			return fmt.Errorf("failed to archive the local CA key: %w", err)
+		}
+		if err := os.Rename(keyPath, oldKeyPath); err != nil {
+			os.Rename(filepath.Join(m.CAROOT, oldRootPath), rootPath)
+			return fmt.Errorf("failed to archive the local CA key: %w", err)
+		}
+	}
+
+	opts := &issuer.Options{ECDSA: m.ecdsa, Curve: m.curve, RSABits: m.rsaBits, KeyDir: m.caKeyDir}
+	ca, err := issuer.NewCA(m.CAROOT, opts)
+	if err != nil {
+		// Put the old CA back, so that the CAROOT is left as it was.
+		os.Remove(rootPath)
+		os.Rename(filepath.Join(m.CAROOT, oldRootPath), rootPath)
+		if hasKey {
+			os.Rename(oldKeyPath, keyPath)
+		}
+		return err
+	}
+
+	// The revocations are of certificates issued by the old CA, so they
+	// are archived with it, instead of being signed by the new one.
+	for name, archived := range map[string]string{revocationsName: "-revoked.json", crlName: ".crl"} {
+		path := filepath.Join(m.CAROOT, name)
+		if !pathExists(path) {
+			continue
+		}
+		if err := os.Rename(path, filepath.Join(m.CAROOT, oldRootsDir, oldFingerprint[:16]+archived)); err != nil {
+			return fmt.Errorf("failed to archive the revocations of the previous local CA: %w", err)
+		}
+	}
+
+	rotations, err := m.loadRotations()
+	if err != nil {
+		return err
+	}
+	rotations = append(rotations, rotation{
+		OldRoot: oldFingerprint, NewRoot: fingerprintHex(ca.Cert.Raw),
+		Time: time.Now(), OldRootPath: filepath.ToSlash(oldRootPath),
+		OverlapUntil: time.Now().Add(m.overlap),
+	})
+	if err := m.saveRotations(rotations); err != nil {
+		return err
+	}
+	log.Printf("Created a new local CA, the previous one is now in %s 💥", filepath.Join(m.CAROOT, oldRootPath))
+	return nil
+}
+
+// retireRoots uninstalls the roots replaced by -rotate-ca whose overlap
+// window is over, and notes the ones that are still trusted.
+func (m *mkcert) retireRoots() error {
+	rotations, err := m.loadRotations()
+	if err != nil || len(rotations) == 0 {
+		return err
+	}
+	var retired bool
+	for i := range rotations {
+		r := &rotations[i]
+		if r.Retired {
+			continue
+		}
+		if time.Now().Before(r.OverlapUntil) {
+			log.Printf("The previous local CA stays trusted until %s, for the certificates it issued ⏳", r.OverlapUntil.Format("2 January 2006 15:04"))
+			continue
+		}
+
+		path := filepath.Join(m.CAROOT, filepath.FromSlash(r.OldRootPath))
+		cert, err := readCertFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to load the previous local CA: %w", err)
+		}
+		store := m.newStore()
+		store.RootPath, store.Root = path, cert
+		results, err := store.Uninstall()
+		for _, res := range results {
+			logResult("uninstall", res)
+			if res.Status == truststore.Failed && !res.Reported && !errors.Is(res.Err, truststore.ErrUnsupported) {
+				log.Printf("Uninstalling the previous local CA from %s failed ⚠️", storeName(res.Store))
+				log.Print(res.Err)
+			}
+			m.messages.flush(res.Store)
+		}
+		if err != nil {
+			return fmt.Errorf("the previous local CA could not be uninstalled: %w", err)
+		}
+		log.Printf("The local CA replaced on %s is now uninstalled, as its overlap window is over 👋", r.Time.Format("2 January 2006"))
+		r.Retired, retired = true, true
+	}
+	if !retired {
+		return nil
+	}
+	return m.saveRotations(rotations)
+}
+
+func (m *mkcert) loadRotations() ([]rotation, error) {
+	data, err := ioutil.ReadFile(m.rotationsPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the rotation log: %w", err)
+	}
+	var rotations []rotation
+	if err := json.Unmarshal(data, &rotations); err != nil {
+		return nil, fmt.Errorf("failed to parse the rotation log %s: %w", m.rotationsPath(), err)
+	}
+	return rotations, nil
+}
+
+func (m *mkcert) saveRotations(rotations []rotation) error {
+	data, err := json.MarshalIndent(rotations, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(m.rotationsPath(), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to save the rotation log: %w", err)
+	}
+	return nil
+}
+
+func fingerprintHex(der []byte) string {
+	h := sha256.Sum256(der)
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,205 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+
+	"howett.net/plist"
+)
+
+// serveCATimeout is how long -serve-ca serves the root before stopping.
+const serveCATimeout = 10 * time.Minute
+
+var serveCAPage = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Name}}</title>
+<h1>{{.Name}}</h1>
+<p>SHA-256 fingerprint: <code>{{.Fingerprint}}</code>
+<ul>
+<li><a href="/rootCA.mobileconfig">iPhone, iPad and Mac</a>: then open Settings, install the downloaded profile, and enable full trust for it in General &gt; About &gt; Certificate Trust Settings.
+<li><a href="/rootCA.crt">Android</a>: then install it in Settings &gt; Security &gt; Encryption &amp; credentials &gt; Install a certificate &gt; CA certificate.
+<li><a href="/rootCA.pem">PEM</a>, for everything else.
+</ul>
+`))
+
+// serveCA implements -serve-ca. It serves the root on a random port on all
+// interfaces until interrupted or for serveCATimeout, and prints a QR code
+// of the URL so that phones and tablets on the same network can get it.
+func (m *mkcert) serveCA() error {
+	mux, err := m.rootMux()
+	if err != nil {
+		return err
+	}
+
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	ips := lanIPs()
+	if len(ips) == 0 {
+		log.Print("Warning: no network address found, the CA can only be downloaded from this machine ⚠️")
+		ips = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	url := "http://" + net.JoinHostPort(ips[0].String(), port) + "/"
+	code, err := qrEncode([]byte(url))
+	if err != nil {
+		return err
+	}
+	log.Printf("Scan the QR code or open %s on the device to download the local CA 📱", url)
+	fmt.Fprint(os.Stderr, "\n"+qrTerminal(code)+"\n")
+	for _, ip := range ips[1:] {
+		log.Printf(" - also at http://%s/", net.JoinHostPort(ip.String(), port))
+	}
+	log.Printf("Serving for %s, press Ctrl-C to stop ⏳", serveCATimeout)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, serveCATimeout)
+	defer cancel()
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	log.Print("Stopped serving the local CA 👋")
+	return nil
+}
+
+// serveRoot implements -serve-root. It serves the root at addr, like
+// -serve-ca but without a time limit, for VMs and teammates that fetch it
+// from a fixed URL.
+func (m *mkcert) serveRoot(addr string) error {
+	mux, err := m.rootMux()
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		log.Printf("Serving the local CA at http://%s/ 📡", net.JoinHostPort("localhost", port))
+		for _, ip := range lanIPs() {
+			log.Printf(" - also at http://%s/", net.JoinHostPort(ip.String(), port))
+		}
+	} else {
+		log.Printf("Serving the local CA at http://%s/ 📡", net.JoinHostPort(host, port))
+	}
+	log.Print("Press Ctrl-C to stop.")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	log.Print("Stopped serving the local CA 👋")
+	return nil
+}
+
+// rootMux returns a handler serving a download page for the root, and the
+// root as PEM, DER and an Apple configuration profile.
+func (m *mkcert) rootMux() (*http.ServeMux, error) {
+	mobileconfig, err := m.rootMobileconfig()
+	if err != nil {
+		return nil, err
+	}
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.ca.Cert.Raw})
+
+	mux := http.NewServeMux()
+	serve := func(name, contentType string, data []byte) {
+		mux.HandleFunc("/"+name, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+			w.Write(data)
+			log.Printf("Served %s to %s 📲", name, r.RemoteAddr)
+		})
+	}
+	serve("rootCA.pem", "application/x-pem-file", pemBytes)
+	serve("rootCA.crt", "application/x-x509-ca-cert", m.ca.Cert.Raw)
+	serve("rootCA.mobileconfig", "application/x-apple-aspen-config", mobileconfig)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		sum := sha256.Sum256(m.ca.Cert.Raw)
+		serveCAPage.Execute(w, struct{ Name, Fingerprint string }{m.ca.Cert.Subject.CommonName, fingerprint(sum[:])})
+	})
+	return mux, nil
+}
+
+// lanIPs returns the addresses of this machine that other devices on the
+// network can probably reach, IPv4 first.
+func lanIPs() []net.IP {
+	addrs, _ := net.InterfaceAddrs()
+	var v4, v6 []net.IP
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ip := ipNet.IP.To4(); ip != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ipNet.IP)
+		}
+	}
+	return append(v4, v6...)
+}
+
+// rootMobileconfig returns an Apple configuration profile that installs the
+// root. Its identifiers are derived from the root, so that downloading it
+// again replaces the profile instead of adding a copy.
+func (m *mkcert) rootMobileconfig() ([]byte, error) {
+	h := sha256.Sum256(m.ca.Cert.Raw)
+	uuid := func(b []byte) string {
+		b[6] = b[6]&0x0f | 0x40 // version 4
+		b[8] = b[8]&0x3f | 0x80 // variant 10
+		return fmt.Sprintf("%X-%X-%X-%X-%X", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+	}
+	name := m.ca.Cert.Subject.CommonName
+	profile := map[string]interface{}{
+		"PayloadContent": []interface{}{map[string]interface{}{
+			"PayloadCertificateFileName": "rootCA.crt",
+			"PayloadContent":             m.ca.Cert.Raw,
+			"PayloadDescription":         "Adds the mkcert local CA",
+			"PayloadDisplayName":         name,
+			"PayloadIdentifier":          fmt.Sprintf("io.mkcert.root.%x.cert", h[:8]),
+			"PayloadType":                "com.apple.security.root",
+			"PayloadUUID":                uuid(append([]byte{}, h[16:32]...)),
+			"PayloadVersion":             1,
+		}},
+		"PayloadDisplayName": name,
+		"PayloadIdentifier":  fmt.Sprintf("io.mkcert.root.%x", h[:8]),
+		"PayloadType":        "Configuration",
+		"PayloadUUID":        uuid(append([]byte{}, h[0:16]...)),
+		"PayloadVersion":     1,
+	}
+	return plist.MarshalIndent(profile, plist.XMLFormat, "\t")
+}
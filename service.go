@@ -0,0 +1,229 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"filippo.io/mkcert/truststore"
+	"howett.net/plist"
+)
+
+// serviceName names the unit, agent or task that -install-service registers.
+const serviceName = "mkcert-renew"
+
+// renewServiceFlags are the flags that -install-service forwards to the
+// service, besides -within and -exec, because they change how -renew-all
+// loads the CA or writes the certificates.
+var renewServiceFlags = map[string]bool{
+	"system-caroot": true, "ca-key-dir": true, "ca-kms": true,
+	"owner": true, "umask": true, "days": true,
+	"cmd-timeout": true, "no-emoji": true, "log-format": true,
+}
+
+// serviceFlags returns the renewServiceFlags set on the command line, for
+// -install-service, with any path made absolute. It fails if another flag is
+// set, as the service would silently renew without it.
+func serviceFlags() ([]string, error) {
+	var args []string
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		switch {
+		case f.Name == "install-service" || f.Name == "renew-all" || f.Name == "within" || f.Name == "exec":
+			// Added by installRenewService.
+		case f.Name == "ca-key-dir":
+			dir, absErr := filepath.Abs(value)
+			if absErr != nil && err == nil {
+				err = absErr
+			}
+			args = append(args, "-ca-key-dir="+dir)
+		case renewServiceFlags[f.Name]:
+			args = append(args, "-"+f.Name+"="+value)
+		case f.Name == "not-after" && err == nil:
+			err = errors.New("-not-after is a fixed date, use -days with -install-service")
+		case err == nil:
+			err = fmt.Errorf("-%s can't be used with -install-service, as it would not apply to the renewals", f.Name)
+		}
+	})
+	return args, err
+}
+
+// installRenewService implements -install-service. It registers a systemd
+// user timer, a launchd agent, or a Windows scheduled task that runs
+// -renew-all once a day, with the same directory, -within, -exec,
+// renewServiceFlags and CAROOT, so that renewals keep happening after
+// reboots. Running it again replaces the previous service.
+func (m *mkcert) installRenewService() error {
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate the mkcert binary: %w", err)
+	}
+	dir, err := filepath.Abs(m.renewDir)
+	if err != nil {
+		return err
+	}
+	args := []string{self, "-renew-all", dir, "-within", m.within.String()}
+	if m.execHook != "" {
+		args = append(args, "-exec", m.execHook)
+	}
+	args = append(args, m.serviceFlags...)
+
+	switch runtime.GOOS {
+	case "linux":
+		return m.installSystemdTimer(args)
+	case "darwin":
+		return m.installLaunchAgent(args)
+	case "windows":
+		return m.installScheduledTask(args)
+	default:
+		return fmt.Errorf("-install-service is not supported on %s, run %q periodically instead", runtime.GOOS, strings.Join(args, " "))
+	}
+}
+
+func (m *mkcert) installSystemdTimer(args []string) error {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return err
+	}
+	unitDir := filepath.Join(configDir, "systemd", "user")
+	if err := os.MkdirAll(unitDir, 0755); err != nil {
+		return err
+	}
+
+	// systemd splits ExecStart like a shell would, and expands specifiers
+	// introduced by a percent sign and variables introduced by a dollar sign,
+	// even in quotes, which would empty the $MKCERT_FILES of an -exec hook.
+	quote := func(s string) string {
+		s = strings.ReplaceAll(s, `\`, `\\`)
+		s = strings.ReplaceAll(s, `"`, `\"`)
+		s = strings.ReplaceAll(s, "$", "$$")
+		return `"` + strings.ReplaceAll(s, "%", "%%") + `"`
+	}
+	var execStart []string
+	for _, arg := range args {
+		execStart = append(execStart, quote(arg))
+	}
+	service := fmt.Sprintf(`[Unit]
+Description=Renew the certificates issued by the mkcert local CA
+
+[Service]
+Type=oneshot
+Environment=%s
+ExecStart=%s
+`, quote("CAROOT="+m.CAROOT), strings.Join(execStart, " "))
+	timer := `[Unit]
+Description=Renew the certificates issued by the mkcert local CA daily
+
+[Timer]
+OnCalendar=daily
+Persistent=true
+RandomizedDelaySec=1h
+
+[Install]
+WantedBy=timers.target
+`
+	servicePath := filepath.Join(unitDir, serviceName+".service")
+	timerPath := filepath.Join(unitDir, serviceName+".timer")
+	if err := ioutil.WriteFile(servicePath, []byte(service), 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(timerPath, []byte(timer), 0644); err != nil {
+		return err
+	}
+
+	if err := m.runServiceCmd("systemctl", "--user", "daemon-reload"); err != nil {
+		return err
+	}
+	if err := m.runServiceCmd("systemctl", "--user", "enable", "--now", serviceName+".timer"); err != nil {
+		return err
+	}
+	log.Printf("Installed %s and %s, which renew the certificates in %q daily ⏰", servicePath, timerPath, m.renewDir)
+	log.Printf("To also run it while you are logged out, run \"loginctl enable-linger\". To remove it, run \"systemctl --user disable --now %s.timer\" and delete the files.", serviceName)
+	return nil
+}
+
+func (m *mkcert) installLaunchAgent(args []string) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	label := "io.mkcert.renew"
+	agentDir := filepath.Join(home, "Library", "LaunchAgents")
+	if err := os.MkdirAll(agentDir, 0755); err != nil {
+		return err
+	}
+	logPath := filepath.Join(home, "Library", "Logs", serviceName+".log")
+	agent, err := plist.MarshalIndent(map[string]interface{}{
+		"Label":                label,
+		"ProgramArguments":     args,
+		"EnvironmentVariables": map[string]string{"CAROOT": m.CAROOT},
+		"StartInterval":        24 * 60 * 60,
+		"RunAtLoad":            true,
+		"StandardOutPath":      logPath,
+		"StandardErrorPath":    logPath,
+	}, plist.XMLFormat, "\t")
+	if err != nil {
+		return err
+	}
+	agentPath := filepath.Join(agentDir, label+".plist")
+
+	// Unload the previous version, if any, so that the new one takes effect.
+	m.runServiceCmd("launchctl", "unload", agentPath)
+	if err := ioutil.WriteFile(agentPath, agent, 0644); err != nil {
+		return err
+	}
+	if err := m.runServiceCmd("launchctl", "load", "-w", agentPath); err != nil {
+		return err
+	}
+	log.Printf("Installed %s, which renews the certificates in %q daily, logging to %s ⏰", agentPath, m.renewDir, logPath)
+	log.Printf("To remove it, run \"launchctl unload -w %s\" and delete the file.", agentPath)
+	return nil
+}
+
+func (m *mkcert) installScheduledTask(args []string) error {
+	// The command of a task is limited to 261 characters, so it runs a
+	// script in CAROOT, which also sets the environment.
+	var script strings.Builder
+	script.WriteString("@echo off\r\n")
+	fmt.Fprintf(&script, "set \"CAROOT=%s\"\r\n", m.CAROOT)
+	for i, arg := range args {
+		if i > 0 {
+			script.WriteString(" ")
+		}
+		script.WriteString(`"` + strings.ReplaceAll(arg, "%", "%%") + `"`)
+	}
+	script.WriteString("\r\n")
+	scriptPath := filepath.Join(m.CAROOT, serviceName+".cmd")
+	if err := ioutil.WriteFile(scriptPath, []byte(script.String()), 0644); err != nil {
+		return err
+	}
+
+	taskName := "mkcert renew"
+	if err := m.runServiceCmd("schtasks", "/Create", "/F", "/TN", taskName, "/SC", "DAILY", "/TR", `"`+scriptPath+`"`); err != nil {
+		return err
+	}
+	log.Printf("Installed the %q scheduled task, which renews the certificates in %q daily ⏰", taskName, m.renewDir)
+	log.Printf("To remove it, run `schtasks /Delete /TN %q` and delete %s.", taskName, scriptPath)
+	return nil
+}
+
+func (m *mkcert) runServiceCmd(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if out, err := m.cmdFS.Exec(context.Background(), cmd); err != nil {
+		return &truststore.CmdError{Cmd: strings.Join(cmd.Args, " "), Out: out, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"filippo.io/mkcert/issuer"
+	"filippo.io/mkcert/truststore"
+)
+
+// systemCAROOT returns the machine-wide CAROOT used with -system-caroot. The
+// CA certificate in it is world-readable, while the key is only readable by
+// root (or the Administrators on Windows).
+func systemCAROOT() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("ProgramData"), "mkcert")
+	}
+	return "/usr/local/share/mkcert"
+}
+
+// errNoSharedCA is returned when a regular user can't create the shared CA.
+var errNoSharedCA = errors.New(`the shared CA doesn't exist yet, create it with "sudo mkcert -system-caroot -install"`)
+
+// loadSharedCA loads the CA certificate from the shared CAROOT, for users
+// that can't read its key, and has the certificates signed through sudo
+// instead.
+func (m *mkcert) loadSharedCA() error {
+	if runtime.GOOS == "windows" {
+		return errors.New("the key of the shared CA is only readable by Administrators, run mkcert from an elevated prompt")
+	}
+	rootPath := filepath.Join(m.CAROOT, issuer.RootName)
+	cert, err := readCertFile(rootPath)
+	if err != nil {
+		return err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	policy, err := issuer.LoadPolicy(m.CAROOT)
+	if err != nil {
+		return err
+	}
+	m.ca = &issuer.CA{Cert: cert, Key: &sudoSigner{self: self, cmdFS: m.cmdFS}, Policy: policy}
+	m.rootPath = rootPath
+	return nil
+}
+
+// A sudoSigner is the key of the shared CA, for users that can't read it.
+// It sends each certificate, signed by a throwaway key, to mkcert running
+// with -sign-draft through sudo, which checks it and signs it for real. The
+// CmdFS is in Batch mode, so the password is asked at most once.
+type sudoSigner struct {
+	self  string
+	cmdFS *truststore.CmdFS
+}
+
+func (s *sudoSigner) SignDraft(draft *x509.Certificate) (*x509.Certificate, error) {
+	cmd := exec.Command(s.self, "-system-caroot", "-sign-draft")
+	cmd.Stdin = bytes.NewReader(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: draft.Raw}))
+	out, err := s.cmdFS.SudoExec(context.Background(), cmd)
+	if err != nil {
+		return nil, &truststore.CmdError{Cmd: "sudo mkcert -sign-draft", Out: out, Err: err}
+	}
+	block, _ := pem.Decode(out)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("unexpected output from \"sudo mkcert -sign-draft\": %q", out)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// signDraft implements -sign-draft, run as root by sudoSigner: it reads a
+// leaf certificate signed by a throwaway key from stdin, and prints it
+// issued by the shared CA. Only the policy.json of the shared CAROOT, which
+// only root can modify, applies, and CA certificates are rejected.
+func signDraft() error {
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return errors.New("failed to read the draft certificate: unexpected content")
+	}
+	draft, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse the draft certificate: %w", err)
+	}
+	ca, err := issuer.LoadCA(systemCAROOT())
+	if err != nil {
+		return err
+	}
+	if ca.Key == nil {
+		return issuer.ErrNoCAKey
+	}
+	cert, err := ca.SignDraft(draft)
+	if err != nil {
+		return err
+	}
+	return pem.Encode(os.Stdout, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
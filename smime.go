@@ -0,0 +1,105 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/mail"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"filippo.io/mkcert/truststore"
+)
+
+// checkSMIMEHosts makes sure all the names for -smime are email addresses.
+func checkSMIMEHosts(hosts []string) error {
+	for _, h := range hosts {
+		if email, err := mail.ParseAddress(h); err != nil || email.Address != h {
+			return fmt.Errorf("%q is not an email address, -smime certificates are only for email addresses", h)
+		}
+	}
+	return nil
+}
+
+// smimeTemplate makes tpl an S/MIME certificate, valid for signing and
+// encrypting email and nothing else. Mail clients like Outlook show the
+// Common Name, so it's set to the first address.
+func (m *mkcert) smimeTemplate(tpl *x509.Certificate) {
+	if tpl.Subject.CommonName == "" && len(tpl.EmailAddresses) > 0 {
+		tpl.Subject.CommonName = tpl.EmailAddresses[0]
+	}
+	tpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection}
+	tpl.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment
+	// RSA keys encrypt the message key directly, while EC keys agree on it
+	// with ECDH.
+	if m.ecdsa {
+		tpl.KeyUsage |= x509.KeyUsageKeyAgreement
+	} else {
+		tpl.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+}
+
+// thunderbirdProfiles are the globs of the Thunderbird profile directories.
+func thunderbirdProfiles() []string {
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{filepath.Join(os.Getenv("HOME"), "Library/Thunderbird/Profiles/*")}
+	case "windows":
+		return []string{filepath.Join(os.Getenv("APPDATA"), "Thunderbird", "Profiles", "*")}
+	default:
+		return []string{
+			filepath.Join(os.Getenv("HOME"), ".thunderbird/*"),
+			filepath.Join(os.Getenv("HOME"), "snap/thunderbird/common/.thunderbird/*"),
+		}
+	}
+}
+
+// importSMIME imports the PKCS #12 bundle with the S/MIME certificate and key
+// into the Thunderbird profiles, with pk12util, and on macOS into the login
+// keychain used by Mail, with security.
+func (m *mkcert) importSMIME(p12File string) error {
+	var profiles []string
+	for _, glob := range thunderbirdProfiles() {
+		matches, _ := filepath.Glob(glob)
+		for _, p := range matches {
+			if pathExists(filepath.Join(p, "cert9.db")) {
+				profiles = append(profiles, p)
+			}
+		}
+	}
+	if len(profiles) > 0 {
+		pk12util, err := exec.LookPath("pk12util")
+		if err != nil {
+			log.Printf(`Warning: "pk12util" is not available, so the certificate can't be imported in Thunderbird! ⚠️`)
+			log.Printf(`Install it with the NSS tools, like "certutil" 👈`)
+		}
+		for _, p := range profiles {
+			if pk12util == "" {
+				break
+			}
+			cmd := exec.Command(pk12util, "-i", p12File, "-d", "sql:"+p, "-W", "changeit")
+			if out, err := m.cmdFS.Exec(context.Background(), cmd); err != nil {
+				return &truststore.CmdError{Cmd: "pk12util -i " + p12File + " -d " + p, Out: out, Err: err}
+			}
+			log.Printf("Imported the certificate in Thunderbird (%s) 📧", p)
+		}
+	}
+	if runtime.GOOS == "darwin" {
+		keychain := filepath.Join(os.Getenv("HOME"), "Library/Keychains/login.keychain-db")
+		cmd := exec.Command("security", "import", p12File, "-k", keychain, "-f", "pkcs12", "-P", "changeit", "-T", "/System/Applications/Mail.app")
+		if out, err := m.cmdFS.Exec(context.Background(), cmd); err != nil {
+			return &truststore.CmdError{Cmd: "security import " + p12File, Out: out, Err: err}
+		}
+		log.Print("Imported the certificate in the login keychain, for Mail 📧")
+	} else if len(profiles) == 0 {
+		log.Print("Warning: no Thunderbird profiles found to import the certificate in ⚠️")
+	}
+	return nil
+}
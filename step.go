@@ -0,0 +1,195 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"filippo.io/mkcert/issuer"
+)
+
+// The step-ca file layout inside $STEPPATH.
+const (
+	stepRootCert = "certs/root_ca.crt"
+	stepRootKey  = "secrets/root_ca_key"
+	stepConfig   = "config/ca.json"
+
+	stepProvisioner = "mkcert"
+)
+
+// importStepCA makes the CAROOT use the root of the step-ca at stepPath,
+// instead of creating a second local CA. The key is imported too if it's not
+// encrypted, otherwise the CA is imported in keyless mode.
+func (m *mkcert) importStepCA(stepPath string) error {
+	if pathExists(filepath.Join(m.CAROOT, issuer.RootName)) {
+		return fmt.Errorf("%q already contains a local CA, delete it or set $CAROOT to an empty directory first", m.CAROOT)
+	}
+	certPEM, err := ioutil.ReadFile(filepath.Join(stepPath, stepRootCert))
+	if err != nil {
+		return fmt.Errorf("failed to read the step-ca root: %w", err)
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil || certBlock.Type != "CERTIFICATE" {
+		return errors.New("failed to read the step-ca root: unexpected content")
+	}
+	if _, err := x509.ParseCertificate(certBlock.Bytes); err != nil {
+		return fmt.Errorf("failed to parse the step-ca root: %w", err)
+	}
+
+	var keyDER []byte
+	keyPEM, err := ioutil.ReadFile(filepath.Join(stepPath, stepRootKey))
+	switch {
+	case os.IsNotExist(err):
+		log.Printf("Note: the step-ca root key is not in %q, so only -install will work ℹ️", stepPath)
+	case err != nil:
+		return fmt.Errorf("failed to read the step-ca root key: %w", err)
+	default:
+		keyDER, err = parseStepKey(keyPEM)
+		if err != nil {
+			return err
+		}
+		if keyDER == nil {
+			log.Printf("Note: the step-ca root key is encrypted, so only -install will work. To issue certificates too, decrypt it with \"step crypto change-pass --no-password --insecure\", delete the CAROOT, and import it again ℹ️")
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(m.CAROOT, issuer.RootName), pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: certBlock.Bytes}), 0644); err != nil {
+		return fmt.Errorf("failed to save CA certificate: %w", err)
+	}
+	if keyDER != nil {
+		if err := ioutil.WriteFile(filepath.Join(m.CAROOT, issuer.RootKeyName), pem.EncodeToMemory(
+			&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0400); err != nil {
+			return fmt.Errorf("failed to save CA key: %w", err)
+		}
+	}
+	log.Printf("Imported the step-ca root from %q 📥", stepPath)
+	return nil
+}
+
+// parseStepKey returns the PKCS #8 encoding of a step-ca key, or nil if the
+// key is encrypted, as it is by default.
+func parseStepKey(keyPEM []byte) ([]byte, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("failed to read the step-ca root key: unexpected content")
+	}
+	if block.Type == "ENCRYPTED PRIVATE KEY" || block.Headers["Proc-Type"] != "" {
+		return nil, nil
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("failed to read the step-ca root key: unexpected %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the step-ca root key: %w", err)
+	}
+	return x509.MarshalPKCS8PrivateKey(key)
+}
+
+// exportStepCA shares the local CA with step-ca. If stepPath already has a
+// step-ca configuration, the local CA is added to it as the trust anchor of
+// an X5C provisioner, so mkcert certificates can be exchanged for step-ca
+// ones. Otherwise, the root and key are written where "step ca init" expects
+// them, so that the new step-ca chains to the local CA.
+func (m *mkcert) exportStepCA(stepPath string) error {
+	configPath := filepath.Join(stepPath, stepConfig)
+	if pathExists(configPath) {
+		return m.addStepProvisioner(configPath)
+	}
+	if m.ca.Key == nil {
+		return issuer.ErrNoCAKey
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(m.ca.Key)
+	if err != nil {
+		return fmt.Errorf("failed to encode CA key: %w", err)
+	}
+	certPath, keyPath := filepath.Join(stepPath, stepRootCert), filepath.Join(stepPath, stepRootKey)
+	for _, dir := range []string{filepath.Dir(certPath), filepath.Dir(keyPath)} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create %q: %w", dir, err)
+		}
+	}
+	if err := ioutil.WriteFile(certPath, pem.EncodeToMemory(
+		&pem.Block{Type: "CERTIFICATE", Bytes: m.ca.Cert.Raw}), 0644); err != nil {
+		return fmt.Errorf("failed to save CA certificate: %w", err)
+	}
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(
+		&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0400); err != nil {
+		return fmt.Errorf("failed to save CA key: %w", err)
+	}
+
+	log.Printf("The local CA was exported to %q 📤", stepPath)
+	log.Printf("Set up step-ca with an intermediate signed by it by running\n\n\tSTEPPATH=%q step ca init --root %q --key %q\n\n", stepPath, certPath, keyPath)
+	return nil
+}
+
+// addStepProvisioner adds (or updates) an X5C provisioner that trusts the
+// local CA to the step-ca configuration at configPath.
+func (m *mkcert) addStepProvisioner(configPath string) error {
+	data, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read the step-ca configuration: %w", err)
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("failed to parse the step-ca configuration: %w", err)
+	}
+	authority, _ := config["authority"].(map[string]interface{})
+	if authority == nil {
+		authority = make(map[string]interface{})
+		config["authority"] = authority
+	}
+	provisioners, _ := authority["provisioners"].([]interface{})
+
+	rootPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.ca.Cert.Raw})
+	provisioner := map[string]interface{}{
+		"type":  "X5C",
+		"name":  stepProvisioner,
+		"roots": base64.StdEncoding.EncodeToString(rootPEM),
+	}
+	found := false
+	for i, p := range provisioners {
+		if p, ok := p.(map[string]interface{}); ok && p["type"] == "X5C" && p["name"] == stepProvisioner {
+			provisioners[i], found = provisioner, true
+		}
+	}
+	if !found {
+		provisioners = append(provisioners, provisioner)
+	}
+	authority["provisioners"] = provisioners
+
+	data, err = json.MarshalIndent(config, "", "\t")
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(configPath)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(configPath, append(data, '\n'), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to save the step-ca configuration: %w", err)
+	}
+	log.Printf("The local CA is now the root of the %q X5C provisioner in %q, restart step-ca to apply it 🔗", stepProvisioner, configPath)
+	return nil
+}
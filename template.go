@@ -0,0 +1,120 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// templatesName is the file name of the optional named certificate
+// templates inside CAROOT, selected with -template.
+const templatesName = "templates.json"
+
+// A certTemplate bundles the options a team uses for a kind of certificate,
+// like "grpc" or "mtls-client", so that they don't have to be repeated as
+// flags. The flags passed along with -template are applied on top of it.
+type certTemplate struct {
+	// KeyType is "rsa", "ecdsa" or "ed25519".
+	KeyType string `json:"key_type,omitempty"`
+
+	// ExtKeyUsage, if not empty, replaces the Extended Key Usages mkcert
+	// picks from the names. Options are "server", "client", "email",
+	// "code-signing" and "time-stamping".
+	ExtKeyUsage []string `json:"ext_key_usage,omitempty"`
+
+	// LifetimeDays, if positive, replaces the default validity period.
+	LifetimeDays int `json:"lifetime_days,omitempty"`
+
+	// Format is "pem" or "pkcs12".
+	Format string `json:"format,omitempty"`
+}
+
+var templateEKUs = map[string]x509.ExtKeyUsage{
+	"server":        x509.ExtKeyUsageServerAuth,
+	"client":        x509.ExtKeyUsageClientAuth,
+	"email":         x509.ExtKeyUsageEmailProtection,
+	"code-signing":  x509.ExtKeyUsageCodeSigning,
+	"time-stamping": x509.ExtKeyUsageTimeStamping,
+}
+
+// loadTemplate loads the template called name from the CAROOT, and applies
+// its key type, lifetime and format to m, unless set with flags.
+func (m *mkcert) loadTemplate(name string) error {
+	path := filepath.Join(m.CAROOT, templatesName)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("-template %q needs a %s file in the CAROOT (%s)", name, templatesName, m.CAROOT)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the templates: %w", err)
+	}
+	var templates map[string]*certTemplate
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&templates); err != nil {
+		return fmt.Errorf("failed to parse the templates %s: %w", templatesName, err)
+	}
+	t, ok := templates[name]
+	if !ok || t == nil {
+		var names []string
+		for name := range templates {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown -template %q, the templates in %s are: %s", name, path, strings.Join(names, ", "))
+	}
+
+	switch t.KeyType {
+	case "", "rsa":
+	case "ecdsa":
+		m.ecdsa = true
+	case "ed25519":
+		m.ed25519 = true
+	default:
+		return fmt.Errorf("template %q: unknown key_type %q, options are rsa, ecdsa and ed25519", name, t.KeyType)
+	}
+	switch t.Format {
+	case "", "pem":
+	case "pkcs12":
+		m.pkcs12 = true
+	default:
+		return fmt.Errorf("template %q: unknown format %q, options are pem and pkcs12", name, t.Format)
+	}
+	for _, eku := range t.ExtKeyUsage {
+		if _, ok := templateEKUs[eku]; !ok {
+			return fmt.Errorf("template %q: unknown ext_key_usage %q, options are server, client, email, code-signing and time-stamping", name, eku)
+		}
+		if eku == "client" {
+			m.client = true
+		}
+	}
+	if t.LifetimeDays < 0 {
+		return fmt.Errorf("template %q: lifetime_days can't be negative", name)
+	}
+	if t.LifetimeDays > 0 && m.notAfter.IsZero() {
+		m.notAfter = time.Now().AddDate(0, 0, t.LifetimeDays)
+	}
+	m.certTemplate = t
+	return nil
+}
+
+// apply sets the Extended Key Usages of tpl from t.
+func (t *certTemplate) apply(tpl *x509.Certificate) {
+	if len(t.ExtKeyUsage) > 0 {
+		tpl.ExtKeyUsage = nil
+		for _, eku := range t.ExtKeyUsage {
+			tpl.ExtKeyUsage = append(tpl.ExtKeyUsage, templateEKUs[eku])
+		}
+	}
+}
@@ -0,0 +1,331 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package truststore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CmdFS runs the external commands and the file operations that inspect and
+// modify the trust stores. The zero value runs them with no timeout, each
+// privileged command with its own sudo invocation. A CmdFS must not be
+// copied after first use.
+type CmdFS struct {
+	// Timeout is the maximum duration of each command. A command that
+	// doesn't complete in time, for example because it's waiting for a
+	// password, is killed and a *TimeoutError is returned.
+	Timeout time.Duration
+
+	// Batch runs all privileged commands in a single sudo session, so the
+	// password is asked at most once, even if sudo doesn't cache it. The
+	// session lasts until Close is called.
+	Batch bool
+
+	// Reporter, if not nil, receives a warning if privileged commands need
+	// sudo, but it's not available.
+	Reporter Reporter
+
+	// FS, if not nil, replaces the local file system in ReadFile, WriteFile,
+	// MkdirTemp and Remove, for example to observe the changes to the trust
+	// stores in tests, or to make them in a container.
+	FS FileSystem
+
+	mu          sync.Mutex
+	shell       *sudoShell
+	sudoWarning sync.Once
+}
+
+// TimeoutError is returned when a command is killed after CmdFS.Timeout.
+type TimeoutError struct {
+	Cmd     string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%q did not complete within %s", e.Cmd, e.Timeout)
+}
+
+// Exec runs cmd and returns its combined standard output and standard error.
+// If ctx is done or the timeout expires first, the command is killed.
+func (c *CmdFS) Exec(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	var timeout <-chan time.Time
+	if c.Timeout > 0 {
+		t := time.NewTimer(c.Timeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	// After killing the command, don't wait for it, as any process it
+	// spawned might keep the output pipe open.
+	select {
+	case err := <-done:
+		return out.Bytes(), err
+	case <-timeout:
+		cmd.Process.Kill()
+		return nil, &TimeoutError{Cmd: strings.Join(cmd.Args, " "), Timeout: c.Timeout}
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		return nil, ctx.Err()
+	}
+}
+
+// FileSystem is the file operations of a CmdFS. Its methods behave like
+// the os functions with the same names, except that Remove also removes
+// any children, and removing a missing path is not an error.
+type FileSystem interface {
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	MkdirTemp(dir, pattern string) (string, error)
+	Remove(name string) error
+}
+
+// osFS is the FileSystem used when CmdFS.FS is nil.
+type osFS struct{}
+
+func (osFS) ReadFile(name string) ([]byte, error) { return ioutil.ReadFile(name) }
+
+func (osFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(name, data, perm)
+}
+
+func (osFS) MkdirTemp(dir, pattern string) (string, error) { return ioutil.TempDir(dir, pattern) }
+
+func (osFS) Remove(name string) error { return os.RemoveAll(name) }
+
+func (c *CmdFS) fs() FileSystem {
+	if c.FS != nil {
+		return c.FS
+	}
+	return osFS{}
+}
+
+// ReadFile reads the named file.
+func (c *CmdFS) ReadFile(name string) ([]byte, error) {
+	return c.fs().ReadFile(name)
+}
+
+// WriteFile writes data to the named file, creating it with perm if needed.
+// Files that need root to be written are written with SudoExec instead.
+func (c *CmdFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return c.fs().WriteFile(name, data, perm)
+}
+
+// MkdirTemp creates a new temporary directory in dir (or in the default
+// temporary directory if empty) and returns its path.
+func (c *CmdFS) MkdirTemp(dir, pattern string) (string, error) {
+	return c.fs().MkdirTemp(dir, pattern)
+}
+
+// Remove removes the named file or directory, including any children.
+// Removing a missing path is not an error.
+func (c *CmdFS) Remove(name string) error {
+	return c.fs().Remove(name)
+}
+
+// SudoExec is like Exec, but runs cmd as root, through sudo if mkcert is
+// not running as root already. The command's Stdin, Env and Dir are kept.
+func (c *CmdFS) SudoExec(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	if c.Batch && c.needsSudo() {
+		return c.batchExec(ctx, cmd)
+	}
+	sudo := c.commandWithSudo(append([]string{cmd.Path}, cmd.Args[1:]...)...)
+	sudo.Stdin, sudo.Env, sudo.Dir = cmd.Stdin, cmd.Env, cmd.Dir
+	return c.Exec(ctx, sudo)
+}
+
+// Close ends the sudo session started in Batch mode, if any.
+func (c *CmdFS) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.shell != nil {
+		c.shell.close()
+		c.shell = nil
+	}
+	return nil
+}
+
+func (c *CmdFS) batchExec(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.shell == nil {
+		shell, err := startSudoShell()
+		if err != nil {
+			return nil, err
+		}
+		c.shell = shell
+	}
+	// The goroutine gets its own reference to the shell, as c.shell is
+	// cleared below if the command times out while it's still running.
+	shell := c.shell
+
+	type result struct {
+		out []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := shell.run(cmd)
+		done <- result{out, err}
+	}()
+
+	var timeout <-chan time.Time
+	if c.Timeout > 0 {
+		t := time.NewTimer(c.Timeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case r := <-done:
+		if errors.Is(r.err, errSessionEnded) {
+			shell.kill()
+			c.shell = nil
+		}
+		return r.out, r.err
+	case <-timeout:
+		shell.kill()
+		c.shell = nil
+		return nil, &TimeoutError{Cmd: strings.Join(cmd.Args, " "), Timeout: c.Timeout}
+	case <-ctx.Done():
+		shell.kill()
+		c.shell = nil
+		return nil, ctx.Err()
+	}
+}
+
+var errSessionEnded = errors.New("the sudo session ended")
+
+// A sudoShell is a root shell started with a single sudo invocation, which
+// runs all the privileged commands of a CmdFS in Batch mode.
+type sudoShell struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	marker string
+}
+
+func startSudoShell() (*sudoShell, error) {
+	marker := make([]byte, 16)
+	if _, err := rand.Read(marker); err != nil {
+		return nil, err
+	}
+	sh := &sudoShell{marker: "mkcert-" + hex.EncodeToString(marker)}
+	sh.cmd = exec.Command("sudo", "--prompt=Sudo password:", "--", "/bin/sh")
+	sh.cmd.Stderr = os.Stderr
+	stdin, err := sh.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := sh.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	sh.stdin, sh.stdout = stdin, bufio.NewReader(stdout)
+	if err := sh.cmd.Start(); err != nil {
+		return nil, err
+	}
+	return sh, nil
+}
+
+// run executes cmd in the shell, and returns its combined output.
+func (sh *sudoShell) run(cmd *exec.Cmd) ([]byte, error) {
+	var script strings.Builder
+	script.WriteString("(")
+	if cmd.Dir != "" {
+		fmt.Fprintf(&script, "cd %s && ", shellQuote(cmd.Dir))
+	}
+	script.WriteString("exec")
+	if cmd.Env != nil {
+		script.WriteString(" env")
+		for _, kv := range cmd.Env {
+			script.WriteString(" " + shellQuote(kv))
+		}
+	}
+	script.WriteString(" " + shellQuote(cmd.Path))
+	for _, arg := range cmd.Args[1:] {
+		script.WriteString(" " + shellQuote(arg))
+	}
+	script.WriteString(") 2>&1")
+
+	// The shell's stdin is the script itself, so pass any input through a
+	// temporary file.
+	if cmd.Stdin != nil {
+		input, err := ioutil.ReadAll(cmd.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		f, err := ioutil.TempFile("", "mkcert-stdin-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(f.Name())
+		_, err = f.Write(input)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&script, " < %s", shellQuote(f.Name()))
+	} else {
+		script.WriteString(" < /dev/null")
+	}
+	fmt.Fprintf(&script, "; printf '\\n%s %%d\\n' $?\n", sh.marker)
+
+	if _, err := io.WriteString(sh.stdin, script.String()); err != nil {
+		return nil, fmt.Errorf("the sudo session ended: %w", err)
+	}
+	var out []byte
+	for {
+		line, err := sh.stdout.ReadBytes('\n')
+		if err != nil {
+			return out, fmt.Errorf("%w: %v", errSessionEnded, err)
+		}
+		if status := strings.TrimPrefix(string(line), sh.marker+" "); status != string(line) {
+			out = bytes.TrimSuffix(out, []byte("\n"))
+			code, _ := strconv.Atoi(strings.TrimSpace(status))
+			if code != 0 {
+				return out, fmt.Errorf("exit status %d", code)
+			}
+			return out, nil
+		}
+		out = append(out, line...)
+	}
+}
+
+func (sh *sudoShell) close() {
+	sh.stdin.Close()
+	sh.cmd.Wait()
+}
+
+func (sh *sudoShell) kill() {
+	sh.cmd.Process.Kill()
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package truststore
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+)
+
+// memFS is a FileSystem in memory.
+type memFS map[string][]byte
+
+func (m memFS) ReadFile(name string) ([]byte, error) {
+	data, ok := m[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return data, nil
+}
+
+func (m memFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	m[name] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m memFS) MkdirTemp(dir, pattern string) (string, error) {
+	return "/tmp/" + pattern, nil
+}
+
+func (m memFS) Remove(name string) error {
+	for path := range m {
+		if path == name || strings.HasPrefix(path, name+"/") {
+			delete(m, path)
+		}
+	}
+	return nil
+}
+
+func TestCmdFSFileSystem(t *testing.T) {
+	files := memFS{
+		"/caroot/rootCA.pem": []byte("ROOT\n"),
+		"/etc/bundle.pem":    []byte("EXISTING"),
+	}
+	s := &Store{RootPath: "/caroot/rootCA.pem", CmdFS: &CmdFS{FS: files}}
+	deno := envStores[0]
+	t.Setenv(deno.envVar, "/etc/bundle.pem")
+
+	var envErr *EnvError
+	if err := s.installEnv(deno); !errors.As(err, &envErr) || envErr.Bundle != "/caroot/deno-ca-bundle.pem" {
+		t.Fatalf("installEnv: got %v, want an EnvError for the new bundle", err)
+	}
+	if got := string(files["/caroot/deno-ca-bundle.pem"]); got != "EXISTING\nROOT\n" {
+		t.Errorf("got bundle %q, want the existing one followed by the root", got)
+	}
+
+	t.Setenv(deno.envVar, "/etc/missing.pem")
+	if err := s.installEnv(deno); !errors.As(err, &envErr) || envErr.Bundle != s.RootPath {
+		t.Errorf("installEnv with a missing bundle: got %v, want an EnvError for the root", err)
+	}
+
+	if err := s.uninstallEnv(deno); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := files["/caroot/deno-ca-bundle.pem"]; ok {
+		t.Error("uninstallEnv didn't remove the bundle")
+	}
+	if len(files) != 2 {
+		t.Errorf("got files %v, want only the original ones", files)
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package truststore
+
+import "strings"
+
+// A Distro is a family of Linux distributions sharing the same system trust
+// store layout.
+type Distro struct {
+	// Family is the name of the family, as in the Ansible os_family fact.
+	Family string
+	// AnchorDir is where extra roots are placed.
+	AnchorDir string
+	// Ext is the file extension expected in AnchorDir.
+	Ext string
+	// Command regenerates the system trust store from AnchorDir.
+	Command []string
+}
+
+// Distros are the Linux distribution families whose system trust store is
+// supported, in the order they are detected.
+var Distros = []Distro{
+	{"RedHat", "/etc/pki/ca-trust/source/anchors", ".pem", []string{"update-ca-trust", "extract"}},
+	{"Debian", "/usr/local/share/ca-certificates", ".crt", []string{"update-ca-certificates"}},
+	{"Alpine", "/usr/local/share/ca-certificates", ".crt", []string{"update-ca-certificates"}},
+	{"Archlinux", "/etc/ca-certificates/trust-source/anchors", ".crt", []string{"trust", "extract-compat"}},
+	{"Suse", "/usr/share/pki/trust/anchors", ".pem", []string{"update-ca-certificates"}},
+}
+
+// SystemTrustName is the name, without extension, of the root file in the
+// AnchorDir of a Linux system trust store.
+func (s *Store) SystemTrustName() string {
+	return strings.Replace(s.uniqueName(), " ", "_", -1)
+}
@@ -0,0 +1,113 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package truststore
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// An envStore is a runtime that trusts neither the system nor the NSS trust
+// stores by default, but loads extra roots from a PEM bundle named by an
+// environment variable.
+type envStore struct {
+	name, binary, envVar string
+}
+
+var envStores = []*envStore{
+	{name: "deno", binary: "deno", envVar: "DENO_CERT"},
+	{name: "bun", binary: "bun", envVar: "NODE_EXTRA_CA_CERTS"},
+}
+
+// detectEnvStores returns the names of the envStores that are installed.
+func detectEnvStores() map[string]bool {
+	found := make(map[string]bool)
+	for _, e := range envStores {
+		found[e.name] = binaryExists(e.binary) ||
+			binaryExists(filepath.Join(os.Getenv("HOME"), "."+e.binary, "bin", e.binary))
+	}
+	return found
+}
+
+// EnvError is returned by Install and Uninstall for runtimes that can only
+// be configured through an environment variable, which is left to the user.
+type EnvError struct {
+	// Var is the environment variable, such as DENO_CERT.
+	Var string
+	// Bundle is the PEM bundle Var should be set to. If empty, Var should be
+	// unset instead.
+	Bundle string
+}
+
+func (e *EnvError) Error() string {
+	if e.Bundle == "" {
+		return fmt.Sprintf("unset %s", e.Var)
+	}
+	return fmt.Sprintf("set %s=%q", e.Var, e.Bundle)
+}
+
+// bundlePath is where a combined bundle is written when the user already
+// points envVar at a bundle without the root.
+func (s *Store) bundlePath(e *envStore) string {
+	return filepath.Join(filepath.Dir(s.RootPath), e.name+"-ca-bundle.pem")
+}
+
+func (s *Store) checkEnv(e *envStore) bool {
+	if e.name == "deno" && strings.Contains(os.Getenv("DENO_TLS_CA_STORE"), "system") && s.checkPlatform() {
+		return true
+	}
+	bundle, err := s.cmdFS().ReadFile(os.Getenv(e.envVar))
+	if err != nil {
+		return false
+	}
+	for _, cert := range parseCertificates(bundle) {
+		if cert.Equal(s.Root) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) installEnv(e *envStore) error {
+	existing := os.Getenv(e.envVar)
+	if existing == "" || existing == s.bundlePath(e) {
+		return &EnvError{Var: e.envVar, Bundle: s.RootPath}
+	}
+
+	// Don't lose the roots the user already trusts, as the variable can only
+	// name a single file.
+	bundle, err := s.cmdFS().ReadFile(existing)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &EnvError{Var: e.envVar, Bundle: s.RootPath}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", e.envVar, err)
+	}
+	root, err := s.cmdFS().ReadFile(s.RootPath)
+	if err != nil {
+		return fmt.Errorf("failed to read root certificate: %w", err)
+	}
+	if len(bundle) > 0 && bundle[len(bundle)-1] != '\n' {
+		bundle = append(bundle, '\n')
+	}
+	if err := s.cmdFS().WriteFile(s.bundlePath(e), append(bundle, root...), 0644); err != nil {
+		return fmt.Errorf("failed to save CA bundle: %w", err)
+	}
+	return &EnvError{Var: e.envVar, Bundle: s.bundlePath(e)}
+}
+
+func (s *Store) uninstallEnv(e *envStore) error {
+	if err := s.cmdFS().Remove(s.bundlePath(e)); err != nil {
+		return fmt.Errorf("failed to remove CA bundle: %w", err)
+	}
+	if v := os.Getenv(e.envVar); v == s.RootPath || v == s.bundlePath(e) {
+		return &EnvError{Var: e.envVar}
+	}
+	return nil
+}
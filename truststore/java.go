@@ -0,0 +1,419 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package truststore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const storePass = "changeit"
+
+// javaState is what detectJava found out about Java on this machine.
+type javaState struct {
+	// found is set if $JAVA_HOME is set or JetBrains Runtimes are present.
+	found bool
+	// runtimes are the Java installations with a keytool or a cacerts
+	// keystore, starting with $JAVA_HOME, followed by any JetBrains Runtimes.
+	runtimes []*JavaRuntime
+}
+
+// A JavaRuntime is a JDK or JRE with its own cacerts keystore.
+type JavaRuntime struct {
+	// Home is the root of the installation, like $JAVA_HOME.
+	Home string
+	// KeytoolPath is the keytool binary used to modify the keystores that
+	// mkcert can't modify itself. It's empty if the runtime has none, like
+	// some JREs.
+	KeytoolPath string
+	// CacertsPath is the cacerts keystore.
+	CacertsPath string
+	// Version is the JAVA_VERSION from the release file in Home, if any.
+	Version string
+	// StoreType is the keystore type of CacertsPath, "JKS", "PKCS12" or
+	// "BCFKS" for the FIPS keystores of the Bouncy Castle FIPS provider.
+	// It's detected from the file if empty.
+	StoreType string
+	// ProviderArgs are passed to keytool to load the security provider of
+	// the keystore, like -providername, -providerclass and -providerpath for
+	// BCFKS. If empty, they are read from $KEYTOOL_PROVIDER_ARGS, split on
+	// spaces.
+	ProviderArgs []string
+}
+
+// newJavaRuntime fills in the empty fields of r from r.Home, and returns nil
+// if the runtime has neither a keytool nor a cacerts keystore.
+func newJavaRuntime(r JavaRuntime) *JavaRuntime {
+	if r.KeytoolPath == "" {
+		if runtime.GOOS == "windows" {
+			r.KeytoolPath = filepath.Join(r.Home, "bin", "keytool.exe")
+		} else {
+			r.KeytoolPath = filepath.Join(r.Home, "bin", "keytool")
+		}
+	}
+	if !pathExists(r.KeytoolPath) {
+		r.KeytoolPath = ""
+	}
+
+	if r.CacertsPath == "" {
+		if pathExists(filepath.Join(r.Home, "lib", "security", "cacerts")) {
+			r.CacertsPath = filepath.Join(r.Home, "lib", "security", "cacerts")
+		}
+
+		if pathExists(filepath.Join(r.Home, "jre", "lib", "security", "cacerts")) {
+			r.CacertsPath = filepath.Join(r.Home, "jre", "lib", "security", "cacerts")
+		}
+	}
+
+	if r.KeytoolPath == "" && r.CacertsPath == "" {
+		return nil
+	}
+
+	if r.StoreType == "" && r.CacertsPath != "" {
+		r.StoreType = keystoreType(r.CacertsPath)
+	}
+	if r.ProviderArgs == nil {
+		r.ProviderArgs = strings.Fields(os.Getenv("KEYTOOL_PROVIDER_ARGS"))
+	}
+
+	if r.Version == "" {
+		release, _ := ioutil.ReadFile(filepath.Join(r.Home, "release"))
+		for _, line := range strings.Split(string(release), "\n") {
+			if v := strings.TrimPrefix(line, "JAVA_VERSION="); v != line {
+				r.Version = strings.Trim(strings.TrimSpace(v), `"`)
+			}
+		}
+	}
+
+	return &r
+}
+
+// keystoreType detects the type of the keystore at path from its first
+// bytes, or returns "" to let keytool figure it out.
+func keystoreType(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	var header [8]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil {
+		return ""
+	}
+	switch {
+	case bytes.Equal(header[:4], []byte{0xfe, 0xed, 0xfe, 0xed}):
+		return "JKS"
+	case bytes.Equal(header[:4], []byte{0xce, 0xce, 0xce, 0xce}):
+		return "JCEKS"
+	case header[0] != 0x30:
+		return ""
+	}
+	// Both PKCS #12 and BCFKS files are DER SEQUENCEs, but a PFX starts with
+	// the version INTEGER 3, and a BCFKS ObjectStore with another SEQUENCE.
+	// Skip the length of the outer SEQUENCE to find the first element.
+	i := 2
+	if header[1]&0x80 != 0 {
+		i += int(header[1] & 0x7f)
+	}
+	if i+2 < len(header) && header[i] == 0x02 && header[i+1] == 0x01 && header[i+2] == 0x03 {
+		return "PKCS12"
+	}
+	if i < len(header) && header[i] == 0x30 {
+		return "BCFKS"
+	}
+	return ""
+}
+
+// keystoreArgs returns the keytool arguments to open the cacerts keystore.
+func (r *JavaRuntime) keystoreArgs() []string {
+	args := []string{"-keystore", r.CacertsPath, "-storepass", storePass}
+	if r.StoreType != "" {
+		args = append(args, "-storetype", r.StoreType)
+	}
+	return append(args, r.ProviderArgs...)
+}
+
+// keytoolErr explains the common keytool failures on hardened or FIPS
+// configurations, or returns a CmdError.
+func (r *JavaRuntime) keytoolErr(err error, cmd string, out []byte) error {
+	switch {
+	case r.StoreType == "BCFKS" && len(r.ProviderArgs) == 0:
+		return fmt.Errorf("%s is a FIPS (BCFKS) keystore, set $KEYTOOL_PROVIDER_ARGS to the -providername, -providerclass and -providerpath arguments of keytool for the Bouncy Castle FIPS provider", r.CacertsPath)
+	case bytes.Contains(out, []byte("password was incorrect")):
+		return fmt.Errorf("failed to open %s: the keystore password is not the default %q", r.CacertsPath, storePass)
+	case bytes.Contains(out, []byte("KeyStoreException")) && bytes.Contains(out, []byte("not found")):
+		return fmt.Errorf("failed to open %s: keytool doesn't support %s keystores, check the security providers of the runtime or $KEYTOOL_PROVIDER_ARGS: %w", r.CacertsPath, r.StoreType, cmdErr(err, cmd, out))
+	}
+	return cmdErr(err, cmd, out)
+}
+
+// jetBrainsRuntimes returns the JetBrains Runtimes bundled with IDEs or
+// downloaded by them. IntelliJ's HTTP client and the Gradle daemons started
+// by the IDE use these, and not $JAVA_HOME.
+func jetBrainsRuntimes() []string {
+	home, _ := os.UserHomeDir()
+	var patterns []string
+	switch runtime.GOOS {
+	case "darwin":
+		patterns = []string{
+			"/Applications/*.app/Contents/jbr/Contents/Home",
+			filepath.Join(home, "Applications/*.app/Contents/jbr/Contents/Home"),
+			filepath.Join(home, "Library/Application Support/JetBrains/Toolbox/apps/*/ch-*/*/*.app/Contents/jbr/Contents/Home"),
+			filepath.Join(home, "Library/Java/JavaVirtualMachines/jbr*/Contents/Home"),
+		}
+	case "windows":
+		patterns = []string{
+			filepath.Join(os.Getenv("ProgramFiles"), "JetBrains", "*", "jbr"),
+			filepath.Join(os.Getenv("LocalAppData"), "Programs", "*", "jbr"),
+			filepath.Join(os.Getenv("LocalAppData"), "JetBrains", "Toolbox", "apps", "*", "ch-*", "*", "jbr"),
+			filepath.Join(home, ".jdks", "jbr*"),
+		}
+	default:
+		patterns = []string{
+			"/opt/*/jbr",
+			filepath.Join(home, ".local/share/JetBrains/Toolbox/apps/*/jbr"),
+			filepath.Join(home, ".local/share/JetBrains/Toolbox/apps/*/ch-*/*/jbr"),
+			filepath.Join(home, ".jdks/jbr*"),
+		}
+	}
+	var homes []string
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		homes = append(homes, matches...)
+	}
+	return homes
+}
+
+// DetectJava returns the Java runtimes whose keystores Install and Uninstall
+// modify: s.Java if set, or $JAVA_HOME followed by any JetBrains Runtimes.
+func (s *Store) DetectJava() []JavaRuntime {
+	var runtimes []JavaRuntime
+	for _, r := range s.detect().java.runtimes {
+		runtimes = append(runtimes, *r)
+	}
+	return runtimes
+}
+
+func (s *Store) detectJava() javaState {
+	var st javaState
+	if s.Java != nil {
+		st.found = true
+		for _, r := range s.Java {
+			if r := newJavaRuntime(r); r != nil {
+				st.runtimes = append(st.runtimes, r)
+			}
+		}
+		return st
+	}
+
+	seen := make(map[string]bool)
+	addRuntime := func(home string) {
+		home = filepath.Clean(home)
+		if seen[home] {
+			return
+		}
+		seen[home] = true
+		if r := newJavaRuntime(JavaRuntime{Home: home}); r != nil {
+			st.runtimes = append(st.runtimes, r)
+		}
+	}
+
+	if v := os.Getenv("JAVA_HOME"); v != "" {
+		st.found = true
+		addRuntime(v)
+	}
+
+	for _, home := range jetBrainsRuntimes() {
+		st.found = true
+		addRuntime(home)
+	}
+	return st
+}
+
+func (s *Store) checkJava(ctx context.Context) (bool, error) {
+	if len(s.detect().java.runtimes) == 0 {
+		return false, nil
+	}
+	for _, r := range s.detect().java.runtimes {
+		ok, err := s.checkJavaRuntime(ctx, r)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// loadKeystore reads the cacerts keystore of r. It returns an error wrapping
+// errKeystoreUnsupported if the keystore has to be modified with keytool.
+func (s *Store) loadKeystore(r *JavaRuntime) (keystore, error) {
+	if r.CacertsPath == "" || len(r.ProviderArgs) > 0 {
+		return nil, errKeystoreUnsupported
+	}
+	data, err := s.cmdFS().ReadFile(r.CacertsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", r.CacertsPath, err)
+	}
+	ks, err := parseKeystore(r.StoreType, data)
+	if err != nil && !errors.Is(err, errKeystoreUnsupported) {
+		return nil, fmt.Errorf("failed to open %s: %w", r.CacertsPath, err)
+	}
+	return ks, err
+}
+
+// writeKeystore writes ks to the cacerts of r, through sudo if needed.
+func (s *Store) writeKeystore(ctx context.Context, r *JavaRuntime, ks keystore) error {
+	data, err := ks.marshal()
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", r.CacertsPath, err)
+	}
+	err = s.cmdFS().WriteFile(r.CacertsPath, data, 0644)
+	if os.IsPermission(err) && runtime.GOOS != "windows" {
+		cmd := exec.Command("tee", r.CacertsPath)
+		cmd.Stdin = bytes.NewReader(data)
+		out, err := s.cmdFS().SudoExec(ctx, cmd)
+		return cmdErr(err, "tee "+r.CacertsPath, out)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", r.CacertsPath, err)
+	}
+	return nil
+}
+
+// needKeytool returns an error if r has no keytool to fall back to, after
+// loadKeystore failed with err.
+func (r *JavaRuntime) needKeytool(err error) error {
+	if r.KeytoolPath == "" {
+		return fmt.Errorf("%w, and %s can't be modified without it (%v)", ErrNoKeytool, r.CacertsPath, err)
+	}
+	return nil
+}
+
+func (s *Store) checkJavaRuntime(ctx context.Context, r *JavaRuntime) (bool, error) {
+	ks, err := s.loadKeystore(r)
+	if err == nil {
+		return containsCert(ks, s.Root), nil
+	}
+	if !errors.Is(err, errKeystoreUnsupported) {
+		return false, err
+	}
+	if err := r.needKeytool(err); err != nil {
+		return false, err
+	}
+
+	// exists returns true if the given x509.Certificate's fingerprint
+	// is in the keytool -list output
+	exists := func(c *x509.Certificate, h hash.Hash, keytoolOutput []byte) bool {
+		h.Write(c.Raw)
+		fp := strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+		return bytes.Contains(keytoolOutput, []byte(fp))
+	}
+
+	keytoolOutput, err := s.cmdFS().Exec(ctx, exec.Command(r.KeytoolPath, append([]string{"-list"}, r.keystoreArgs()...)...))
+	if err != nil {
+		return false, r.keytoolErr(err, "keytool -list", keytoolOutput)
+	}
+	// keytool outputs SHA1 and SHA256 (Java 9+) certificates in uppercase hex
+	// with each octet pair delimitated by ":". Drop them from the keytool output
+	keytoolOutput = bytes.Replace(keytoolOutput, []byte(":"), nil, -1)
+
+	// pre-Java 9 uses SHA1 fingerprints
+	s1, s256 := sha1.New(), sha256.New()
+	return exists(s.Root, s1, keytoolOutput) || exists(s.Root, s256, keytoolOutput), nil
+}
+
+func (s *Store) installJava(ctx context.Context) error {
+	for _, r := range s.detect().java.runtimes {
+		if ok, err := s.checkJavaRuntime(ctx, r); err != nil {
+			return err
+		} else if ok {
+			continue
+		}
+
+		if ks, err := s.loadKeystore(r); err == nil {
+			if err := ks.add(s.uniqueName(), s.Root); err != nil {
+				return err
+			}
+			if err := s.writeKeystore(ctx, r, ks); err != nil {
+				return err
+			}
+			continue
+		} else if !errors.Is(err, errKeystoreUnsupported) {
+			return err
+		} else if err := r.needKeytool(err); err != nil {
+			return err
+		}
+
+		args := []string{
+			"-importcert", "-noprompt",
+			"-file", s.RootPath,
+			"-alias", s.uniqueName(),
+		}
+
+		out, err := s.execKeytool(ctx, r, exec.Command(r.KeytoolPath, append(args, r.keystoreArgs()...)...))
+		if err != nil {
+			return r.keytoolErr(err, "keytool -importcert", out)
+		}
+	}
+	return nil
+}
+
+func (s *Store) uninstallJava(ctx context.Context) error {
+	for _, r := range s.detect().java.runtimes {
+		if ks, err := s.loadKeystore(r); err == nil {
+			if !ks.remove(s.Root) {
+				continue
+			}
+			if err := s.writeKeystore(ctx, r, ks); err != nil {
+				return err
+			}
+			continue
+		} else if !errors.Is(err, errKeystoreUnsupported) {
+			return err
+		} else if err := r.needKeytool(err); err != nil {
+			return err
+		}
+
+		args := []string{
+			"-delete",
+			"-alias", s.uniqueName(),
+		}
+		out, err := s.execKeytool(ctx, r, exec.Command(r.KeytoolPath, append(args, r.keystoreArgs()...)...))
+		if bytes.Contains(out, []byte("does not exist")) {
+			continue // cert didn't exist
+		}
+		if err != nil {
+			return r.keytoolErr(err, "keytool -delete", out)
+		}
+	}
+	return nil
+}
+
+// execKeytool will execute a "keytool" command and if needed re-execute
+// the command with sudo to work around file permissions.
+func (s *Store) execKeytool(ctx context.Context, r *JavaRuntime, cmd *exec.Cmd) ([]byte, error) {
+	out, err := s.cmdFS().Exec(ctx, cmd)
+	if err != nil && bytes.Contains(out, []byte("java.io.FileNotFoundException")) && runtime.GOOS != "windows" {
+		cmd = exec.Command(cmd.Path, cmd.Args[1:]...)
+		cmd.Env = []string{
+			"JAVA_HOME=" + r.Home,
+		}
+		out, err = s.cmdFS().SudoExec(ctx, cmd)
+	}
+	return out, err
+}
@@ -0,0 +1,564 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package truststore
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// The Java cacerts keystore is read and written natively when possible, so
+// that the root can be installed without starting a JVM, and in runtimes
+// that ship only a JRE without keytool. keytool remains the fallback for the
+// keystores that can't be handled here, like BCFKS, JCEKS, and password
+// protected PKCS #12 files.
+
+// errKeystoreUnsupported is returned by loadKeystore when keytool has to be
+// used instead.
+var errKeystoreUnsupported = errors.New("unsupported keystore")
+
+// A keystore is a Java trust store loaded in memory.
+type keystore interface {
+	// certs returns the trusted certificates in the keystore.
+	certs() []*x509.Certificate
+	// add adds cert as a trusted certificate with the given alias.
+	add(alias string, cert *x509.Certificate) error
+	// remove removes all the entries for cert, and reports whether any were
+	// found.
+	remove(cert *x509.Certificate) bool
+	marshal() ([]byte, error)
+}
+
+// parseKeystore parses the keystore of type storeType ("JKS" or "PKCS12").
+func parseKeystore(storeType string, data []byte) (keystore, error) {
+	switch storeType {
+	case "JKS":
+		return parseJKS(data, storePass)
+	case "PKCS12":
+		return parsePKCS12Keystore(data)
+	}
+	return nil, errKeystoreUnsupported
+}
+
+func containsCert(ks keystore, cert *x509.Certificate) bool {
+	for _, c := range ks.certs() {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+// JKS is the proprietary format of the Sun provider, used for cacerts until
+// Java 9. See sun.security.provider.JavaKeyStore.
+
+var jksMagic = []byte{0xfe, 0xed, 0xfe, 0xed}
+
+const (
+	jksPrivateKeyTag  = 1
+	jksTrustedCertTag = 2
+)
+
+type jksKeystore struct {
+	password string
+	entries  []jksEntry
+}
+
+type jksEntry struct {
+	// raw is the encoded entry, starting with the tag, so that private key
+	// entries and unknown certificate types are written back as they were.
+	raw  []byte
+	cert *x509.Certificate // only for trusted certificate entries
+}
+
+// parseJKS parses a JKS keystore, checking its integrity with password.
+func parseJKS(data []byte, password string) (*jksKeystore, error) {
+	if len(data) < 12+sha1.Size || !bytes.Equal(data[:4], jksMagic) {
+		return nil, errors.New("not a JKS keystore")
+	}
+	if version := binary.BigEndian.Uint32(data[4:]); version != 2 {
+		return nil, fmt.Errorf("%w: JKS version %d", errKeystoreUnsupported, version)
+	}
+	ks := &jksKeystore{password: password}
+	body, digest := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	if !bytes.Equal(ks.digest(body), digest) {
+		if password == storePass {
+			return nil, fmt.Errorf("the keystore password is not the default %q, or the file is corrupted", storePass)
+		}
+		return nil, errors.New("the keystore password is wrong, or the file is corrupted")
+	}
+
+	r := bytes.NewReader(body[12:])
+	count := binary.BigEndian.Uint32(data[8:])
+	for i := uint32(0); i < count; i++ {
+		start := len(body) - 12 - r.Len()
+		var tag uint32
+		if err := binary.Read(r, binary.BigEndian, &tag); err != nil {
+			return nil, errJKSTruncated
+		}
+		if _, err := readJKSUTF(r); err != nil { // alias
+			return nil, err
+		}
+		if _, err := r.Seek(8, io.SeekCurrent); err != nil { // timestamp
+			return nil, errJKSTruncated
+		}
+		var e jksEntry
+		switch tag {
+		case jksPrivateKeyTag:
+			if _, err := readJKSBytes(r); err != nil { // encrypted key
+				return nil, err
+			}
+			var chain uint32
+			if err := binary.Read(r, binary.BigEndian, &chain); err != nil {
+				return nil, errJKSTruncated
+			}
+			for j := uint32(0); j < chain; j++ {
+				if _, _, err := readJKSCert(r); err != nil {
+					return nil, err
+				}
+			}
+		case jksTrustedCertTag:
+			certType, der, err := readJKSCert(r)
+			if err != nil {
+				return nil, err
+			}
+			if certType == "X.509" {
+				e.cert, _ = x509.ParseCertificate(der)
+			}
+		default:
+			return nil, fmt.Errorf("%w: JKS entry tag %d", errKeystoreUnsupported, tag)
+		}
+		end := len(body) - 12 - r.Len()
+		e.raw = body[12+start : 12+end]
+		ks.entries = append(ks.entries, e)
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("failed to parse JKS keystore: trailing data")
+	}
+	return ks, nil
+}
+
+var errJKSTruncated = errors.New("failed to parse JKS keystore: truncated entry")
+
+// readJKSUTF reads a string written by DataOutputStream.writeUTF.
+func readJKSUTF(r *bytes.Reader) (string, error) {
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return "", errJKSTruncated
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", errJKSTruncated
+	}
+	return string(b), nil
+}
+
+func readJKSBytes(r *bytes.Reader) ([]byte, error) {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil || int64(n) > int64(r.Len()) {
+		return nil, errJKSTruncated
+	}
+	b := make([]byte, n)
+	io.ReadFull(r, b)
+	return b, nil
+}
+
+func readJKSCert(r *bytes.Reader) (string, []byte, error) {
+	certType, err := readJKSUTF(r)
+	if err != nil {
+		return "", nil, err
+	}
+	der, err := readJKSBytes(r)
+	return certType, der, err
+}
+
+// writeJKSUTF writes s like DataOutputStream.writeUTF, which matches UTF-8
+// for the aliases mkcert uses.
+func writeJKSUTF(b *bytes.Buffer, s string) {
+	binary.Write(b, binary.BigEndian, uint16(len(s)))
+	b.WriteString(s)
+}
+
+// digest computes the integrity check of a JKS keystore, a SHA-1 hash of the
+// password as UTF-16, the string "Mighty Aphrodite", and the keystore.
+func (ks *jksKeystore) digest(body []byte) []byte {
+	h := sha1.New()
+	h.Write(utf16BE(ks.password))
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+func (ks *jksKeystore) certs() []*x509.Certificate {
+	var certs []*x509.Certificate
+	for _, e := range ks.entries {
+		if e.cert != nil {
+			certs = append(certs, e.cert)
+		}
+	}
+	return certs
+}
+
+func (ks *jksKeystore) add(alias string, cert *x509.Certificate) error {
+	b := &bytes.Buffer{}
+	binary.Write(b, binary.BigEndian, uint32(jksTrustedCertTag))
+	// The Sun provider only finds lowercase aliases.
+	writeJKSUTF(b, strings.ToLower(alias))
+	binary.Write(b, binary.BigEndian, time.Now().UnixNano()/int64(time.Millisecond))
+	writeJKSUTF(b, "X.509")
+	binary.Write(b, binary.BigEndian, uint32(len(cert.Raw)))
+	b.Write(cert.Raw)
+	ks.entries = append(ks.entries, jksEntry{raw: b.Bytes(), cert: cert})
+	return nil
+}
+
+func (ks *jksKeystore) remove(cert *x509.Certificate) bool {
+	var entries []jksEntry
+	for _, e := range ks.entries {
+		if e.cert == nil || !e.cert.Equal(cert) {
+			entries = append(entries, e)
+		}
+	}
+	found := len(entries) != len(ks.entries)
+	ks.entries = entries
+	return found
+}
+
+func (ks *jksKeystore) marshal() ([]byte, error) {
+	b := &bytes.Buffer{}
+	b.Write(jksMagic)
+	binary.Write(b, binary.BigEndian, uint32(2))
+	binary.Write(b, binary.BigEndian, uint32(len(ks.entries)))
+	for _, e := range ks.entries {
+		b.Write(e.raw)
+	}
+	b.Write(ks.digest(b.Bytes()))
+	return b.Bytes(), nil
+}
+
+// oidJKSKeyProtector identifies the proprietary key encryption of the Sun
+// provider, see sun.security.provider.KeyProtector.
+var oidJKSKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+// MarshalJKS returns a JKS keystore with a single private key entry under
+// alias, holding key and chain, leaf first. Both the key and the keystore
+// are protected by password, so that it can be used where Java expects a
+// key password equal to the keystore one, like in Tomcat and Spring Boot.
+func MarshalJKS(alias string, key crypto.PrivateKey, chain []*x509.Certificate, password string) ([]byte, error) {
+	privDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := jksProtectKey(privDER, password)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &bytes.Buffer{}
+	binary.Write(b, binary.BigEndian, uint32(jksPrivateKeyTag))
+	// The Sun provider only finds lowercase aliases.
+	writeJKSUTF(b, strings.ToLower(alias))
+	binary.Write(b, binary.BigEndian, time.Now().UnixNano()/int64(time.Millisecond))
+	binary.Write(b, binary.BigEndian, uint32(len(encrypted)))
+	b.Write(encrypted)
+	binary.Write(b, binary.BigEndian, uint32(len(chain)))
+	for _, c := range chain {
+		writeJKSUTF(b, "X.509")
+		binary.Write(b, binary.BigEndian, uint32(len(c.Raw)))
+		b.Write(c.Raw)
+	}
+
+	ks := &jksKeystore{password: password, entries: []jksEntry{{raw: b.Bytes()}}}
+	return ks.marshal()
+}
+
+// jksProtectKey encrypts a PKCS #8 key like the Sun KeyProtector: it's XORed
+// with a keystream of chained SHA-1 hashes of the password and a random
+// salt, and followed by a SHA-1 hash of the password and the plaintext.
+func jksProtectKey(privDER []byte, password string) ([]byte, error) {
+	passwd := utf16BE(password)
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{}, salt...)
+	digest := salt
+	for i := 0; i < len(privDER); i += sha1.Size {
+		h := sha1.New()
+		h.Write(passwd)
+		h.Write(digest)
+		digest = h.Sum(nil)
+		for j := 0; j < sha1.Size && i+j < len(privDER); j++ {
+			out = append(out, privDER[i+j]^digest[j])
+		}
+	}
+	h := sha1.New()
+	h.Write(passwd)
+	h.Write(privDER)
+	out = h.Sum(out)
+
+	return asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		Data      []byte
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidJKSKeyProtector, Parameters: asn1.NullRawValue},
+		Data:      out,
+	})
+}
+
+// utf16BE encodes s as big-endian UTF-16, like Java passwords are hashed.
+func utf16BE(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(c>>8), byte(c))
+	}
+	return b
+}
+
+// Since Java 18, cacerts is a PKCS #12 file without integrity protection,
+// holding the trusted certificates in an unencrypted data ContentInfo. Only
+// that layout is supported, other PKCS #12 keystores are left to keytool.
+
+var (
+	oidDataContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidCertBag           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidCertTypeX509      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidJavaTrustedKeyUse = asn1.ObjectIdentifier{2, 16, 840, 1, 113894, 746875, 1, 1}
+	oidAnyExtendedKeyUse = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
+)
+
+type pfxPDU struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  asn1.RawValue `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"` // [0] EXPLICIT
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     // [0] EXPLICIT
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue // SET
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data asn1.RawValue // [0] EXPLICIT OCTET STRING
+}
+
+type pkcs12Keystore struct {
+	// authSafe are the ContentInfos of the keystore, with the one at data
+	// replaced by bags on marshal.
+	authSafe []asn1.RawValue
+	data     int
+	bags     []pkcs12Bag
+}
+
+type pkcs12Bag struct {
+	raw  asn1.RawValue
+	cert *x509.Certificate // only for X.509 certBags
+}
+
+// explicit wraps the DER encoding b in a [0] EXPLICIT tag.
+func explicit(b []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: b}
+}
+
+// unwrapOctetString returns the contents of the OCTET STRING inside the
+// [0] EXPLICIT tag v.
+func unwrapOctetString(v asn1.RawValue) ([]byte, error) {
+	var octets []byte
+	if rest, err := asn1.Unmarshal(v.Bytes, &octets); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, errors.New("trailing data")
+	}
+	return octets, nil
+}
+
+func parsePKCS12Keystore(data []byte) (*pkcs12Keystore, error) {
+	var pfx pfxPDU
+	if _, err := asn1.Unmarshal(data, &pfx); err != nil {
+		return nil, fmt.Errorf("failed to parse PKCS #12 keystore: %w", err)
+	}
+	if len(pfx.MacData.FullBytes) != 0 {
+		return nil, fmt.Errorf("%w: password protected PKCS #12", errKeystoreUnsupported)
+	}
+	if !pfx.AuthSafe.ContentType.Equal(oidDataContentType) {
+		return nil, fmt.Errorf("%w: signed PKCS #12", errKeystoreUnsupported)
+	}
+	authSafe, err := unwrapOctetString(pfx.AuthSafe.Content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PKCS #12 keystore: %w", err)
+	}
+	ks := &pkcs12Keystore{data: -1}
+	if _, err := asn1.Unmarshal(authSafe, &ks.authSafe); err != nil {
+		return nil, fmt.Errorf("failed to parse PKCS #12 keystore: %w", err)
+	}
+	for i, raw := range ks.authSafe {
+		var ci contentInfo
+		if _, err := asn1.Unmarshal(raw.FullBytes, &ci); err != nil {
+			return nil, fmt.Errorf("failed to parse PKCS #12 keystore: %w", err)
+		}
+		if !ci.ContentType.Equal(oidDataContentType) {
+			// Encrypted contents could hold certificates too.
+			return nil, fmt.Errorf("%w: encrypted PKCS #12 contents", errKeystoreUnsupported)
+		}
+		if ks.data != -1 {
+			continue // leave any other data contents alone
+		}
+		contents, err := unwrapOctetString(ci.Content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse PKCS #12 keystore: %w", err)
+		}
+		var raws []asn1.RawValue
+		if _, err := asn1.Unmarshal(contents, &raws); err != nil {
+			return nil, fmt.Errorf("failed to parse PKCS #12 keystore: %w", err)
+		}
+		for _, raw := range raws {
+			ks.bags = append(ks.bags, pkcs12Bag{raw: raw, cert: parseCertBag(raw)})
+		}
+		ks.data = i
+	}
+	return ks, nil
+}
+
+func parseCertBag(raw asn1.RawValue) *x509.Certificate {
+	var bag safeBag
+	if _, err := asn1.Unmarshal(raw.FullBytes, &bag); err != nil || !bag.ID.Equal(oidCertBag) {
+		return nil
+	}
+	var cb certBag
+	if _, err := asn1.Unmarshal(bag.Value.Bytes, &cb); err != nil || !cb.ID.Equal(oidCertTypeX509) {
+		return nil
+	}
+	der, err := unwrapOctetString(cb.Data)
+	if err != nil {
+		return nil
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+func (ks *pkcs12Keystore) certs() []*x509.Certificate {
+	var certs []*x509.Certificate
+	for _, b := range ks.bags {
+		if b.cert != nil {
+			certs = append(certs, b.cert)
+		}
+	}
+	return certs
+}
+
+func (ks *pkcs12Keystore) add(alias string, cert *x509.Certificate) error {
+	var name []byte
+	for _, c := range utf16.Encode([]rune(alias)) {
+		name = append(name, byte(c>>8), byte(c))
+	}
+	certDER, err := asn1.Marshal(cert.Raw)
+	if err != nil {
+		return err
+	}
+	bagValue, err := asn1.Marshal(certBag{ID: oidCertTypeX509, Data: explicit(certDER)})
+	if err != nil {
+		return err
+	}
+	friendlyName, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagBMPString, Bytes: name})
+	if err != nil {
+		return err
+	}
+	trustedKeyUse, err := asn1.Marshal(oidAnyExtendedKeyUse)
+	if err != nil {
+		return err
+	}
+	set := func(v []byte) asn1.RawValue {
+		return asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: v}
+	}
+	bag, err := asn1.Marshal(safeBag{
+		ID:    oidCertBag,
+		Value: explicit(bagValue),
+		Attributes: []pkcs12Attribute{
+			{ID: oidFriendlyName, Value: set(friendlyName)},
+			// Java only loads certificates with this attribute as trusted.
+			{ID: oidJavaTrustedKeyUse, Value: set(trustedKeyUse)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode the PKCS #12 certificate bag: %w", err)
+	}
+	ks.bags = append(ks.bags, pkcs12Bag{raw: asn1.RawValue{FullBytes: bag}, cert: cert})
+	return nil
+}
+
+func (ks *pkcs12Keystore) remove(cert *x509.Certificate) bool {
+	var bags []pkcs12Bag
+	for _, b := range ks.bags {
+		if b.cert == nil || !b.cert.Equal(cert) {
+			bags = append(bags, b)
+		}
+	}
+	found := len(bags) != len(ks.bags)
+	ks.bags = bags
+	return found
+}
+
+func (ks *pkcs12Keystore) marshal() ([]byte, error) {
+	var raws []asn1.RawValue
+	for _, b := range ks.bags {
+		raws = append(raws, b.raw)
+	}
+	contents, err := asn1.Marshal(raws)
+	if err != nil {
+		return nil, err
+	}
+	octets, err := asn1.Marshal(contents)
+	if err != nil {
+		return nil, err
+	}
+	data, err := asn1.Marshal(contentInfo{ContentType: oidDataContentType, Content: explicit(octets)})
+	if err != nil {
+		return nil, err
+	}
+	authSafe := append([]asn1.RawValue{}, ks.authSafe...)
+	if ks.data == -1 {
+		authSafe = append(authSafe, asn1.RawValue{FullBytes: data})
+	} else {
+		authSafe[ks.data] = asn1.RawValue{FullBytes: data}
+	}
+	authSafeDER, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	octets, err = asn1.Marshal(authSafeDER)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pfxPDU{
+		Version:  3,
+		AuthSafe: contentInfo{ContentType: oidDataContentType, Content: explicit(octets)},
+	})
+}
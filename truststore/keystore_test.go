@@ -0,0 +1,228 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package truststore
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+)
+
+// The fixtures in testdata hold the two roots in testdata/FixtureRoot*.pem.
+// cacerts.p12 and cacerts-mac.p12 were made by OpenSSL 3.0, with
+//
+//	openssl pkcs12 -export -nokeys -in roots.pem -nomac -certpbe NONE -passout pass:
+//	openssl pkcs12 -export -nokeys -in roots.pem -passout pass:changeit
+//
+// and cacerts.jks by an encoder written from the OpenJDK JavaKeyStore
+// source, independently of this package, with the password "changeit".
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func fixtureRoots(t *testing.T) []*x509.Certificate {
+	t.Helper()
+	var certs []*x509.Certificate
+	for _, name := range []string{"FixtureRootA.pem", "FixtureRootB.pem"} {
+		block, _ := pem.Decode(readFixture(t, name))
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs
+}
+
+func newTestRoot(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "mkcert test root"},
+		NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour),
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	for _, tt := range []struct{ storeType, file string }{
+		{"JKS", "cacerts.jks"},
+		{"PKCS12", "cacerts.p12"},
+	} {
+		t.Run(tt.storeType, func(t *testing.T) {
+			data := readFixture(t, tt.file)
+			ks, err := parseKeystore(tt.storeType, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			roots := fixtureRoots(t)
+			if got := ks.certs(); len(got) != len(roots) {
+				t.Fatalf("got %d certificates, want %d", len(got), len(roots))
+			}
+			for _, root := range roots {
+				if !containsCert(ks, root) {
+					t.Errorf("%s is missing", root.Subject)
+				}
+			}
+			if out, err := ks.marshal(); err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(out, data) {
+				t.Error("the unmodified keystore doesn't marshal to the fixture")
+			}
+
+			root := newTestRoot(t)
+			if err := ks.add("mkcert development CA 1", root); err != nil {
+				t.Fatal(err)
+			}
+			out, err := ks.marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ks, err = parseKeystore(tt.storeType, out)
+			if err != nil {
+				t.Fatalf("failed to parse the keystore with the new root: %v", err)
+			}
+			if !containsCert(ks, root) || len(ks.certs()) != len(roots)+1 {
+				t.Fatal("the new root is missing after a round trip")
+			}
+
+			if !ks.remove(root) {
+				t.Fatal("remove didn't find the new root")
+			}
+			if ks.remove(root) {
+				t.Error("remove found the new root twice")
+			}
+			out, err = ks.marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Error("removing the new root didn't restore the fixture")
+			}
+		})
+	}
+}
+
+func TestParseKeystoreErrors(t *testing.T) {
+	jks := readFixture(t, "cacerts.jks")
+	jks[len(jks)-1] ^= 1
+	if _, err := parseKeystore("JKS", jks); err == nil {
+		t.Error("a JKS keystore with a bad digest was accepted")
+	}
+	if _, err := parseKeystore("JKS", jks[:40]); err == nil {
+		t.Error("a truncated JKS keystore was accepted")
+	}
+	_, err := parseKeystore("PKCS12", readFixture(t, "cacerts-mac.p12"))
+	if !errors.Is(err, errKeystoreUnsupported) {
+		t.Errorf("a password protected PKCS #12 keystore returned %v, want errKeystoreUnsupported", err)
+	}
+	if _, err := parseKeystore("BCFKS", nil); !errors.Is(err, errKeystoreUnsupported) {
+		t.Errorf("a BCFKS keystore returned %v, want errKeystoreUnsupported", err)
+	}
+}
+
+func TestMarshalJKS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := fixtureRoots(t)
+	data, err := MarshalJKS("Server", key, chain, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parseJKS(data, storePass); err == nil {
+		t.Error("the keystore was accepted with the wrong password")
+	}
+	ks, err := parseJKS(data, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ks.entries) != 1 || ks.entries[0].cert != nil {
+		t.Fatalf("got %d entries, want a single private key entry", len(ks.entries))
+	}
+
+	// Decrypt the key with the inverse of the Sun KeyProtector, to check
+	// that it's protected by the keystore password.
+	r := bytes.NewReader(ks.entries[0].raw[4:])
+	if alias, err := readJKSUTF(r); err != nil || alias != "server" {
+		t.Errorf("got alias %q, want %q", alias, "server")
+	}
+	r.Seek(8, io.SeekCurrent)
+	encrypted, err := readJKSBytes(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var protected struct {
+		Algorithm pkix.AlgorithmIdentifier
+		Data      []byte
+	}
+	if _, err := asn1.Unmarshal(encrypted, &protected); err != nil {
+		t.Fatal(err)
+	}
+	salt, ciphertext := protected.Data[:sha1.Size], protected.Data[sha1.Size:len(protected.Data)-sha1.Size]
+	var privDER []byte
+	digest := salt
+	for i := 0; i < len(ciphertext); i += sha1.Size {
+		h := sha1.New()
+		h.Write(utf16BE("hunter2"))
+		h.Write(digest)
+		digest = h.Sum(nil)
+		for j := 0; j < sha1.Size && i+j < len(ciphertext); j++ {
+			privDER = append(privDER, ciphertext[i+j]^digest[j])
+		}
+	}
+	priv, err := x509.ParsePKCS8PrivateKey(privDER)
+	if err != nil {
+		t.Fatalf("failed to decrypt the key: %v", err)
+	}
+	if !key.Equal(priv) {
+		t.Error("the decrypted key doesn't match")
+	}
+
+	var n uint32
+	binary.Read(r, binary.BigEndian, &n)
+	if int(n) != len(chain) {
+		t.Fatalf("got a chain of %d certificates, want %d", n, len(chain))
+	}
+	for _, c := range chain {
+		certType, der, err := readJKSCert(r)
+		if err != nil || certType != "X.509" || !bytes.Equal(der, c.Raw) {
+			t.Errorf("the chain doesn't match: %q, %v", certType, err)
+		}
+	}
+}
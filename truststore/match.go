@@ -0,0 +1,190 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package truststore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// A Selector identifies root certificates by SHA-256 fingerprint, serial
+// number, or both.
+type Selector struct {
+	SHA256 []byte
+	Serial *big.Int
+}
+
+// Matches reports whether cert is selected by sel.
+func (sel Selector) Matches(cert *x509.Certificate) bool {
+	if sel.SHA256 != nil {
+		sum := sha256.Sum256(cert.Raw)
+		if !bytes.Equal(sel.SHA256, sum[:]) {
+			return false
+		}
+	}
+	if sel.Serial != nil && sel.Serial.Cmp(cert.SerialNumber) != 0 {
+		return false
+	}
+	return true
+}
+
+// Installed returns the roots installed by mkcert that match sel, from any
+// CAROOT, found in the enabled system, NSS and Java trust stores. Roots are
+// recognized by the mkcert names in NSS and Java, and by the mkcert
+// Organization in the system store.
+func (s *Store) Installed(ctx context.Context, sel Selector) ([]*x509.Certificate, error) {
+	if sel.SHA256 == nil && sel.Serial == nil {
+		return nil, errors.New("empty selector")
+	}
+	var candidates []*x509.Certificate
+	if s.Enabled("system") {
+		certs, err := s.platformRoots(ctx)
+		if err != nil && !errors.Is(err, ErrUnsupported) {
+			return nil, err
+		}
+		for _, cert := range certs {
+			if len(cert.Subject.Organization) == 1 && cert.Subject.Organization[0] == "mkcert development CA" {
+				candidates = append(candidates, cert)
+			}
+		}
+	}
+	if s.Enabled("nss") && s.detect().nss.found && s.detect().nss.certutilPath != "" {
+		certs, err := s.nssRoots(ctx)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, certs...)
+	}
+	if s.Enabled("java") {
+		for _, r := range s.detect().java.runtimes {
+			if ks, err := s.loadKeystore(r); err == nil {
+				for _, cert := range ks.certs() {
+					if len(cert.Subject.Organization) == 1 && cert.Subject.Organization[0] == "mkcert development CA" {
+						candidates = append(candidates, cert)
+					}
+				}
+				continue
+			} else if !errors.Is(err, errKeystoreUnsupported) {
+				return nil, err
+			} else if err := r.needKeytool(err); err != nil {
+				return nil, err
+			}
+			out, err := s.cmdFS().Exec(ctx, exec.Command(r.KeytoolPath, append([]string{"-list", "-rfc"}, r.keystoreArgs()...)...))
+			if err != nil {
+				return nil, r.keytoolErr(err, "keytool -list", out)
+			}
+			for _, entry := range strings.Split(string(out), "Alias name: ")[1:] {
+				if strings.HasPrefix(entry, "mkcert development CA ") {
+					candidates = append(candidates, parseCertificates([]byte(entry))...)
+				}
+			}
+		}
+	}
+
+	var matches []*x509.Certificate
+	seen := make(map[string]bool)
+	for _, cert := range candidates {
+		if !sel.Matches(cert) || seen[string(cert.Raw)] {
+			continue
+		}
+		seen[string(cert.Raw)] = true
+		matches = append(matches, cert)
+	}
+	return matches, nil
+}
+
+// nssRoots returns the roots installed by mkcert in the NSS databases.
+func (s *Store) nssRoots(ctx context.Context) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	var nssErr error
+	s.forEachNSSProfile(func(profile string) error {
+		out, err := s.cmdFS().Exec(ctx, exec.Command(s.detect().nss.certutilPath, "-L", "-d", profile))
+		if err != nil {
+			nssErr = certutilErr(profile, err, "certutil -L -d "+profile, out)
+			return nssErr
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(out))
+		for scanner.Scan() {
+			// The nickname is followed by the trust attributes column.
+			line := strings.TrimSpace(scanner.Text())
+			if !strings.HasPrefix(line, "mkcert development CA ") {
+				continue
+			}
+			nickname := strings.TrimSpace(line[:strings.LastIndexAny(line, " \t")])
+			out, err := s.cmdFS().Exec(ctx, exec.Command(s.detect().nss.certutilPath, "-L", "-d", profile, "-n", nickname, "-a"))
+			if err != nil {
+				nssErr = certutilErr(profile, err, "certutil -L -d "+profile+" -n "+nickname, out)
+				return nssErr
+			}
+			certs = append(certs, parseCertificates(out)...)
+		}
+		return nil
+	})
+	return certs, nssErr
+}
+
+// UninstallMatching removes the roots installed by mkcert that match sel
+// from each enabled system, NSS and Java trust store, even if their CAROOT
+// is long gone. s.Root and s.RootPath are ignored.
+//
+// The returned results are those of Uninstall for each matching root, in
+// the order returned by Installed, with Result.Root set.
+func (s *Store) UninstallMatching(ctx context.Context, sel Selector) ([]Result, error) {
+	certs, err := s.Installed(ctx, sel)
+	if err != nil {
+		return nil, err
+	}
+
+	var stores []string
+	for _, name := range []string{"system", "nss", "java"} {
+		if s.Enabled(name) {
+			stores = append(stores, name)
+		}
+	}
+	// The platform tools take the root as a file.
+	dir, err := s.cmdFS().MkdirTemp("", "mkcert-uninstall")
+	if err != nil {
+		return nil, err
+	}
+	defer s.cmdFS().Remove(dir)
+
+	var results []Result
+	var errs []error
+	for i, cert := range certs {
+		rootPath := filepath.Join(dir, fmt.Sprintf("root-%d.pem", i))
+		if err := s.cmdFS().WriteFile(rootPath, pem.EncodeToMemory(
+			&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0644); err != nil {
+			return results, err
+		}
+
+		other := &Store{
+			RootPath: rootPath, Root: cert, Stores: stores, CmdFS: s.cmdFS(),
+			ContinueOnError: s.ContinueOnError,
+		}
+		other.detectOnce.Do(func() { other.detected = *s.detect() })
+		rr, err := other.UninstallContext(ctx)
+		for i := range rr {
+			rr[i].Root = cert
+		}
+		results = append(results, rr...)
+		var multiErr *MultiError
+		if errors.As(err, &multiErr) {
+			errs = append(errs, multiErr.Errors...)
+		} else if err != nil {
+			return results, fmt.Errorf("failed to uninstall %q: %w", cert.Subject.CommonName, err)
+		}
+	}
+	return results, multiError(errs)
+}
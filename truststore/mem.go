@@ -0,0 +1,138 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package truststore
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"sync"
+)
+
+// A TrustStore checks, installs and removes a root certificate. *Store is
+// the implementation for the trust stores of the local machine, while the
+// ones returned by MemStore.Store are for application tests.
+type TrustStore interface {
+	CheckContext(ctx context.Context) ([]Result, error)
+	InstallContext(ctx context.Context) ([]Result, error)
+	UninstallContext(ctx context.Context) ([]Result, error)
+}
+
+var _ TrustStore = (*Store)(nil)
+
+// MemStore is a set of in-memory trust stores, which tracks the roots
+// installed through the TrustStores it returns, and can be made to fail.
+// It's safe for concurrent use.
+type MemStore struct {
+	// Stores are the names of the simulated trust stores. If empty, a
+	// single "system" store is simulated.
+	Stores []string
+
+	// ContinueOnError behaves like Store.ContinueOnError.
+	ContinueOnError bool
+
+	mu    sync.Mutex
+	fail  map[string]error
+	roots map[string][]*x509.Certificate
+}
+
+// Fail makes every following operation on the named store fail with err,
+// or succeed again if err is nil.
+func (ms *MemStore) Fail(store string, err error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.fail == nil {
+		ms.fail = make(map[string]error)
+	}
+	ms.fail[store] = err
+}
+
+// Roots returns the roots currently installed in the named store.
+func (ms *MemStore) Roots(store string) []*x509.Certificate {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return append([]*x509.Certificate(nil), ms.roots[store]...)
+}
+
+// Store returns a TrustStore managing root in ms.
+func (ms *MemStore) Store(root *x509.Certificate) TrustStore {
+	return &memTrustStore{ms: ms, root: root}
+}
+
+type memTrustStore struct {
+	ms   *MemStore
+	root *x509.Certificate
+}
+
+func (ms *MemStore) stores() []string {
+	if len(ms.Stores) == 0 {
+		return []string{"system"}
+	}
+	return ms.Stores
+}
+
+func (ms *MemStore) index(store string, root *x509.Certificate) int {
+	for i, c := range ms.roots[store] {
+		if c.Equal(root) {
+			return i
+		}
+	}
+	return -1
+}
+
+// do runs op on each store, with the same error handling as Store.
+func (t *memTrustStore) do(ctx context.Context, op func(store string) Status) ([]Result, error) {
+	ms := t.ms
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var results []Result
+	var errs []error
+	for _, store := range ms.stores() {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		r := Result{Store: store}
+		if err := ms.fail[store]; err != nil {
+			if !ms.ContinueOnError {
+				return results, err
+			}
+			r.Status, r.Err = Failed, err
+			errs = append(errs, fmt.Errorf("%s: %w", store, err))
+		} else {
+			r.Status = op(store)
+		}
+		results = append(results, r)
+	}
+	return results, multiError(errs)
+}
+
+func (t *memTrustStore) CheckContext(ctx context.Context) ([]Result, error) {
+	return t.do(ctx, func(store string) Status {
+		return installedStatus(t.ms.index(store, t.root) >= 0)
+	})
+}
+
+func (t *memTrustStore) InstallContext(ctx context.Context) ([]Result, error) {
+	return t.do(ctx, func(store string) Status {
+		if t.ms.index(store, t.root) >= 0 {
+			return AlreadyInstalled
+		}
+		if t.ms.roots == nil {
+			t.ms.roots = make(map[string][]*x509.Certificate)
+		}
+		t.ms.roots[store] = append(t.ms.roots[store], t.root)
+		return Installed
+	})
+}
+
+func (t *memTrustStore) UninstallContext(ctx context.Context) ([]Result, error) {
+	return t.do(ctx, func(store string) Status {
+		if i := t.ms.index(store, t.root); i >= 0 {
+			roots := t.ms.roots[store]
+			t.ms.roots[store] = append(roots[:i:i], roots[i+1:]...)
+		}
+		return Uninstalled
+	})
+}
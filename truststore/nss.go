@@ -0,0 +1,356 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package truststore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// firefoxPaths are the Firefox installations that imply NSS databases might
+// show up even if none exist yet.
+var firefoxPaths = []string{
+	"/usr/bin/firefox",
+	"/usr/bin/firefox-nightly",
+	"/usr/bin/firefox-developer-edition",
+	"/snap/firefox",
+	"/Applications/Firefox.app",
+	"/Applications/FirefoxDeveloperEdition.app",
+	"/Applications/Firefox Developer Edition.app",
+	"/Applications/Firefox Nightly.app",
+	"C:\\Program Files\\Mozilla Firefox",
+}
+
+// nssDBs returns the shared NSS databases used by Chrome/Chromium.
+func nssDBs() []string {
+	return []string{
+		filepath.Join(os.Getenv("HOME"), ".pki/nssdb"),
+		filepath.Join(os.Getenv("HOME"), "snap/chromium/current/.pki/nssdb"), // Snapcraft
+		"/etc/pki/nssdb", // CentOS 7
+	}
+}
+
+// nssState is what detectNSS found out about NSS on this machine.
+type nssState struct {
+	// found is set if NSS databases or Firefox are present.
+	found bool
+	// certutilPath is the path of certutil, or empty if it's not installed.
+	certutilPath string
+	// enterpriseRoots is set on Windows, where Firefox is made to trust the
+	// system store instead. See nss_enterprise.go.
+	enterpriseRoots bool
+}
+
+// usable reports whether the NSS trust store can be managed.
+func (st nssState) usable() bool {
+	return st.certutilPath != "" || st.enterpriseRoots
+}
+
+func (s *Store) detectNSS() nssState {
+	var st nssState
+	paths := append(nssDBs(), firefoxPaths...)
+	if s.NSSProfile != "" {
+		paths = []string{s.NSSProfile}
+	}
+	for _, path := range paths {
+		if pathExists(path) {
+			st.found = true
+			break
+		}
+	}
+	if s.NSSProfile == "" && !st.found {
+		// Firefox from the Microsoft Store is only visible through its
+		// profiles.
+		for _, ff := range FirefoxProfiles {
+			if pp, _ := filepath.Glob(ff); len(pp) > 0 {
+				st.found = true
+				break
+			}
+		}
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		switch {
+		case binaryExists("certutil"):
+			st.certutilPath, _ = exec.LookPath("certutil")
+		case binaryExists("/usr/local/opt/nss/bin/certutil"):
+			// Check the default Homebrew path, to save executing Ruby. #135
+			st.certutilPath = "/usr/local/opt/nss/bin/certutil"
+		default:
+			out, err := exec.Command("brew", "--prefix", "nss").Output()
+			if err == nil {
+				path := filepath.Join(strings.TrimSpace(string(out)), "bin", "certutil")
+				if pathExists(path) {
+					st.certutilPath = path
+				}
+			}
+		}
+
+	case "linux":
+		st.certutilPath, _ = exec.LookPath("certutil")
+
+	case "windows":
+		st.enterpriseRoots = true
+	}
+	return st
+}
+
+func (s *Store) checkNSS(ctx context.Context) bool {
+	if s.detect().nss.enterpriseRoots {
+		return s.checkEnterpriseRoots()
+	}
+	if s.detect().nss.certutilPath == "" {
+		return false
+	}
+	success := true
+	if s.forEachNSSProfile(func(profile string) error {
+		_, err := s.cmdFS().Exec(ctx, exec.Command(s.detect().nss.certutilPath, "-V", "-d", profile, "-u", "L", "-n", s.uniqueName()))
+		if err != nil {
+			success = false
+		}
+		return nil
+	}) == 0 {
+		success = false
+	}
+	return success
+}
+
+func (s *Store) installNSS(ctx context.Context) error {
+	if s.detect().nss.enterpriseRoots {
+		return s.installEnterpriseRoots()
+	}
+	var installErr error
+	if s.forEachNSSProfile(func(profile string) error {
+		cmd := exec.Command(s.detect().nss.certutilPath, "-A", "-d", profile, "-t", s.nssTrust(), "-n", s.uniqueName(), "-i", s.RootPath)
+		out, err := s.execCertutil(ctx, cmd)
+		if err != nil {
+			installErr = certutilErr(profile, err, "certutil -A -d "+profile, out)
+		}
+		return installErr
+	}) == 0 {
+		return ErrNoNSSDatabases
+	}
+	if installErr != nil {
+		return installErr
+	}
+	if !s.checkNSS(ctx) {
+		return ErrNSSInstallFailed
+	}
+	return nil
+}
+
+// nssTrust returns the certutil trust flags for s.Purpose, for the SSL,
+// S/MIME and code signing trust categories.
+func (s *Store) nssTrust() string {
+	if s.Purpose == PurposeAll {
+		return "C,C,C"
+	}
+	return "C,,"
+}
+
+func (s *Store) uninstallNSS(ctx context.Context) error {
+	if s.detect().nss.enterpriseRoots {
+		return s.uninstallEnterpriseRoots()
+	}
+	var uninstallErr error
+	s.forEachNSSProfile(func(profile string) error {
+		_, err := s.cmdFS().Exec(ctx, exec.Command(s.detect().nss.certutilPath, "-V", "-d", profile, "-u", "L", "-n", s.uniqueName()))
+		if err != nil {
+			return nil
+		}
+		cmd := exec.Command(s.detect().nss.certutilPath, "-D", "-d", profile, "-n", s.uniqueName())
+		out, err := s.execCertutil(ctx, cmd)
+		if err != nil {
+			uninstallErr = certutilErr(profile, err, "certutil -D -d "+profile, out)
+		}
+		return uninstallErr
+	})
+	return uninstallErr
+}
+
+// An NSSError is returned when certutil fails on an NSS database for a known
+// reason. It matches its Cause with errors.Is, and unwraps to the CmdError
+// with the output of certutil.
+type NSSError struct {
+	// Profile is the directory of the database.
+	Profile string
+	// Cause is ErrNSSReadOnly, ErrNSSBadDatabase or ErrNSSLocked.
+	Cause error
+	// Err is the failed certutil command.
+	Err *CmdError
+}
+
+func (e *NSSError) Error() string {
+	var fix string
+	switch e.Cause {
+	case ErrNSSReadOnly:
+		fix = "check that it's owned by the current user, or run mkcert as its owner"
+	case ErrNSSBadDatabase:
+		fix = `if the browser can't open it either, move its "cert9.db" aside and restart the browser to recreate it, which removes the certificates added to it`
+	case ErrNSSLocked:
+		fix = "close the browser using it and retry"
+	}
+	return fmt.Sprintf("%s: %v, %s", e.Profile, e.Cause, fix)
+}
+
+func (e *NSSError) Is(target error) bool { return target == e.Cause }
+
+func (e *NSSError) Unwrap() error { return e.Err }
+
+// certutilErr returns an NSSError if the output of a certutil command on
+// profile (a -d argument, like "sql:/path") shows a known failure, or a
+// CmdError otherwise.
+func certutilErr(profile string, err error, cmd string, out []byte) error {
+	cerr := &CmdError{Cmd: cmd, Out: out, Err: err}
+	dir := strings.TrimPrefix(strings.TrimPrefix(profile, "sql:"), "dbm:")
+	var cause error
+	switch {
+	case bytes.Contains(out, []byte("SEC_ERROR_READ_ONLY")):
+		cause = ErrNSSReadOnly
+	case bytes.Contains(out, []byte("database is locked")) || bytes.Contains(out, []byte("SEC_ERROR_LOCKED_DATABASE")):
+		cause = ErrNSSLocked
+	case bytes.Contains(out, []byte("SEC_ERROR_BAD_DATABASE")):
+		// Firefox holds a "lock" symlink in the profile while it runs on
+		// Linux, and older databases can't be opened while it does.
+		if _, err := os.Lstat(filepath.Join(dir, "lock")); err == nil {
+			cause = ErrNSSLocked
+		} else {
+			cause = ErrNSSBadDatabase
+		}
+	default:
+		return cerr
+	}
+	return &NSSError{Profile: dir, Cause: cause, Err: cerr}
+}
+
+// execCertutil will execute a "certutil" command and if needed re-execute
+// the command with sudo to work around file permissions.
+func (s *Store) execCertutil(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	out, err := s.cmdFS().Exec(ctx, cmd)
+	if err != nil && bytes.Contains(out, []byte("SEC_ERROR_READ_ONLY")) && runtime.GOOS != "windows" {
+		out, err = s.cmdFS().SudoExec(ctx, exec.Command(cmd.Path, cmd.Args[1:]...))
+	}
+	return out, err
+}
+
+// An NSSProfile is an NSS security database the root is installed into.
+type NSSProfile struct {
+	// Path is the directory of the database.
+	Path string
+	// Format is "sql" for cert9.db databases, or "dbm" for legacy cert8.db
+	// ones.
+	Format string
+	// Legacy is set if the directory has a cert8.db, even if it also has a
+	// cert9.db and Format is "sql".
+	Legacy bool
+	// Browser is "Firefox" for Firefox profiles, "Chrome/Chromium" for the
+	// per-user shared database, "system" for the system-wide one, or
+	// "custom" for a Store.NSSProfile that is none of those.
+	Browser string
+	// Writable reports whether the database can be modified without sudo.
+	Writable bool
+}
+
+// certutilDirs returns the -d arguments of certutil for p, according to
+// s.NSSLegacy.
+func (s *Store) certutilDirs(p NSSProfile) []string {
+	switch {
+	case !p.Legacy || s.NSSLegacy == NSSLegacyDBM && p.Format == "dbm":
+		return []string{p.Format + ":" + p.Path}
+	case s.NSSLegacy == NSSLegacyDualWrite:
+		return []string{"sql:" + p.Path, "dbm:" + p.Path}
+	default:
+		return []string{"sql:" + p.Path}
+	}
+}
+
+// NSSProfiles returns the NSS databases that Install and Uninstall modify.
+func (s *Store) NSSProfiles() []NSSProfile {
+	var candidates []NSSProfile
+	if s.NSSProfile != "" {
+		candidates = append(candidates, NSSProfile{Path: s.NSSProfile, Browser: nssBrowser(s.NSSProfile)})
+	}
+	for _, db := range nssDBs() {
+		if s.NSSProfile != "" {
+			break
+		}
+		browser := "Chrome/Chromium"
+		if !strings.HasPrefix(db, os.Getenv("HOME")) {
+			browser = "system"
+		}
+		candidates = append(candidates, NSSProfile{Path: db, Browser: browser})
+	}
+	for _, ff := range FirefoxProfiles {
+		if s.NSSProfile != "" {
+			break
+		}
+		pp, _ := filepath.Glob(ff)
+		for _, p := range pp {
+			candidates = append(candidates, NSSProfile{Path: p, Browser: "Firefox"})
+		}
+	}
+
+	var profiles []NSSProfile
+	for _, p := range candidates {
+		if stat, err := os.Stat(p.Path); err != nil || !stat.IsDir() {
+			continue
+		}
+		var db string
+		p.Legacy = pathExists(filepath.Join(p.Path, "cert8.db"))
+		switch {
+		case pathExists(filepath.Join(p.Path, "cert9.db")):
+			p.Format, db = "sql", filepath.Join(p.Path, "cert9.db")
+		case pathExists(filepath.Join(p.Path, "cert8.db")):
+			p.Format, db = "dbm", filepath.Join(p.Path, "cert8.db")
+		default:
+			continue
+		}
+		if f, err := os.OpenFile(db, os.O_WRONLY, 0); err == nil {
+			f.Close()
+			p.Writable = true
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles
+}
+
+// nssBrowser guesses the Browser of the NSS database at path.
+func nssBrowser(path string) string {
+	for _, ff := range FirefoxProfiles {
+		if ok, _ := filepath.Match(ff, path); ok {
+			return "Firefox"
+		}
+	}
+	for _, db := range nssDBs() {
+		if filepath.Clean(path) == db {
+			if !strings.HasPrefix(db, os.Getenv("HOME")) {
+				return "system"
+			}
+			return "Chrome/Chromium"
+		}
+	}
+	return "custom"
+}
+
+// forEachNSSProfile calls f for each NSS database, stopping early if f
+// returns an error, and returns the number of databases visited.
+func (s *Store) forEachNSSProfile(f func(profile string) error) (found int) {
+	for _, p := range s.NSSProfiles() {
+		found++
+		for _, dir := range s.certutilDirs(p) {
+			if err := f(dir); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
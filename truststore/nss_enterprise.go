@@ -0,0 +1,95 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package truststore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// On Windows, where the NSS certutil is not available, the root is not added
+// to the Firefox profiles. Instead, the profiles are configured to trust the
+// roots in the Windows certificate store, where the "system" trust store
+// installs it. This also covers the Microsoft Store (MSIX) version of Firefox,
+// whose profiles are in a sandboxed package directory.
+
+// enterpriseRootsPref is the line added to the user.js of each profile.
+const enterpriseRootsPref = `user_pref("security.enterprise_roots.enabled", true); // added by mkcert`
+
+var enterpriseRootsSetting = []byte(`"security.enterprise_roots.enabled", true`)
+
+func userJS(profile NSSProfile) string {
+	return filepath.Join(profile.Path, "user.js")
+}
+
+// checkEnterpriseRoots reports whether all Firefox profiles trust the
+// Windows certificate store, and the root is in it.
+func (s *Store) checkEnterpriseRoots() bool {
+	profiles := s.NSSProfiles()
+	if len(profiles) == 0 || !s.checkPlatform() {
+		return false
+	}
+	for _, p := range profiles {
+		data, _ := s.cmdFS().ReadFile(userJS(p))
+		if !bytes.Contains(data, enterpriseRootsSetting) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Store) installEnterpriseRoots() error {
+	profiles := s.NSSProfiles()
+	if len(profiles) == 0 {
+		return ErrNoNSSDatabases
+	}
+	if !s.checkPlatform() {
+		return errors.New("Firefox trusts the root through the system trust store, which doesn't have it, install it there too")
+	}
+	for _, p := range profiles {
+		data, err := s.cmdFS().ReadFile(userJS(p))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if bytes.Contains(data, enterpriseRootsSetting) {
+			continue
+		}
+		if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+			data = append(data, '\r', '\n')
+		}
+		data = append(data, enterpriseRootsPref+"\r\n"...)
+		if err := s.cmdFS().WriteFile(userJS(p), data, 0644); err != nil {
+			return fmt.Errorf("failed to enable enterprise roots in %s: %w", p.Path, err)
+		}
+	}
+	if !s.checkEnterpriseRoots() {
+		return ErrNSSInstallFailed
+	}
+	return nil
+}
+
+// uninstallEnterpriseRoots removes the preference added by
+// installEnterpriseRoots, leaving any set by the user.
+func (s *Store) uninstallEnterpriseRoots() error {
+	for _, p := range s.NSSProfiles() {
+		data, err := s.cmdFS().ReadFile(userJS(p))
+		if err != nil {
+			continue
+		}
+		var out [][]byte
+		for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+			if !bytes.HasPrefix(bytes.TrimSpace(line), []byte(enterpriseRootsPref)) {
+				out = append(out, line)
+			}
+		}
+		if err := s.cmdFS().WriteFile(userJS(p), bytes.Join(out, nil), 0644); err != nil {
+			return fmt.Errorf("failed to disable enterprise roots in %s: %w", p.Path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package truststore
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"howett.net/plist"
+)
+
+var (
+	FirefoxProfiles = []string{os.Getenv("HOME") + "/Library/Application Support/Firefox/Profiles/*"}
+	NSSBrowsers     = "Firefox"
+)
+
+type platformState struct {
+	certutilInstallHelp string
+}
+
+func detectPlatform() platformState {
+	return platformState{certutilInstallHelp: "brew install nss"}
+}
+
+// https://github.com/golang/go/issues/24652#issuecomment-399826583
+// The first entry is the sslServer policy, used alone for PurposeServerAuth.
+var trustSettings []interface{}
+var _, _ = plist.Unmarshal(trustSettingsData, &trustSettings)
+var trustSettingsData = []byte(`
+<array>
+	<dict>
+		<key>kSecTrustSettingsPolicy</key>
+		<data>
+		KoZIhvdjZAED
+		</data>
+		<key>kSecTrustSettingsPolicyName</key>
+		<string>sslServer</string>
+		<key>kSecTrustSettingsResult</key>
+		<integer>1</integer>
+	</dict>
+	<dict>
+		<key>kSecTrustSettingsPolicy</key>
+		<data>
+		KoZIhvdjZAEC
+		</data>
+		<key>kSecTrustSettingsPolicyName</key>
+		<string>basicX509</string>
+		<key>kSecTrustSettingsResult</key>
+		<integer>1</integer>
+	</dict>
+</array>
+`)
+
+// SystemRoots returns the certificates in the system roots keychain.
+func SystemRoots() ([]*x509.Certificate, error) {
+	out, err := exec.Command("security", "find-certificate", "-a", "-p",
+		"/System/Library/Keychains/SystemRootCertificates.keychain").Output()
+	if err != nil {
+		return nil, cmdErr(err, "security find-certificate", out)
+	}
+	return parseCertificates(out), nil
+}
+
+func (s *Store) installPlatform(ctx context.Context) error {
+	cmd := exec.Command("security", "add-trusted-cert", "-d", "-k", "/Library/Keychains/System.keychain", s.RootPath)
+	out, err := s.cmdFS().SudoExec(ctx, cmd)
+	if err != nil {
+		return cmdErr(err, "security add-trusted-cert", out)
+	}
+
+	// Make trustSettings explicit, as older Go does not know the defaults.
+	// https://github.com/golang/go/issues/24652
+
+	tmpDir, err := s.cmdFS().MkdirTemp("", "trust-settings")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer s.cmdFS().Remove(tmpDir)
+	plistFile := filepath.Join(tmpDir, "trust-settings.plist")
+
+	cmd = exec.Command("security", "trust-settings-export", "-d", plistFile)
+	out, err = s.cmdFS().SudoExec(ctx, cmd)
+	if err != nil {
+		return cmdErr(err, "security trust-settings-export", out)
+	}
+
+	plistData, err := s.cmdFS().ReadFile(plistFile)
+	if err != nil {
+		return fmt.Errorf("failed to read trust settings: %w", err)
+	}
+	var plistRoot map[string]interface{}
+	_, err = plist.Unmarshal(plistData, &plistRoot)
+	if err != nil {
+		return fmt.Errorf("failed to parse trust settings: %w", err)
+	}
+
+	rootSubjectASN1, _ := asn1.Marshal(s.Root.Subject.ToRDNSequence())
+
+	if plistRoot["trustVersion"].(uint64) != 1 {
+		return fmt.Errorf("unsupported trust settings version: %v", plistRoot["trustVersion"])
+	}
+	trustList := plistRoot["trustList"].(map[string]interface{})
+	for key := range trustList {
+		entry := trustList[key].(map[string]interface{})
+		if _, ok := entry["issuerName"]; !ok {
+			continue
+		}
+		issuerName := entry["issuerName"].([]byte)
+		if !bytes.Equal(rootSubjectASN1, issuerName) {
+			continue
+		}
+		switch s.Purpose {
+		case PurposeServerAuth:
+			entry["trustSettings"] = trustSettings[:1]
+		case PurposeAll:
+			// An empty list of trust settings means trusted for everything.
+			entry["trustSettings"] = []interface{}{}
+		default:
+			entry["trustSettings"] = trustSettings
+		}
+		break
+	}
+
+	plistData, err = plist.MarshalIndent(plistRoot, plist.XMLFormat, "\t")
+	if err != nil {
+		return fmt.Errorf("failed to serialize trust settings: %w", err)
+	}
+	err = s.cmdFS().WriteFile(plistFile, plistData, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write trust settings: %w", err)
+	}
+
+	cmd = exec.Command("security", "trust-settings-import", "-d", plistFile)
+	out, err = s.cmdFS().SudoExec(ctx, cmd)
+	return cmdErr(err, "security trust-settings-import", out)
+}
+
+func (s *Store) uninstallPlatform(ctx context.Context) error {
+	cmd := exec.Command("security", "remove-trusted-cert", "-d", s.RootPath)
+	out, err := s.cmdFS().SudoExec(ctx, cmd)
+	return cmdErr(err, "security remove-trusted-cert", out)
+}
+
+// platformRoots returns the certificates in the System keychain, where
+// installPlatform adds the root.
+func (s *Store) platformRoots(ctx context.Context) ([]*x509.Certificate, error) {
+	cmd := exec.Command("security", "find-certificate", "-a", "-p", "/Library/Keychains/System.keychain")
+	out, err := s.cmdFS().Exec(ctx, cmd)
+	if err != nil {
+		return nil, cmdErr(err, "security find-certificate", out)
+	}
+	return parseCertificates(out), nil
+}
+
+func (s *Store) platformInfo() PlatformInfo {
+	return PlatformInfo{Mechanism: "keychain", Path: "/Library/Keychains/System.keychain"}
+}
+
+func (s *Store) platformDistrusted() bool {
+	return false
+}
@@ -0,0 +1,151 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package truststore
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	FirefoxProfiles = []string{os.Getenv("HOME") + "/.mozilla/firefox/*",
+		os.Getenv("HOME") + "/snap/firefox/common/.mozilla/firefox/*"}
+	NSSBrowsers = "Firefox and/or Chrome/Chromium"
+)
+
+// platformState is what detectPlatform found out about this machine.
+type platformState struct {
+	// distro is the first of Distros whose AnchorDir exists, or nil.
+	distro              *Distro
+	certutilInstallHelp string
+}
+
+func detectPlatform() platformState {
+	var st platformState
+	switch {
+	case binaryExists("apt"):
+		st.certutilInstallHelp = "apt install libnss3-tools"
+	case binaryExists("yum"):
+		st.certutilInstallHelp = "yum install nss-tools"
+	case binaryExists("zypper"):
+		st.certutilInstallHelp = "zypper install mozilla-nss-tools"
+	}
+	for i, d := range Distros {
+		if pathExists(d.AnchorDir + "/") {
+			st.distro = &Distros[i]
+			break
+		}
+	}
+	return st
+}
+
+// systemRootFiles are the CA bundles of the common distributions, as listed
+// by crypto/x509.
+var systemRootFiles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",                // Debian/Ubuntu/Gentoo etc.
+	"/etc/pki/tls/certs/ca-bundle.crt",                  // Fedora/RHEL 6
+	"/etc/ssl/ca-bundle.pem",                            // OpenSUSE
+	"/etc/pki/tls/cacert.pem",                           // OpenELEC
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem", // CentOS/RHEL 7
+	"/etc/ssl/cert.pem",                                 // Alpine Linux
+}
+
+// SystemRoots returns the certificates in the system CA bundle.
+func SystemRoots() ([]*x509.Certificate, error) {
+	for _, name := range systemRootFiles {
+		bundle, err := ioutil.ReadFile(name)
+		if err == nil {
+			return parseCertificates(bundle), nil
+		}
+	}
+	return nil, errors.New("no system CA bundle found")
+}
+
+func (s *Store) systemTrustFilename(name string) string {
+	d := s.detect().platform.distro
+	return filepath.Join(d.AnchorDir, name+d.Ext)
+}
+
+func (s *Store) installPlatform(ctx context.Context) error {
+	d := s.detect().platform.distro
+	if d == nil {
+		return ErrUnsupported
+	}
+
+	cert, err := s.cmdFS().ReadFile(s.RootPath)
+	if err != nil {
+		return fmt.Errorf("failed to read root certificate: %w", err)
+	}
+
+	cmd := exec.Command("tee", s.systemTrustFilename(s.SystemTrustName()))
+	cmd.Stdin = bytes.NewReader(cert)
+	out, err := s.cmdFS().SudoExec(ctx, cmd)
+	if err != nil {
+		return cmdErr(err, "tee", out)
+	}
+
+	cmd = exec.Command(d.Command[0], d.Command[1:]...)
+	out, err = s.cmdFS().SudoExec(ctx, cmd)
+	return cmdErr(err, strings.Join(d.Command, " "), out)
+}
+
+func (s *Store) uninstallPlatform(ctx context.Context) error {
+	d := s.detect().platform.distro
+	if d == nil {
+		return ErrUnsupported
+	}
+
+	cmd := exec.Command("rm", "-f", s.systemTrustFilename(s.SystemTrustName()))
+	out, err := s.cmdFS().SudoExec(ctx, cmd)
+	if err != nil {
+		return cmdErr(err, "rm", out)
+	}
+
+	// We used to install under non-unique filenames.
+	legacyFilename := s.systemTrustFilename("mkcert-rootCA")
+	if pathExists(legacyFilename) {
+		cmd := exec.Command("rm", "-f", legacyFilename)
+		out, err := s.cmdFS().SudoExec(ctx, cmd)
+		if err != nil {
+			return cmdErr(err, "rm (legacy filename)", out)
+		}
+	}
+
+	cmd = exec.Command(d.Command[0], d.Command[1:]...)
+	out, err = s.cmdFS().SudoExec(ctx, cmd)
+	return cmdErr(err, strings.Join(d.Command, " "), out)
+}
+
+func (s *Store) platformRoots(ctx context.Context) ([]*x509.Certificate, error) {
+	return SystemRoots()
+}
+
+func (s *Store) platformInfo() PlatformInfo {
+	d := s.detect().platform.distro
+	if d == nil {
+		return PlatformInfo{}
+	}
+	info := PlatformInfo{
+		Mechanism: d.Command[0],
+		Path:      s.systemTrustFilename(s.SystemTrustName()),
+		Command:   d.Command,
+	}
+	if d.Command[0] == "trust" {
+		info.Mechanism = "p11-kit"
+	}
+	return info
+}
+
+func (s *Store) platformDistrusted() bool {
+	return false
+}
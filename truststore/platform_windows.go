@@ -0,0 +1,257 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package truststore
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	FirefoxProfiles = []string{os.Getenv("USERPROFILE") + "\\AppData\\Roaming\\Mozilla\\Firefox\\Profiles\\*",
+		// Microsoft Store (MSIX) package
+		os.Getenv("USERPROFILE") + "\\AppData\\Local\\Packages\\Mozilla.Firefox_*\\LocalCache\\Roaming\\Mozilla\\Firefox\\Profiles\\*"}
+	NSSBrowsers = "Firefox"
+)
+
+type platformState struct {
+	certutilInstallHelp string // certutil unsupported on Windows
+}
+
+func detectPlatform() platformState {
+	return platformState{}
+}
+
+var (
+	modcrypt32                            = syscall.NewLazyDLL("crypt32.dll")
+	procCertAddEncodedCertificateToStore  = modcrypt32.NewProc("CertAddEncodedCertificateToStore")
+	procCertCloseStore                    = modcrypt32.NewProc("CertCloseStore")
+	procCertDeleteCertificateFromStore    = modcrypt32.NewProc("CertDeleteCertificateFromStore")
+	procCertDuplicateCertificateContext   = modcrypt32.NewProc("CertDuplicateCertificateContext")
+	procCertOpenSystemStoreW              = modcrypt32.NewProc("CertOpenSystemStoreW")
+	procCertFreeCertificateContext        = modcrypt32.NewProc("CertFreeCertificateContext")
+	procCertSetCertificateContextProperty = modcrypt32.NewProc("CertSetCertificateContextProperty")
+)
+
+func (s *Store) installPlatform(ctx context.Context) error {
+	// Load cert
+	cert, err := s.cmdFS().ReadFile(s.RootPath)
+	if err != nil {
+		return fmt.Errorf("failed to read root certificate: %w", err)
+	}
+	// Decode PEM
+	certBlock, _ := pem.Decode(cert)
+	if certBlock == nil || certBlock.Type != "CERTIFICATE" {
+		return fmt.Errorf("decode pem: invalid PEM data")
+	}
+	cert = certBlock.Bytes
+	// Open root store
+	store, err := openWindowsRootStore()
+	if err != nil {
+		return fmt.Errorf("open root store: %w", err)
+	}
+	defer store.close()
+	// Add cert, restricting its purposes if requested. Without the property,
+	// Windows trusts roots for every purpose.
+	var ekus []asn1.ObjectIdentifier
+	if s.Purpose == PurposeServerAuth {
+		ekus = []asn1.ObjectIdentifier{{1, 3, 6, 1, 5, 5, 7, 3, 1}}
+	}
+	if err := store.addCert(cert, ekus); err != nil {
+		return fmt.Errorf("add cert: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) uninstallPlatform(ctx context.Context) error {
+	// We'll just remove all certs with the same serial number
+	// Open root store
+	store, err := openWindowsRootStore()
+	if err != nil {
+		return fmt.Errorf("open root store: %w", err)
+	}
+	defer store.close()
+	// Do the deletion
+	deletedAny, err := store.deleteCertsWithSerial(s.Root.SerialNumber)
+	if err == nil && !deletedAny {
+		err = fmt.Errorf("no certs found")
+	}
+	if err != nil {
+		return fmt.Errorf("delete cert: %w", err)
+	}
+	return nil
+}
+
+// SystemRoots returns the certificates in the system root store.
+func SystemRoots() ([]*x509.Certificate, error) {
+	store, err := openWindowsRootStore()
+	if err != nil {
+		return nil, fmt.Errorf("open root store: %w", err)
+	}
+	defer store.close()
+	return store.certs()
+}
+
+// platformDistrusted reports whether the root is in the Untrusted
+// Certificates store, where group policies and antivirus software put
+// certificates they block. Windows rejects those even if they are in the
+// root store.
+func (s *Store) platformDistrusted() bool {
+	store, err := openWindowsSystemStore("Disallowed")
+	if err != nil {
+		return false
+	}
+	defer store.close()
+	certs, err := store.certs()
+	if err != nil {
+		return false
+	}
+	for _, cert := range certs {
+		if bytes.Equal(cert.Raw, s.Root.Raw) {
+			return true
+		}
+	}
+	return false
+}
+
+type windowsRootStore uintptr
+
+func openWindowsRootStore() (windowsRootStore, error) {
+	return openWindowsSystemStore("ROOT")
+}
+
+// openWindowsSystemStore opens a system store of the current user, which
+// also includes the certificates of the machine and of group policies.
+func openWindowsSystemStore(name string) (windowsRootStore, error) {
+	nameStr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+	store, _, err := procCertOpenSystemStoreW.Call(0, uintptr(unsafe.Pointer(nameStr)))
+	if store != 0 {
+		return windowsRootStore(store), nil
+	}
+	return 0, fmt.Errorf("failed to open windows %s store: %v", name, err)
+}
+
+func (w windowsRootStore) close() error {
+	ret, _, err := procCertCloseStore.Call(uintptr(w), 0)
+	if ret != 0 {
+		return nil
+	}
+	return fmt.Errorf("failed to close windows root store: %v", err)
+}
+
+// addCert adds cert to the store. If ekus is not empty, the certificate is
+// only trusted for those extended key usages.
+func (w windowsRootStore) addCert(cert []byte, ekus []asn1.ObjectIdentifier) error {
+	// TODO: ok to always overwrite?
+	var ctx *syscall.CertContext
+	ret, _, err := procCertAddEncodedCertificateToStore.Call(
+		uintptr(w), // HCERTSTORE hCertStore
+		uintptr(syscall.X509_ASN_ENCODING|syscall.PKCS_7_ASN_ENCODING), // DWORD dwCertEncodingType
+		uintptr(unsafe.Pointer(&cert[0])),                              // const BYTE *pbCertEncoded
+		uintptr(len(cert)),                                             // DWORD cbCertEncoded
+		3,                                                              // DWORD dwAddDisposition (CERT_STORE_ADD_REPLACE_EXISTING is 3)
+		uintptr(unsafe.Pointer(&ctx)),                                  // PCCERT_CONTEXT *ppCertContext
+	)
+	if ret == 0 {
+		return fmt.Errorf("failed adding cert: %v", err)
+	}
+	defer procCertFreeCertificateContext.Call(uintptr(unsafe.Pointer(ctx)))
+	if len(ekus) == 0 {
+		return nil
+	}
+
+	// CERT_ENHKEY_USAGE_PROP_ID is an encoded EKU extension value.
+	usage, err := asn1.Marshal(ekus)
+	if err != nil {
+		return err
+	}
+	blob := struct { // CRYPT_DATA_BLOB
+		size uint32
+		data *byte
+	}{uint32(len(usage)), &usage[0]}
+	ret, _, err = procCertSetCertificateContextProperty.Call(
+		uintptr(unsafe.Pointer(ctx)),   // PCCERT_CONTEXT pCertContext
+		9,                              // DWORD dwPropId (CERT_ENHKEY_USAGE_PROP_ID is 9)
+		0,                              // DWORD dwFlags
+		uintptr(unsafe.Pointer(&blob)), // const void *pvData
+	)
+	if ret == 0 {
+		return fmt.Errorf("failed setting cert purposes: %v", err)
+	}
+	return nil
+}
+
+func (w windowsRootStore) deleteCertsWithSerial(serial *big.Int) (bool, error) {
+	// Go over each, deleting the ones we find
+	var cert *syscall.CertContext
+	deletedAny := false
+	for {
+		// Next enum
+		var err error
+		if cert, err = syscall.CertEnumCertificatesInStore(syscall.Handle(w), cert); cert == nil {
+			if errno, ok := err.(syscall.Errno); ok && errno == 0x80092004 {
+				break
+			}
+			return deletedAny, fmt.Errorf("failed enumerating certs: %v", err)
+		}
+		// Parse cert
+		certBytes := unsafe.Slice(cert.EncodedCert, cert.Length)
+		parsedCert, err := x509.ParseCertificate(certBytes)
+		// We'll just ignore parse failures for now
+		if err == nil && parsedCert.SerialNumber != nil && parsedCert.SerialNumber.Cmp(serial) == 0 {
+			// Duplicate the context so it doesn't stop the enum when we delete it
+			dupCertPtr, _, err := procCertDuplicateCertificateContext.Call(uintptr(unsafe.Pointer(cert)))
+			if dupCertPtr == 0 {
+				return deletedAny, fmt.Errorf("failed duplicating context: %v", err)
+			}
+			if ret, _, err := procCertDeleteCertificateFromStore.Call(dupCertPtr); ret == 0 {
+				return deletedAny, fmt.Errorf("failed deleting certificate: %v", err)
+			}
+			deletedAny = true
+		}
+	}
+	return deletedAny, nil
+}
+
+func (w windowsRootStore) certs() ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	var cert *syscall.CertContext
+	for {
+		var err error
+		if cert, err = syscall.CertEnumCertificatesInStore(syscall.Handle(w), cert); cert == nil {
+			if errno, ok := err.(syscall.Errno); ok && errno == 0x80092004 {
+				break
+			}
+			return nil, fmt.Errorf("failed enumerating certs: %v", err)
+		}
+		// Copy the encoding, as it's freed when the enumeration moves on
+		certBytes := unsafe.Slice(cert.EncodedCert, cert.Length)
+		parsedCert, err := x509.ParseCertificate(append([]byte(nil), certBytes...))
+		// We'll just ignore parse failures for now
+		if err == nil {
+			certs = append(certs, parsedCert)
+		}
+	}
+	return certs, nil
+}
+
+func (s *Store) platformRoots(ctx context.Context) ([]*x509.Certificate, error) {
+	return SystemRoots()
+}
+
+func (s *Store) platformInfo() PlatformInfo {
+	return PlatformInfo{Mechanism: "windows-store", Path: "CurrentUser\\Root"}
+}
@@ -0,0 +1,204 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package truststore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Level is the severity of a Message.
+type Level int
+
+const (
+	// Note is information the user might need to act on, like a browser
+	// that has to be restarted.
+	Note Level = iota
+	// Warning means an operation could not be completed, like a trust store
+	// that can't be modified because a tool is missing.
+	Warning
+)
+
+func (l Level) String() string {
+	switch l {
+	case Note:
+		return "note"
+	case Warning:
+		return "warning"
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// The kinds of Messages. New kinds might be added in the future, so
+// applications should render the Text and Help of any kind they don't
+// handle specially.
+const (
+	KindUnsupported    = "unsupported"
+	KindNoCertutil     = "no-certutil"
+	KindNoKeytool      = "no-keytool"
+	KindNoSudo         = "no-sudo"
+	KindNoNSSDatabases = "no-nss-databases"
+	KindNSSLegacy      = "nss-legacy"
+	KindVerifyFailed   = "verify-failed"
+	KindDistrusted     = "distrusted"
+	KindRestartBrowser = "restart-browser"
+	KindRestartVM      = "restart-vm"
+	KindSetEnv         = "set-env"
+	KindUnsetEnv       = "unset-env"
+)
+
+// A Message is a note or warning for the user about an operation on a trust
+// store, beyond its Result.
+type Message struct {
+	Level Level
+	Kind  string
+
+	// Store is the trust store the message is about, or empty if it's about
+	// the operation as a whole.
+	Store string
+
+	// Text is the message, as a lowercase sentence like an error message.
+	Text string
+
+	// Help, if not empty, is what the user can do about it, as a sentence.
+	Help string
+}
+
+// A Reporter receives the Messages produced by a Store or a CmdFS, so that
+// applications can show them in their own interface.
+type Reporter interface {
+	Report(Message)
+}
+
+// ReporterFunc adapts a function to the Reporter interface.
+type ReporterFunc func(Message)
+
+// Report calls f(msg).
+func (f ReporterFunc) Report(msg Message) { f(msg) }
+
+// report sends a message about the trust store of r to s.Reporter, if set,
+// and marks r as Reported.
+func (s *Store) report(r *Result, level Level, kind, text, help string) {
+	r.Reported = true
+	if s.Reporter != nil {
+		s.Reporter.Report(Message{Level: level, Kind: kind, Store: r.Store, Text: text, Help: help})
+	}
+}
+
+// reportInstall explains the outcome of installing in the trust store of r,
+// if there is something the user should know.
+func (s *Store) reportInstall(r *Result) {
+	if r.Store == "nss" && r.Err == nil {
+		s.reportNSSLegacy(r)
+	}
+	var envErr *EnvError
+	switch {
+	case r.Store == "system" && errors.Is(r.Err, ErrUnsupported):
+		s.report(r, Warning, KindUnsupported,
+			fmt.Sprintf("installing to the system store is not yet supported on this Linux, but %s will still work", NSSBrowsers),
+			fmt.Sprintf("You can also manually install the root certificate at %q.", s.RootPath))
+	case r.Store == "system" && errors.Is(r.Err, ErrPlatformDistrusted):
+		s.report(r, Warning, KindDistrusted,
+			"the CA is in the Windows Untrusted Certificates store, so browsers like Chrome and Edge will reject its certificates even though it's installed",
+			`It was probably put there by a group policy or an antivirus. Remove it from "Untrusted Certificates" in certmgr.msc, or ask your administrator.`)
+	case r.Store == "system" && errors.Is(r.Err, ErrPlatformInstallFailed):
+		s.report(r, Warning, KindVerifyFailed,
+			fmt.Sprintf("the CA was added to the system trust store, but a new process still doesn't trust it: %v", r.Err), "")
+
+	case r.Store == "nss" && r.Status == Installed:
+		s.report(r, Note, KindRestartBrowser,
+			fmt.Sprintf("%s must be restarted to trust the CA", NSSBrowsers), "")
+	case r.Store == "nss" && errors.Is(r.Err, ErrUnsupported):
+		s.report(r, Note, KindUnsupported,
+			fmt.Sprintf("%s support is not available on your platform", NSSBrowsers), "")
+	case r.Store == "nss" && errors.Is(r.Err, ErrNoCertutil):
+		s.report(r, Warning, KindNoCertutil,
+			fmt.Sprintf(`"certutil" is not available, so the CA can't be automatically installed in %s!`, NSSBrowsers),
+			fmt.Sprintf(`Install "certutil" with "%s" and try again.`, s.CertutilInstallHelp()))
+	case r.Store == "nss" && errors.Is(r.Err, ErrNoNSSDatabases):
+		s.report(r, Warning, KindNoNSSDatabases,
+			fmt.Sprintf("no %s security databases found", NSSBrowsers), "")
+	case r.Store == "nss" && errors.Is(r.Err, ErrNSSInstallFailed):
+		s.report(r, Warning, KindVerifyFailed,
+			fmt.Sprintf("installing in %s failed, please report the issue with details about your environment at https://github.com/FiloSottile/mkcert/issues/new", NSSBrowsers),
+			fmt.Sprintf("Note that if you never started %s, you need to do that at least once.", NSSBrowsers))
+
+	case r.Store == "java" && errors.Is(r.Err, ErrNoKeytool):
+		s.report(r, Warning, KindNoKeytool,
+			`"keytool" is not available, so the CA can't be automatically installed in Java's trust store!`, "")
+
+	case r.Status == Installed && isVMStore(r.Store):
+		s.report(r, Note, KindRestartVM,
+			fmt.Sprintf("restart the container engine for the CA to take effect in the running %s VMs", displayName(r.Store)), "")
+	case errors.As(r.Err, &envErr):
+		s.report(r, Note, KindSetEnv,
+			fmt.Sprintf("%s doesn't use the system trust store", displayName(r.Store)),
+			fmt.Sprintf("To trust the CA, set %s=%q in your environment.", envErr.Var, envErr.Bundle))
+	}
+}
+
+// reportUninstall is like reportInstall, for uninstalling.
+func (s *Store) reportUninstall(r *Result) {
+	var envErr *EnvError
+	switch {
+	case r.Store == "nss" && errors.Is(r.Err, ErrNoCertutil):
+		s.report(r, Warning, KindNoCertutil,
+			fmt.Sprintf(`"certutil" is not available, so the CA can't be automatically uninstalled from %s (if it was ever installed)!`, NSSBrowsers),
+			fmt.Sprintf(`Install "certutil" with "%s" and try again.`, s.CertutilInstallHelp()))
+	case r.Store == "java" && errors.Is(r.Err, ErrNoKeytool):
+		s.report(r, Warning, KindNoKeytool,
+			`"keytool" is not available, so the CA can't be automatically uninstalled from Java's trust store (if it was ever installed)!`, "")
+	case errors.As(r.Err, &envErr):
+		s.report(r, Note, KindUnsetEnv,
+			fmt.Sprintf("%s still points at the CA, remember to unset it for %s", envErr.Var, displayName(r.Store)), "")
+	}
+}
+
+// reportNSSLegacy warns about the cert8.db databases that were written as
+// they are, because browsers that can't read them might use the profile.
+func (s *Store) reportNSSLegacy(r *Result) {
+	if s.NSSLegacy != NSSLegacyDBM || s.detect().nss.enterpriseRoots {
+		return
+	}
+	var legacy []string
+	for _, p := range s.NSSProfiles() {
+		if p.Format == "dbm" {
+			legacy = append(legacy, p.Path)
+		}
+	}
+	if len(legacy) == 0 {
+		return
+	}
+	s.report(r, Note, KindNSSLegacy,
+		fmt.Sprintf("the NSS databases in %s use the legacy cert8.db format, which Firefox 58 and later ignore", strings.Join(legacy, ", ")),
+		"Migrate them to the cert9.db format, or write both formats for older browsers.")
+}
+
+// displayName returns the name of a trust store for messages.
+func displayName(store string) string {
+	switch store {
+	case "deno":
+		return "Deno"
+	case "bun":
+		return "Bun"
+	case "podman":
+		return "podman machine"
+	case "lima":
+		return "Lima"
+	case "colima":
+		return "Colima"
+	}
+	return store
+}
+
+func isVMStore(name string) bool {
+	for _, v := range vmStores {
+		if v.name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,724 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package truststore installs and removes a root certificate from the trust
+// stores of the local machine: the system store, the NSS databases used by
+// Firefox and Chrome/Chromium, the Java cacerts keystore, the CA bundles
+// loaded by Deno and Bun, and the running podman machine, Lima and Colima
+// VMs.
+//
+// None of the functions in this package terminate the program, so it can be
+// driven by GUIs and daemons as well as by the mkcert command. External
+// commands are run through a CmdFS, which can bound how long they run, and
+// notes and warnings for the user are sent to a Reporter to be displayed.
+// Applications can depend on the TrustStore interface, and use a MemStore
+// in their tests instead of modifying the trust stores of the machine.
+package truststore
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrUnsupported is returned when a trust store can't be managed on the
+	// current platform.
+	ErrUnsupported = errors.New("not supported on this platform")
+
+	// ErrNoCertutil is returned when NSS databases are present, but the
+	// "certutil" tool needed to modify them is not installed.
+	ErrNoCertutil = errors.New(`"certutil" is not available`)
+
+	// ErrNoKeytool is returned when Java is present, but the "keytool" tool
+	// needed to modify its trust store is not installed.
+	ErrNoKeytool = errors.New(`"keytool" is not available`)
+
+	// ErrNoNSSDatabases is returned when no NSS security databases were found
+	// to install the root into.
+	ErrNoNSSDatabases = errors.New("no NSS security databases found")
+
+	// ErrNSSInstallFailed is returned when the root was added to the NSS
+	// databases, but is still not reported as trusted by them.
+	ErrNSSInstallFailed = errors.New("NSS installation could not be verified")
+
+	// ErrNSSReadOnly, ErrNSSBadDatabase and ErrNSSLocked are the causes of
+	// an NSSError: the database can't be written even with sudo, it's
+	// corrupted or from an incompatible NSS version, or it's locked by a
+	// running browser.
+	ErrNSSReadOnly    = errors.New("the NSS database is read-only")
+	ErrNSSBadDatabase = errors.New("the NSS database is corrupted or unsupported")
+	ErrNSSLocked      = errors.New("the NSS database is locked")
+
+	// ErrPlatformInstallFailed is returned when the root was added to the
+	// system trust store, but VerifyPlatform reports it's still not trusted.
+	ErrPlatformInstallFailed = errors.New("system trust store installation could not be verified")
+
+	// ErrPlatformDistrusted is returned when the root is in the system trust
+	// store, but also in a store of explicitly distrusted certificates, like
+	// the Untrusted Certificates store on Windows, which takes precedence.
+	ErrPlatformDistrusted = errors.New("the root is explicitly distrusted by the system")
+)
+
+// CmdError is returned when an external command fails.
+type CmdError struct {
+	Cmd string
+	Out []byte
+	Err error
+}
+
+func (e *CmdError) Error() string {
+	return fmt.Sprintf("failed to execute \"%s\": %s\n\n%s\n", e.Cmd, e.Err, e.Out)
+}
+
+func (e *CmdError) Unwrap() error { return e.Err }
+
+// Status is the state of the root in a single trust store.
+type Status int
+
+const (
+	// NotInstalled means the root is not in the store.
+	NotInstalled Status = iota
+	// AlreadyInstalled means the root was in the store before the operation.
+	AlreadyInstalled
+	// Installed means the root was added to the store.
+	Installed
+	// Uninstalled means the root was removed from the store, if present.
+	Uninstalled
+	// Failed means the operation could not be completed. Result.Err says why.
+	Failed
+)
+
+func (s Status) String() string {
+	switch s {
+	case NotInstalled:
+		return "not-installed"
+	case AlreadyInstalled:
+		return "already-installed"
+	case Installed:
+		return "installed"
+	case Uninstalled:
+		return "uninstalled"
+	case Failed:
+		return "failed"
+	default:
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+}
+
+// MultiError is returned by Install and Uninstall when ContinueOnError is set
+// and the operation failed for one or more trust stores.
+type MultiError struct {
+	Errors []error
+}
+
+func (e *MultiError) Error() string {
+	var msgs []string
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the errors matches target.
+func (e *MultiError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors that matches target.
+func (e *MultiError) As(target any) bool {
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Result is the outcome of an operation on a single trust store.
+type Result struct {
+	// Store is "system", "nss", "java", "deno", "bun", "podman", "lima" or
+	// "colima".
+	Store  string
+	Status Status
+	Err    error
+
+	// Root is the certificate the operation was about. It's only set by
+	// UninstallMatching, which can operate on multiple roots.
+	Root *x509.Certificate
+
+	// Reported is set if the Store produced a Message about this Result,
+	// which was sent to its Reporter, so that callers don't need to explain
+	// it again.
+	Reported bool
+}
+
+// Store manages a root certificate in the trust stores of the local machine.
+type Store struct {
+	// RootPath is the path of the PEM-encoded root certificate, which is
+	// passed to the external tools that modify the trust stores.
+	RootPath string
+
+	// Root is the parsed root certificate at RootPath.
+	Root *x509.Certificate
+
+	// Stores restricts operations to the named trust stores ("system",
+	// "nss", "java", "deno", "bun", "podman", "lima" and "colima"). If
+	// empty, all trust stores are used. A name prefixed with "-" excludes
+	// that store instead, and "all" selects every store, so that
+	// {"all", "-nss"} and {"-nss"} both select all stores but NSS.
+	Stores []string
+
+	// CmdFS runs the external commands. If nil, the zero CmdFS is used.
+	CmdFS *CmdFS
+
+	// ContinueOnError makes Install and Uninstall go through all the trust
+	// stores even if some fail, recording the errors in their Results and
+	// returning them at the end as a *MultiError.
+	ContinueOnError bool
+
+	// NSSProfile, if set, is the directory of the only NSS database the
+	// "nss" trust store operates on, like a single Firefox profile, instead
+	// of all the detected ones.
+	NSSProfile string
+
+	// NSSLegacy selects how NSS databases in the legacy cert8.db format are
+	// handled. Databases that also have a cert9.db are always written in
+	// that format, which is the only one current browsers read.
+	NSSLegacy NSSLegacy
+
+	// Java, if not nil, replaces the detected Java runtimes. Empty fields
+	// other than Home are filled in from Home, as by DetectJava.
+	Java []JavaRuntime
+
+	// Purpose selects what the root is trusted to certify in the system and
+	// NSS trust stores. The Linux system stores and Java have no per-root
+	// purposes, so there the root is always trusted for everything.
+	Purpose Purpose
+
+	// VerifyPlatform, if not nil, is called after installing the root in the
+	// system trust store, and returns an error if the platform verifier still
+	// doesn't trust it. The system cert pool is only loaded once per process
+	// (https://github.com/golang/go/issues/24540), so it should check from a
+	// new process, like a re-execution of the program. If nil, a successful
+	// installation is assumed to be trusted.
+	VerifyPlatform func(ctx context.Context) error
+
+	// Reporter, if not nil, receives the notes and warnings produced by
+	// Install and Uninstall, like a browser that needs to be restarted or a
+	// missing tool. Otherwise, they are only reflected in the Results.
+	Reporter Reporter
+
+	// After installing the root, checks in this process keep failing until
+	// the next execution, so they are skipped once the install is verified.
+	ignoreCheckFailure bool
+
+	// The trust stores present on the machine are detected on first use, and
+	// the results are kept with the Store, so that Stores with different
+	// CmdFS or environments don't interfere. A Store must not be copied
+	// after first use.
+	detectOnce   sync.Once
+	detected     detection
+	cmdFSOnce    sync.Once
+	defaultCmdFS *CmdFS
+}
+
+// detection is what a Store found out about the trust stores of the machine.
+type detection struct {
+	platform platformState
+	nss      nssState
+	java     javaState
+	env      map[string]bool
+	vms      map[string]bool
+}
+
+func (s *Store) detect() *detection {
+	s.detectOnce.Do(func() {
+		s.detected = detection{
+			platform: detectPlatform(),
+			nss:      s.detectNSS(),
+			java:     s.detectJava(),
+			env:      detectEnvStores(),
+			vms:      detectVMStores(),
+		}
+	})
+	return &s.detected
+}
+
+// PlatformInfo describes how the root is added to the system trust store.
+type PlatformInfo struct {
+	// Mechanism is "keychain" on macOS, "windows-store" on Windows, and
+	// "update-ca-trust", "update-ca-certificates" or "p11-kit" on Linux,
+	// depending on the tool that regenerates the system trust store. It's
+	// empty if the system trust store is not supported.
+	Mechanism string
+
+	// Path is the file the root is written to on Linux, or the keychain or
+	// certificate store it's added to on macOS and Windows.
+	Path string
+
+	// Command is the command run after writing Path on Linux.
+	Command []string
+}
+
+// PlatformInfo returns the mechanism used to install the root in the system
+// trust store.
+func (s *Store) PlatformInfo() PlatformInfo {
+	return s.platformInfo()
+}
+
+// CertutilInstallHelp returns the command to install certutil, which is
+// needed to manage the NSS databases, or "" if it's not supported on this
+// platform.
+func (s *Store) CertutilInstallHelp() string {
+	return s.detect().platform.certutilInstallHelp
+}
+
+// A Purpose is what an installed root is trusted to certify.
+type Purpose int
+
+const (
+	// PurposeDefault trusts the root for TLS servers in NSS, for TLS servers
+	// and basic X.509 validation on macOS, and for all purposes on Windows.
+	PurposeDefault Purpose = iota
+
+	// PurposeServerAuth only trusts the root for TLS server certificates.
+	PurposeServerAuth
+
+	// PurposeAll trusts the root for all purposes, including client
+	// authentication, S/MIME and code signing.
+	PurposeAll
+)
+
+// NSSLegacy is how an NSS database in the legacy cert8.db format is handled.
+// Firefox 58 and later only read the cert9.db format, and migrate profiles
+// to it, so a root added to a leftover cert8.db is not trusted.
+type NSSLegacy int
+
+const (
+	// NSSLegacyDBM writes the root to cert8.db databases as they are, and
+	// reports a Message suggesting to migrate them.
+	NSSLegacyDBM NSSLegacy = iota
+
+	// NSSLegacyMigrate opens cert8.db databases in the cert9.db format,
+	// which makes NSS migrate their contents to a new cert9.db.
+	NSSLegacyMigrate
+
+	// NSSLegacyDualWrite migrates databases like NSSLegacyMigrate, but also
+	// keeps the cert8.db up to date, for older browsers using the profile.
+	NSSLegacyDualWrite
+)
+
+// Enabled reports whether the named trust store is selected by s.Stores.
+func (s *Store) Enabled(name string) bool {
+	if len(s.Stores) == 0 {
+		return true
+	}
+	enabled, onlyExclusions := false, true
+	for _, store := range s.Stores {
+		switch {
+		case store == "-"+name:
+			return false
+		case strings.HasPrefix(store, "-"):
+		case store == "all" || store == name:
+			enabled, onlyExclusions = true, false
+		default:
+			onlyExclusions = false
+		}
+	}
+	return enabled || onlyExclusions
+}
+
+// Check reports whether the root is installed in each enabled trust store
+// that is present on the system.
+func (s *Store) Check() ([]Result, error) {
+	return s.CheckContext(context.Background())
+}
+
+// CheckContext is like Check, but kills any running command if ctx is done.
+func (s *Store) CheckContext(ctx context.Context) ([]Result, error) {
+	var results []Result
+	if s.Enabled("system") {
+		results = append(results, Result{Store: "system", Status: installedStatus(s.checkPlatform())})
+	}
+	if s.Enabled("nss") && s.detect().nss.found && (s.CertutilInstallHelp() != "" || s.detect().nss.enterpriseRoots) {
+		results = append(results, Result{Store: "nss", Status: installedStatus(s.checkNSS(ctx))})
+	}
+	if s.Enabled("java") && s.detect().java.found {
+		ok, err := s.checkJava(ctx)
+		if err != nil && !errors.Is(err, ErrNoKeytool) {
+			return results, err
+		}
+		results = append(results, Result{Store: "java", Status: installedStatus(ok)})
+	}
+	for _, e := range envStores {
+		if s.Enabled(e.name) && s.detect().env[e.name] {
+			results = append(results, Result{Store: e.name, Status: installedStatus(s.checkEnv(e))})
+		}
+	}
+	for _, v := range vmStores {
+		if !s.Enabled(v.name) || !s.detect().vms[v.name] {
+			continue
+		}
+		vms, err := s.runningVMs(ctx, v)
+		if err != nil {
+			return results, err
+		}
+		if len(vms) > 0 {
+			results = append(results, Result{Store: v.name, Status: s.checkVMs(ctx, v, vms)})
+		}
+	}
+	return results, nil
+}
+
+func installedStatus(ok bool) Status {
+	if ok {
+		return AlreadyInstalled
+	}
+	return NotInstalled
+}
+
+// Install adds the root to each enabled trust store that is present on the
+// system. It stops at the first error, returning the results so far, unless
+// ContinueOnError is set.
+func (s *Store) Install() ([]Result, error) {
+	return s.InstallContext(context.Background())
+}
+
+// InstallContext is like Install, but kills any running command if ctx is
+// done.
+func (s *Store) InstallContext(ctx context.Context) ([]Result, error) {
+	var results []Result
+	var errs []error
+	if s.Enabled("system") {
+		r := Result{Store: "system", Status: AlreadyInstalled}
+		if !s.checkPlatform() {
+			err := s.installPlatform(ctx)
+			if err == nil && s.VerifyPlatform != nil {
+				if verr := s.VerifyPlatform(ctx); verr != nil {
+					err = fmt.Errorf("%w: %v", ErrPlatformInstallFailed, verr)
+				}
+			}
+			if err == nil {
+				s.ignoreCheckFailure = true
+			}
+			if err == nil && s.platformDistrusted() {
+				err = ErrPlatformDistrusted
+			}
+			if errors.Is(err, ErrUnsupported) || errors.Is(err, ErrPlatformInstallFailed) || errors.Is(err, ErrPlatformDistrusted) {
+				r.Status, r.Err = Failed, err
+			} else if err != nil {
+				if err := s.storeFailed(&r, err, &errs); err != nil {
+					return results, err
+				}
+			} else {
+				r.Status = Installed
+			}
+		}
+		s.reportInstall(&r)
+		results = append(results, r)
+	}
+	if s.Enabled("nss") && s.detect().nss.found {
+		r := Result{Store: "nss", Status: AlreadyInstalled}
+		if !s.checkNSS(ctx) {
+			switch {
+			case !s.detect().nss.usable() && s.CertutilInstallHelp() == "":
+				r.Status, r.Err = Failed, ErrUnsupported
+			case !s.detect().nss.usable():
+				r.Status, r.Err = Failed, ErrNoCertutil
+			default:
+				err := s.installNSS(ctx)
+				if errors.Is(err, ErrNoNSSDatabases) || errors.Is(err, ErrNSSInstallFailed) {
+					r.Status, r.Err = Failed, err
+				} else if err != nil {
+					if err := s.storeFailed(&r, err, &errs); err != nil {
+						return results, err
+					}
+				} else {
+					r.Status = Installed
+				}
+			}
+		}
+		s.reportInstall(&r)
+		results = append(results, r)
+	}
+	if s.Enabled("java") && s.detect().java.found {
+		r := Result{Store: "java", Status: AlreadyInstalled}
+		ok, err := s.checkJava(ctx)
+		switch {
+		case errors.Is(err, ErrNoKeytool):
+			r.Status, r.Err = Failed, err
+		case err != nil:
+			if err := s.storeFailed(&r, err, &errs); err != nil {
+				return results, err
+			}
+		case ok:
+		case len(s.detect().java.runtimes) == 0:
+			r.Status, r.Err = Failed, ErrNoKeytool
+		default:
+			if err := s.installJava(ctx); errors.Is(err, ErrNoKeytool) {
+				r.Status, r.Err = Failed, err
+			} else if err != nil {
+				if err := s.storeFailed(&r, err, &errs); err != nil {
+					return results, err
+				}
+			} else {
+				r.Status = Installed
+			}
+		}
+		s.reportInstall(&r)
+		results = append(results, r)
+	}
+	for _, e := range envStores {
+		if !s.Enabled(e.name) || !s.detect().env[e.name] {
+			continue
+		}
+		r := Result{Store: e.name, Status: AlreadyInstalled}
+		if !s.checkEnv(e) {
+			var envErr *EnvError
+			if err := s.installEnv(e); errors.As(err, &envErr) {
+				r.Status, r.Err = Failed, err
+			} else if err != nil {
+				if err := s.storeFailed(&r, err, &errs); err != nil {
+					return results, err
+				}
+			} else {
+				r.Status = Installed
+			}
+		}
+		s.reportInstall(&r)
+		results = append(results, r)
+	}
+	for _, v := range vmStores {
+		if !s.Enabled(v.name) || !s.detect().vms[v.name] {
+			continue
+		}
+		r := Result{Store: v.name}
+		vms, err := s.runningVMs(ctx, v)
+		if err == nil && len(vms) == 0 {
+			continue
+		}
+		if err == nil {
+			r.Status, err = s.installVMs(ctx, v, vms)
+		}
+		if err != nil {
+			if err := s.storeFailed(&r, err, &errs); err != nil {
+				return results, err
+			}
+		}
+		s.reportInstall(&r)
+		results = append(results, r)
+	}
+	return results, multiError(errs)
+}
+
+// Uninstall removes the root from each enabled trust store that is present
+// on the system. It stops at the first error, returning the results so far,
+// unless ContinueOnError is set.
+func (s *Store) Uninstall() ([]Result, error) {
+	return s.UninstallContext(context.Background())
+}
+
+// UninstallContext is like Uninstall, but kills any running command if ctx
+// is done.
+func (s *Store) UninstallContext(ctx context.Context) ([]Result, error) {
+	var results []Result
+	var errs []error
+	if s.Enabled("nss") && s.detect().nss.found {
+		r := Result{Store: "nss", Status: Uninstalled}
+		switch {
+		case s.detect().nss.usable():
+			if err := s.uninstallNSS(ctx); err != nil {
+				if err := s.storeFailed(&r, err, &errs); err != nil {
+					return results, err
+				}
+			}
+		case s.CertutilInstallHelp() != "":
+			r.Status, r.Err = Failed, ErrNoCertutil
+		default:
+			r.Status, r.Err = Failed, ErrUnsupported
+		}
+		s.reportUninstall(&r)
+		results = append(results, r)
+	}
+	if s.Enabled("java") && s.detect().java.found {
+		r := Result{Store: "java", Status: Uninstalled}
+		if len(s.detect().java.runtimes) > 0 {
+			if err := s.uninstallJava(ctx); errors.Is(err, ErrNoKeytool) {
+				r.Status, r.Err = Failed, err
+			} else if err != nil {
+				if err := s.storeFailed(&r, err, &errs); err != nil {
+					return results, err
+				}
+			}
+		} else {
+			r.Status, r.Err = Failed, ErrNoKeytool
+		}
+		s.reportUninstall(&r)
+		results = append(results, r)
+	}
+	for _, e := range envStores {
+		if !s.Enabled(e.name) || !s.detect().env[e.name] {
+			continue
+		}
+		r := Result{Store: e.name, Status: Uninstalled}
+		var envErr *EnvError
+		if err := s.uninstallEnv(e); errors.As(err, &envErr) {
+			r.Status, r.Err = Failed, err
+		} else if err != nil {
+			if err := s.storeFailed(&r, err, &errs); err != nil {
+				return results, err
+			}
+		}
+		s.reportUninstall(&r)
+		results = append(results, r)
+	}
+	for _, v := range vmStores {
+		if !s.Enabled(v.name) || !s.detect().vms[v.name] {
+			continue
+		}
+		r := Result{Store: v.name}
+		vms, err := s.runningVMs(ctx, v)
+		if err == nil && len(vms) == 0 {
+			continue
+		}
+		if err == nil {
+			r.Status, err = s.uninstallVMs(ctx, v, vms)
+		}
+		if err != nil {
+			if err := s.storeFailed(&r, err, &errs); err != nil {
+				return results, err
+			}
+		}
+		s.reportUninstall(&r)
+		results = append(results, r)
+	}
+	if s.Enabled("system") {
+		r := Result{Store: "system", Status: Uninstalled}
+		err := s.uninstallPlatform(ctx)
+		if errors.Is(err, ErrUnsupported) {
+			r.Status, r.Err = Failed, err
+		} else if err != nil {
+			if err := s.storeFailed(&r, err, &errs); err != nil {
+				return results, err
+			}
+		}
+		s.reportUninstall(&r)
+		results = append(results, r)
+	}
+	return results, multiError(errs)
+}
+
+// storeFailed handles an unexpected error for the trust store of r. If
+// ContinueOnError is set, it records err in r and errs, and returns nil.
+// Otherwise, it returns err to stop the operation.
+func (s *Store) storeFailed(r *Result, err error, errs *[]error) error {
+	if !s.ContinueOnError {
+		return err
+	}
+	r.Status, r.Err = Failed, err
+	*errs = append(*errs, fmt.Errorf("%s: %w", r.Store, err))
+	return nil
+}
+
+func multiError(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return &MultiError{Errors: errs}
+}
+
+func (s *Store) checkPlatform() bool {
+	if s.ignoreCheckFailure {
+		return true
+	}
+
+	_, err := s.Root.Verify(x509.VerifyOptions{})
+	return err == nil
+}
+
+func (s *Store) cmdFS() *CmdFS {
+	if s.CmdFS != nil {
+		return s.CmdFS
+	}
+	s.cmdFSOnce.Do(func() { s.defaultCmdFS = &CmdFS{} })
+	return s.defaultCmdFS
+}
+
+func (s *Store) uniqueName() string {
+	return "mkcert development CA " + s.Root.SerialNumber.String()
+}
+
+// parseCertificates returns the certificates in a PEM bundle, skipping any
+// other blocks and unparseable certificates.
+func parseCertificates(bundle []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			return certs
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+}
+
+func cmdErr(err error, cmd string, out []byte) error {
+	if err != nil {
+		return &CmdError{Cmd: cmd, Out: out, Err: err}
+	}
+	return nil
+}
+
+func pathExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func binaryExists(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+func (c *CmdFS) commandWithSudo(cmd ...string) *exec.Cmd {
+	if !c.needsSudo() {
+		return exec.Command(cmd[0], cmd[1:]...)
+	}
+	return exec.Command("sudo", append([]string{"--prompt=Sudo password:", "--"}, cmd...)...)
+}
+
+// needsSudo reports whether privileged commands have to go through sudo,
+// warning if they need to but can't.
+func (c *CmdFS) needsSudo() bool {
+	if u, err := user.Current(); err == nil && u.Uid == "0" {
+		return false
+	}
+	if !binaryExists("sudo") {
+		c.sudoWarning.Do(func() {
+			if c.Reporter != nil {
+				c.Reporter.Report(Message{Level: Warning, Kind: KindNoSudo,
+					Text: `"sudo" is not available, and mkcert is not running as root, so the (un)install operation might fail`})
+			}
+		})
+		return false
+	}
+	return true
+}
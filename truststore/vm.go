@@ -0,0 +1,185 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package truststore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// A vmStore is a tool that runs containers in Linux VMs, like on macOS.
+// Containers don't inherit the trust store of the host, so the root is
+// installed in the system trust store of each running VM.
+type vmStore struct {
+	name, binary string
+	// list returns the running VMs, given the output of listArgs.
+	listArgs []string
+	list     func(out []byte) []string
+	// shell returns the arguments to run a root shell command in vm.
+	shell func(vm, script string) []string
+}
+
+var vmStores = []*vmStore{
+	{
+		name: "podman", binary: "podman",
+		listArgs: []string{"machine", "list", "--format", "{{.Name}} {{.Running}}"},
+		list: func(out []byte) []string {
+			var vms []string
+			for _, f := range fieldLines(out) {
+				if len(f) == 2 && f[1] == "true" {
+					// The default machine is listed with a trailing "*".
+					vms = append(vms, strings.TrimSuffix(f[0], "*"))
+				}
+			}
+			return vms
+		},
+		shell: func(vm, script string) []string {
+			return []string{"machine", "ssh", vm, "sudo sh -c " + shellQuote(script)}
+		},
+	},
+	{
+		name: "lima", binary: "limactl",
+		listArgs: []string{"list", "--format", "{{.Name}} {{.Status}}"},
+		list: func(out []byte) []string {
+			var vms []string
+			for _, f := range fieldLines(out) {
+				// Colima VMs are Lima instances, handled by the colima store.
+				if len(f) == 2 && f[1] == "Running" && !strings.HasPrefix(f[0], "colima") {
+					vms = append(vms, f[0])
+				}
+			}
+			return vms
+		},
+		shell: func(vm, script string) []string {
+			return []string{"shell", vm, "sudo", "sh", "-c", script}
+		},
+	},
+	{
+		name: "colima", binary: "colima",
+		listArgs: []string{"list", "--json"},
+		list: func(out []byte) []string {
+			var vms []string
+			for _, line := range bytes.Split(out, []byte("\n")) {
+				var p struct{ Name, Status string }
+				if json.Unmarshal(line, &p) == nil && p.Status == "Running" {
+					vms = append(vms, p.Name)
+				}
+			}
+			return vms
+		},
+		shell: func(vm, script string) []string {
+			return []string{"ssh", "--profile", vm, "--", "sudo", "sh", "-c", script}
+		},
+	},
+}
+
+func fieldLines(out []byte) [][]string {
+	var lines [][]string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		lines = append(lines, strings.Fields(scanner.Text()))
+	}
+	return lines
+}
+
+// detectVMStores returns the names of the vmStores whose tool is installed.
+func detectVMStores() map[string]bool {
+	found := make(map[string]bool)
+	for _, v := range vmStores {
+		found[v.name] = binaryExists(v.binary)
+	}
+	return found
+}
+
+// runningVMs returns the running VMs of v.
+func (s *Store) runningVMs(ctx context.Context, v *vmStore) ([]string, error) {
+	out, err := s.cmdFS().Exec(ctx, exec.Command(v.binary, v.listArgs...))
+	if err != nil {
+		return nil, cmdErr(err, v.binary+" "+strings.Join(v.listArgs, " "), out)
+	}
+	return v.list(out), nil
+}
+
+// vmScript returns a shell script that runs op with the anchor file and
+// regeneration command of the first of Distros the VM matches. The script
+// exits with status 3 if none match.
+func (s *Store) vmScript(op func(file string, regen []string) string) string {
+	var script strings.Builder
+	for _, d := range Distros {
+		file := d.AnchorDir + "/" + s.SystemTrustName() + d.Ext
+		fmt.Fprintf(&script, "if [ -d %s ]; then %s; exit $?; fi; ", shellQuote(d.AnchorDir), op(file, d.Command))
+	}
+	script.WriteString("exit 3")
+	return script.String()
+}
+
+func (s *Store) execVM(ctx context.Context, v *vmStore, vm, script string, stdin []byte) ([]byte, error) {
+	cmd := exec.Command(v.binary, v.shell(vm, script)...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	out, err := s.cmdFS().Exec(ctx, cmd)
+	return out, cmdErr(err, fmt.Sprintf("%s %s %s", v.binary, cmd.Args[1], vm), out)
+}
+
+func (s *Store) checkVM(ctx context.Context, v *vmStore, vm string) bool {
+	_, err := s.execVM(ctx, v, vm, s.vmScript(func(file string, _ []string) string {
+		return "test -f " + shellQuote(file)
+	}), nil)
+	return err == nil
+}
+
+// vmStatus runs op on each VM, and returns AlreadyInstalled if all of them
+// did, or the other status they returned.
+func vmStatus(vms []string, op func(vm string) (Status, error)) (Status, error) {
+	status := AlreadyInstalled
+	for _, vm := range vms {
+		st, err := op(vm)
+		if err != nil {
+			return Failed, err
+		}
+		if st != AlreadyInstalled {
+			status = st
+		}
+	}
+	return status, nil
+}
+
+func (s *Store) checkVMs(ctx context.Context, v *vmStore, vms []string) Status {
+	status, _ := vmStatus(vms, func(vm string) (Status, error) {
+		return installedStatus(s.checkVM(ctx, v, vm)), nil
+	})
+	return status
+}
+
+func (s *Store) installVMs(ctx context.Context, v *vmStore, vms []string) (Status, error) {
+	root, err := s.cmdFS().ReadFile(s.RootPath)
+	if err != nil {
+		return Failed, fmt.Errorf("failed to read root certificate: %w", err)
+	}
+	return vmStatus(vms, func(vm string) (Status, error) {
+		if s.checkVM(ctx, v, vm) {
+			return AlreadyInstalled, nil
+		}
+		_, err := s.execVM(ctx, v, vm, s.vmScript(func(file string, regen []string) string {
+			return "cat > " + shellQuote(file) + " && " + strings.Join(regen, " ")
+		}), root)
+		return Installed, err
+	})
+}
+
+func (s *Store) uninstallVMs(ctx context.Context, v *vmStore, vms []string) (Status, error) {
+	return vmStatus(vms, func(vm string) (Status, error) {
+		_, err := s.execVM(ctx, v, vm, s.vmScript(func(file string, regen []string) string {
+			return "rm -f " + shellQuote(file) + " && " + strings.Join(regen, " ")
+		}), nil)
+		return Uninstalled, err
+	})
+}
@@ -0,0 +1,292 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package vault issues certificates from a HashiCorp Vault PKI secrets
+// engine, so that mkcert can front a CA shared by a team while still
+// managing the local trust stores.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/mkcert/issuer"
+)
+
+// Client issues certificates from a role of a Vault PKI mount.
+type Client struct {
+	// Addr is the Vault server address, like https://vault.example.com:8200.
+	Addr string
+	// Token is the Vault token used to authenticate.
+	Token string
+	// Namespace is the Vault Enterprise namespace, if any.
+	Namespace string
+	// Mount is the path of the PKI secrets engine, like "pki".
+	Mount string
+	// Role is the name of the role certificates are issued against.
+	Role string
+
+	// HTTPClient is used to make requests. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+}
+
+// FromEnv returns a Client for mount and role, configured from the same
+// environment variables as the vault command: VAULT_ADDR, VAULT_TOKEN (or
+// ~/.vault-token), VAULT_NAMESPACE and VAULT_CACERT.
+func FromEnv(mount, role string) (*Client, error) {
+	c := &Client{
+		Addr:      os.Getenv("VAULT_ADDR"),
+		Token:     os.Getenv("VAULT_TOKEN"),
+		Namespace: os.Getenv("VAULT_NAMESPACE"),
+		Mount:     strings.Trim(mount, "/"),
+		Role:      role,
+	}
+	if c.Addr == "" {
+		return nil, errors.New("VAULT_ADDR is not set")
+	}
+	if c.Token == "" {
+		home, _ := os.UserHomeDir()
+		token, err := ioutil.ReadFile(filepath.Join(home, ".vault-token"))
+		if err != nil {
+			return nil, errors.New("VAULT_TOKEN is not set, and ~/.vault-token can't be read")
+		}
+		c.Token = strings.TrimSpace(string(token))
+	}
+	if caFile := os.Getenv("VAULT_CACERT"); caFile != "" {
+		caPEM, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read VAULT_CACERT: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("failed to read VAULT_CACERT: no certificates found")
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		c.HTTPClient = &http.Client{Transport: transport}
+	}
+	return c, nil
+}
+
+// Error is an error response from Vault.
+type Error struct {
+	StatusCode int
+	Errors     []string
+}
+
+func (e *Error) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("vault returned HTTP status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("vault returned HTTP status %d: %s", e.StatusCode, strings.Join(e.Errors, "; "))
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body interface{}) ([]byte, error) {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.Addr, "/")+"/v1/"+path, &reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", c.Token)
+	req.Header.Set("X-Vault-Request", "true")
+	if c.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach vault: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the vault response: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		vaultErr := &Error{StatusCode: resp.StatusCode}
+		json.Unmarshal(respBody, vaultErr)
+		return nil, vaultErr
+	}
+	return respBody, nil
+}
+
+// Root returns the root of the chain of the PKI mount, which is the
+// certificate to install in the trust stores.
+func (c *Client) Root(ctx context.Context) (*x509.Certificate, error) {
+	chain, err := c.do(ctx, "GET", c.Mount+"/ca_chain", nil)
+	if err != nil || len(bytes.TrimSpace(chain)) == 0 {
+		// Older mounts and mounts without a chain only return the CA.
+		chain, err = c.do(ctx, "GET", c.Mount+"/ca/pem", nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the vault CA: %w", err)
+	}
+	var root *x509.Certificate
+	for {
+		var block *pem.Block
+		block, chain = pem.Decode(chain)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the vault CA: %w", err)
+		}
+		root = cert // the chain is ordered from the issuer to the root
+	}
+	if root == nil {
+		return nil, errors.New("failed to fetch the vault CA: no certificates returned")
+	}
+	return root, nil
+}
+
+type certResponse struct {
+	Data struct {
+		Certificate string `json:"certificate"`
+		PrivateKey  string `json:"private_key"`
+	} `json:"data"`
+}
+
+// sanRequest converts hosts, in the format accepted by issuer.IssueServer,
+// into the parameters of the issue and sign endpoints.
+func sanRequest(hosts []string) map[string]interface{} {
+	var cn string
+	var altNames, ipSANs, uriSANs []string
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			ipSANs = append(ipSANs, h)
+		} else if email, err := mail.ParseAddress(h); err == nil && email.Address == h {
+			altNames = append(altNames, h)
+		} else if uriName, err := url.Parse(h); err == nil && uriName.Scheme != "" && uriName.Host != "" {
+			uriSANs = append(uriSANs, h)
+		} else if cn == "" {
+			cn = h
+		} else {
+			altNames = append(altNames, h)
+		}
+	}
+	req := map[string]interface{}{"format": "pem"}
+	if cn != "" {
+		req["common_name"] = cn
+	} else {
+		req["exclude_cn_from_sans"] = true
+	}
+	if len(altNames) > 0 {
+		req["alt_names"] = strings.Join(altNames, ",")
+	}
+	if len(ipSANs) > 0 {
+		req["ip_sans"] = strings.Join(ipSANs, ",")
+	}
+	if len(uriSANs) > 0 {
+		req["uri_sans"] = strings.Join(uriSANs, ",")
+	}
+	return req
+}
+
+// Issue issues a certificate and key for hosts. The key type, key usages and
+// lifetime are decided by the role.
+func (c *Client) Issue(ctx context.Context, hosts []string) (*issuer.Certificate, error) {
+	if len(hosts) == 0 {
+		return nil, errors.New("no hosts specified")
+	}
+	req := sanRequest(hosts)
+	req["private_key_format"] = "pkcs8"
+	body, err := c.do(ctx, "POST", c.Mount+"/issue/"+c.Role, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue the certificate: %w", err)
+	}
+	var resp certResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse the vault response: %w", err)
+	}
+	cert, err := parseCertificate(resp.Data.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	keyBlock, _ := pem.Decode([]byte(resp.Data.PrivateKey))
+	if keyBlock == nil {
+		return nil, errors.New("failed to parse the issued key: no PEM data")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the issued key: %w", err)
+	}
+	return &issuer.Certificate{Cert: cert, Key: key}, nil
+}
+
+// SignCSR signs csr with the names it requests. Which extensions are
+// honored is decided by the role.
+func (c *Client) SignCSR(ctx context.Context, csr *x509.CertificateRequest) (*issuer.Certificate, error) {
+	var hosts []string
+	if csr.Subject.CommonName != "" {
+		hosts = append(hosts, csr.Subject.CommonName)
+	}
+	hosts = append(hosts, csr.DNSNames...)
+	hosts = append(hosts, csr.EmailAddresses...)
+	for _, ip := range csr.IPAddresses {
+		hosts = append(hosts, ip.String())
+	}
+	for _, uri := range csr.URIs {
+		hosts = append(hosts, uri.String())
+	}
+	req := sanRequest(hosts)
+	req["csr"] = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw}))
+	body, err := c.do(ctx, "POST", c.Mount+"/sign/"+c.Role, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign the CSR: %w", err)
+	}
+	var resp certResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse the vault response: %w", err)
+	}
+	cert, err := parseCertificate(resp.Data.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	return &issuer.Certificate{Cert: cert}, nil
+}
+
+func parseCertificate(certPEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("failed to parse the issued certificate: no PEM data")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the issued certificate: %w", err)
+	}
+	return cert, nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"filippo.io/mkcert/truststore"
+)
+
+// watch implements -watch. It checks the trust stores every m.watchEvery
+// until interrupted, and when the local CA disappears from some of them,
+// for example after a Firefox profile refresh, a macOS upgrade or a
+// ca-certificates update, installs it again with -install, or otherwise
+// reports it and runs -exec.
+func (m *mkcert) watch() error {
+	if err := m.serveHealth("watch"); err != nil {
+		return err
+	}
+	if m.installMode {
+		if err := m.install(); err != nil {
+			return err
+		}
+	} else if err := m.check(); err != nil {
+		return err
+	}
+	log.Printf("Checking the trust stores every %s, press Ctrl-C to stop 👀", m.watchEvery)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ticker := time.NewTicker(m.watchEvery)
+	defer ticker.Stop()
+	m.health.recordCheck(nil, time.Now().Add(m.watchEvery))
+	reported := make(map[string]bool)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if err := m.watchOnce(ctx, reported); err != nil {
+			log.Printf("Warning: %v ⚠️", err)
+		}
+	}
+}
+
+// watchOnce checks the trust stores once. reported holds the stores that
+// were already reported missing, so that they are only reported again after
+// the CA is back in them.
+func (m *mkcert) watchOnce(ctx context.Context, reported map[string]bool) error {
+	// A new Store detects the trust stores again, like Firefox profiles
+	// created since the last check.
+	m.store = m.newStore()
+	results, err := m.store.CheckContext(ctx)
+	if err != nil {
+		return err
+	}
+	installed := make(map[string]bool)
+	defer func() { m.health.recordCheck(installed, time.Now().Add(m.watchEvery)) }()
+	var missing []string
+	for _, r := range results {
+		if r.Store == "system" {
+			// The system roots of this process might have been loaded before
+			// the change, so ask a new process, like after installing.
+			r.Status = truststore.AlreadyInstalled
+			if m.verifyPlatform(ctx) != nil {
+				r.Status = truststore.NotInstalled
+			}
+		}
+		logResult("check", r)
+		installed[r.Store] = r.Status == truststore.AlreadyInstalled
+		if r.Status == truststore.AlreadyInstalled {
+			delete(reported, r.Store)
+			continue
+		}
+		if m.installMode || !reported[r.Store] {
+			log.Printf("The local CA is missing from %s ⚠️", storeName(r.Store))
+			missing = append(missing, r.Store)
+		}
+		reported[r.Store] = true
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if m.execHook != "" {
+		if err := m.runHook("MKCERT_STORES=" + strings.Join(missing, ",")); err != nil {
+			log.Printf("Warning: %v ⚠️", err)
+		}
+	}
+	if !m.installMode {
+		log.Println("Run \"mkcert -install\" for certificates to be trusted automatically ⚠️")
+		return nil
+	}
+	m.store.Stores = missing
+	if err := m.install(); err != nil {
+		return err
+	}
+	for _, name := range missing {
+		delete(reported, name)
+		installed[name] = true
+	}
+	return nil
+}
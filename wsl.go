@@ -0,0 +1,155 @@
+// Copyright 2018 The mkcert Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"filippo.io/mkcert/issuer"
+)
+
+// isWSL reports whether mkcert is running in the Windows Subsystem for Linux.
+func isWSL() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	version, err := ioutil.ReadFile("/proc/version")
+	return err == nil && bytes.Contains(bytes.ToLower(version), []byte("microsoft"))
+}
+
+// peerCAROOT returns the default CAROOT of the Windows host when running in
+// WSL, or of the default WSL distribution when running on Windows, as a path
+// usable from this side. It returns "" if there is no such peer.
+func peerCAROOT() (string, error) {
+	switch {
+	case isWSL():
+		out, err := exec.Command("cmd.exe", "/c", "echo %LOCALAPPDATA%").Output()
+		if err != nil {
+			return "", fmt.Errorf("failed to execute \"cmd.exe\": %w", err)
+		}
+		winPath := strings.TrimSpace(string(out)) + `\mkcert`
+		out, err = exec.Command("wslpath", "-u", winPath).Output()
+		if err != nil {
+			return "", fmt.Errorf("failed to execute \"wslpath\": %w", err)
+		}
+		return strings.TrimSpace(string(out)), nil
+	case runtime.GOOS == "windows":
+		if _, err := exec.LookPath("wsl.exe"); err != nil {
+			return "", nil
+		}
+		out, err := exec.Command("wsl.exe", "-e", "sh", "-c",
+			`wslpath -w "${CAROOT:-${XDG_DATA_HOME:-$HOME/.local/share}/mkcert}"`).Output()
+		if err != nil {
+			return "", fmt.Errorf("failed to execute \"wsl.exe\": %w", err)
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	return "", nil
+}
+
+func peerName() string {
+	if runtime.GOOS == "windows" {
+		return "WSL"
+	}
+	return "Windows host"
+}
+
+// notePeerCA lets the user know that a CA from the other side of WSL could
+// be shared, before a new one is generated.
+func (m *mkcert) notePeerCA() {
+	if !isWSL() && runtime.GOOS != "windows" {
+		return
+	}
+	peer, err := peerCAROOT()
+	if err != nil || peer == "" || !pathExists(filepath.Join(peer, issuer.RootName)) {
+		return
+	}
+	log.Printf("Note: the %s has a local CA at %q. To share it, delete the new one and run \"mkcert -link-caroot\" ℹ️", peerName(), peer)
+}
+
+// linkCAROOT makes the CAROOT share the same CA as the other side of WSL.
+// In WSL the CA files are replaced with symlinks into the Windows CAROOT,
+// while on Windows they are copied, as symlinks require privileges.
+func (m *mkcert) linkCAROOT() error {
+	peer, err := peerCAROOT()
+	if err != nil {
+		return fmt.Errorf("failed to locate the %s CAROOT: %w", peerName(), err)
+	}
+	if peer == "" {
+		return errors.New("-link-caroot is only supported in WSL, or on Windows with WSL installed")
+	}
+
+	localCert, localErr := ioutil.ReadFile(filepath.Join(m.CAROOT, issuer.RootName))
+	peerCert, peerErr := ioutil.ReadFile(filepath.Join(peer, issuer.RootName))
+	switch {
+	case localErr != nil && peerErr != nil:
+		return fmt.Errorf("neither %q nor %q contain a local CA, run mkcert once on either side first", m.CAROOT, peer)
+	case localErr == nil && peerErr == nil && !bytes.Equal(localCert, peerCert):
+		return fmt.Errorf("%q and %q contain different local CAs, delete the one you don't use and try again", m.CAROOT, peer)
+	case peerErr != nil:
+		// Only this side has a CA, so share it with the peer.
+		if err := os.MkdirAll(peer, 0755); err != nil {
+			return fmt.Errorf("failed to create the %s CAROOT: %w", peerName(), err)
+		}
+		for _, name := range []string{issuer.RootName, issuer.RootKeyName} {
+			if !pathExists(filepath.Join(m.CAROOT, name)) {
+				continue // keyless mode
+			}
+			if err := copyFile(filepath.Join(m.CAROOT, name), filepath.Join(peer, name)); err != nil {
+				return err
+			}
+		}
+		log.Printf("The local CA is now shared with the %s at %q 🔗", peerName(), peer)
+		return nil
+	}
+
+	for _, name := range []string{issuer.RootName, issuer.RootKeyName} {
+		local, target := filepath.Join(m.CAROOT, name), filepath.Join(peer, name)
+		if !pathExists(target) {
+			continue // keyless mode
+		}
+		if runtime.GOOS == "windows" {
+			if pathExists(local) {
+				continue // already the same CA
+			}
+			if err := copyFile(target, local); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.Remove(local); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to replace %q: %w", local, err)
+		}
+		if err := os.Symlink(target, local); err != nil {
+			return fmt.Errorf("failed to link %q: %w", local, err)
+		}
+	}
+	log.Printf("The local CA is now shared with the %s at %q 🔗", peerName(), peer)
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %w", src, err)
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %w", src, err)
+	}
+	if err := ioutil.WriteFile(dst, data, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write %q: %w", dst, err)
+	}
+	return nil
+}